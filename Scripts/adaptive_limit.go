@@ -0,0 +1,254 @@
+/*
+Adaptive concurrency: a worker pool that sizes itself to a downstream whose capacity
+changes while it runs.
+
+The downstream handles -capacity calls at a time (one number per -phase), each takes
+-service, calls over the capacity wait in its queue and once more than -queue are
+waiting it rejects them. -clients workers call it as fast as they can, every call
+takes a slot from an adaptive.Controller first:
+
+	fixed    - the limit stays at -fixed, right for one phase at best
+	aimd     - +1 per window while the latency is under target, ×0.9 when it isn't
+	gradient - Little's law, the limit follows limit × minRTT / rtt
+
+Every -tick a row of the plot is printed: the bar is the limit, | marks the
+downstream's capacity at that moment, then the mean round trip and the throughput.
+A limit above the capacity only adds queueing (rtt grows, nothing else does), below
+it capacity is wasted (throughput drops). AIMD saws around its target, twice the
+no load rtt by default, so it runs up to twice the capacity. Gradient settles just
+above the capacity (the sqrt headroom) but climbs more slowly when capacity is added.
+
+usage: go run Scripts/adaptive_limit.go -algo fixed|aimd|gradient|all -capacity 8,32,4,16
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/adaptive"
+	"github.com/neilharia7/operating-systems-with-go/loadgen"
+)
+
+var errOverloaded = errors.New("downstream overloaded")
+
+// downstream serves capacity calls at a time, first come first served, with a
+// bounded queue in front.
+type downstream struct {
+	service  time.Duration
+	maxQueue int
+
+	mu       sync.Mutex
+	capacity int
+	busy     int
+	queue    []chan struct{}
+}
+
+// admit hands free slots to the queue in order, d.mu must be held.
+func (d *downstream) admit() {
+	for len(d.queue) > 0 && d.busy < d.capacity {
+		d.busy++
+		close(d.queue[0])
+		d.queue = d.queue[1:]
+	}
+}
+
+func (d *downstream) setCapacity(n int) {
+	d.mu.Lock()
+	d.capacity = n
+	d.admit()
+	d.mu.Unlock()
+}
+
+func (d *downstream) call(ctx context.Context) error {
+	d.mu.Lock()
+	if d.busy < d.capacity && len(d.queue) == 0 {
+		d.busy++
+		d.mu.Unlock()
+	} else if len(d.queue) >= d.maxQueue {
+		d.mu.Unlock()
+		return errOverloaded
+	} else {
+		ready := make(chan struct{})
+		d.queue = append(d.queue, ready)
+		d.mu.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			d.mu.Lock()
+			for i, q := range d.queue {
+				if q == ready {
+					d.queue = append(d.queue[:i], d.queue[i+1:]...)
+					d.mu.Unlock()
+					return ctx.Err()
+				}
+			}
+			// got the slot anyway, serve the call
+			d.mu.Unlock()
+		}
+	}
+
+	time.Sleep(d.service)
+
+	d.mu.Lock()
+	d.busy--
+	d.admit()
+	d.mu.Unlock()
+	return nil
+}
+
+type options struct {
+	phases   []int
+	phase    time.Duration
+	service  time.Duration
+	queue    int
+	clients  int
+	timeout  time.Duration
+	fixed    int
+	tick     time.Duration
+	width    int
+	maxLimit int
+}
+
+func run(name string, o options) {
+	cfg := adaptive.Config{Initial: o.fixed, Max: o.maxLimit}
+	switch name {
+	case "fixed":
+		cfg.Min, cfg.Max = o.fixed, o.fixed
+	default:
+		algo, err := adaptive.ParseAlgorithm(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cfg.Algorithm = algo
+	}
+	ctl := adaptive.New(cfg)
+	down := &downstream{service: o.service, maxQueue: o.queue, capacity: o.phases[0]}
+
+	// per tick round trip and throughput for the plot
+	var calls, rttSum, failed atomic.Int64
+	var limitSum, limitTicks, capSum int64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	plotDone := make(chan struct{})
+	go func() {
+		defer close(plotDone)
+		start := time.Now()
+		ticker := time.NewTicker(o.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				elapsed := now.Sub(start)
+				capacity := o.phases[min(int(elapsed/o.phase), len(o.phases)-1)]
+				down.setCapacity(capacity)
+				limit := ctl.Limit()
+				limitSum += int64(limit)
+				capSum += int64(capacity)
+				limitTicks++
+
+				n, sum, f := calls.Swap(0), rttSum.Swap(0), failed.Swap(0)
+				rtt := time.Duration(0)
+				if n > 0 {
+					rtt = time.Duration(sum / n)
+				}
+				scale := float64(o.width) / float64(o.maxLimit)
+				bar := []byte(strings.Repeat(" ", o.width+1))
+				for i := 0; i < min(int(float64(limit)*scale), o.width); i++ {
+					bar[i] = '#'
+				}
+				bar[min(int(float64(capacity)*scale), o.width)] = '|'
+				fmt.Printf("%5.1fs cap %3v limit %3v %s rtt %6v %5.0f/s", elapsed.Seconds(), capacity, limit,
+					bar, rtt.Round(100*time.Microsecond), float64(n)/o.tick.Seconds())
+				if f > 0 {
+					fmt.Printf(" %v failed", f)
+				}
+				fmt.Println()
+			}
+		}
+	}()
+
+	rep, _ := loadgen.Run(ctx, loadgen.Config{
+		Concurrency: o.clients,
+		Duration:    time.Duration(len(o.phases)) * o.phase,
+	}, func(ctx context.Context, worker int) error {
+		tok, err := ctl.Acquire(ctx)
+		if err != nil {
+			// the run is over
+			return nil
+		}
+		cctx, cancelCall := context.WithTimeout(ctx, o.timeout)
+		start := time.Now()
+		err = down.call(cctx)
+		cancelCall()
+		tok.Done(err != nil)
+		if ctx.Err() != nil {
+			// the run ended mid call, not the downstream's fault
+			return nil
+		}
+		calls.Add(1)
+		rttSum.Add(int64(time.Since(start)))
+		if err != nil {
+			failed.Add(1)
+		}
+		return err
+	})
+	cancel()
+	<-plotDone
+
+	fmt.Printf("%v: %v\n", name, rep)
+	if limitTicks > 0 {
+		fmt.Printf("  mean limit %.1f, mean capacity %.1f\n\n",
+			float64(limitSum)/float64(limitTicks), float64(capSum)/float64(limitTicks))
+	}
+}
+
+func main() {
+	algo := flag.String("algo", "all", "fixed, aimd, gradient or all")
+	capacity := flag.String("capacity", "8,32,4,16", "downstream capacity per phase, comma separated")
+	phase := flag.Duration("phase", 2*time.Second, "how long each capacity lasts")
+	service := flag.Duration("service", 10*time.Millisecond, "downstream time per call")
+	queue := flag.Int("queue", 64, "calls the downstream lets wait before rejecting")
+	clients := flag.Int("clients", 100, "workers calling the downstream")
+	timeout := flag.Duration("timeout", 200*time.Millisecond, "client timeout per call")
+	fixed := flag.Int("fixed", 16, "the fixed limit, and every algorithm's starting point")
+	tick := flag.Duration("tick", 200*time.Millisecond, "plot interval")
+	width := flag.Int("width", 40, "plot width")
+	flag.Parse()
+
+	o := options{phase: *phase, service: *service, queue: *queue, clients: *clients,
+		timeout: *timeout, fixed: *fixed, tick: *tick, width: *width}
+	for _, f := range strings.Split(*capacity, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			fmt.Printf("bad capacity %q\n", f)
+			os.Exit(1)
+		}
+		o.phases = append(o.phases, n)
+		o.maxLimit = max(o.maxLimit, n)
+	}
+	// the plot leaves room above the largest capacity to show overshoot
+	o.maxLimit = max(o.maxLimit*2, o.fixed)
+
+	fmt.Printf("capacity %v, %v per phase, %v per call, %v clients\n\n", o.phases, o.phase, o.service, o.clients)
+	algos := []string{*algo}
+	if *algo == "all" {
+		algos = []string{"fixed", "aimd", "gradient"}
+	}
+	for _, a := range algos {
+		run(a, o)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+FAT vs inode (indexed) allocation, side by side.
+
+The same create/append/delete trace is replayed against a fresh fssim file system per
+allocator and the resulting layout is compared:
+
+	fragmentation  - internal: bytes allocated to files but unused
+	                 external: how shattered the free space is
+	seeks          - jumps needed to read every file once from start to end
+	metadata       - blocks spent on bookkeeping: the FAT is a fixed table sized by
+	                 the disk, index blocks grow with the number and size of files
+
+By default a random trace is generated (-seed, -ops), or pass a trace file with
+lines like "create f1", "append f1 300", "delete f1". Contiguous and linked
+allocation can be thrown in with -alloc.
+
+usage: go run Scripts/alloc_compare.go [-trace file] [-alloc fat,indexed,contiguous,linked]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/neilharia7/operating-systems-with-go/fssim"
+)
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file, a random trace is generated if empty")
+	seed := flag.Int64("seed", 1, "seed for the generated trace")
+	nops := flag.Int("ops", 2000, "ops in the generated trace")
+	files := flag.Int("files", 40, "max live files in the generated trace")
+	maxAppend := flag.Int("max-append", 2000, "max bytes per append in the generated trace")
+	allocs := flag.String("alloc", "fat,indexed", "comma separated allocators to compare")
+	blocks := flag.Int("blocks", 1024, "disk size in blocks")
+	blockSize := flag.Int("block-size", 512, "block size in bytes")
+	flag.Parse()
+
+	var ops []fssim.Op
+	if *tracePath != "" {
+		f, err := os.Open(*tracePath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ops, err = fssim.ParseTrace(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		ops = fssim.GenerateTrace(*seed, *nops, *files, *maxAppend)
+	}
+	fmt.Printf("trace: %v ops, disk: %v blocks of %vB\n\n", len(ops), *blocks, *blockSize)
+
+	fmt.Printf("%-11s %6s %7s %6s %6s %8s %9s %8s %7s %9s %8s\n",
+		"allocator", "files", "data", "meta", "free", "int frag", "ext frag", "holes", "seeks", "seek dist", "nospace")
+	for _, name := range strings.Split(*allocs, ",") {
+		alloc, err := fssim.NewAllocator(strings.TrimSpace(name))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fs := fssim.New(alloc, *blocks, *blockSize)
+		res := fs.Replay(ops)
+		u := fs.Usage()
+		fmt.Printf("%-11s %6d %7d %6d %6d %7dB %8.0f%% %8d %7d %9d %8d\n",
+			u.Allocator, u.Files, u.DataBlocks, u.MetaBlocks, u.Free, u.InternalFrag,
+			100*u.ExternalFrag, u.FreeExtents, u.Seeks, u.SeekDistance, res.NoSpace)
+	}
+}
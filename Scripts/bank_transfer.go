@@ -0,0 +1,298 @@
+/*
+Classic bank transfer problem.
+
+N accounts, a bunch of tellers (goroutines) moving random amounts between random
+pairs of accounts. Every transfer needs both accounts locked, which is exactly the
+setup for a deadlock:
+
+	teller 1: lock(A) ... lock(B)
+	teller 2: lock(B) ... lock(A)
+
+Four versions of the transfer are implemented:
+
+	naive   - lock "from" then "to"; deadlocks pretty quickly (circular wait)
+	ordered - always lock the account with the lower id first (breaks circular wait)
+	trylock - lock "from", try "to", back off and retry if it's taken (breaks hold and wait)
+	nolock  - no locks at all, concurrent updates of the same balance get lost. This
+	          one is meant to break the invariant, it's there for the checker to catch.
+
+Money should never be created or destroyed. An invariant checker verifies that the
+total balance is constant every -check-every while the tellers are running, at a safe
+point between transfers (the world is stopped for it, tellers waiting for a lock
+count as stopped). nolock trips it, the report comes with a dump of the accounts.
+After the run the total is checked once more.
+
+Every teller is an actor (see the actor package), carried in its context: the
+transfers it made and how long it waited for locks are reported per teller at the
+end, and when the tellers deadlock.
+
+Hangs are caught by a watchdog (see the watchdog package): every teller beats after
+each transfer and notes which account it's waiting for. A teller that misses
+-misses beats is reported with its note, the stacks of all goroutines are dumped
+(-stacks) and the run is canceled with exit status 2.
+
+usage: go run Scripts/bank_transfer.go -mode naive|ordered|trylock|nolock
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/actor"
+	"github.com/neilharia7/operating-systems-with-go/checkpoint"
+	"github.com/neilharia7/operating-systems-with-go/invariant"
+	"github.com/neilharia7/operating-systems-with-go/watchdog"
+)
+
+type account struct {
+	sync.Mutex
+	id      int
+	balance int
+}
+
+type bank struct {
+	accounts []*account
+	// number of completed transfers
+	transfers atomic.Int64
+	// tellers are its participants, waiting for a lock counts as stopped
+	world *checkpoint.World
+}
+
+func newBank(n, initial int) *bank {
+	b := &bank{accounts: make([]*account, n), world: checkpoint.NewWorld()}
+	for i := range b.accounts {
+		b.accounts[i] = &account{id: i, balance: initial}
+	}
+	return b
+}
+
+// total locks every account (in id order) so the sum is a consistent snapshot.
+func (b *bank) total() int {
+	for _, a := range b.accounts {
+		a.Lock()
+	}
+	sum := 0
+	for _, a := range b.accounts {
+		sum += a.balance
+	}
+	for _, a := range b.accounts {
+		a.Unlock()
+	}
+	return sum
+}
+
+// lock takes an account's lock, a teller waiting here doesn't hold up a stop. The
+// wait is put down to the teller in ctx, and noted for the watchdog.
+func (b *bank) lock(ctx context.Context, a *account) {
+	watchdog.From(ctx).Note("waiting for account %v", a.id)
+	actor.From(ctx).Wait("lock wait", func() { b.world.Blocked(a.Lock) })
+}
+
+// done counts a completed transfer, for the bank and for the teller, and is the
+// teller's heartbeat.
+func (b *bank) done(ctx context.Context) {
+	b.transfers.Add(1)
+	actor.From(ctx).Add("transfers", 1)
+	watchdog.From(ctx).Beat()
+}
+
+func move(from, to *account, amount int) {
+	if from.balance < amount {
+		return
+	}
+	from.balance -= amount
+	to.balance += amount
+}
+
+// naive locks in argument order, two tellers going opposite ways will deadlock.
+func (b *bank) naive(ctx context.Context, from, to *account, amount int) {
+	b.lock(ctx, from)
+	// widen the window between the two locks so the deadlock shows up fast
+	runtime.Gosched()
+	b.lock(ctx, to)
+	move(from, to, amount)
+	to.Unlock()
+	from.Unlock()
+	b.done(ctx)
+}
+
+// ordered imposes a global order on the locks, so a cycle can never form.
+func (b *bank) ordered(ctx context.Context, from, to *account, amount int) {
+	first, second := from, to
+	if second.id < first.id {
+		first, second = second, first
+	}
+	b.lock(ctx, first)
+	runtime.Gosched()
+	b.lock(ctx, second)
+	move(from, to, amount)
+	second.Unlock()
+	first.Unlock()
+	b.done(ctx)
+}
+
+// trylock never waits while holding a lock, if the second lock is busy
+// it releases the first one and tries again after a small random backoff.
+func (b *bank) trylock(ctx context.Context, from, to *account, amount int) {
+	for attempt := 0; ; attempt++ {
+		b.lock(ctx, from)
+		runtime.Gosched()
+		if to.TryLock() {
+			move(from, to, amount)
+			to.Unlock()
+			from.Unlock()
+			b.done(ctx)
+			return
+		}
+		from.Unlock()
+		actor.From(ctx).Add("backoffs", 1)
+		// random backoff so the two tellers don't retry in lockstep (livelock)
+		time.Sleep(time.Duration(rand.Intn(1+attempt%8)) * time.Microsecond)
+	}
+}
+
+// nolock doesn't lock at all, two tellers updating the same account at once may
+// both read the old balance and one of the updates is lost.
+func (b *bank) nolock(ctx context.Context, from, to *account, amount int) {
+	if from.balance >= amount {
+		balance := from.balance
+		runtime.Gosched()
+		from.balance = balance - amount
+		runtime.Gosched()
+		to.balance += amount
+	}
+	b.done(ctx)
+}
+
+func main() {
+	mode := flag.String("mode", "naive", "naive, ordered, trylock or nolock")
+	accounts := flag.Int("accounts", 5, "number of accounts")
+	tellers := flag.Int("tellers", 8, "number of concurrent tellers")
+	perTeller := flag.Int("transfers", 10000, "transfers per teller")
+	initial := flag.Int("balance", 1000, "initial balance per account")
+	checkEvery := flag.Duration("check-every", 5*time.Millisecond, "how often the invariant is checked while running, 0 to turn it off")
+	beat := flag.Duration("beat", 100*time.Millisecond, "watchdog interval, a teller is expected to finish a transfer at least that often")
+	misses := flag.Int("misses", 5, "missed beats before a teller counts as stalled")
+	stacks := flag.Bool("stacks", true, "dump the goroutine stacks when a teller stalls")
+	flag.Parse()
+
+	if *accounts < 2 {
+		fmt.Println("need at least 2 accounts")
+		os.Exit(1)
+	}
+
+	b := newBank(*accounts, *initial)
+	var transfer func(ctx context.Context, from, to *account, amount int)
+	switch *mode {
+	case "naive":
+		transfer = b.naive
+	case "ordered":
+		transfer = b.ordered
+	case "trylock":
+		transfer = b.trylock
+	case "nolock":
+		transfer = b.nolock
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	expected := b.total()
+	fmt.Printf("mode=%v accounts=%v tellers=%v total=%v\n", *mode, *accounts, *tellers, expected)
+
+	checker := invariant.New(invariant.Config{World: b.world, Every: *checkEvery})
+	checker.Register("total balance constant", func() error {
+		// the world is stopped, nobody is halfway through a transfer
+		sum := 0
+		for _, a := range b.accounts {
+			sum += a.balance
+		}
+		return invariant.That(sum == expected, "total is %v, expected %v", sum, expected)
+	})
+	checker.Dump(func(w io.Writer) {
+		for _, a := range b.accounts {
+			fmt.Fprintf(w, "  account %v: %v\n", a.id, a.balance)
+		}
+		fmt.Fprintf(w, "  %v transfers done\n", b.transfers.Load())
+	})
+	if *checkEvery > 0 {
+		checker.Start()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a stall cancels ctx, which is how main learns about it
+	wd := watchdog.New(watchdog.Config{
+		Interval: *beat,
+		Misses:   *misses,
+		Output:   os.Stdout,
+		Stacks:   *stacks,
+		Cancel:   cancel,
+	})
+	wd.Start()
+	defer wd.Stop()
+
+	tellersGroup := actor.NewGroup(actor.Hooks{})
+	tellersCtx := actor.WithGroup(ctx, tellersGroup)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(*tellers)
+	for t := 0; t < *tellers; t++ {
+		b.world.Join()
+		name := fmt.Sprintf("teller %d", t)
+		ctx := actor.WithActor(tellersCtx, name)
+		go func() {
+			defer wg.Done()
+			defer b.world.Leave()
+			defer actor.From(ctx).Done()
+			wk := wd.Register(name)
+			defer wk.Done()
+			ctx := watchdog.WithWorker(ctx, wk)
+			for i := 0; i < *perTeller; i++ {
+				// between transfers a teller holds nothing, a safe point
+				b.world.SafePoint()
+				from := rand.Intn(*accounts)
+				to := rand.Intn(*accounts - 1)
+				if to >= from {
+					to++
+				}
+				transfer(ctx, b.accounts[from], b.accounts[to], rand.Intn(100))
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	select {
+	case <-done:
+		wd.Stop()
+		checker.Stop()
+		got := b.total()
+		fmt.Printf("finished %v transfers in %v, invariant checked %v times while running\n",
+			b.transfers.Load(), time.Since(start), checker.Checks())
+		tellersGroup.Report(os.Stdout)
+		if got != expected {
+			fmt.Printf("INVARIANT VIOLATED: total is %v, expected %v\n", got, expected)
+			os.Exit(1)
+		}
+		fmt.Printf("invariant holds: total is still %v\n", got)
+	case <-ctx.Done():
+		fmt.Printf("deadlock: no progress after %v transfers, tellers are waiting on each other\n", b.transfers.Load())
+		// the waits that never ended aren't in the lock wait column
+		tellersGroup.Report(os.Stdout)
+		os.Exit(2)
+	}
+}
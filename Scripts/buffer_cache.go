@@ -0,0 +1,76 @@
+/*
+Buffer cache: write-back vs write-through, LRU vs Clock.
+
+A block access trace (zipf distributed with the odd sequential scan, or read from a
+file with "r <block>" / "w <block>" lines) is replayed against caches of growing size
+with every combination of eviction and write policy.
+
+	hit rate   - the eviction policy decides this, write policy barely matters
+	write amp  - disk writes per logical write: write-through is always 1.00,
+	             write-back folds repeated writes of hot blocks into one
+
+usage: go run Scripts/buffer_cache.go [-trace file] -sizes 16,64,256 -writes 0.3
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/neilharia7/operating-systems-with-go/bufcache"
+)
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file, generated if empty")
+	seed := flag.Int64("seed", 1, "seed for the generated trace")
+	n := flag.Int("n", 100000, "accesses in the generated trace")
+	blocks := flag.Int("blocks", 4096, "distinct blocks in the generated trace")
+	writes := flag.Float64("writes", 0.3, "fraction of writes in the generated trace")
+	scanEvery := flag.Int("scan-every", 5000, "mix in a sequential scan every that many accesses, 0 for none")
+	scanLen := flag.Int("scan-len", 500, "length of the sequential scans")
+	sizes := flag.String("sizes", "16,64,256,1024", "cache sizes in frames")
+	syncEvery := flag.Int("sync-every", 0, "write-back: flush dirty blocks every that many accesses")
+	flag.Parse()
+
+	var trace []bufcache.Access
+	if *tracePath != "" {
+		f, err := os.Open(*tracePath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		trace, err = bufcache.ParseTrace(f)
+		f.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		trace = bufcache.GenerateTrace(*seed, *n, *blocks, *writes, *scanEvery, *scanLen)
+	}
+	fmt.Printf("trace: %v accesses\n\n", len(trace))
+
+	fmt.Printf("%7s %-6s %-14s %9s %11s %11s %10s\n", "frames", "evict", "write", "hit rate", "disk reads", "disk writes", "write amp")
+	for _, s := range strings.Split(*sizes, ",") {
+		frames, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Printf("bad size %q\n", s)
+			os.Exit(1)
+		}
+		for _, ev := range []string{"lru", "clock"} {
+			for _, wp := range []bufcache.WritePolicy{bufcache.WriteBack, bufcache.WriteThrough} {
+				st, err := bufcache.Replay(bufcache.Config{Frames: frames, Eviction: ev, Write: wp, SyncEvery: *syncEvery}, trace)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				fmt.Printf("%7d %-6s %-14v %8.1f%% %11d %11d %10.2f\n",
+					frames, ev, wp, 100*st.HitRate(), st.DiskReads, st.DiskWrites, st.WriteAmplification())
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Lamport clocks and vector clocks on message passing goroutines.
+
+N processes (goroutines) with an inbox channel each. Every step a process either does
+something local, sends a message to a random peer or receives one from its inbox.
+Every event is stamped with both clocks, messages carry the sender's clocks along.
+
+After the run:
+
+	- the events are printed in Lamport order (ties broken by process id), a total
+	  order in which every receive comes after its send
+	- every pair of events is checked: whenever the vector clocks say a happened before
+	  b the Lamport clocks must agree (the clock condition)
+	- pairs the vector clocks say are concurrent are counted, including the ones whose
+	  Lamport times differ: Lamport order alone can't tell causality from coincidence
+
+usage: go run Scripts/causal_clocks.go -procs 3 -steps 6 -seed 1
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/neilharia7/operating-systems-with-go/causal"
+)
+
+type message struct {
+	from    int
+	id      string
+	lamport uint64
+	vector  causal.Vector
+}
+
+type event struct {
+	proc    int
+	what    string
+	lamport uint64
+	vector  causal.Vector
+}
+
+func (e event) String() string {
+	return fmt.Sprintf("L=%-3v V=%-12v p%v %v", e.lamport, e.vector, e.proc, e.what)
+}
+
+func main() {
+	procs := flag.Int("procs", 3, "number of processes")
+	steps := flag.Int("steps", 6, "steps per process")
+	seed := flag.Int64("seed", 1, "random seed for the choices each process makes")
+	flag.Parse()
+
+	inbox := make([]chan message, *procs)
+	for i := range inbox {
+		// big enough that a send never blocks, a process never waits for a peer
+		inbox[i] = make(chan message, *procs**steps)
+	}
+
+	var mu sync.Mutex
+	var events []event
+	var wg sync.WaitGroup
+	wg.Add(*procs)
+	for p := 0; p < *procs; p++ {
+		go func(p int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(*seed + int64(p)))
+			var lc causal.Lamport
+			vc := causal.NewVector(*procs)
+			sent := 0
+			record := func(what string, l uint64) {
+				mu.Lock()
+				events = append(events, event{p, what, l, vc.Copy()})
+				mu.Unlock()
+			}
+			for s := 0; s < *steps; s++ {
+				switch r := rng.Intn(3); {
+				case r == 0:
+					vc.Tick(p)
+					record("local", lc.Tick())
+				case r == 1 && *procs > 1:
+					to := rng.Intn(*procs - 1)
+					if to >= p {
+						to++
+					}
+					vc.Tick(p)
+					l := lc.Tick()
+					sent++
+					id := fmt.Sprintf("m%v.%v", p, sent)
+					record(fmt.Sprintf("send %v to p%v", id, to), l)
+					inbox[to] <- message{p, id, l, vc.Copy()}
+				default:
+					select {
+					case m := <-inbox[p]:
+						vc.Recv(p, m.vector)
+						record(fmt.Sprintf("recv %v from p%v", m.id, m.from), lc.Recv(m.lamport))
+					default:
+						vc.Tick(p)
+						record("local (inbox empty)", lc.Tick())
+					}
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].lamport != events[j].lamport {
+			return events[i].lamport < events[j].lamport
+		}
+		return events[i].proc < events[j].proc
+	})
+	fmt.Println("events in Lamport order:")
+	for _, e := range events {
+		fmt.Println(" ", e)
+	}
+
+	var ordered, concurrent, lamportOnly, violations int
+	var example [2]event
+	for i := range events {
+		for j := i + 1; j < len(events); j++ {
+			a, b := events[i], events[j]
+			switch causal.Compare(a.vector, b.vector) {
+			case causal.Before:
+				ordered++
+				if a.lamport >= b.lamport {
+					violations++
+				}
+			case causal.After:
+				ordered++
+				if b.lamport >= a.lamport {
+					violations++
+				}
+			case causal.Concurrent:
+				concurrent++
+				if a.lamport != b.lamport {
+					if lamportOnly == 0 {
+						example = [2]event{a, b}
+					}
+					lamportOnly++
+				}
+			}
+		}
+	}
+	fmt.Printf("\n%v event pairs: %v causally ordered, %v concurrent\n", ordered+concurrent, ordered, concurrent)
+	fmt.Printf("%v concurrent pairs still have different Lamport times", lamportOnly)
+	if lamportOnly > 0 {
+		fmt.Printf(", e.g.\n  %v\n  %v\nLamport puts one first, the vector clocks show neither could have known about the other\n",
+			example[0], example[1])
+	} else {
+		fmt.Println()
+	}
+	if violations > 0 {
+		fmt.Printf("CLOCK CONDITION VIOLATED %v times\n", violations)
+		os.Exit(1)
+	}
+	fmt.Println("clock condition holds: a happened before b implies L(a) < L(b)")
+}
@@ -0,0 +1,205 @@
+/*
+What do channels cost?
+
+Runs a set of benchmarks with testing.Benchmark (no _test.go needed) and prints ns per
+message:
+
+	unbuffered   - every send waits for its receiver, a goroutine handoff per message
+	buffered/N   - the sender only blocks when the buffer is full, bigger buffers mean
+	               fewer handoffs until the lock/copy cost is all that's left
+	pingpong     - round trip over two unbuffered channels, pure latency
+	select/N     - one receiver fanning in from N producer channels with a select
+	reflect/N    - the same fan-in with reflect.Select, for when N isn't known at
+	               compile time
+
+usage: go run Scripts/channel_bench.go [-benchtime 1s]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func sendRecv(buffer int) func(b *testing.B) {
+	return func(b *testing.B) {
+		ch := make(chan int, buffer)
+		done := make(chan struct{})
+		go func() {
+			for range ch {
+			}
+			close(done)
+		}()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+		close(ch)
+		<-done
+	}
+}
+
+func pingPong(b *testing.B) {
+	ping, pong := make(chan int), make(chan int)
+	go func() {
+		for v := range ping {
+			pong <- v
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ping <- i
+		<-pong
+	}
+	close(ping)
+}
+
+// producers starts n goroutines, each sending b.N/n values on its own channel.
+func producers(n, total int) []chan int {
+	chans := make([]chan int, n)
+	for i := range chans {
+		chans[i] = make(chan int, 16)
+		go func(ch chan int, count int) {
+			for j := 0; j < count; j++ {
+				ch <- j
+			}
+			close(ch)
+		}(chans[i], total/n+boolInt(i < total%n))
+	}
+	return chans
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// selectFanIn drains n producers with a select, a select statement's cases are fixed
+// at compile time so selectN has one per benchmarked size.
+func selectFanIn(n int) func(b *testing.B) {
+	return func(b *testing.B) {
+		c := producers(n, b.N)
+		b.ResetTimer()
+		for open := n; open > 0; {
+			if idx, ok := selectN(c, n); !ok {
+				// a nil channel is never ready, so the closed producer drops out of the select
+				c[idx] = nil
+				open--
+			}
+		}
+	}
+}
+
+// selectN does a real select over the n channels, one case per channel.
+func selectN(c []chan int, n int) (int, bool) {
+	var ok bool
+	switch n {
+	case 1:
+		_, ok = <-c[0]
+		return 0, ok
+	case 2:
+		select {
+		case _, ok = <-c[0]:
+			return 0, ok
+		case _, ok = <-c[1]:
+			return 1, ok
+		}
+	case 4:
+		select {
+		case _, ok = <-c[0]:
+			return 0, ok
+		case _, ok = <-c[1]:
+			return 1, ok
+		case _, ok = <-c[2]:
+			return 2, ok
+		case _, ok = <-c[3]:
+			return 3, ok
+		}
+	default: // 8
+		select {
+		case _, ok = <-c[0]:
+			return 0, ok
+		case _, ok = <-c[1]:
+			return 1, ok
+		case _, ok = <-c[2]:
+			return 2, ok
+		case _, ok = <-c[3]:
+			return 3, ok
+		case _, ok = <-c[4]:
+			return 4, ok
+		case _, ok = <-c[5]:
+			return 5, ok
+		case _, ok = <-c[6]:
+			return 6, ok
+		case _, ok = <-c[7]:
+			return 7, ok
+		}
+	}
+}
+
+func reflectFanIn(n int) func(b *testing.B) {
+	return func(b *testing.B) {
+		chans := producers(n, b.N)
+		cases := make([]reflect.SelectCase, n)
+		for i, ch := range chans {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+		}
+		b.ResetTimer()
+		open := n
+		for open > 0 {
+			i, _, ok := reflect.Select(cases)
+			if !ok {
+				// a zero Value channel is ignored by reflect.Select, like a nil channel
+				cases[i].Chan = reflect.Value{}
+				open--
+			}
+		}
+	}
+}
+
+func main() {
+	benchtime := flag.Duration("benchtime", time.Second, "target time per benchmark")
+	flag.Parse()
+	// testing.Benchmark reads the benchtime flag of the testing package
+	testing.Init()
+	flag.Set("test.benchtime", benchtime.String())
+
+	benches := []struct {
+		name string
+		fn   func(b *testing.B)
+	}{
+		{"unbuffered", sendRecv(0)},
+		{"buffered/1", sendRecv(1)},
+		{"buffered/16", sendRecv(16)},
+		{"buffered/256", sendRecv(256)},
+		{"buffered/4096", sendRecv(4096)},
+		{"pingpong", pingPong},
+		{"select/1", selectFanIn(1)},
+		{"select/2", selectFanIn(2)},
+		{"select/4", selectFanIn(4)},
+		{"select/8", selectFanIn(8)},
+		{"reflect/1", reflectFanIn(1)},
+		{"reflect/2", reflectFanIn(2)},
+		{"reflect/4", reflectFanIn(4)},
+		{"reflect/8", reflectFanIn(8)},
+		{"reflect/32", reflectFanIn(32)},
+		{"reflect/128", reflectFanIn(128)},
+	}
+
+	fmt.Printf("GOMAXPROCS=%v\n\n%-15s %12s %14s %10s\n", runtime.GOMAXPROCS(0), "benchmark", "ns/msg", "msgs/s", "allocs/op")
+	for _, bench := range benches {
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			bench.fn(b)
+		})
+		ns := float64(r.T.Nanoseconds()) / float64(r.N)
+		fmt.Printf("%-15s %12.1f %14.0f %10d\n", bench.name, ns, 1e9/ns, r.AllocsPerOp())
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Chaos testing a pipeline and a worker pool.
+
+	pipeline:    generate -> square -> sum, the channels between the stages go through
+	             chaos.Chan so messages can be delayed or dropped
+	worker pool: N workers pulling jobs off a channel, each job is wrapped by the
+	             injector so it can be delayed or panic. Workers recover from the panic
+	             and retry the job a few times before giving up on it.
+
+Whatever chaos is injected, the bookkeeping has to add up at the end:
+
+	pipeline:    received + dropped == generated
+	worker pool: succeeded + failed == submitted
+
+Every channel and every worker draws from a chaos site of its own, so the same seed
+drops the same messages of the pipeline on every run. Which job a worker panics on
+still depends on which jobs the scheduler hands it.
+
+usage: go run Scripts/chaos_demo.go -chaos-latency 0.2 -chaos-drop 0.05 -chaos-panic 0.1 -chaos-seed 7
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+)
+
+func pipeline(inj *chaos.Injector, n int) bool {
+	var dropped atomic.Int64
+	onDrop := func(int) { dropped.Add(1) }
+
+	gen := make(chan int)
+	go func() {
+		defer close(gen)
+		for i := 1; i <= n; i++ {
+			gen <- i
+		}
+	}()
+
+	squares := make(chan int)
+	in := chaos.Chan(inj.Site("generate->square"), gen, onDrop)
+	go func() {
+		defer close(squares)
+		for v := range in {
+			squares <- v * v
+		}
+	}()
+
+	received := 0
+	for range chaos.Chan(inj.Site("square->sum"), squares, onDrop) {
+		received++
+	}
+
+	fmt.Printf("pipeline: generated=%v received=%v dropped=%v\n", n, received, dropped.Load())
+	return int64(received)+dropped.Load() == int64(n)
+}
+
+// runJob runs one job, turning an injected panic into an error.
+func runJob(job func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(chaos.Panic)
+			if !ok {
+				// a real bug, don't hide it
+				panic(r)
+			}
+			err = p
+		}
+	}()
+	job()
+	return nil
+}
+
+func workerPool(inj *chaos.Injector, workers, jobs, retries int) bool {
+	var succeeded, failed, recovered atomic.Int64
+	queue := make(chan int)
+	latency := histogram.New()
+	var wg sync.WaitGroup
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		site := inj.Site(fmt.Sprintf("worker %d", w))
+		go func() {
+			defer wg.Done()
+			rec := latency.NewRecorder()
+			for id := range queue {
+				start := time.Now()
+				var err error
+				job := site.Wrap(func() { _ = id * id })
+				for attempt := 0; attempt <= retries; attempt++ {
+					if err = runJob(job); err == nil {
+						break
+					}
+					var p chaos.Panic
+					if errors.As(err, &p) {
+						recovered.Add(1)
+					}
+				}
+				if err != nil {
+					failed.Add(1)
+				} else {
+					succeeded.Add(1)
+				}
+				rec.Record(time.Since(start))
+			}
+		}()
+	}
+	for i := 0; i < jobs; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	fmt.Printf("worker pool: submitted=%v succeeded=%v failed=%v panics recovered=%v\n",
+		jobs, succeeded.Load(), failed.Load(), recovered.Load())
+	fmt.Print("job latency (retries included): ")
+	latency.Snapshot().WriteText(os.Stdout)
+	return succeeded.Load()+failed.Load() == int64(jobs)
+}
+
+func main() {
+	cfg := chaos.RegisterFlags(flag.CommandLine)
+	n := flag.Int("n", 1000, "messages through the pipeline / jobs through the pool")
+	workers := flag.Int("workers", 4, "worker pool size")
+	retries := flag.Int("retries", 2, "how many times a panicking job is retried")
+	flag.Parse()
+
+	inj := chaos.New(*cfg)
+	if inj == nil {
+		fmt.Println("chaos disabled, pass -chaos-latency / -chaos-drop / -chaos-panic to enable it")
+	}
+
+	ok := pipeline(inj, *n)
+	ok = workerPool(inj, *workers, *n, *retries) && ok
+	fmt.Println("injected:", inj.Stats())
+	if !ok {
+		fmt.Println("accounting is off, something got lost")
+		os.Exit(1)
+	}
+	fmt.Println("all messages and jobs accounted for")
+}
@@ -0,0 +1,611 @@
+/*
+Checkpoint and restore of running concurrent simulations.
+
+Three simulations run with real goroutines, each one long enough to be interrupted:
+
+	pc           - producers and consumers around a bounded buffer. Every item value
+	               is unique, so at the end the consumed sum must be exactly 0+1+..+N-1.
+	philosophers - dining philosophers (lowest fork first) until everybody has eaten
+	               -meals times.
+	scheduler    - a round-robin CPU scheduler: -cpus goroutines take processes off a
+	               run queue, run them for a quantum and put them back until their
+	               CPU bursts are used up.
+
+On SIGUSR2 the simulation stops the world (checkpoint.World) and writes its whole
+state to the -state file. Safe points are placed where the state is consistent: a
+consumer is never halfway through accounting an item it took, a CPU is never
+in the middle of a quantum. Anything waiting (for a buffer slot, a fork, a
+process) counts as stopped already. The world then keeps going.
+
+-mode demo shows the whole cycle for a simulation: start it as a child process, send
+SIGUSR2, let it run on for a bit, SIGKILL it, start a new child with -resume and let
+that one finish. The work done between the checkpoint and the kill is lost and
+simply done again, the final state is checked to be exactly what an uninterrupted
+run gives. Each simulation's invariants (every fork held by at most one
+philosopher, no item lost between producers and consumers...) are checked
+at safe points while it runs.
+
+usage: go run Scripts/checkpoint_sim.go [-sim pc|philosophers|scheduler|all]
+       go run Scripts/checkpoint_sim.go -mode run -sim pc -state file [-resume]
+         (then kill -USR2 <pid> from another terminal)
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/checkpoint"
+	"github.com/neilharia7/operating-systems-with-go/invariant"
+)
+
+// Checkpoint is what gets saved, the state of one of the simulations.
+type Checkpoint struct {
+	Sim   string
+	Taken time.Time
+	PC    *PCState
+	Phil  *PhilState
+	Sched *SchedState
+}
+
+type simulation interface {
+	// run starts the goroutines, all of them participants in w, and returns when
+	// the simulation is over.
+	run(w *checkpoint.World)
+	// progress describes the state, it's only called with the world stopped or
+	// after run returned.
+	progress() string
+	verify() error
+	// invariants registers what must hold at every safe point.
+	invariants(c *invariant.Checker)
+}
+
+// notify wakes up one waiter on a channel with a buffer of one, without blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// --- producer-consumer
+
+type PCState struct {
+	Producers, Consumers, PerProducer, Cap int
+	Next                                   []int // next sequence number per producer
+	Buffer                                 []int
+	Consumed                               []int // items per consumer
+	Sum                                    int64
+}
+
+type pcSim struct {
+	st                *PCState
+	mu                sync.Mutex
+	notEmpty, notFull chan struct{}
+}
+
+func (s *pcSim) produce(w *checkpoint.World, p int) {
+	st := s.st
+	for {
+		w.SafePoint()
+		s.mu.Lock()
+		if st.Next[p] == st.PerProducer {
+			s.mu.Unlock()
+			return
+		}
+		if len(st.Buffer) < st.Cap {
+			st.Buffer = append(st.Buffer, p*st.PerProducer+st.Next[p])
+			st.Next[p]++
+			if len(st.Buffer) < st.Cap {
+				notify(s.notFull)
+			}
+			s.mu.Unlock()
+			notify(s.notEmpty)
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		s.mu.Unlock()
+		w.Blocked(func() { <-s.notFull })
+	}
+}
+
+func (s *pcSim) consume(w *checkpoint.World, c int) {
+	st := s.st
+	total := st.Producers * st.PerProducer
+	for {
+		w.SafePoint()
+		s.mu.Lock()
+		if len(st.Buffer) > 0 {
+			v := st.Buffer[0]
+			st.Buffer = st.Buffer[1:]
+			if len(st.Buffer) > 0 {
+				notify(s.notEmpty)
+			}
+			s.mu.Unlock()
+			notify(s.notFull)
+			// the item is in neither the buffer nor the sum now, there's no safe point
+			// until it's been accounted for
+			time.Sleep(2 * time.Millisecond)
+			s.mu.Lock()
+			st.Sum += int64(v)
+			st.Consumed[c]++
+			s.mu.Unlock()
+			continue
+		}
+		produced := 0
+		for _, n := range st.Next {
+			produced += n
+		}
+		s.mu.Unlock()
+		if produced == total {
+			notify(s.notEmpty) // pass it on to the next idle consumer
+			return
+		}
+		w.Blocked(func() { <-s.notEmpty })
+	}
+}
+
+func (s *pcSim) run(w *checkpoint.World) {
+	s.notEmpty, s.notFull = make(chan struct{}, 1), make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for p := 0; p < s.st.Producers; p++ {
+		wg.Add(1)
+		w.Join()
+		go func(p int) { defer wg.Done(); defer w.Leave(); s.produce(w, p) }(p)
+	}
+	for c := 0; c < s.st.Consumers; c++ {
+		wg.Add(1)
+		w.Join()
+		go func(c int) { defer wg.Done(); defer w.Leave(); s.consume(w, c) }(c)
+	}
+	wg.Wait()
+}
+
+func (s *pcSim) progress() string {
+	produced, consumed := 0, 0
+	for p := range s.st.Next {
+		produced += s.st.Next[p]
+	}
+	for c := range s.st.Consumed {
+		consumed += s.st.Consumed[c]
+	}
+	total := s.st.Producers * s.st.PerProducer
+	return fmt.Sprintf("produced %v/%v, %v in the buffer, consumed %v/%v, sum %v", produced, total, len(s.st.Buffer), consumed, total, s.st.Sum)
+}
+
+func (s *pcSim) invariants(c *invariant.Checker) {
+	c.Register("every item produced is buffered or consumed", func() error {
+		produced, consumed := 0, 0
+		for _, n := range s.st.Next {
+			produced += n
+		}
+		for _, n := range s.st.Consumed {
+			consumed += n
+		}
+		return invariant.That(produced == consumed+len(s.st.Buffer),
+			"produced %v, consumed %v with %v in the buffer", produced, consumed, len(s.st.Buffer))
+	})
+	c.Register("buffer within its capacity", func() error {
+		return invariant.That(len(s.st.Buffer) <= s.st.Cap, "%v items in a buffer of %v", len(s.st.Buffer), s.st.Cap)
+	})
+	c.Dump(func(w io.Writer) { fmt.Fprintf(w, "  %v\n", s.progress()) })
+}
+
+func (s *pcSim) verify() error {
+	n := int64(s.st.Producers * s.st.PerProducer)
+	consumed := 0
+	for _, c := range s.st.Consumed {
+		consumed += c
+	}
+	if int64(consumed) != n || s.st.Sum != n*(n-1)/2 || len(s.st.Buffer) != 0 {
+		return fmt.Errorf("consumed %v items summing to %v, expected %v summing to %v", consumed, s.st.Sum, n, n*(n-1)/2)
+	}
+	return nil
+}
+
+// --- dining philosophers
+
+type PhilState struct {
+	Target int
+	Meals  []int
+}
+
+type philSim struct {
+	st    *PhilState
+	mu    sync.Mutex
+	forks []sync.Mutex
+	held  []int // how many philosophers think they hold each fork, not saved
+}
+
+func (s *philSim) dine(w *checkpoint.World, i int, rng *rand.Rand) {
+	first, second := i, (i+1)%len(s.forks)
+	if second < first {
+		first, second = second, first
+	}
+	for {
+		// holding no forks, the meal count is the whole state
+		w.SafePoint()
+		s.mu.Lock()
+		done := s.st.Meals[i] == s.st.Target
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Duration(1+rng.Intn(4)) * time.Millisecond)
+		w.Blocked(func() {
+			s.forks[first].Lock()
+			s.forks[second].Lock()
+		})
+		s.mu.Lock()
+		s.held[first]++
+		s.held[second]++
+		s.mu.Unlock()
+		time.Sleep(time.Duration(1+rng.Intn(4)) * time.Millisecond)
+		s.mu.Lock()
+		s.st.Meals[i]++
+		s.held[first]--
+		s.held[second]--
+		s.mu.Unlock()
+		s.forks[second].Unlock()
+		s.forks[first].Unlock()
+	}
+}
+
+func (s *philSim) run(w *checkpoint.World) {
+	s.forks = make([]sync.Mutex, len(s.st.Meals))
+	s.held = make([]int, len(s.st.Meals))
+	var wg sync.WaitGroup
+	for i := range s.st.Meals {
+		wg.Add(1)
+		w.Join()
+		go func(i int) {
+			defer wg.Done()
+			defer w.Leave()
+			s.dine(w, i, rand.New(rand.NewSource(int64(i))))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s *philSim) progress() string {
+	return fmt.Sprintf("meals %v of %v each", s.st.Meals, s.st.Target)
+}
+
+func (s *philSim) invariants(c *invariant.Checker) {
+	c.Register("every fork held by at most one philosopher", func() error {
+		for f, n := range s.held {
+			if n > 1 {
+				return fmt.Errorf("fork %v is held by %v philosophers", f, n)
+			}
+		}
+		return nil
+	})
+	c.Dump(func(w io.Writer) { fmt.Fprintf(w, "  forks held %v, meals %v\n", s.held, s.st.Meals) })
+}
+
+func (s *philSim) verify() error {
+	for i, m := range s.st.Meals {
+		if m != s.st.Target {
+			return fmt.Errorf("philosopher %v ate %v meals, expected %v", i, m, s.st.Target)
+		}
+	}
+	return nil
+}
+
+// --- round-robin scheduler
+
+type Proc struct {
+	Burst, Remaining, Slices int
+	Done                     int // completion order, 0 while unfinished
+}
+
+type SchedState struct {
+	CPUs, Quantum int
+	Procs         []Proc
+	Queue         []int // run queue of indexes into Procs
+	Ticks         int64 // CPU time handed out
+	Finished      int
+}
+
+type schedSim struct {
+	st   *SchedState
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+const tick = 500 * time.Microsecond
+
+func (s *schedSim) cpu(w *checkpoint.World) {
+	st := s.st
+	for {
+		w.SafePoint()
+		s.mu.Lock()
+		if st.Finished == len(st.Procs) {
+			s.mu.Unlock()
+			notify(s.wake)
+			return
+		}
+		if len(st.Queue) == 0 {
+			// every process left is on another CPU
+			s.mu.Unlock()
+			w.Blocked(func() { <-s.wake })
+			continue
+		}
+		id := st.Queue[0]
+		st.Queue = st.Queue[1:]
+		ran := min(st.Quantum, st.Procs[id].Remaining)
+		s.mu.Unlock()
+
+		// the process is off the queue now, no safe point until it's back or done
+		time.Sleep(time.Duration(ran) * tick)
+
+		s.mu.Lock()
+		p := &st.Procs[id]
+		p.Remaining -= ran
+		p.Slices++
+		st.Ticks += int64(ran)
+		if p.Remaining > 0 {
+			st.Queue = append(st.Queue, id)
+		} else {
+			st.Finished++
+			p.Done = st.Finished
+		}
+		s.mu.Unlock()
+		notify(s.wake)
+	}
+}
+
+func (s *schedSim) run(w *checkpoint.World) {
+	s.wake = make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for c := 0; c < s.st.CPUs; c++ {
+		wg.Add(1)
+		w.Join()
+		go func() { defer wg.Done(); defer w.Leave(); s.cpu(w) }()
+	}
+	wg.Wait()
+}
+
+func (s *schedSim) progress() string {
+	return fmt.Sprintf("%v/%v processes finished, %v queued, %v ticks of CPU time", s.st.Finished, len(s.st.Procs), len(s.st.Queue), s.st.Ticks)
+}
+
+func (s *schedSim) invariants(c *invariant.Checker) {
+	c.Register("CPU time handed out matches the bursts used up", func() error {
+		var used int64
+		queued := 0
+		for _, p := range s.st.Procs {
+			used += int64(p.Burst - p.Remaining)
+			if p.Remaining > 0 {
+				queued++
+			}
+		}
+		if used != s.st.Ticks {
+			return fmt.Errorf("%v ticks handed out, %v used", s.st.Ticks, used)
+		}
+		// at a safe point no process is on a CPU, the unfinished ones are all queued
+		return invariant.That(queued == len(s.st.Queue), "%v unfinished processes, %v queued", queued, len(s.st.Queue))
+	})
+	c.Dump(func(w io.Writer) { fmt.Fprintf(w, "  %v\n", s.progress()) })
+}
+
+func (s *schedSim) verify() error {
+	var bursts int64
+	seen := map[int]bool{}
+	for i, p := range s.st.Procs {
+		bursts += int64(p.Burst)
+		slices := (p.Burst + s.st.Quantum - 1) / s.st.Quantum
+		if p.Remaining != 0 || p.Slices != slices || p.Done == 0 || seen[p.Done] {
+			return fmt.Errorf("process %v: %+v, expected %v slices", i, p, slices)
+		}
+		seen[p.Done] = true
+	}
+	if s.st.Ticks != bursts {
+		return fmt.Errorf("handed out %v ticks for %v ticks of bursts", s.st.Ticks, bursts)
+	}
+	return nil
+}
+
+func fresh(sim string) (*Checkpoint, error) {
+	ck := &Checkpoint{Sim: sim}
+	switch sim {
+	case "pc":
+		st := &PCState{Producers: 4, Consumers: 3, PerProducer: 300, Cap: 16}
+		st.Next, st.Consumed = make([]int, st.Producers), make([]int, st.Consumers)
+		ck.PC = st
+	case "philosophers":
+		ck.Phil = &PhilState{Target: 150, Meals: make([]int, 5)}
+	case "scheduler":
+		st := &SchedState{CPUs: 4, Quantum: 4}
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 240; i++ {
+			burst := 5 + rng.Intn(56)
+			st.Procs = append(st.Procs, Proc{Burst: burst, Remaining: burst})
+			st.Queue = append(st.Queue, i)
+		}
+		ck.Sched = st
+	default:
+		return nil, fmt.Errorf("unknown simulation %q", sim)
+	}
+	return ck, nil
+}
+
+func simFor(ck *Checkpoint) simulation {
+	switch {
+	case ck.PC != nil:
+		return &pcSim{st: ck.PC}
+	case ck.Phil != nil:
+		return &philSim{st: ck.Phil}
+	}
+	return &schedSim{st: ck.Sched}
+}
+
+// runSim runs a simulation to the end, checkpointing on every SIGUSR2.
+func runSim(sim, path string, resume bool) error {
+	var ck *Checkpoint
+	if resume {
+		ck = &Checkpoint{}
+		if err := checkpoint.Load(path, ck); err != nil {
+			return err
+		}
+		fmt.Printf("resumed %v from the checkpoint taken at %v\n", ck.Sim, ck.Taken.Format("15:04:05.000"))
+		fmt.Printf("  %v\n", simFor(ck).progress())
+	} else {
+		var err error
+		if ck, err = fresh(sim); err != nil {
+			return err
+		}
+	}
+	s := simFor(ck)
+	w := checkpoint.NewWorld()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+	go func() {
+		for range sigs {
+			var err error
+			w.Stop(func() {
+				ck.Taken = time.Now()
+				fmt.Printf("world stopped: %v\n", s.progress())
+				err = checkpoint.Save(path, ck)
+			})
+			st := w.Stats()
+			if err != nil {
+				fmt.Printf("checkpoint failed: %v\n", err)
+				continue
+			}
+			fmt.Printf("checkpoint written, world stopped for %v (%v to reach the safe points)\n",
+				st.Pause.Round(time.Microsecond), st.Reach.Round(time.Microsecond))
+		}
+	}()
+
+	// the invariants are checked at safe points too, every stop sees a consistent state
+	checker := invariant.New(invariant.Config{World: w, Every: 20 * time.Millisecond, Output: os.Stdout})
+	s.invariants(checker)
+	checker.Start()
+
+	start := time.Now()
+	s.run(w)
+	if err := checker.Stop(); err != nil {
+		return err
+	}
+	fmt.Printf("invariants held at %v checks\n", checker.Checks())
+	fmt.Printf("finished in %v: %v\n", time.Since(start).Round(time.Millisecond), s.progress())
+	if err := s.verify(); err != nil {
+		return err
+	}
+	fmt.Println("final state verified")
+	return nil
+}
+
+// demo runs sim in a child, checkpoints it, kills it and finishes it from the
+// checkpoint in a second child.
+func demo(sim string, after, killAfter time.Duration) error {
+	dir, err := os.MkdirTemp("", "checkpoint")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, sim+".ckpt")
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("=== %v\n", sim)
+	cmd := exec.Command(self, "-mode", "run", "-sim", sim, "-state", path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	written := make(chan struct{})
+	go func() {
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			fmt.Println("  |", s.Text())
+			if strings.HasPrefix(s.Text(), "checkpoint written") {
+				close(written)
+			}
+		}
+	}()
+	time.Sleep(after)
+	fmt.Println("sending SIGUSR2")
+	cmd.Process.Signal(syscall.SIGUSR2)
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		return fmt.Errorf("no checkpoint written")
+	}
+	time.Sleep(killAfter)
+	fmt.Println("sending SIGKILL, the work since the checkpoint is lost")
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	resumed := exec.Command(self, "-mode", "run", "-sim", sim, "-state", path, "-resume")
+	resumed.Stdout, resumed.Stderr = prefixed{}, prefixed{}
+	return resumed.Run()
+}
+
+type prefixed struct{}
+
+func (prefixed) Write(p []byte) (int, error) {
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line != "" {
+			fmt.Print("  | ", line)
+		}
+	}
+	return len(p), nil
+}
+
+func main() {
+	mode := flag.String("mode", "demo", "demo or run")
+	sim := flag.String("sim", "all", "pc, philosophers, scheduler (or all for the demo)")
+	state := flag.String("state", "", "checkpoint file (run)")
+	resume := flag.Bool("resume", false, "continue from the checkpoint in -state (run)")
+	after := flag.Duration("after", 400*time.Millisecond, "when the demo sends SIGUSR2")
+	killAfter := flag.Duration("kill-after", 200*time.Millisecond, "how long after the checkpoint the demo kills the child")
+	flag.Parse()
+
+	var err error
+	switch *mode {
+	case "run":
+		if *state == "" {
+			err = fmt.Errorf("-state is required")
+			break
+		}
+		err = runSim(*sim, *state, *resume)
+	case "demo":
+		sims := []string{*sim}
+		if *sim == "all" {
+			sims = []string{"pc", "philosophers", "scheduler"}
+		}
+		for _, s := range sims {
+			if err = demo(s, *after, *killAfter); err != nil {
+				break
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown mode %q", *mode)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
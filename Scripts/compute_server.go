@@ -0,0 +1,299 @@
+/*
+A small compute service: fibonacci, primes and sort over HTTP, with the overload
+protection from httpmw in front.
+
+	GET /fib?n=35           - naive recursive fibonacci (exponential, CPU only)
+	GET /primes?n=10000000  - sieve of Eratosthenes, count and largest prime <= n
+	GET /sort?n=1000000     - sort n random ints, chunks then pairwise merges
+
+Every request runs under a context deadline, ?timeout= (default -timeout, never above
+-max-timeout), and the computations check it as they go: a request that runs out of
+time stops burning CPU and gets a 504. In front of the handlers:
+
+	httpmw.RateLimiter - -client-rate requests per second per client (the X-Client
+	                     header, or the address), 429 over that
+	httpmw.Limiter     - -limit requests computing at once, up to -queue waiting at
+	                     most -queue-wait for a slot, the rest shed with 503
+	pool.Pool          - sieves are big, at most -sieves exist and a primes request
+	                     waits for one (under its deadline)
+
+-mode serve listens on -addr. -mode demo starts the server on a random port and
+sends it a mix of requests in open loop at -rate from a few clients, one of them
+("scraper") sending far more than its share, and prints what happened to them.
+
+On a machine with few cores the load generator competes with the server for the CPU,
+the numbers suffer, the shape doesn't.
+
+usage: go run Scripts/compute_server.go -mode serve|demo -limit 4 -queue 16
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/compute"
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+	"github.com/neilharia7/operating-systems-with-go/httpmw"
+	"github.com/neilharia7/operating-systems-with-go/loadgen"
+	"github.com/neilharia7/operating-systems-with-go/pool"
+)
+
+type server struct {
+	timeout, maxTimeout time.Duration
+	maxN                map[string]int
+	sieves              *pool.Pool[[]bool]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// compute parses n and the deadline, runs fn and writes its result or its error.
+func (s *server) compute(name string, fn func(ctx context.Context, n int) (any, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil || n < 0 || n > s.maxN[name] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("n must be in [0, %v]", s.maxN[name])})
+			return
+		}
+		timeout := s.timeout
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			if timeout, err = time.ParseDuration(t); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		timeout = min(timeout, s.maxTimeout)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		start := time.Now()
+		result, err := fn(ctx, n)
+		elapsed := time.Since(start)
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, map[string]any{"n": n, "result": result, "elapsed": elapsed.String()})
+		case errors.Is(err, context.DeadlineExceeded):
+			writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": fmt.Sprintf("%v(%v) didn't finish within %v", name, n, timeout)})
+		case r.Context().Err() != nil:
+			// the client went away, nobody to answer
+		default:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	})
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/fib", s.compute("fib", func(ctx context.Context, n int) (any, error) {
+		return compute.Fib(ctx, n)
+	}))
+	mux.Handle("/primes", s.compute("primes", func(ctx context.Context, n int) (any, error) {
+		sieve, err := s.sieves.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cap(sieve) < n+1 {
+			sieve = make([]bool, n+1)
+		}
+		// the grown sieve goes back, not the one Get returned
+		defer func() { s.sieves.Put(sieve[:0]) }()
+		count, largest, err := compute.Primes(ctx, n, sieve[:n+1])
+		return map[string]int{"count": count, "largest": largest}, err
+	}))
+	mux.Handle("/sort", s.compute("sort", func(ctx context.Context, n int) (any, error) {
+		a := make([]int, n)
+		for i := range a {
+			a[i] = rand.Int()
+		}
+		if err := compute.Sort(ctx, a); err != nil {
+			return nil, err
+		}
+		res := map[string]int{"sorted": n}
+		if n > 0 {
+			res["min"], res["median"], res["max"] = a[0], a[n/2], a[n-1]
+		}
+		return res, nil
+	}))
+	return mux
+}
+
+type tally struct {
+	mu       sync.Mutex
+	byStatus map[string]map[int]int
+	ok       map[string]*histogram.Histogram
+}
+
+func (t *tally) add(endpoint string, status int, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byStatus[endpoint] == nil {
+		t.byStatus[endpoint] = map[int]int{}
+		t.ok[endpoint] = histogram.New()
+	}
+	t.byStatus[endpoint][status]++
+	if status == http.StatusOK {
+		t.ok[endpoint].Record(d)
+	}
+}
+
+// demo sends a mix of requests from a few clients, scraper sends half of them.
+func demo(url string, rate float64, duration time.Duration) error {
+	type request struct{ client, path string }
+	mix := []request{
+		{"alice", "/fib?n=25"},
+		{"bob", "/primes?n=1000000"},
+		{"carol", "/sort?n=100000"},
+		{"alice", "/fib?n=25"},
+		{"bob", "/primes?n=1000000"},
+		{"carol", "/sort?n=100000"},
+		{"dave", "/fib?n=40&timeout=100ms"}, // never makes it in time
+	}
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 1024}}
+	t := &tally{byStatus: map[string]map[int]int{}, ok: map[string]*histogram.Histogram{}}
+	var seq atomic.Int64
+	var failed atomic.Int64
+	rep, err := loadgen.Run(context.Background(), loadgen.Config{
+		Mode:        loadgen.Open,
+		Concurrency: 500,
+		Rate:        rate,
+		Duration:    duration,
+	}, func(ctx context.Context, worker int) error {
+		i := seq.Add(1)
+		req := mix[i/2%int64(len(mix))]
+		if i%2 == 0 {
+			req = request{"scraper", "/fib?n=25"}
+		}
+		hr, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+req.path, nil)
+		hr.Header.Set("X-Client", req.client)
+		start := time.Now()
+		resp, err := client.Do(hr)
+		if err != nil {
+			if ctx.Err() == nil {
+				failed.Add(1)
+			}
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		name := req.client + " " + req.path
+		t.add(name, resp.StatusCode, time.Since(start))
+		if resp.StatusCode != http.StatusOK {
+			return errors.New(resp.Status)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v requests in %v, %v transport errors\n\n", rep.Completed+rep.Errors, rep.Elapsed.Round(time.Millisecond), failed.Load())
+
+	names := make([]string, 0, len(t.byStatus))
+	for name := range t.byStatus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%-40s %6s %6s %6s %6s %6s   %v\n", "client request", "200", "429", "503", "504", "other", "p50/p99 of 200s")
+	for _, name := range names {
+		st := t.byStatus[name]
+		other := 0
+		for code, n := range st {
+			switch code {
+			case 200, 429, 503, 504:
+			default:
+				other += n
+			}
+		}
+		lat := t.ok[name].Snapshot()
+		fmt.Printf("%-40s %6v %6v %6v %6v %6v   %v / %v\n", name, st[200], st[429], st[503], st[504], other,
+			lat.Percentile(50).Round(100*time.Microsecond), lat.Percentile(99).Round(100*time.Microsecond))
+	}
+	return nil
+}
+
+func main() {
+	mode := flag.String("mode", "demo", "serve or demo")
+	addr := flag.String("addr", "127.0.0.1:8080", "listen address (serve)")
+	limit := flag.Int("limit", runtime.GOMAXPROCS(0), "requests computing at once")
+	queue := flag.Int("queue", 16, "requests allowed to wait for a slot")
+	queueWait := flag.Duration("queue-wait", 100*time.Millisecond, "longest wait for a slot")
+	timeout := flag.Duration("timeout", time.Second, "default deadline per request")
+	maxTimeout := flag.Duration("max-timeout", 5*time.Second, "cap on ?timeout=")
+	clientRate := flag.Float64("client-rate", 50, "requests per second per client")
+	sieves := flag.Int("sieves", 2, "sieves kept, primes requests beyond that wait")
+	rate := flag.Float64("rate", 150, "requests per second offered (demo)")
+	duration := flag.Duration("duration", 3*time.Second, "how long to send requests (demo)")
+	flag.Parse()
+
+	s := &server{
+		timeout:    *timeout,
+		maxTimeout: *maxTimeout,
+		maxN:       map[string]int{"fib": 92, "primes": 50_000_000, "sort": 10_000_000},
+		sieves: pool.New(pool.Config[[]bool]{
+			New:     func(ctx context.Context) ([]bool, error) { return nil, nil },
+			MaxOpen: *sieves,
+		}),
+	}
+	limiter := httpmw.NewLimiter(*limit, *queue, *queueWait)
+	rl := httpmw.NewRateLimiter(*clientRate, int(*clientRate), func(r *http.Request) string {
+		if c := r.Header.Get("X-Client"); c != "" {
+			return c
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return host
+	})
+	handler := httpmw.Chain(s.handler(), rl.Handler, limiter.Handler)
+
+	listen := *addr
+	if *mode == "demo" {
+		listen = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: handler}
+	fmt.Printf("listening on %v: limit=%v queue=%v (wait %v) timeout=%v client rate=%v/s sieves=%v\n",
+		ln.Addr(), *limit, *queue, *queueWait, *timeout, *clientRate, *sieves)
+
+	switch *mode {
+	case "serve":
+		fmt.Println("try: curl 'http://" + ln.Addr().String() + "/primes?n=1000000'")
+		if err := srv.Serve(ln); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "demo":
+		go srv.Serve(ln)
+		defer srv.Close()
+		if err := demo("http://"+ln.Addr().String(), *rate, *duration); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("\nrate limiter:", rl.Stats())
+		fmt.Println("limiter:", limiter.Stats())
+		fmt.Println("sieves:", s.sieves.Stats())
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Pooling expensive connections.
+
+Clients hammer a few fake backends. Dialing a backend is slow (-dial), using a
+connection is quick (-work), and connections go bad now and then (-fail is the
+chance a request breaks its connection). Two ways of doing it:
+
+	dial - every request dials its own connection and closes it afterwards
+	pool - every backend gets a pool.Pool of at most -max connections, created the
+	       first time a client talks to that backend through a pool.OncePerKey, so
+	       concurrent first requests don't build the same pool twice
+
+Broken connections are discarded, idle ones are health checked before reuse and
+closed after -idle of sitting around. Every request has a -timeout, in pool mode a
+request that can't get a connection in time gives up.
+
+usage: go run Scripts/conn_pool.go -mode dial|pool -clients 100 -requests 20 -backends 3 -max 8
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+	"github.com/neilharia7/operating-systems-with-go/pool"
+)
+
+var errBroken = errors.New("connection broken")
+
+type conn struct {
+	id      int64
+	backend string
+	broken  atomic.Bool
+	uses    int
+}
+
+type backend struct {
+	name    string
+	dial    time.Duration
+	dials   atomic.Int64
+	open    atomic.Int64
+	maxOpen atomic.Int64
+	nextID  atomic.Int64
+}
+
+func (b *backend) connect(ctx context.Context) (*conn, error) {
+	select {
+	case <-time.After(b.dial):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	b.dials.Add(1)
+	n := b.open.Add(1)
+	for {
+		m := b.maxOpen.Load()
+		if n <= m || b.maxOpen.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	return &conn{id: b.nextID.Add(1), backend: b.name}, nil
+}
+
+func (b *backend) disconnect(c *conn) { b.open.Add(-1) }
+
+func main() {
+	mode := flag.String("mode", "pool", "dial or pool")
+	clients := flag.Int("clients", 100, "concurrent clients")
+	requests := flag.Int("requests", 20, "requests per client")
+	nBackends := flag.Int("backends", 3, "number of backends")
+	maxOpen := flag.Int("max", 8, "max open connections per backend (pool mode)")
+	dial := flag.Duration("dial", 20*time.Millisecond, "time to dial a connection")
+	work := flag.Duration("work", time.Millisecond, "time a request uses its connection")
+	fail := flag.Float64("fail", 0.01, "probability that a request breaks its connection")
+	idle := flag.Duration("idle", time.Second, "close connections idle for longer than this")
+	timeout := flag.Duration("timeout", 500*time.Millisecond, "per request timeout")
+	flag.Parse()
+
+	backends := make([]*backend, *nBackends)
+	for i := range backends {
+		backends[i] = &backend{name: fmt.Sprintf("db%v", i), dial: *dial}
+	}
+
+	var pools pool.OncePerKey[string, *pool.Pool[*conn]]
+	var poolsBuilt atomic.Int64
+	getPool := func(b *backend) *pool.Pool[*conn] {
+		p, _ := pools.Do(b.name, func() (*pool.Pool[*conn], error) {
+			poolsBuilt.Add(1)
+			return pool.New(pool.Config[*conn]{
+				New:   b.connect,
+				Close: b.disconnect,
+				Check: func(c *conn) error {
+					if c.broken.Load() {
+						return errBroken
+					}
+					return nil
+				},
+				MaxOpen:     *maxOpen,
+				MaxIdleTime: *idle,
+			}), nil
+		})
+		return p
+	}
+
+	request := func(ctx context.Context, b *backend) error {
+		var c *conn
+		var err error
+		var p *pool.Pool[*conn]
+		if *mode == "pool" {
+			p = getPool(b)
+			c, err = p.Get(ctx)
+		} else {
+			c, err = b.connect(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		time.Sleep(*work)
+		c.uses++
+		if rand.Float64() < *fail {
+			c.broken.Store(true)
+		}
+		switch {
+		case p == nil:
+			b.disconnect(c)
+		case c.broken.Load():
+			p.Discard(c)
+		default:
+			p.Put(c)
+		}
+		return nil
+	}
+
+	switch *mode {
+	case "dial", "pool":
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	latency := histogram.New()
+	var ok, timedOut atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	wg.Add(*clients)
+	for c := 0; c < *clients; c++ {
+		go func() {
+			defer wg.Done()
+			rec := latency.NewRecorder()
+			for i := 0; i < *requests; i++ {
+				b := backends[rand.Intn(len(backends))]
+				ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+				t := time.Now()
+				err := request(ctx, b)
+				cancel()
+				if err != nil {
+					timedOut.Add(1)
+					continue
+				}
+				rec.Record(time.Since(t))
+				ok.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("mode=%v clients=%v requests=%v ok=%v timed out=%v in %v (%.0f req/s)\n",
+		*mode, *clients, *clients**requests, ok.Load(), timedOut.Load(),
+		elapsed.Round(time.Millisecond), float64(ok.Load())/elapsed.Seconds())
+	for _, b := range backends {
+		fmt.Printf("  %v: dials=%v peak open=%v\n", b.name, b.dials.Load(), b.maxOpen.Load())
+	}
+	if *mode == "pool" {
+		fmt.Printf("pools built: %v for %v backends\n", poolsBuilt.Load(), len(backends))
+		pools.Range(func(name string, p *pool.Pool[*conn]) {
+			fmt.Printf("  %v: %v\n", name, p.Stats())
+			p.Close()
+		})
+	}
+	fmt.Print("request latency: ")
+	latency.Snapshot().WriteText(os.Stdout)
+}
@@ -0,0 +1,248 @@
+/*
+Consistent iteration: copy-on-write snapshots versus a read lock.
+
+Writers move money between random accounts (the total never changes) while readers
+keep summing every account. Two ways to get a consistent sum:
+
+	rwlock - readers hold an RWMutex read lock for the whole scan. The sum is always
+	         right, but every writer waits until the scan is over, so the bigger the
+	         map the longer writers stall.
+	cow    - readers take a cowmap snapshot and scan that. Writers only ever wait for
+	         the few microseconds it takes to pin the shards, the price is the shard
+	         copies made by the first write to each shard under a live snapshot.
+
+Both modes run for -duration and report write and scan throughput, the worst time a
+single write waited, whether any scan saw a wrong total and the memory the copies
+cost. After that a few testing.Benchmark runs price the individual operations (no
+_test.go needed). On a machine with few cores max wait is mostly scheduling delay, the
+write throughput gap is the number to look at.
+
+usage: go run Scripts/cow_snapshot.go [-keys 100000] [-shards 64] [-writers 4] [-readers 2] [-duration 1s]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/cowmap"
+)
+
+const balance = 100
+
+// store is what the workload needs from either implementation.
+type store interface {
+	transfer(from, to, amount int)
+	scan() (total, n int)
+}
+
+type lockedStore struct {
+	mu sync.RWMutex
+	m  map[int]int
+}
+
+func (s *lockedStore) transfer(from, to, amount int) {
+	s.mu.Lock()
+	s.m[from] -= amount
+	s.m[to] += amount
+	s.mu.Unlock()
+}
+
+func (s *lockedStore) scan() (total, n int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.m {
+		total += v
+	}
+	return total, len(s.m)
+}
+
+type cowStore struct {
+	m *cowmap.Map[int, int]
+}
+
+func (s *cowStore) transfer(from, to, amount int) {
+	if from == to {
+		return
+	}
+	s.m.Update([]int{from, to}, func(v []int, _ []bool) []int {
+		return []int{v[0] - amount, v[1] + amount}
+	})
+}
+
+func (s *cowStore) scan() (total, n int) {
+	snap := s.m.Snapshot()
+	defer snap.Release()
+	snap.Range(func(_, v int) bool {
+		total += v
+		n++
+		return true
+	})
+	return total, n
+}
+
+func newStore(mode string, keys, shards int) store {
+	if mode == "rwlock" {
+		m := make(map[int]int, keys)
+		for k := 0; k < keys; k++ {
+			m[k] = balance
+		}
+		return &lockedStore{m: m}
+	}
+	m := cowmap.New[int, int](shards, cowmap.HashInt)
+	for k := 0; k < keys; k++ {
+		m.Set(k, balance)
+	}
+	return &cowStore{m: m}
+}
+
+type result struct {
+	writes, scans, bad int64
+	maxWait            time.Duration
+	alloc              uint64
+}
+
+func run(mode string, keys, shards, writers, readers int, d time.Duration) (result, store) {
+	s := newStore(mode, keys, shards)
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var res result
+	var maxWait atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			var n int64
+			for {
+				select {
+				case <-stop:
+					atomic.AddInt64(&res.writes, n)
+					return
+				default:
+				}
+				from, to := rng.Intn(keys), rng.Intn(keys)
+				start := time.Now()
+				s.transfer(from, to, rng.Intn(10))
+				if wait := int64(time.Since(start)); wait > maxWait.Load() {
+					// racy max, close enough for a report
+					maxWait.Store(wait)
+				}
+				n++
+			}
+		}(int64(w))
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				total, n := s.scan()
+				atomic.AddInt64(&res.scans, 1)
+				if total != n*balance {
+					atomic.AddInt64(&res.bad, 1)
+				}
+			}
+		}()
+	}
+	time.Sleep(d)
+	close(stop)
+	wg.Wait()
+
+	runtime.ReadMemStats(&after)
+	res.maxWait = time.Duration(maxWait.Load())
+	res.alloc = after.TotalAlloc - before.TotalAlloc
+	return res, s
+}
+
+func bench(name string, fn func(b *testing.B)) {
+	r := testing.Benchmark(fn)
+	fmt.Printf("  %-28s %10.0f ns/op %8d B/op\n", name, float64(r.T.Nanoseconds())/float64(r.N), r.AllocedBytesPerOp())
+}
+
+func main() {
+	keys := flag.Int("keys", 100000, "number of accounts")
+	shards := flag.Int("shards", 64, "cowmap shards")
+	writers := flag.Int("writers", 4, "writer goroutines")
+	readers := flag.Int("readers", 2, "goroutines scanning the whole map")
+	duration := flag.Duration("duration", time.Second, "how long each mode runs")
+	benchtime := flag.String("benchtime", "500ms", "per benchmark, like go test -benchtime")
+	flag.Parse()
+	testing.Init()
+	flag.Set("test.benchtime", *benchtime)
+
+	fmt.Printf("%v accounts, %v writers, %v readers, %v per mode\n\n", *keys, *writers, *readers, *duration)
+	fmt.Printf("%-7s %12s %10s %12s %10s %12s\n", "mode", "writes/s", "scans/s", "max wait", "bad scans", "allocated")
+	failed := false
+	for _, mode := range []string{"rwlock", "cow"} {
+		res, s := run(mode, *keys, *shards, *writers, *readers, *duration)
+		secs := duration.Seconds()
+		fmt.Printf("%-7s %12.0f %10.1f %12v %10v %10.1fMB\n", mode, float64(res.writes)/secs, float64(res.scans)/secs,
+			res.maxWait.Round(time.Microsecond), res.bad, float64(res.alloc)/(1<<20))
+		if cs, ok := s.(*cowStore); ok {
+			st := cs.m.Stats()
+			fmt.Printf("        %v snapshots, %v shard copies, %v entries copied (%.1f per snapshot, map has %v)\n",
+				st.Snapshots, st.Copies, st.EntriesCopied, float64(st.EntriesCopied)/float64(max(st.Snapshots, 1)), *keys)
+		}
+		failed = failed || res.bad > 0
+	}
+
+	fmt.Println("\nper operation:")
+	m := cowmap.New[int, int](*shards, cowmap.HashInt)
+	for k := 0; k < *keys; k++ {
+		m.Set(k, balance)
+	}
+	bench("set", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Set(i%*keys, i)
+		}
+	})
+	bench("set under a live snapshot", func(b *testing.B) {
+		// a fresh snapshot per op is the worst case: every write copies a shard
+		for i := 0; i < b.N; i++ {
+			snap := m.Snapshot()
+			m.Set(i%*keys, i)
+			snap.Release()
+		}
+	})
+	bench("snapshot+release", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.Snapshot().Release()
+		}
+	})
+	bench("scan snapshot", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			snap := m.Snapshot()
+			snap.Range(func(int, int) bool { return true })
+			snap.Release()
+		}
+	})
+	locked := newStore("rwlock", *keys, *shards)
+	bench("scan under read lock", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			locked.scan()
+		}
+	})
+
+	if failed {
+		fmt.Println("\na scan saw an inconsistent total")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+A crawler's visited set: exact sharded map versus a Bloom filter.
+
+The "web" is synthetic, page i links to page i+1 and to a handful of pseudo random
+pages, so every page is reachable from page 0. The crawler goes breadth first, each
+level is fetched by a pool of workers (parallel.Slice) and every link found is
+checked against the visited set, only the goroutine that inserts a URL first gets
+to queue it. The Bloom filter can tell two goroutines finding the same new page at
+once that it's new to both of them, so a level's frontier is deduplicated before
+it's crawled.
+
+	exact - 64 shards of map[string]struct{}, each behind a mutex. Never wrong, but it
+	        keeps every URL it has seen.
+	bloom - a bloom.Filter sized for -pages at a false positive rate of -fp. A fixed
+	        ~1.2 bytes per URL at 1%, but a false positive means a page that was
+	        never crawled is taken for visited and skipped (and so is anything only
+	        reachable through it).
+
+The report shows the memory the visited set holds after the crawl, how many pages
+were reached and how many were missed.
+
+usage: go run Scripts/crawl_visited.go [-pages 200000] [-fp 0.01] [-workers 8]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/maphash"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/bloom"
+	"github.com/neilharia7/operating-systems-with-go/parallel"
+)
+
+type visitedSet interface {
+	// visit reports whether url is new, marking it visited.
+	visit(url string) bool
+}
+
+const shards = 64
+
+type exactSet struct {
+	seed   maphash.Seed
+	shards [shards]struct {
+		sync.Mutex
+		m map[string]struct{}
+	}
+}
+
+func newExactSet() *exactSet {
+	s := &exactSet{seed: maphash.MakeSeed()}
+	for i := range s.shards {
+		s.shards[i].m = make(map[string]struct{})
+	}
+	return s
+}
+
+func (s *exactSet) visit(url string) bool {
+	sh := &s.shards[maphash.String(s.seed, url)%shards]
+	sh.Lock()
+	defer sh.Unlock()
+	if _, ok := sh.m[url]; ok {
+		return false
+	}
+	sh.m[url] = struct{}{}
+	return true
+}
+
+type bloomSet struct{ f *bloom.Filter }
+
+func (s bloomSet) visit(url string) bool { return !s.f.AddString(url) }
+
+func pageURL(i int) string {
+	return fmt.Sprintf("https://host%d.example.com/articles/%d/index.html", i%997, i)
+}
+
+// links "fetches" page i and returns the pages it links to.
+func links(i, pages, fanout int) []int {
+	out := []int{(i + 1) % pages}
+	x := uint64(i) + 1
+	for j := 0; j < fanout; j++ {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		out = append(out, int(x%uint64(pages)))
+	}
+	return out
+}
+
+func crawl(set visitedSet, pages, fanout, workers int) (reached int) {
+	frontier := []int{0}
+	set.visit(pageURL(0))
+	for len(frontier) > 0 {
+		reached += len(frontier)
+		found := parallel.Slice(frontier, workers, func(page int) []int {
+			var next []int
+			for _, link := range links(page, pages, fanout) {
+				if set.visit(pageURL(link)) {
+					next = append(next, link)
+				}
+			}
+			return next
+		})
+		frontier = frontier[:0]
+		queued := map[int]bool{}
+		for _, next := range found {
+			for _, page := range next {
+				if !queued[page] {
+					queued[page] = true
+					frontier = append(frontier, page)
+				}
+			}
+		}
+	}
+	return reached
+}
+
+func heap() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func main() {
+	pages := flag.Int("pages", 200000, "pages in the synthetic web")
+	fanout := flag.Int("fanout", 8, "random links per page")
+	fp := flag.Float64("fp", 0.01, "Bloom filter false positive rate")
+	workers := flag.Int("workers", 8, "fetch workers")
+	flag.Parse()
+
+	fmt.Printf("%v pages, %v links each, %v workers\n\n", *pages, *fanout+1, *workers)
+	fmt.Printf("%-6s %10s %10s %12s %10s %12s\n", "set", "reached", "missed", "memory", "B/page", "elapsed")
+	for _, kind := range []string{"exact", "bloom"} {
+		base := heap()
+		var set visitedSet
+		var filter *bloom.Filter
+		if kind == "exact" {
+			set = newExactSet()
+		} else {
+			filter = bloom.New(*pages, *fp)
+			set = bloomSet{filter}
+		}
+		start := time.Now()
+		reached := crawl(set, *pages, *fanout, *workers)
+		elapsed := time.Since(start)
+		used := heap() - base
+		runtime.KeepAlive(set)
+
+		fmt.Printf("%-6s %10v %10v %10.1fMB %10.1f %12v\n", kind, reached, *pages-reached,
+			float64(used)/(1<<20), float64(used)/float64(*pages), elapsed.Round(time.Millisecond))
+		if filter != nil {
+			fmt.Printf("       %v bits, %v hashes, estimated false positive rate now %.3f%%\n",
+				filter.Bits(), filter.Hashes(), 100*filter.FalsePositiveRate())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Fair share scheduling of a worker pool between tenants.
+
+Four workers are shared by four tenants:
+
+	ingest  - weight 50, dumps -batch jobs of 4ms at once at the start
+	batch   - weight 1, the same, but it's the least important work there is
+	web     - weight 20, a steady -web-rate jobs/s of 1ms, what users are waiting on
+	reports - weight 1, -reports-rate jobs/s of 20ms, more than one worker's worth,
+	          but never more than 1 at a time (quota) so its queue grows
+
+The same load runs under three schedulers:
+
+	fifo      - one queue in submission order, everybody waits behind the floods
+	fair      - weighted fair queuing by worker time: web gets what it asks for,
+	            ingest takes most of the rest and batch only gets a job every now
+	            and then
+	fair+age  - fair, plus starvation protection: a backlogged tenant that hasn't had
+	            a worker for -max-wait gets the next one regardless of share
+
+For each tenant: jobs completed per second, its share of the worker time, and how
+long its jobs waited in the queue.
+
+usage: go run Scripts/fair_share.go [-duration 2s] [-workers 4] [-batch 3000] [-max-wait 20ms] [-web-rate 400]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/fairshare"
+)
+
+// steady submits a job every 1/rate until stop is closed.
+func steady(s *fairshare.Scheduler, tenant string, rate int, work time.Duration, stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	t := time.NewTicker(time.Second / time.Duration(rate))
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			s.Submit(tenant, func() { time.Sleep(work) })
+		}
+	}
+}
+
+func run(name string, cfg fairshare.Config, duration time.Duration, batch, webRate, reportsRate int) {
+	s := fairshare.New(cfg)
+	s.AddTenant("ingest", fairshare.TenantConfig{Weight: 50})
+	s.AddTenant("batch", fairshare.TenantConfig{Weight: 1})
+	s.AddTenant("web", fairshare.TenantConfig{Weight: 20})
+	s.AddTenant("reports", fairshare.TenantConfig{Weight: 1, Quota: 1})
+
+	start := time.Now()
+	for i := 0; i < batch; i++ {
+		s.Submit("ingest", func() { time.Sleep(4 * time.Millisecond) })
+		s.Submit("batch", func() { time.Sleep(4 * time.Millisecond) })
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go steady(s, "web", webRate, time.Millisecond, stop, &wg)
+	go steady(s, "reports", reportsRate, 20*time.Millisecond, stop, &wg)
+	time.Sleep(duration)
+	// what's finished within the duration counts, the rest is drained uncounted
+	stats := s.Stats()
+	elapsed := time.Since(start)
+	close(stop)
+	wg.Wait()
+	s.Close()
+
+	var busy time.Duration
+	for _, st := range stats {
+		busy += st.Busy
+	}
+	fmt.Printf("%v\n", name)
+	fmt.Printf("  %-8s %6s %6s %9s %7s %7s %10s %10s %10s\n", "tenant", "weight", "quota", "done/s", "share", "queued", "wait p50", "wait p99", "aged")
+	for _, st := range stats {
+		quota := "-"
+		if st.Quota > 0 {
+			quota = fmt.Sprint(st.Quota)
+		}
+		fmt.Printf("  %-8s %6v %6v %9.1f %6.1f%% %7v %10v %10v %10v\n", st.Name, st.Weight, quota,
+			float64(st.Completed)/elapsed.Seconds(), 100*st.Busy.Seconds()/busy.Seconds(), st.Queued,
+			st.Wait.Percentile(50).Round(10*time.Microsecond), st.Wait.Percentile(99).Round(10*time.Microsecond), st.Aged)
+	}
+}
+
+func main() {
+	duration := flag.Duration("duration", 2*time.Second, "how long each scheduler runs")
+	workers := flag.Int("workers", 4, "workers in the pool")
+	batch := flag.Int("batch", 3000, "batch jobs queued at the start")
+	webRate := flag.Int("web-rate", 400, "web jobs per second")
+	reportsRate := flag.Int("reports-rate", 80, "report jobs per second")
+	maxWait := flag.Duration("max-wait", 20*time.Millisecond, "starvation protection threshold for fair+age")
+	flag.Parse()
+
+	fmt.Printf("%v workers, %v ingest and batch jobs up front, web %v/s, reports %v/s\n\n", *workers, *batch, *webRate, *reportsRate)
+	run("fifo", fairshare.Config{Workers: *workers, Policy: fairshare.FIFO}, *duration, *batch, *webRate, *reportsRate)
+	run("fair", fairshare.Config{Workers: *workers, Policy: fairshare.Fair}, *duration, *batch, *webRate, *reportsRate)
+	run("fair+age", fairshare.Config{Workers: *workers, Policy: fairshare.Fair, MaxWait: *maxWait}, *duration, *batch, *webRate, *reportsRate)
+}
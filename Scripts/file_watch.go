@@ -0,0 +1,215 @@
+//go:build linux
+
+/*
+Watching a directory with inotify and coalescing bursts of events.
+
+Saving a file in an editor, untarring something or a build writing its output all
+produce a storm of inotify events, reacting to every single one (say, rerunning a
+build) is wasteful. The raw events are run through the stream combinators instead:
+
+	debounce - react once the directory has been quiet for -wait
+	throttle - react at most once per -wait while events keep coming
+
+Events are printed as they arrive (with -v) and every coalesced "reaction" is
+printed along with how many raw events came in since the previous one.
+
+With -burst the demo generates its own traffic: bursts of writes to a few files in the
+watched directory with pauses in between, and exits when they're done.
+
+usage: go run Scripts/file_watch.go [-mode debounce|throttle] [-edge leading|trailing|both] [-wait 200ms] [-burst] [dir]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/neilharia7/operating-systems-with-go/clock"
+	"github.com/neilharia7/operating-systems-with-go/stream"
+)
+
+type event struct {
+	name string
+	mask uint32
+	seq  int64
+}
+
+func (e event) String() string {
+	op := "?"
+	switch {
+	case e.mask&syscall.IN_CREATE != 0:
+		op = "create"
+	case e.mask&syscall.IN_DELETE != 0:
+		op = "delete"
+	case e.mask&syscall.IN_MODIFY != 0:
+		op = "modify"
+	case e.mask&syscall.IN_CLOSE_WRITE != 0:
+		op = "close_write"
+	case e.mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO) != 0:
+		op = "move"
+	}
+	return fmt.Sprintf("#%v %v %v", e.seq, op, e.name)
+}
+
+// watch adds an inotify watch on dir and streams its events until stop is called.
+func watch(dir string, verbose bool) (<-chan event, func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inotify_init1: %w", err)
+	}
+	mask := uint32(syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO)
+	wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, nil, fmt.Errorf("inotify_add_watch %v: %w", dir, err)
+	}
+
+	out := make(chan event)
+	go func() {
+		defer close(out)
+		defer syscall.Close(fd)
+		var seq int64
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				return
+			}
+			// the buffer holds a sequence of variable length inotify_event records
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(raw.Len)]
+				off += syscall.SizeofInotifyEvent + int(raw.Len)
+				name := string(nameBytes)
+				for len(name) > 0 && name[len(name)-1] == 0 {
+					name = name[:len(name)-1]
+				}
+				if raw.Mask&syscall.IN_IGNORED != 0 {
+					// the watch is gone, either stop removed it or dir was deleted
+					return
+				}
+				seq++
+				e := event{name: name, mask: raw.Mask, seq: seq}
+				if verbose {
+					fmt.Println("  raw", e)
+				}
+				out <- e
+			}
+		}
+	}()
+	// closing the fd doesn't wake up a blocked read on an inotify fd, removing the
+	// watch does: the kernel queues IN_IGNORED and the reader closes the fd itself
+	stop := func() {
+		syscall.InotifyRmWatch(fd, uint32(wd))
+	}
+	return out, stop, nil
+}
+
+// burst writes to a few files in dir in bursts, with pauses long enough for the
+// debouncer to fire in between.
+func burst(dir string, bursts, writes int, pause time.Duration) {
+	for b := 0; b < bursts; b++ {
+		for i := 0; i < writes; i++ {
+			name := filepath.Join(dir, fmt.Sprintf("file%v.txt", i%3))
+			os.WriteFile(name, []byte(fmt.Sprintf("burst %v write %v\n", b, i)), 0o644)
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(pause)
+	}
+}
+
+func main() {
+	mode := flag.String("mode", "debounce", "debounce or throttle")
+	edgeName := flag.String("edge", "trailing", "leading, trailing or both")
+	wait := flag.Duration("wait", 200*time.Millisecond, "debounce quiet period / throttle interval")
+	verbose := flag.Bool("v", false, "print every raw event")
+	selfTest := flag.Bool("burst", false, "generate bursts of writes in the directory and exit after them")
+	flag.Parse()
+
+	var edge stream.Edge
+	switch *edgeName {
+	case "leading":
+		edge = stream.Leading
+	case "trailing":
+		edge = stream.Trailing
+	case "both":
+		edge = stream.Leading | stream.Trailing
+	default:
+		fmt.Printf("unknown edge %q\n", *edgeName)
+		os.Exit(1)
+	}
+
+	dir := flag.Arg(0)
+	if dir == "" {
+		if !*selfTest {
+			dir = "."
+		} else {
+			tmp, err := os.MkdirTemp("", "file_watch")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			defer os.RemoveAll(tmp)
+			dir = tmp
+		}
+	}
+
+	events, stop, err := watch(dir, *verbose)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// count raw events on the way in, so every reaction can say how many it replaced
+	var raw atomic.Int64
+	counted := make(chan event)
+	go func() {
+		defer close(counted)
+		for e := range events {
+			raw.Add(1)
+			counted <- e
+		}
+	}()
+
+	var coalesced <-chan event
+	switch *mode {
+	case "debounce":
+		coalesced = stream.Debounce(clock.Real(), counted, *wait, edge)
+	case "throttle":
+		coalesced = stream.Throttle(clock.Real(), counted, *wait, edge)
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	if *selfTest {
+		go func() {
+			burst(dir, 3, 50, 3**wait)
+			stop()
+		}()
+	} else {
+		fmt.Printf("watching %v (%v, %v edge, %v), ctrl-c to quit\n", dir, *mode, *edgeName, *wait)
+	}
+
+	start := time.Now()
+	reactions := 0
+	var seen int64
+	for e := range coalesced {
+		reactions++
+		n := raw.Load()
+		fmt.Printf("%8v  reaction %v: %v (%v raw events since the last one)\n",
+			time.Since(start).Round(time.Millisecond), reactions, e, n-seen)
+		seen = n
+	}
+	fmt.Printf("%v raw events, %v reactions\n", raw.Load(), reactions)
+}
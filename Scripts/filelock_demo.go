@@ -0,0 +1,176 @@
+//go:build unix
+
+/*
+Two processes sharing a log file through an advisory lock.
+
+The parent starts two copies of this program as worker processes. Each worker appends
+"begin" and "end" records for every round with a sleep in between, holding the
+exclusive lock for the whole round. Every few rounds a worker also takes the shared
+lock to count the records so far, upgrades to exclusive to append a checkpoint and
+downgrades again to read it back. flock converts neither way atomically, the other
+worker can get in during either conversion.
+
+When everyone is done the parent reads the log: between a worker's begin and end there
+must be nothing from the other worker. Run with -nolock to see what happens without it.
+
+usage: go run Scripts/filelock_demo.go -rounds 20 [-nolock]
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/filelock"
+)
+
+func appendLine(path, line string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		panic(err)
+	}
+	// two writes per record on purpose, makes unprotected interleaving easy to hit
+	fmt.Fprint(f, line)
+	time.Sleep(time.Millisecond)
+	fmt.Fprintln(f)
+	f.Close()
+}
+
+func countLines(path string) int {
+	data, _ := os.ReadFile(path)
+	return strings.Count(string(data), "\n")
+}
+
+func worker(id, logPath string, rounds int, nolock bool) {
+	lock, err := filelock.Open(logPath + ".lock")
+	if err != nil {
+		panic(err)
+	}
+	defer lock.Close()
+
+	for i := 0; i < rounds; i++ {
+		if !nolock {
+			if err := lock.LockTimeout(filelock.Exclusive, 5*time.Second); err != nil {
+				fmt.Fprintf(os.Stderr, "worker %v: %v\n", id, err)
+				os.Exit(1)
+			}
+		}
+		appendLine(logPath, fmt.Sprintf("%v begin %d", id, i))
+		time.Sleep(2 * time.Millisecond)
+		appendLine(logPath, fmt.Sprintf("%v end %d", id, i))
+		if !nolock {
+			lock.Unlock()
+		}
+
+		if nolock || i%5 != 4 {
+			continue
+		}
+		if err := lock.RLock(); err != nil {
+			fmt.Fprintf(os.Stderr, "worker %v: %v\n", id, err)
+			os.Exit(1)
+		}
+		seen := countLines(logPath)
+		if err := lock.Upgrade(5 * time.Second); err != nil {
+			fmt.Fprintf(os.Stderr, "worker %v: %v\n", id, err)
+			os.Exit(1)
+		}
+		// the upgrade isn't atomic, the count taken under the shared lock may be stale
+		now := countLines(logPath)
+		appendLine(logPath, fmt.Sprintf("%v checkpoint saw=%d now=%d", id, seen, now))
+		if err := lock.Downgrade(); err != nil {
+			fmt.Fprintf(os.Stderr, "worker %v: %v\n", id, err)
+			os.Exit(1)
+		}
+		// neither is the downgrade, the other worker may have written a round meanwhile
+		fmt.Printf("worker %v: checkpoint written, log has %v lines\n", id, countLines(logPath))
+		lock.Unlock()
+	}
+}
+
+// verify checks that no record of another worker sits between a begin and its end.
+func verify(logPath string) (records, violations int) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	open := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		records++
+		if len(fields) < 3 {
+			// torn line, two writers got mixed up inside a single record
+			violations++
+			continue
+		}
+		id, kind := fields[0], fields[1]
+		switch {
+		case open != "" && id != open, kind != "begin" && kind != "end" && kind != "checkpoint":
+			violations++
+		case kind == "begin":
+			open = id
+		case kind == "end":
+			open = ""
+		}
+	}
+	return records, violations
+}
+
+func main() {
+	role := flag.String("role", "parent", "parent or worker (workers are started by the parent)")
+	id := flag.String("id", "", "worker id")
+	logPath := flag.String("log", "", "shared log file, a temp file by default")
+	rounds := flag.Int("rounds", 20, "rounds per worker")
+	nolock := flag.Bool("nolock", false, "don't take the lock")
+	flag.Parse()
+
+	if *role == "worker" {
+		worker(*id, *logPath, *rounds, *nolock)
+		return
+	}
+
+	if *logPath == "" {
+		dir, err := os.MkdirTemp("", "filelock")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(dir)
+		*logPath = filepath.Join(dir, "shared.log")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+
+	var cmds []*exec.Cmd
+	for _, w := range []string{"A", "B"} {
+		cmd := exec.Command(self, "-role", "worker", "-id", w, "-log", *logPath,
+			"-rounds", fmt.Sprint(*rounds), fmt.Sprintf("-nolock=%v", *nolock))
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			panic(err)
+		}
+		fmt.Printf("started worker %v (pid %v)\n", w, cmd.Process.Pid)
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			fmt.Println("worker failed:", err)
+		}
+	}
+
+	records, violations := verify(*logPath)
+	fmt.Printf("log has %v records, %v interleaving violations\n", records, violations)
+	if violations > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Shell for the in-memory file system simulator (package fssim).
+
+	mkdir <path>            create a directory
+	write <path> <text>     create/truncate a file and write text into it
+	append <path> <text>    append text to a file
+	fill <path> <bytes>     append that many bytes of filler, handy to grow files
+	read <path>             print a file
+	ls [path]               list a directory
+	rm <path>               remove a file or an empty directory
+	stat <path>             show the inode and its blocks
+	open <path>             open a file and keep the descriptor around
+	dup <fd> / close <fd>   duplicate / close a descriptor
+	fds                     show the fd table and the system wide open file table
+	df                      space usage and fragmentation
+	map                     the disk block by block
+
+Pick the allocator with -alloc and compare what df and map say after the same
+commands. -demo runs a canned session that creates, grows and deletes files so the
+free space gets fragmented.
+
+usage: go run Scripts/fssim_shell.go -alloc contiguous|linked|indexed|fat [-demo]
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/neilharia7/operating-systems-with-go/fssim"
+)
+
+const demo = `mkdir /docs
+write /docs/a hello from file a
+fill /docs/a 300
+write /docs/b second file
+fill /docs/b 200
+write /docs/c third
+fill /docs/c 400
+rm /docs/b
+fill /docs/a 300
+write /docs/d the fourth one
+fill /docs/d 250
+ls /docs
+stat /docs/a
+open /docs/c
+rm /docs/c
+fds
+df
+map
+close 0
+df
+map`
+
+type shell struct {
+	fs   *fssim.FS
+	proc *fssim.Process
+}
+
+func (sh *shell) writeFile(path, text string, truncate bool) error {
+	fd, err := sh.proc.Open(path, true, truncate)
+	if err != nil {
+		return err
+	}
+	defer sh.proc.Close(fd)
+	if !truncate {
+		st, err := sh.fs.Stat(path)
+		if err != nil {
+			return err
+		}
+		sh.proc.Seek(fd, st.Size)
+	}
+	_, err = sh.proc.Write(fd, []byte(text))
+	return err
+}
+
+func (sh *shell) run(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	cmd, args := fields[0], fields[1:]
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	rest := func(i int) string {
+		if i < len(args) {
+			return strings.Join(args[i:], " ")
+		}
+		return ""
+	}
+
+	switch cmd {
+	case "mkdir":
+		return sh.fs.Mkdir(arg(0))
+	case "write":
+		return sh.writeFile(arg(0), rest(1), true)
+	case "append":
+		return sh.writeFile(arg(0), rest(1), false)
+	case "fill":
+		n, err := strconv.Atoi(arg(1))
+		if err != nil || n < 0 {
+			return fmt.Errorf("fill: bad size %q", arg(1))
+		}
+		return sh.writeFile(arg(0), strings.Repeat("x", n), false)
+	case "read", "cat":
+		fd, err := sh.proc.Open(arg(0), false, false)
+		if err != nil {
+			return err
+		}
+		defer sh.proc.Close(fd)
+		st, _ := sh.fs.Stat(arg(0))
+		data, err := sh.proc.Read(fd, st.Size)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "ls":
+		p := arg(0)
+		if p == "" {
+			p = "/"
+		}
+		entries, err := sh.fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			kind := "-"
+			if e.Dir {
+				kind = "d"
+			}
+			fmt.Printf("%v %4d %6dB %3d blocks  %v\n", kind, e.Inode, e.Size, e.Blocks, e.Name)
+		}
+	case "rm":
+		return sh.fs.Remove(arg(0))
+	case "stat":
+		st, err := sh.fs.Stat(arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("inode %v dir=%v size=%v data blocks=%v", st.Num, st.Dir, st.Size, st.Blocks)
+		if len(st.Index) > 0 {
+			fmt.Printf(" index blocks=%v", st.Index)
+		}
+		if st.Relocations > 0 {
+			fmt.Printf(" relocated %v times", st.Relocations)
+		}
+		fmt.Println()
+	case "open":
+		fd, err := sh.proc.Open(arg(0), false, false)
+		if err != nil {
+			return err
+		}
+		fmt.Println("fd", fd)
+	case "dup", "close":
+		fd, err := strconv.Atoi(arg(0))
+		if err != nil {
+			return fmt.Errorf("%v: bad fd %q", cmd, arg(0))
+		}
+		if cmd == "close" {
+			return sh.proc.Close(fd)
+		}
+		nfd, err := sh.proc.Dup(fd)
+		if err != nil {
+			return err
+		}
+		fmt.Println("fd", nfd)
+	case "fds":
+		fmt.Println("fd table:", sh.proc.Descriptors())
+		for i, of := range sh.fs.OpenFiles() {
+			fmt.Printf("open file %d: inode %v offset %v refs %v\n", i, of.Inode, of.Offset, of.Refs)
+		}
+	case "df":
+		fmt.Println(sh.fs.Usage())
+	case "map":
+		m := sh.fs.BlockMap()
+		for len(m) > 64 {
+			fmt.Println(m[:64])
+			m = m[64:]
+		}
+		fmt.Println(m)
+	case "help":
+		fmt.Println("mkdir write append fill read ls rm stat open dup close fds df map exit")
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+func main() {
+	allocName := flag.String("alloc", "contiguous", "contiguous, linked, indexed or fat")
+	blocks := flag.Int("blocks", 128, "disk size in blocks")
+	blockSize := flag.Int("block-size", 64, "block size in bytes")
+	runDemo := flag.Bool("demo", false, "run the canned demo session")
+	flag.Parse()
+
+	alloc, err := fssim.NewAllocator(*allocName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fs := fssim.New(alloc, *blocks, *blockSize)
+	sh := &shell{fs: fs, proc: fs.NewProcess()}
+
+	if *runDemo {
+		for _, line := range strings.Split(demo, "\n") {
+			fmt.Println("fs>", line)
+			if err := sh.run(line); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	for fmt.Print("fs> "); in.Scan(); fmt.Print("fs> ") {
+		line := strings.TrimSpace(in.Text())
+		if line == "exit" || line == "quit" {
+			return
+		}
+		if err := sh.run(line); err != nil {
+			fmt.Println(err)
+		}
+	}
+	fmt.Println()
+}
@@ -0,0 +1,123 @@
+/*
+Happens-before graphs for tiny programs.
+
+Each scenario records its reads, writes and synchronisation into an event log, then
+the happens-before relation is computed with vector clocks and printed as a graphviz
+graph. Two accesses to the same variable from different goroutines that aren't ordered
+(and at least one is a write) are a data race, they show up as red edges.
+
+	racy    - two goroutines increment a counter without a lock
+	mutex   - same thing with a mutex, unlock -> lock edges order everything
+	channel - message passing, the send -> recv edge orders the write of data
+
+usage: go run Scripts/happens_before.go -scenario racy | dot -Tpng > hb.png
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/neilharia7/operating-systems-with-go/eventlog"
+)
+
+func racy(log *eventlog.Log) {
+	var wg sync.WaitGroup
+	counter := 0
+	inc := func(actor string) {
+		defer wg.Done()
+		for i := 0; i < 2; i++ {
+			log.Read(actor, "counter")
+			v := counter
+			log.Write(actor, "counter")
+			counter = v + 1
+		}
+	}
+	wg.Add(2)
+	log.Spawn("main", "g1")
+	go inc("g1")
+	log.Spawn("main", "g2")
+	go inc("g2")
+	wg.Wait()
+}
+
+func mutex(log *eventlog.Log) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counter := 0
+	inc := func(actor string) {
+		defer wg.Done()
+		for i := 0; i < 2; i++ {
+			mu.Lock()
+			log.Acquire(actor, "mu")
+			log.Read(actor, "counter")
+			log.Write(actor, "counter")
+			counter++
+			log.Release(actor, "mu")
+			mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	log.Spawn("main", "g1")
+	go inc("g1")
+	log.Spawn("main", "g2")
+	go inc("g2")
+	wg.Wait()
+}
+
+func channel(log *eventlog.Log) {
+	var wg sync.WaitGroup
+	data := 0
+	ready := make(chan struct{})
+	wg.Add(2)
+	log.Spawn("main", "producer")
+	go func() {
+		defer wg.Done()
+		log.Write("producer", "data")
+		data = 42
+		log.Send("producer", "ready")
+		ready <- struct{}{}
+	}()
+	log.Spawn("main", "consumer")
+	go func() {
+		defer wg.Done()
+		<-ready
+		log.Recv("consumer", "ready")
+		log.Read("consumer", "data")
+		_ = data
+	}()
+	wg.Wait()
+}
+
+func main() {
+	scenario := flag.String("scenario", "racy", "racy, mutex or channel")
+	flag.Parse()
+
+	scenarios := map[string]func(*eventlog.Log){
+		"racy":    racy,
+		"mutex":   mutex,
+		"channel": channel,
+	}
+	run, ok := scenarios[*scenario]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown scenario %q\n", *scenario)
+		os.Exit(1)
+	}
+
+	log := eventlog.New()
+	run(log)
+	g := eventlog.Build(log.Events())
+
+	// the summary goes to stderr so stdout can be piped straight into dot
+	fmt.Fprintf(os.Stderr, "%v events, %v possible races\n", len(g.Events), len(g.Races))
+	for _, r := range g.Races {
+		fmt.Fprintf(os.Stderr, "  race: #%d %v  <->  #%d %v\n", r.A.ID, r.A, r.B.ID, r.B)
+	}
+	if err := g.WriteDOT(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Hash every file under a directory, sha256sum style, with a pool of workers.
+
+The walk feeds file paths into parallel.Map, the workers hash them concurrently and
+the output still comes out in walk order, identical to what a sequential run prints,
+so the two can be diffed:
+
+	go run Scripts/hash_files.go -workers 1 . > seq.txt
+	go run Scripts/hash_files.go -workers 8 . > par.txt
+	diff seq.txt par.txt
+
+Timing is printed to stderr so it doesn't get in the way of the diff.
+
+usage: go run Scripts/hash_files.go [-workers 8] [-window 64] <dir>
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/parallel"
+)
+
+type digest struct {
+	path string
+	sum  []byte
+	size int64
+	err  error
+}
+
+func hashFile(path string) digest {
+	d := digest{path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	defer f.Close()
+	h := sha256.New()
+	d.size, d.err = io.Copy(h, f)
+	d.sum = h.Sum(nil)
+	return d
+}
+
+func main() {
+	workers := flag.Int("workers", runtime.NumCPU(), "number of hashing goroutines")
+	window := flag.Int("window", 0, "max files in flight, 0 means 2*workers")
+	flag.Parse()
+	root := "."
+	if flag.NArg() > 0 {
+		root = flag.Arg(0)
+	}
+
+	paths := make(chan string)
+	go func() {
+		defer close(paths)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return nil
+			}
+			if d.Type().IsRegular() {
+				paths <- path
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+
+	start := time.Now()
+	var files, bytes int64
+	failed := false
+	for d := range parallel.Map(paths, *workers, *window, hashFile) {
+		if d.err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", d.path, d.err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%x  %v\n", d.sum, d.path)
+		files++
+		bytes += d.size
+	}
+	elapsed := time.Since(start)
+	fmt.Fprintf(os.Stderr, "hashed %v files, %.1f MB in %v with %v workers (%.1f MB/s)\n",
+		files, float64(bytes)/1e6, elapsed, *workers, float64(bytes)/1e6/elapsed.Seconds())
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Protecting an HTTP server from overload with the httpmw middleware.
+
+The handler burns -work of CPU per request, so the server can do roughly
+GOMAXPROCS / work requests per second. The load generator sends requests in open
+loop at -rate, well above that, and measures every request from the moment it was
+due (see loadgen):
+
+	off - no protection. Every request is accepted and they all share the CPU, so
+	      every request gets slower and slower and the backlog never drains.
+	on  - httpmw.Limiter admits -limit requests at once, queues up to -queue more
+	      for at most -queue-wait and answers the rest with 503 right away, plus
+	      httpmw.Timeout as a backstop. The server does the same amount of useful
+	      work, but the requests it serves stay fast and the rejected ones learn it
+	      right away instead of after a client timeout.
+
+On a machine with few cores the load generator competes with the server for the
+CPU, so the absolute numbers suffer, the difference between off and on doesn't.
+
+usage: go run Scripts/http_limits.go -protect on|off -rate 1000 -duration 3s
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+	"github.com/neilharia7/operating-systems-with-go/httpmw"
+	"github.com/neilharia7/operating-systems-with-go/loadgen"
+)
+
+// burn keeps a CPU busy for d, checking ctx now and then so a timed out request
+// stops wasting CPU.
+func burn(ctx context.Context, d time.Duration) bool {
+	end := time.Now().Add(d)
+	x := 0
+	for i := 0; time.Now().Before(end); i++ {
+		for j := 0; j < 1000; j++ {
+			x += j * i
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	_ = x
+	return true
+}
+
+func main() {
+	protect := flag.String("protect", "on", "on or off")
+	rate := flag.Float64("rate", 1000, "requests per second offered")
+	duration := flag.Duration("duration", 3*time.Second, "how long to generate load")
+	work := flag.Duration("work", 2*time.Millisecond, "CPU time per request")
+	limit := flag.Int("limit", runtime.GOMAXPROCS(0), "requests handled at once (on)")
+	queue := flag.Int("queue", 32, "requests allowed to wait for a slot (on)")
+	queueWait := flag.Duration("queue-wait", 50*time.Millisecond, "longest wait for a slot (on)")
+	timeout := flag.Duration("timeout", time.Second, "request timeout, server side (on) and client side")
+	flag.Parse()
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !burn(r.Context(), *work) {
+			return
+		}
+		io.WriteString(w, "ok\n")
+	}))
+	var limiter *httpmw.Limiter
+	switch *protect {
+	case "off":
+	case "on":
+		limiter = httpmw.NewLimiter(*limit, *queue, *queueWait)
+		handler = httpmw.Chain(handler, limiter.Handler, httpmw.Timeout(*timeout))
+	default:
+		fmt.Printf("unknown -protect %q\n", *protect)
+		os.Exit(1)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	defer srv.Close()
+	url := "http://" + ln.Addr().String() + "/"
+
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: 1024, MaxConnsPerHost: 0},
+	}
+	served := histogram.New()
+	rejectedLat := histogram.New()
+	var ok, rejected, failed atomic.Int64
+	request := func(ctx context.Context, worker int) error {
+		start := time.Now()
+		resp, err := client.Get(url)
+		if err != nil {
+			failed.Add(1)
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			ok.Add(1)
+			served.Record(time.Since(start))
+			return nil
+		case http.StatusServiceUnavailable:
+			rejected.Add(1)
+			rejectedLat.Record(time.Since(start))
+			return errors.New("rejected")
+		}
+		failed.Add(1)
+		return fmt.Errorf("status %v", resp.StatusCode)
+	}
+
+	fmt.Printf("protect=%v rate=%v/s work=%v GOMAXPROCS=%v (capacity about %.0f req/s)\n",
+		*protect, *rate, *work, runtime.GOMAXPROCS(0), float64(runtime.GOMAXPROCS(0))/work.Seconds())
+	rep, err := loadgen.Run(context.Background(), loadgen.Config{
+		Mode:        loadgen.Open,
+		Concurrency: 2000,
+		Rate:        *rate,
+		Duration:    *duration,
+	}, request)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("ok=%v rejected=%v failed/timed out=%v, goodput %.0f req/s\n",
+		ok.Load(), rejected.Load(), failed.Load(), float64(ok.Load())/rep.Elapsed.Seconds())
+	if limiter != nil {
+		fmt.Println("limiter:", limiter.Stats())
+	}
+	fmt.Print("latency of everything, from when it was due: ")
+	rep.Latency.WriteText(os.Stdout)
+	fmt.Print("round trip of served requests: ")
+	served.Snapshot().WriteText(os.Stdout)
+	if rejected.Load() > 0 {
+		fmt.Print("round trip of rejected requests: ")
+		rejectedLat.Snapshot().WriteText(os.Stdout)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+CPU-bound vs I/O-bound workloads and the "right" number of workers.
+
+The same number of tasks is run through worker pools of growing size:
+
+	cpu   - every task hashes a buffer with sha256 a bunch of times
+	sleep - every task sleeps, stands in for waiting on a network/disk
+	file  - every task reads a temp file from disk (mostly page cache, but still syscalls)
+
+CPU-bound work stops getting faster once there are as many workers as cores
+(GOMAXPROCS), more workers just add scheduling overhead. I/O-bound work keeps scaling
+way past the core count because the workers spend most of their time waiting, not
+running. That's why there's no single good pool size.
+
+usage: go run Scripts/io_vs_cpu_bound.go -tasks 256 -max-workers 128
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+func cpuTask(rounds int) func() {
+	buf := make([]byte, 4096)
+	return func() {
+		sum := sha256.Sum256(buf)
+		for i := 1; i < rounds; i++ {
+			sum = sha256.Sum256(sum[:])
+		}
+	}
+}
+
+func sleepTask(d time.Duration) func() {
+	return func() { time.Sleep(d) }
+}
+
+func fileTask(path string) func() {
+	return func() {
+		if _, err := os.ReadFile(path); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// run pushes tasks through a pool of the given size and returns the wall time.
+func run(workers, tasks int, task func()) time.Duration {
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				task()
+			}
+		}()
+	}
+	for i := 0; i < tasks; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	return time.Since(start)
+}
+
+func main() {
+	tasks := flag.Int("tasks", 256, "number of tasks per run")
+	maxWorkers := flag.Int("max-workers", 128, "largest pool size to try")
+	rounds := flag.Int("hash-rounds", 2000, "sha256 rounds per cpu task")
+	sleep := flag.Duration("sleep", 5*time.Millisecond, "sleep per sleep task")
+	fileSize := flag.Int("file-size", 1<<20, "size of the file read by file tasks")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "iocpu")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+	if err := os.WriteFile(path, make([]byte, *fileSize), 0o644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	workloads := []struct {
+		name string
+		task func()
+	}{
+		{"cpu", cpuTask(*rounds)},
+		{"sleep", sleepTask(*sleep)},
+		{"file", fileTask(path)},
+	}
+
+	fmt.Printf("GOMAXPROCS=%v tasks=%v\n\n", runtime.GOMAXPROCS(0), *tasks)
+	fmt.Printf("%-8s", "workers")
+	for _, w := range workloads {
+		fmt.Printf("%14s", w.name)
+	}
+	fmt.Println()
+
+	best := make([]int, len(workloads))
+	bestTime := make([]time.Duration, len(workloads))
+	for workers := 1; workers <= *maxWorkers; workers *= 2 {
+		fmt.Printf("%-8d", workers)
+		for i, w := range workloads {
+			d := run(workers, *tasks, w.task)
+			fmt.Printf("%14v", d.Round(time.Microsecond))
+			// only count it as better if it's a real (>5%) improvement, otherwise
+			// the noise keeps pushing the "optimum" to larger pools
+			if bestTime[i] == 0 || d < bestTime[i]*95/100 {
+				best[i], bestTime[i] = workers, d
+			}
+		}
+		fmt.Println()
+	}
+
+	fmt.Println()
+	for i, w := range workloads {
+		fmt.Printf("%-6s best pool size: %v (%v)\n", w.name, best[i], bestTime[i].Round(time.Microsecond))
+	}
+}
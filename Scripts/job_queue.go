@@ -0,0 +1,223 @@
+/*
+A worker service that can be killed at any moment without losing jobs.
+
+The parent enqueues -jobs jobs into a jobqueue and then keeps starting a worker
+service (this binary with -mode worker) until the queue is empty. Every worker service
+runs -workers goroutines that take a job, "process" it (sleep, then append the job id
+to a results file) and ack it. The parent SIGKILLs the service after a random time,
+mid-job, then starts a new one, which recovers the queue from its log and carries on.
+
+At the end every job must show up in the results file. Some show up twice: the
+service died after writing the result but before the ack made it to the log, so the
+job ran again after the restart. That's at-least-once delivery, the price of not
+being able to make "do the work" and "ack it" one atomic step.
+
+With -drain the last service isn't killed but sent SIGTERM after it's been running a
+while: it stops taking jobs, finishes the ones in flight and exits cleanly, and the
+next start finds no interrupted jobs.
+
+usage: go run Scripts/job_queue.go -jobs 500 -workers 8 -kills 5
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/jobqueue"
+	"github.com/neilharia7/operating-systems-with-go/wal"
+)
+
+func openQueue(dir string) (*jobqueue.Queue, jobqueue.Recovery) {
+	q, rec, err := jobqueue.Open(filepath.Join(dir, "jobs.wal"), wal.Options{Sync: wal.SyncAlways})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return q, rec
+}
+
+// worker is the service: process jobs until the queue is empty or SIGTERM asks it to
+// drain.
+func worker(dir string, workers int, work, ackDelay time.Duration) {
+	q, rec := openQueue(dir)
+	fmt.Printf("  [worker %v] recovered: %v pending, %v interrupted, %v log records, compacted: %v\n",
+		os.Getpid(), rec.Pending, rec.Interrupted, rec.Records, rec.Compacted)
+
+	results, err := os.OpenFile(filepath.Join(dir, "results"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM)
+	go func() {
+		<-term
+		fmt.Printf("  [worker %v] SIGTERM, draining\n", os.Getpid())
+		q.Drain(context.Background())
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				// idle for a moment means the queue is done, nobody else enqueues here
+				jctx, jcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+				j, err := q.Dequeue(jctx)
+				jcancel()
+				if err != nil {
+					return
+				}
+				time.Sleep(time.Duration(rand.Int63n(int64(work) + 1)))
+				// one write of the whole line, O_APPEND keeps concurrent lines intact
+				results.Write([]byte(fmt.Sprintf("%v\n", j.ID)))
+				// the window between doing the work and recording it, a kill in here
+				// makes the job run twice
+				time.Sleep(ackDelay)
+				if err := q.Ack(j.ID); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	cancel()
+	pending, _ := q.Len()
+	q.Close()
+	fmt.Printf("  [worker %v] exiting cleanly, %v jobs left\n", os.Getpid(), pending)
+}
+
+func readResults(path string) map[uint64]int {
+	seen := map[uint64]int{}
+	f, err := os.Open(path)
+	if err != nil {
+		return seen
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if id, err := strconv.ParseUint(s.Text(), 10, 64); err == nil {
+			seen[id]++
+		}
+	}
+	return seen
+}
+
+func parent(dir string, jobs, workers, kills int, work, ackDelay time.Duration, drain bool) {
+	q, _ := openQueue(dir)
+	for i := 0; i < jobs; i++ {
+		if _, err := q.Enqueue([]byte(fmt.Sprintf("job %v", i))); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	q.Close()
+	fmt.Printf("enqueued %v jobs\n", jobs)
+
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	for run := 1; ; run++ {
+		cmd := exec.Command(self, "-mode", "worker", "-dir", dir,
+			"-workers", strconv.Itoa(workers), "-work", work.String(), "-ack-delay", ackDelay.String())
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Start(); err != nil {
+			panic(err)
+		}
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		if run <= kills {
+			after := time.Duration(50+rand.Intn(150)) * time.Millisecond
+			sig := syscall.SIGKILL
+			if drain && run == kills {
+				sig = syscall.SIGTERM
+			}
+			select {
+			case err := <-exited:
+				fmt.Printf("run %v: service finished before it could be %v (%v)\n", run, sig, err)
+			case <-time.After(after):
+				cmd.Process.Signal(sig)
+				err := <-exited
+				fmt.Printf("run %v: service %v after %v (%v)\n", run, sig, after, err)
+				continue
+			}
+		} else if err := <-exited; err != nil {
+			var ee *exec.ExitError
+			if errors.As(err, &ee) {
+				fmt.Printf("run %v: worker failed: %v\n", run, err)
+				os.Exit(1)
+			}
+		}
+		// the service exited on its own, is there anything left?
+		q, rec := openQueue(dir)
+		q.Close()
+		if rec.Pending == 0 {
+			fmt.Printf("run %v: queue is empty\n", run)
+			break
+		}
+	}
+
+	seen := readResults(filepath.Join(dir, "results"))
+	missing, dup := 0, 0
+	for id := uint64(1); id <= uint64(jobs); id++ {
+		switch n := seen[id]; {
+		case n == 0:
+			missing++
+		case n > 1:
+			dup += n - 1
+		}
+	}
+	fmt.Printf("%v jobs, %v distinct results, %v duplicate runs (killed between the work and the ack)\n",
+		jobs, len(seen), dup)
+	if missing > 0 {
+		fmt.Printf("LOST %v jobs\n", missing)
+		os.Exit(1)
+	}
+	fmt.Println("every job ran at least once")
+}
+
+func main() {
+	mode := flag.String("mode", "parent", "parent, or worker (started by the parent)")
+	dir := flag.String("dir", "", "directory for the queue and results, a temp dir by default")
+	jobs := flag.Int("jobs", 500, "number of jobs to enqueue")
+	workers := flag.Int("workers", 8, "worker goroutines per service")
+	kills := flag.Int("kills", 5, "how many times the service is killed")
+	work := flag.Duration("work", 10*time.Millisecond, "max time a job takes")
+	ackDelay := flag.Duration("ack-delay", 2*time.Millisecond, "pause between writing a result and acking the job")
+	drain := flag.Bool("drain", false, "stop the last killed service with SIGTERM instead of SIGKILL")
+	flag.Parse()
+
+	if *mode == "worker" {
+		worker(*dir, *workers, *work, *ackDelay)
+		return
+	}
+	if *dir == "" {
+		d, err := os.MkdirTemp("", "jobqueue")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(d)
+		*dir = d
+	}
+	parent(*dir, *jobs, *workers, *kills, *work, *ackDelay, *drain)
+}
@@ -0,0 +1,152 @@
+/*
+Serializing requests per user with a keyed mutex.
+
+Every request does a read-modify-write on one user's record with some simulated I/O
+in the middle (load, think, store). Two requests for the same user must not overlap
+or one of the updates gets lost, requests for different users have no reason to wait
+for each other.
+
+	none    - no locking, fast and wrong: concurrent updates to the same user are lost
+	global  - one mutex for everything, correct but every request waits for every other
+	striped - keyedmutex with -stripes stripes and no per-key locks, users sharing a
+	          stripe wait for each other (the fewer stripes, the more often)
+	perkey  - keyedmutex with per-key locks, only requests for the same user serialize
+
+At the end every user's counter must equal the number of requests made for them.
+
+usage: go run Scripts/keyed_mutex.go -mode none|global|striped|perkey -users 50 -requests 2000 -workers 64
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/keyedmutex"
+)
+
+type store struct {
+	mu      sync.Mutex
+	records map[string]int
+}
+
+func (s *store) load(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[user]
+}
+
+func (s *store) save(user string, v int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[user] = v
+}
+
+type locker interface {
+	Lock(key string)
+	Unlock(key string)
+}
+
+type noLock struct{}
+
+func (noLock) Lock(string)   {}
+func (noLock) Unlock(string) {}
+
+type globalLock struct{ sync.Mutex }
+
+func (g *globalLock) Lock(string)   { g.Mutex.Lock() }
+func (g *globalLock) Unlock(string) { g.Mutex.Unlock() }
+
+func main() {
+	mode := flag.String("mode", "perkey", "none, global, striped or perkey")
+	users := flag.Int("users", 50, "number of distinct users")
+	requests := flag.Int("requests", 2000, "total number of requests")
+	workers := flag.Int("workers", 64, "concurrent request handlers")
+	stripes := flag.Int("stripes", 8, "stripes for the keyed mutex")
+	work := flag.Duration("work", time.Millisecond, "simulated I/O between load and store")
+	flag.Parse()
+
+	var km *keyedmutex.Mutex
+	var l locker
+	switch *mode {
+	case "none":
+		l = noLock{}
+	case "global":
+		l = &globalLock{}
+	case "striped":
+		km = keyedmutex.New(*stripes, false)
+		l = km
+	case "perkey":
+		km = keyedmutex.New(*stripes, true)
+		l = km
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	names := make([]string, *users)
+	for i := range names {
+		names[i] = fmt.Sprintf("user%03d", i)
+	}
+	expected := make(map[string]int)
+	jobs := make([]string, *requests)
+	for i := range jobs {
+		jobs[i] = names[rand.Intn(len(names))]
+		expected[jobs[i]]++
+	}
+
+	db := &store{records: make(map[string]int)}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	start := time.Now()
+	wg.Add(*workers)
+	for w := 0; w < *workers; w++ {
+		go func() {
+			defer wg.Done()
+			for user := range queue {
+				l.Lock(user)
+				v := db.load(user)
+				time.Sleep(*work)
+				db.save(user, v+1)
+				l.Unlock(user)
+			}
+		}()
+	}
+	for _, user := range jobs {
+		queue <- user
+	}
+	close(queue)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	lost := 0
+	for user, want := range expected {
+		lost += want - db.records[user]
+	}
+	fmt.Printf("mode=%v users=%v requests=%v workers=%v in %v (%.0f req/s)\n",
+		*mode, *users, *requests, *workers, elapsed.Round(time.Millisecond), float64(*requests)/elapsed.Seconds())
+	if km != nil {
+		// how unevenly users landed on stripes, the worst stripe is the bottleneck in
+		// striped mode
+		perStripe := make(map[int]int)
+		for _, n := range names {
+			perStripe[km.Stripe(n)]++
+		}
+		most := 0
+		for _, c := range perStripe {
+			most = max(most, c)
+		}
+		fmt.Printf("%v users on %v stripes, busiest stripe has %v users, entries left: %v\n",
+			*users, *stripes, most, km.Keys())
+	}
+	if lost > 0 {
+		fmt.Printf("LOST UPDATES: %v of %v increments disappeared\n", lost, *requests)
+		os.Exit(1)
+	}
+	fmt.Println("every update accounted for")
+}
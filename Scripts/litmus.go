@@ -0,0 +1,51 @@
+/*
+Memory model litmus tests.
+
+Runs each litmus test from the memmodel package with plain (racy) accesses and with
+sync/atomic, and prints how often every outcome was observed. The plain runs are data
+races on purpose, don't run this one under -race.
+
+usage: go run Scripts/litmus.go -test sb -n 200000
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/neilharia7/operating-systems-with-go/memmodel"
+)
+
+func main() {
+	name := flag.String("test", "all", "litmus test to run (mp, sb, corr or all)")
+	n := flag.Int("n", 200000, "iterations per test")
+	flag.Parse()
+
+	if runtime.GOMAXPROCS(0) < 3 {
+		fmt.Println("warning: litmus tests need at least 3 procs to be interesting")
+	}
+
+	tests := memmodel.Tests()
+	if *name != "all" {
+		t, ok := memmodel.Lookup(*name)
+		if !ok {
+			fmt.Printf("unknown test %q\n", *name)
+			os.Exit(1)
+		}
+		tests = []memmodel.Test{t}
+	}
+
+	for _, t := range tests {
+		for _, useAtomics := range []bool{false, true} {
+			r := memmodel.Run(t, *n, useAtomics)
+			fmt.Print(r)
+			if useAtomics && r.ForbiddenCount() > 0 {
+				fmt.Println("  !! forbidden outcome observed with atomics, that's a bug")
+			}
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Backpressure in a logging pipeline.
+
+-handlers goroutines play a web server: every request takes -work and logs an access
+line (and, now and then, a debug line) through a logpipe.Logger, which queues lines
+for one writer goroutine that writes them in batches, fsyncs every -sync-every and
+rotates the file at -max-size. The disk is made to stall now and then with the chaos
+flags (by default 5% of writes take up to 100ms).
+
+When a stall lasts longer than the queue can absorb, the queue fills up and the
+policy decides who pays:
+
+	block - the handlers wait in Log. Nothing is lost, but the disk's stalls show up
+	        in the request latency: the p99 and max are the disk's, not the work's.
+	drop  - Log never waits. Request latency stays that of the work, the lines that
+	        didn't fit are counted and a "dropped N lines" marker in the file marks
+	        the gap.
+
+After each run the files are read back and every line is accounted for: written, or
+dropped and noted in a marker.
+
+usage: go run Scripts/log_pipeline.go -policy block|drop|all -duration 2s
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+	"github.com/neilharia7/operating-systems-with-go/logpipe"
+)
+
+var paths = []string{"/", "/login", "/api/items", "/api/items/42", "/api/orders", "/static/app.js"}
+
+type options struct {
+	handlers  int
+	duration  time.Duration
+	work      time.Duration
+	queue     int
+	syncEvery time.Duration
+	maxSize   int64
+	maxFiles  int
+	chaos     chaos.Config
+}
+
+// count reads back the live file and the rotated ones, returning the log lines and
+// the drops noted in markers.
+func count(path string, maxFiles int) (lines, noted int64, files int, err error) {
+	names := []string{path}
+	for i := 1; i <= maxFiles; i++ {
+		names = append(names, fmt.Sprintf("%v.%d", path, i))
+	}
+	for _, name := range names {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, 0, 0, err
+		}
+		files++
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var n int64
+			if _, err := fmt.Sscanf(sc.Text(), "logpipe: dropped %d lines", &n); err == nil {
+				noted += n
+				continue
+			}
+			lines++
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	return lines, noted, files, nil
+}
+
+func run(policy logpipe.Policy, o options) {
+	dir, err := os.MkdirTemp("", "logpipe")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+
+	inj := chaos.New(o.chaos)
+	l, err := logpipe.Open(logpipe.Config{
+		Path:      path,
+		Policy:    policy,
+		Queue:     o.queue,
+		SyncEvery: o.syncEvery,
+		MaxSize:   o.maxSize,
+		MaxFiles:  o.maxFiles,
+		Chaos:     inj,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	latency := histogram.New()
+	var requests, logged atomic.Int64
+	ctx, cancel := context.WithTimeout(context.Background(), o.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for h := 0; h < o.handlers; h++ {
+		wg.Add(1)
+		go func(h int) {
+			defer wg.Done()
+			rec := latency.NewRecorder()
+			rng := rand.New(rand.NewSource(int64(h)))
+			for req := 0; ctx.Err() == nil; req++ {
+				began := time.Now()
+				time.Sleep(o.work)
+				status := 200
+				if rng.Intn(50) == 0 {
+					status = 500
+				}
+				l.Log(ctx, fmt.Sprintf("%v handler=%d req=%d method=GET path=%v status=%d bytes=%d dur=%v",
+					began.Format(time.RFC3339Nano), h, req, paths[rng.Intn(len(paths))], status,
+					rng.Intn(4096), time.Since(began).Round(time.Microsecond)))
+				logged.Add(1)
+				if status != 200 || rng.Intn(10) == 0 {
+					l.Log(ctx, fmt.Sprintf("%v handler=%d req=%d debug: cache miss, backend took %v",
+						time.Now().Format(time.RFC3339Nano), h, req, time.Duration(rng.Intn(1000))*time.Microsecond))
+					logged.Add(1)
+				}
+				rec.Record(time.Since(began))
+				requests.Add(1)
+			}
+		}(h)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if err := l.Close(); err != nil {
+		fmt.Println("close:", err)
+	}
+
+	st := l.Stats()
+	fmt.Printf("%v: %v requests in %v (%.0f/s)\n", policy, requests.Load(), elapsed.Round(time.Millisecond),
+		float64(requests.Load())/elapsed.Seconds())
+	fmt.Print("  request latency: ")
+	latency.Snapshot().WriteText(os.Stdout)
+	fmt.Println("  pipeline:", st)
+	fmt.Print("  line latency to disk: ")
+	st.Latency.WriteText(os.Stdout)
+	if inj != nil {
+		fmt.Println("  disk stalls:", inj.Stats())
+	}
+
+	lines, noted, files, err := count(path, o.maxFiles)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// a line that never got a marker would be the lost kind of lost
+	fmt.Printf("  read back %v lines in %v files, %v drops noted in markers", lines, files, noted)
+	switch {
+	case st.Rotations > int64(o.maxFiles):
+		fmt.Printf(", older files rotated away\n")
+	case lines+noted == logged.Load() && noted == st.Dropped:
+		fmt.Printf(", all %v logged lines accounted for\n", logged.Load())
+	default:
+		fmt.Printf(", MISMATCH: %v logged\n", logged.Load())
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+func main() {
+	policy := flag.String("policy", "all", "block, drop or all")
+	o := options{}
+	flag.IntVar(&o.handlers, "handlers", 16, "request handling goroutines")
+	flag.DurationVar(&o.duration, "duration", 2*time.Second, "how long the server runs")
+	flag.DurationVar(&o.work, "work", 500*time.Microsecond, "time per request besides logging")
+	flag.IntVar(&o.queue, "queue", 256, "lines waiting for the writer")
+	flag.DurationVar(&o.syncEvery, "sync-every", 50*time.Millisecond, "fsync interval, 0 syncs every batch")
+	flag.Int64Var(&o.maxSize, "max-size", 2<<20, "rotate the log at this size")
+	flag.IntVar(&o.maxFiles, "max-files", 10, "rotated files kept")
+	cfg := chaos.RegisterFlags(flag.CommandLine)
+	// a disk that stalls now and then unless told otherwise
+	cfg.LatencyProb, cfg.MaxLatency = 0.05, 100*time.Millisecond
+	flag.Parse()
+	o.chaos = *cfg
+
+	var policies []logpipe.Policy
+	for _, name := range strings.Split(*policy, ",") {
+		if name == "all" {
+			policies = append(policies, logpipe.Block, logpipe.Drop)
+			continue
+		}
+		p, err := logpipe.ParsePolicy(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		policies = append(policies, p)
+	}
+	for _, p := range policies {
+		run(p, o)
+	}
+}
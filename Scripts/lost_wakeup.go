@@ -0,0 +1,84 @@
+/*
+Lost wakeup problem (sleep/wakeup race).
+
+A consumer checks a condition, sees it's false and goes to sleep. A producer makes the
+condition true and wakes the consumer up. If the producer's wakeup lands after the
+consumer checked but *before* it actually went to sleep, nobody is listening and the
+wakeup is lost: the consumer sleeps forever even though the condition is already true.
+
+	consumer                    producer
+	--------                    --------
+	lock; ok := ready; unlock
+	                            lock; ready = true; unlock
+	                            wakeup()   <- nobody is sleeping yet, dropped
+	sleep()                    <- sleeps forever
+
+buggy - flag + non blocking channel send as the "wakeup" (the check and the sleep are not atomic)
+cond  - sync.Cond: Wait() releases the mutex and goes to sleep atomically, and the
+        condition is re-checked in a loop, so the wakeup can't fall in the gap
+
+Both signals are in the wakeup package, its tests put the wakeup right into the gap
+and check that the flag hangs every time and the cond never does. The stress loop
+here runs many independent producer/consumer pairs and counts how many of them hang.
+A yield is placed inside the race window so the buggy version fails reliably.
+
+usage: go run Scripts/lost_wakeup.go -mode buggy|cond -iterations 1000
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/wakeup"
+)
+
+// gap widens the race window between checking the condition and going to sleep.
+func gap() {
+	runtime.Gosched()
+	time.Sleep(50 * time.Microsecond)
+}
+
+func main() {
+	mode := flag.String("mode", "buggy", "buggy or cond")
+	iterations := flag.Int("iterations", 1000, "number of producer/consumer rounds")
+	timeout := flag.Duration("timeout", 100*time.Millisecond, "how long to wait for a wakeup before calling it lost")
+	yield := flag.Bool("yield", true, "yield inside the race window to make the bug reproducible")
+	flag.Parse()
+
+	var hook func()
+	if *yield {
+		hook = gap
+	}
+	var signal func() wakeup.Signal
+	switch *mode {
+	case "buggy":
+		signal = func() wakeup.Signal { return &wakeup.Flag{Gap: hook} }
+	case "cond":
+		signal = func() wakeup.Signal {
+			c := wakeup.NewCond()
+			// the cond holds its lock in the gap, a yield there can't let the
+			// producer slip in between the check and the Wait
+			if *yield {
+				c.Gap = runtime.Gosched
+			}
+			return c
+		}
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	lost := wakeup.Stress(signal, *iterations, *timeout)
+
+	fmt.Printf("mode=%v rounds=%v lost wakeups=%v (%v)\n", *mode, *iterations, lost, time.Since(start))
+	if lost > 0 {
+		fmt.Printf("%v consumers are still asleep, their condition is true but nobody will wake them\n", lost)
+		os.Exit(2)
+	}
+}
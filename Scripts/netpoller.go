@@ -0,0 +1,164 @@
+//go:build linux
+
+/*
+Blocking syscalls vs the netpoller.
+
+When a goroutine makes a blocking syscall the runtime can't park just the goroutine,
+the whole OS thread (M) is stuck in the kernel. The scheduler hands the P to another
+thread so other goroutines keep running, which means every goroutine sitting in a
+blocking syscall costs one OS thread.
+
+Network I/O is different: sockets are non blocking and registered with the netpoller
+(epoll on linux). A goroutine waiting for data is parked like on a channel and the
+thread is free for other work, so thousands of idle connections need a handful of
+threads.
+
+	syscall - N goroutines each do a raw read(2) on a blocking pipe fd
+	net     - N goroutines each wait on a loopback TCP connection
+
+While they're waiting the thread count is sampled from /proc/self/status.
+
+usage: go run Scripts/netpoller.go -mode syscall -n 200
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// osThreads reads the "Threads:" line of /proc/self/status.
+func osThreads() string {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return "?"
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "Threads:"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return "?"
+}
+
+func sample(label string) {
+	fmt.Printf("%-10s goroutines=%-6d os threads=%v\n", label, runtime.NumGoroutine(), osThreads())
+}
+
+// blockingSyscalls parks n goroutines in read(2) and returns a func that wakes them.
+func blockingSyscalls(n int, wg *sync.WaitGroup) (release func(), err error) {
+	writers := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		var p [2]int
+		// syscall.Pipe gives plain blocking fds, unlike os.Pipe which goes
+		// through the netpoller
+		if err := syscall.Pipe(p[:]); err != nil {
+			return nil, err
+		}
+		writers = append(writers, p[1])
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			buf := make([]byte, 1)
+			syscall.Read(r, buf)
+			syscall.Close(r)
+		}(p[0])
+	}
+	return func() {
+		for _, w := range writers {
+			syscall.Write(w, []byte{1})
+			syscall.Close(w)
+		}
+	}, nil
+}
+
+// netConns parks n goroutines on reads from loopback TCP connections.
+func netConns(n int, wg *sync.WaitGroup) (release func(), err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	accepted := make(chan net.Conn, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 1)
+			c.Read(buf)
+			c.Close()
+		}()
+	}
+	return func() {
+		for i := 0; i < n; i++ {
+			c := <-accepted
+			c.Write([]byte{1})
+			c.Close()
+		}
+		ln.Close()
+	}, nil
+}
+
+func main() {
+	mode := flag.String("mode", "syscall", "syscall or net")
+	n := flag.Int("n", 200, "number of waiting goroutines")
+	flag.Parse()
+
+	var start func(int, *sync.WaitGroup) (func(), error)
+	switch *mode {
+	case "syscall":
+		start = blockingSyscalls
+	case "net":
+		start = netConns
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+
+	fmt.Printf("mode=%v n=%v GOMAXPROCS=%v\n", *mode, *n, runtime.GOMAXPROCS(0))
+	sample("before")
+
+	var wg sync.WaitGroup
+	release, err := start(*n, &wg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// give the runtime a moment to notice the blocked threads and spawn new ones
+	for i := 1; i <= 3; i++ {
+		time.Sleep(200 * time.Millisecond)
+		sample(fmt.Sprintf("waiting %d", i))
+	}
+
+	release()
+	wg.Wait()
+	sample("after")
+	if *mode == "syscall" {
+		fmt.Println("(threads created for blocking syscalls stay around idle, the runtime doesn't free them)")
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Dining philosophers as a discrete-event simulation.
+
+No goroutines and no sleeping: every philosopher is a chain of events on a des.Sim,
+thinking and eating times are drawn from a seeded random source and the simulated
+clock jumps from one event to the next. Hours of simulated dinner finish in
+milliseconds and the same seed always gives the same numbers, so strategies can be
+compared exactly.
+
+	naive     - pick up the left fork, then the right one. With a -pickup delay between
+	            the two every philosopher can end up holding one fork: deadlock. The
+	            simulation notices because the event queue runs dry, everybody waits
+	            for a fork and nobody will ever put one down.
+	hierarchy - pick up the lower numbered fork first, no cycle can form
+
+A philosopher waiting for a fork queues on it and gets it when it's put down (FIFO).
+Reported per philosopher: meals eaten and time spent hungry.
+
+usage: go run Scripts/philosophers_sim.go -strategy naive|hierarchy -n 5 -duration 1h -seed 1
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/des"
+)
+
+type waiter struct {
+	phil int
+	then func()
+}
+
+type fork struct {
+	holder  int // -1 when on the table
+	waiters []waiter
+}
+
+type philosopher struct {
+	id          int
+	meals       int
+	hungrySince time.Duration
+	hungry      time.Duration
+	maxHungry   time.Duration
+	state       string
+}
+
+type table struct {
+	sim      *des.Sim
+	rng      *rand.Rand
+	forks    []*fork
+	phils    []*philosopher
+	think    time.Duration
+	eat      time.Duration
+	pickup   time.Duration
+	ordered  bool
+	verbose  bool
+	handoffs int
+}
+
+// exp draws an exponentially distributed duration with the given mean.
+func (t *table) exp(mean time.Duration) time.Duration {
+	return time.Duration(t.rng.ExpFloat64() * float64(mean))
+}
+
+func (t *table) logf(format string, args ...any) {
+	if t.verbose {
+		fmt.Printf("%12v  %v\n", t.sim.Now(), fmt.Sprintf(format, args...))
+	}
+}
+
+func (t *table) acquire(f, phil int, then func()) {
+	k := t.forks[f]
+	if k.holder < 0 {
+		k.holder = phil
+		then()
+		return
+	}
+	t.logf("philosopher %v waits for fork %v (held by %v)", phil, f, k.holder)
+	k.waiters = append(k.waiters, waiter{phil, then})
+}
+
+func (t *table) release(f int) {
+	k := t.forks[f]
+	if len(k.waiters) == 0 {
+		k.holder = -1
+		return
+	}
+	w := k.waiters[0]
+	k.waiters = k.waiters[1:]
+	k.holder = w.phil
+	t.handoffs++
+	// hand it over as a new event rather than a nested call, so the releasing
+	// philosopher finishes its own step first
+	t.sim.After(0, w.then)
+}
+
+func (t *table) startThinking(p *philosopher) {
+	p.state = "thinking"
+	t.sim.After(t.exp(t.think), func() { t.getHungry(p) })
+}
+
+func (t *table) getHungry(p *philosopher) {
+	p.state = "hungry"
+	p.hungrySince = t.sim.Now()
+	left, right := p.id, (p.id+1)%len(t.forks)
+	first, second := left, right
+	if t.ordered && second < first {
+		first, second = second, first
+	}
+	t.logf("philosopher %v is hungry", p.id)
+	t.acquire(first, p.id, func() {
+		// the gap between the two pickups is what lets the naive version deadlock
+		t.sim.After(t.pickup, func() {
+			t.acquire(second, p.id, func() { t.startEating(p, first, second) })
+		})
+	})
+}
+
+func (t *table) startEating(p *philosopher, first, second int) {
+	p.state = "eating"
+	waited := t.sim.Now() - p.hungrySince
+	p.hungry += waited
+	if waited > p.maxHungry {
+		p.maxHungry = waited
+	}
+	p.meals++
+	t.logf("philosopher %v eats (waited %v)", p.id, waited)
+	t.sim.After(t.exp(t.eat), func() {
+		t.release(second)
+		t.release(first)
+		t.startThinking(p)
+	})
+}
+
+func main() {
+	strategy := flag.String("strategy", "hierarchy", "naive or hierarchy")
+	n := flag.Int("n", 5, "number of philosophers (and forks)")
+	duration := flag.Duration("duration", time.Hour, "simulated time to run for")
+	think := flag.Duration("think", time.Second, "mean thinking time")
+	eat := flag.Duration("eat", 500*time.Millisecond, "mean eating time")
+	pickup := flag.Duration("pickup", 100*time.Millisecond, "time between picking up the first and second fork")
+	seed := flag.Int64("seed", 1, "random seed, same seed same dinner")
+	verbose := flag.Bool("v", false, "print every event")
+	flag.Parse()
+
+	if *n < 2 {
+		fmt.Println("need at least 2 philosophers")
+		os.Exit(1)
+	}
+	t := &table{
+		sim:     des.New(),
+		rng:     rand.New(rand.NewSource(*seed)),
+		think:   *think,
+		eat:     *eat,
+		pickup:  *pickup,
+		verbose: *verbose,
+	}
+	switch *strategy {
+	case "naive":
+	case "hierarchy":
+		t.ordered = true
+	default:
+		fmt.Printf("unknown strategy %q\n", *strategy)
+		os.Exit(1)
+	}
+	for i := 0; i < *n; i++ {
+		t.forks = append(t.forks, &fork{holder: -1})
+		p := &philosopher{id: i}
+		t.phils = append(t.phils, p)
+		t.startThinking(p)
+	}
+
+	start := time.Now()
+	t.sim.Run(*duration)
+	wall := time.Since(start)
+	simulated := t.sim.Now()
+
+	fmt.Printf("strategy=%v philosophers=%v simulated %v in %v (%v events, %v fork handoffs)\n",
+		*strategy, *n, simulated, wall.Round(time.Microsecond), t.sim.Events(), t.handoffs)
+	fmt.Printf("%5v %8v %14v %14v %10v\n", "phil", "meals", "hungry total", "hungry max", "state")
+	for _, p := range t.phils {
+		fmt.Printf("%5v %8v %14v %14v %10v\n", p.id, p.meals,
+			p.hungry.Round(time.Millisecond), p.maxHungry.Round(time.Millisecond), p.state)
+	}
+	if t.sim.Pending() == 0 {
+		fmt.Printf("deadlock at %v: every philosopher holds one fork and waits for the other\n", simulated)
+		os.Exit(2)
+	}
+}
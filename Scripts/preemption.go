@@ -0,0 +1,116 @@
+/*
+Async preemption and tight loop starvation.
+
+A goroutine spinning in a loop without function calls, like the read/update loops in
+race_condition.go, never reaches a point where it would voluntarily give up the CPU.
+Since go 1.14 the runtime deals with it by preempting asynchronously: sysmon notices a
+goroutine running for more than ~10ms and sends its thread a signal. With
+GODEBUG=asyncpreemptoff=1 that's switched off and, on a single P, the spinner has the
+CPU to itself until it's done: every other goroutine starves.
+
+The measurement: a probe goroutine sleeps 1ms in a loop and records how late it wakes
+up (scheduler latency) while a spinner burns through -spin iterations on GOMAXPROCS=1.
+
+By default both variants are run as child processes (GODEBUG has to be set before the
+runtime starts) and compared.
+
+usage: go run Scripts/preemption.go [-spin 2000000000] [-variant on|off]
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+)
+
+// spin has no calls inside the loop, so there's no cooperative preemption point.
+//
+//go:noinline
+func spin(n int) int {
+	x := 0
+	for i := 0; i < n; i++ {
+		x += i ^ x
+	}
+	return x
+}
+
+var sink int
+
+func measure(iterations int) {
+	runtime.GOMAXPROCS(1)
+	lat := histogram.New()
+	rec := lat.NewRecorder()
+	var stop atomic.Bool
+	probeDone := make(chan struct{})
+
+	go func() {
+		defer close(probeDone)
+		for !stop.Load() {
+			want := time.Now().Add(time.Millisecond)
+			time.Sleep(time.Millisecond)
+			rec.Record(time.Since(want))
+		}
+	}()
+
+	// let the probe settle, then hog the only P
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	spinDone := make(chan struct{})
+	go func() {
+		sink = spin(iterations)
+		close(spinDone)
+	}()
+	<-spinDone
+	spun := time.Since(start)
+	time.Sleep(20 * time.Millisecond)
+	stop.Store(true)
+	<-probeDone
+
+	s := lat.Snapshot()
+	fmt.Printf("spin took %v, probe woke %v times, wakeup latency p50=%v p99=%v max=%v\n",
+		spun.Round(time.Millisecond), s.Count(), s.Percentile(50), s.Percentile(99), s.Max().Round(time.Microsecond))
+}
+
+func main() {
+	variant := flag.String("variant", "", "on or off runs one variant in this process, empty runs both as children")
+	iterations := flag.Int("spin", 2000000000, "iterations of the tight loop")
+	flag.Parse()
+
+	if *variant != "" {
+		preemptOff := strings.Contains(os.Getenv("GODEBUG"), "asyncpreemptoff=1")
+		if (*variant == "off") != preemptOff {
+			fmt.Println("variant and GODEBUG don't match, run without -variant")
+			os.Exit(1)
+		}
+		measure(*iterations)
+		return
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	for _, v := range []struct{ name, godebug string }{
+		{"on", "asyncpreemptoff=0"},
+		{"off", "asyncpreemptoff=1"},
+	} {
+		fmt.Printf("async preemption %-3v: ", v.name)
+		cmd := exec.Command(self, "-variant", v.name, "-spin", fmt.Sprint(*iterations))
+		cmd.Env = append(os.Environ(), "GODEBUG="+v.godebug)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	fmt.Println("\nwith preemption off the probe can't run at all until the spinner is done")
+}
@@ -1,30 +1,164 @@
+/*
+A gallery of data races, each next to its fix, the cases are in package races.
+
+	map-rw         - one goroutine reads a map while another updates it (the runtime
+	                 may kill the program: concurrent map read and map write)
+	check-then-act - "if balance >= amount { balance -= amount }" by many tellers
+	                 at once, the check is stale by the time they act
+	rmw            - counter++ is a read, an add and a write, increments get lost
+	lazy-init      - "if cfg == nil { cfg = load() }", loaded more than once
+	map-iter       - ranging over a map while another goroutine writes to it
+	slice-append   - two appends to the same slice write the same backing array
+	loop-capture   - goroutines started in a loop read the loop variable, which the
+	                 loop keeps changing (races pins the old loop semantics, one
+	                 variable for the whole loop)
+
+Run a case with -case, -variant racy or fixed (or both). A racy variant doesn't
+always misbehave visibly, especially on a single core, which is exactly why races are
+nasty: run it with go run -race to have the detector catch it every time.
+
+-verify does that for every case: it builds this file with -race, runs each racy
+variant expecting a race report (or the runtime's map crash) and each fixed variant
+expecting a clean run, and exits 1 if any of them doesn't behave. go test -race
+./races checks the same.
+
+usage: go run Scripts/race_condition.go -case all|<name> -variant racy|fixed|both
+       go run Scripts/race_condition.go -verify
+*/
+
 package main
 
-import "time"
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 
-// this code triggers race condition
+	"github.com/neilharia7/operating-systems-with-go/races"
+)
 
-// write function to read value
-func read(shared_map map[int]int) {
-	for {
-		var _ = shared_map[0]
+func run(c races.Case, which string) {
+	v := c.Fixed
+	if which == "racy" {
+		v = c.Racy
+		if c.Crashy {
+			fmt.Printf("%-15v racy : (the runtime may abort the program here)\n", c.Name)
+		}
+	}
+	bad, detail := v()
+	verdict := "ok"
+	if bad {
+		verdict = "WENT WRONG"
+	} else if which == "racy" {
+		verdict = "looked fine this time, still a race"
 	}
+	fmt.Printf("%-15v %-5v: %v, %v\n", c.Name, which, detail, verdict)
 }
 
-// write function to update value
-func update(shared_map map[int]int) {
-	for {
-		shared_map[0] = shared_map[0] + 1
+// verify builds this program with the race detector and checks every variant in
+// a child process of its own, a crashing racy variant only takes its child down.
+func verify(cases []races.Case) bool {
+	_, src, _, ok := runtime.Caller(0)
+	if !ok {
+		fmt.Println("can't find the source file")
+		return false
+	}
+	dir, err := os.MkdirTemp("", "race")
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "race_condition")
+	build := exec.Command("go", "build", "-race", "-o", bin, filepath.Base(src))
+	build.Dir = filepath.Dir(src)
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Printf("go build -race: %v\n%s", err, out)
+		return false
 	}
+
+	allOK := true
+	for _, c := range cases {
+		for _, which := range []string{"racy", "fixed"} {
+			var out bytes.Buffer
+			cmd := exec.Command(bin, "-case", c.Name, "-variant", which)
+			cmd.Stdout, cmd.Stderr = &out, &out
+			err := cmd.Run()
+			text := out.String()
+			raced := strings.Contains(text, "WARNING: DATA RACE")
+			crashed := strings.Contains(text, "concurrent map")
+			got := "clean"
+			switch {
+			case raced:
+				got = "race reported"
+			case crashed:
+				got = "runtime abort"
+			case err != nil:
+				got = "failed: " + err.Error()
+			}
+			good := got == "clean"
+			if which == "racy" {
+				good = raced || crashed
+			}
+			mark := "ok"
+			if !good {
+				mark = "UNEXPECTED"
+				allOK = false
+			}
+			fmt.Printf("%-15v %-5v -race: %-14v %v\n", c.Name, which, got, mark)
+		}
+	}
+	return allOK
 }
 
-// complete the main function as well.
 func main() {
-	shared_map := make(map[int]int)
-	shared_map[0] = 0
+	name := flag.String("case", "all", "case to run, all or one of the names from -list")
+	which := flag.String("variant", "both", "racy, fixed or both")
+	list := flag.Bool("list", false, "list the cases")
+	check := flag.Bool("verify", false, "run every case under the race detector and check the outcome")
+	flag.Parse()
 
-	go read(shared_map)
-	go update(shared_map)
+	cases := races.Gallery()
+	if *list {
+		for _, c := range cases {
+			fmt.Println(c.Name)
+		}
+		return
+	}
+	if *check {
+		if !verify(cases) {
+			os.Exit(1)
+		}
+		fmt.Println("every racy variant was caught, every fixed one ran clean")
+		return
+	}
 
-	time.Sleep(2 * time.Second)
+	var variants []string
+	switch *which {
+	case "racy", "fixed":
+		variants = []string{*which}
+	case "both":
+		variants = []string{"racy", "fixed"}
+	default:
+		fmt.Printf("unknown variant %q\n", *which)
+		os.Exit(1)
+	}
+	found := false
+	for _, c := range cases {
+		if *name != "all" && c.Name != *name {
+			continue
+		}
+		found = true
+		for _, v := range variants {
+			run(c, v)
+		}
+	}
+	if !found {
+		fmt.Printf("unknown case %q, see -list\n", *name)
+		os.Exit(1)
+	}
 }
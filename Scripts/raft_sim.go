@@ -0,0 +1,138 @@
+/*
+Raft in a box: leader election and log replication between goroutines.
+
+The nodes talk over channels that go through the chaos injector, so messages can be
+delayed and dropped (-chaos-latency, -chaos-drop). The scenario:
+
+	1. elect a leader and replicate a first batch of commands to everybody
+	2. partition the leader away together with one follower, a minority. The old
+	   leader still accepts commands but can never commit them, the majority side
+	   elects a new leader with a higher term and carries on
+	3. heal the partition: the old leader sees the higher term, steps down, and its
+	   uncommitted entries are overwritten by the new leader's log
+
+At the end the safety properties are checked: at most one leader per term, and no
+two nodes applied different commands at the same index.
+
+usage: go run Scripts/raft_sim.go -nodes 5 -chaos-latency 0.3 -chaos-max-latency 20ms -chaos-drop 0.05
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+	"github.com/neilharia7/operating-systems-with-go/raft"
+)
+
+func fail(format string, args ...any) {
+	fmt.Printf("FAILED: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func propose(c *raft.Cluster, leader int, prefix string, n int) int {
+	last := 0
+	for i := 1; i <= n; i++ {
+		idx, _, ok := c.Node(leader).Propose(fmt.Sprintf("%v-%v", prefix, i))
+		if !ok {
+			fail("n%v is no longer the leader", leader)
+		}
+		last = idx
+	}
+	return last
+}
+
+func status(c *raft.Cluster) {
+	for _, st := range c.Status() {
+		fmt.Println("   ", st)
+	}
+}
+
+func main() {
+	cfg := chaos.RegisterFlags(flag.CommandLine)
+	nodes := flag.Int("nodes", 5, "cluster size")
+	heartbeat := flag.Duration("heartbeat", 30*time.Millisecond, "leader heartbeat interval")
+	commands := flag.Int("commands", 10, "commands per batch")
+	verbose := flag.Bool("v", true, "print elections and step downs")
+	flag.Parse()
+	if *nodes < 3 {
+		fail("need at least 3 nodes for a partition to leave a majority")
+	}
+
+	start := time.Now()
+	logf := func(format string, args ...any) {
+		if *verbose {
+			fmt.Printf("  %6v  %v\n", time.Since(start).Round(time.Millisecond), fmt.Sprintf(format, args...))
+		}
+	}
+	c := raft.NewCluster(raft.Config{Nodes: *nodes, Heartbeat: *heartbeat, Seed: cfg.Seed, Chaos: chaos.New(*cfg), Logf: logf})
+	c.Start()
+	defer c.Stop()
+
+	all := make([]int, *nodes)
+	for i := range all {
+		all[i] = i
+	}
+	const wait = 5 * time.Second
+
+	fmt.Println("1. electing a leader")
+	leader, ok := c.WaitLeader(-1, wait)
+	if !ok {
+		fail("no leader elected")
+	}
+	idx := propose(c, leader, "a", *commands)
+	if !c.WaitCommit(idx, all, wait) {
+		fail("first batch not committed everywhere")
+	}
+	fmt.Printf("   n%v replicated %v commands to all %v nodes\n", leader, *commands, *nodes)
+	status(c)
+
+	old := leader
+	buddy := (old + 1) % *nodes
+	var majority []int
+	for _, id := range all {
+		if id != old && id != buddy {
+			majority = append(majority, id)
+		}
+	}
+	fmt.Printf("2. partitioning [n%v n%v] from %v\n", old, buddy, majority)
+	c.Network().Partition([]int{old, buddy}, majority)
+	lost := propose(c, old, "lost", *commands/2)
+	fmt.Printf("   old leader n%v accepted %v commands up to index %v, it can't reach a majority\n", old, *commands/2, lost)
+	leader, ok = c.WaitLeader(old, wait)
+	if !ok {
+		fail("majority side didn't elect a new leader")
+	}
+	idx = propose(c, leader, "b", *commands)
+	if !c.WaitCommit(idx, majority, wait) {
+		fail("majority didn't commit the second batch")
+	}
+	fmt.Printf("   new leader n%v committed %v more commands on the majority side\n", leader, *commands)
+	status(c)
+
+	fmt.Println("3. healing the partition")
+	c.Network().Heal()
+	idx = propose(c, leader, "c", 1)
+	if !c.WaitCommit(idx, all, wait) {
+		fail("cluster didn't converge after healing")
+	}
+	status(c)
+
+	applied := c.Node(old).Applied()
+	for _, e := range applied {
+		if len(e.Command) > 4 && e.Command[:4] == "lost" {
+			fail("n%v applied %v, which was never committed", old, e.Command)
+		}
+	}
+	sent, dropped, cut := c.Network().Stats()
+	fmt.Printf("network: %v messages sent, %v dropped, %v cut by the partition\n", sent, dropped, cut)
+	if err := c.Check(); err != nil {
+		fail("%v", err)
+	}
+	fmt.Printf("safety holds: one leader per term, all %v nodes applied the same %v entries, the %v lost commands are gone\n",
+		*nodes, len(applied), *commands/2)
+}
@@ -0,0 +1,269 @@
+/*
+Changing mode while holding a readers-writer lock, which sync.RWMutex can't do.
+
+	publish  - writers publish a new config version and read it back. With
+	           sync.RWMutex that's Unlock then RLock, and another writer can get in
+	           between: what's read back isn't what was written. rwlock's Downgrade
+	           turns the write lock into a read lock in one step, nothing gets in.
+	upgrade  - a cache filled on a miss: look under the read lock, upgrade on a
+	           miss and fill the key. Many goroutines miss at once, only one upgrade
+	           can be pending, the others fail, RUnlock, Lock and check again. Every
+	           key must be computed exactly once and nothing may deadlock.
+	deadlock - the upgrade done by hand with sync.RWMutex, RLock and then Lock, by two
+	           goroutines at once: each waits for the other's read lock forever.
+	           Then the same with TryUpgrade: one wins, the other backs off.
+
+The checks exit 1 when something's wrong (a write slipped in under rwlock, a key
+computed twice) and 2 when the upgrade stress doesn't finish (deadlock).
+
+usage: go run Scripts/rwlock_upgrade.go -mode publish|upgrade|deadlock|all
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/rwlock"
+)
+
+type config struct {
+	version int64
+	writer  int
+}
+
+// publish runs writers that publish and read back, returning how often the read
+// back found another writer's version.
+func publish(writers, rounds int, downgrade bool) int64 {
+	var std sync.RWMutex
+	var l rwlock.RWMutex
+	cfg := &config{}
+	var versions, slipped atomic.Int64
+	var stop atomic.Bool
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for !stop.Load() {
+				if downgrade {
+					l.RLock()
+					_ = cfg.version
+					l.RUnlock()
+				} else {
+					std.RLock()
+					_ = cfg.version
+					std.RUnlock()
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				v := versions.Add(1)
+				if downgrade {
+					l.Lock()
+					cfg = &config{version: v, writer: w}
+					l.Downgrade()
+				} else {
+					std.Lock()
+					cfg = &config{version: v, writer: w}
+					std.Unlock()
+					// the gap the other cores would use, a single core needs a nudge
+					runtime.Gosched()
+					std.RLock()
+				}
+				// let the others run, under Downgrade they can only read
+				runtime.Gosched()
+				if cfg.version != v {
+					slipped.Add(1)
+				}
+				if downgrade {
+					l.RUnlock()
+				} else {
+					std.RUnlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	stop.Store(true)
+	readers.Wait()
+	return slipped.Load()
+}
+
+func runPublish(writers, rounds int) {
+	fmt.Printf("publish: %v writers x %v versions, 4 readers\n", writers, rounds)
+	std := publish(writers, rounds, false)
+	fmt.Printf("  sync.RWMutex, Unlock then RLock: read back another writer's version %v times\n", std)
+	down := publish(writers, rounds, true)
+	fmt.Printf("  rwlock Downgrade:                read back another writer's version %v times\n", down)
+	if down != 0 {
+		fmt.Println("  FAIL: a writer got in during Downgrade")
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+func runUpgrade(workers, keys, ops int) {
+	fmt.Printf("upgrade: %v goroutines, %v lookups each over %v keys\n", workers, ops, keys)
+	var l rwlock.RWMutex
+	cache := map[int]int{}
+	computed := make([]atomic.Int64, keys)
+	var retried atomic.Int64
+	fill := func(k int) {
+		computed[k].Add(1)
+		cache[k] = k * k
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				rng := rand.New(rand.NewSource(int64(w)))
+				for i := 0; i < ops; i++ {
+					k := rng.Intn(keys)
+					l.RLock()
+					if v, ok := cache[k]; ok {
+						if v != k*k {
+							panic(fmt.Sprintf("key %v has %v", k, v))
+						}
+						l.RUnlock()
+						continue
+					}
+					runtime.Gosched() // more readers miss at the same time
+					if l.TryUpgrade() {
+						// nothing was written since the miss, it's still a miss
+						fill(k)
+						l.Unlock()
+						continue
+					}
+					// another upgrade is pending: back off and check again
+					l.RUnlock()
+					retried.Add(1)
+					l.Lock()
+					if _, ok := cache[k]; !ok {
+						fill(k)
+					}
+					l.Unlock()
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		fmt.Println("  FAIL: no progress for 10s, deadlock")
+		os.Exit(2)
+	}
+
+	st := l.Stats()
+	fmt.Printf("  upgrades=%v failed upgrades=%v (retried with Lock: %v)\n", st.Upgrades, st.FailedUpgrades, retried.Load())
+	for k := range computed {
+		if n := computed[k].Load(); n != 1 {
+			fmt.Printf("  FAIL: key %v computed %v times\n", k, n)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("  every one of the %v keys computed exactly once\n\n", keys)
+}
+
+func runDeadlock() {
+	fmt.Println("deadlock: two goroutines upgrade at once")
+	var std sync.RWMutex
+	var both sync.WaitGroup
+	both.Add(2)
+	stuck := make(chan struct{}, 2)
+	for g := 0; g < 2; g++ {
+		go func() {
+			std.RLock()
+			both.Done()
+			both.Wait()
+			std.Lock() // waits for the other reader, which waits for us
+			stuck <- struct{}{}
+			std.Unlock()
+		}()
+	}
+	select {
+	case <-stuck:
+		fmt.Println("  sync.RWMutex: got through?")
+	case <-time.After(500 * time.Millisecond):
+		fmt.Println("  sync.RWMutex, RLock then Lock: stuck, each holds a read lock the other waits for (left hanging)")
+	}
+
+	var l rwlock.RWMutex
+	var ready sync.WaitGroup
+	ready.Add(2)
+	var wg sync.WaitGroup
+	results := make(chan string, 2)
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			l.RLock()
+			ready.Done()
+			ready.Wait()
+			if l.TryUpgrade() {
+				results <- fmt.Sprintf("goroutine %v upgraded", g)
+				l.Unlock()
+				return
+			}
+			l.RUnlock()
+			l.Lock()
+			results <- fmt.Sprintf("goroutine %v failed to upgrade, released its read lock and got Lock", g)
+			l.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	close(results)
+	fmt.Println("  rwlock TryUpgrade:")
+	for r := range results {
+		fmt.Println("   ", r)
+	}
+	fmt.Println()
+}
+
+func main() {
+	mode := flag.String("mode", "all", "publish, upgrade, deadlock or all")
+	writers := flag.Int("writers", 4, "publishing writers")
+	rounds := flag.Int("rounds", 2000, "versions per writer")
+	workers := flag.Int("workers", 16, "cache goroutines")
+	keys := flag.Int("keys", 500, "cache keys")
+	ops := flag.Int("ops", 5000, "lookups per cache goroutine")
+	flag.Parse()
+
+	switch *mode {
+	case "publish":
+		runPublish(*writers, *rounds)
+	case "upgrade":
+		runUpgrade(*workers, *keys, *ops)
+	case "deadlock":
+		runDeadlock()
+	case "all":
+		runPublish(*writers, *rounds)
+		runUpgrade(*workers, *keys, *ops)
+		runDeadlock()
+	default:
+		fmt.Printf("unknown mode %q\n", *mode)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Goroutine-per-connection vs fixed worker pool server.
+
+Both servers speak the same toy protocol over TCP: the client sends a line, the server
+does a bit of work and echoes it back.
+
+	perconn - the accept loop starts a new goroutine for every connection (the
+	          go equivalent of thread-per-connection, but goroutines are cheap)
+	pool    - a fixed number of workers take connections off a bounded listener queue,
+	          when the queue is full the accept loop stops accepting (backpressure)
+
+The loadgen package drives -conns workers with a connection each, -reqs requests per
+connection, either closed loop (send, wait, send) or open loop at a fixed -rate.
+With more connections than pool workers the pool variant makes connections wait in
+the queue, which shows up in p99, while perconn pays in goroutines and memory.
+
+usage: go run Scripts/server_bench.go -conns 500 -workers 32
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/loadgen"
+)
+
+// handle serves one connection until the client hangs up.
+func handle(c net.Conn, work time.Duration) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		// a little bit of cpu plus a little bit of waiting, like a real handler
+		sha256.Sum256(line)
+		time.Sleep(work)
+		if _, err := c.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+func servePerConn(ln net.Listener, work time.Duration) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go handle(c, work)
+	}
+}
+
+func servePool(ln net.Listener, work time.Duration, workers, queue int) {
+	conns := make(chan net.Conn, queue)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for c := range conns {
+				handle(c, work)
+			}
+		}()
+	}
+	defer close(conns)
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// blocks when the queue is full, new connections pile up in the kernel backlog
+		conns <- c
+	}
+}
+
+// client keeps one connection per load generator worker, dialed on first use and
+// hung up after perConn requests, so the pool server gets its workers back.
+type client struct {
+	addr    string
+	perConn int
+	conns   []net.Conn
+	rd      []*bufio.Reader
+	sent    []int
+}
+
+func newClient(addr string, workers, perConn int) *client {
+	return &client{
+		addr:    addr,
+		perConn: perConn,
+		conns:   make([]net.Conn, workers),
+		rd:      make([]*bufio.Reader, workers),
+		sent:    make([]int, workers),
+	}
+}
+
+func (c *client) request(_ context.Context, worker int) error {
+	// the connect time counts towards the first request, a connection sitting
+	// in the pool's queue is latency the client sees
+	if c.conns[worker] == nil {
+		conn, err := net.Dial("tcp", c.addr)
+		if err != nil {
+			return err
+		}
+		c.conns[worker], c.rd[worker] = conn, bufio.NewReader(conn)
+	}
+	if _, err := c.conns[worker].Write([]byte("ping\n")); err != nil {
+		return err
+	}
+	if _, err := c.rd[worker].ReadBytes('\n'); err != nil {
+		return err
+	}
+	if c.sent[worker]++; c.sent[worker] == c.perConn {
+		c.conns[worker].Close()
+		c.conns[worker], c.sent[worker] = nil, 0
+	}
+	return nil
+}
+
+// hangup closes the worker's connection once it's done, an idle connection would
+// otherwise keep a pool worker busy forever.
+func (c *client) hangup(worker int) {
+	if c.conns[worker] != nil {
+		c.conns[worker].Close()
+		c.conns[worker] = nil
+	}
+}
+
+func main() {
+	mode := flag.String("mode", "both", "perconn, pool or both")
+	conns := flag.Int("conns", 200, "concurrent client connections")
+	reqs := flag.Int("reqs", 20, "requests per connection")
+	open := flag.Bool("open", false, "open loop load at -rate instead of closed loop")
+	rate := flag.Float64("rate", 5000, "requests per second in open loop mode")
+	workers := flag.Int("workers", 32, "pool size for the pool server")
+	queue := flag.Int("queue", 64, "listener queue length for the pool server")
+	work := flag.Duration("work", time.Millisecond, "time spent waiting per request")
+	flag.Parse()
+
+	modes := []string{*mode}
+	if *mode == "both" {
+		modes = []string{"perconn", "pool"}
+	}
+
+	fmt.Printf("load=%v loop conns=%v reqs/conn=%v work=%v pool workers=%v queue=%v\n\n", map[bool]string{false: "closed", true: "open"}[*open], *conns, *reqs, *work, *workers, *queue)
+	fmt.Printf("%-8s %10s %10s %10s %10s %8s %12s %10s\n", "server", "req/s", "p50", "p99", "max", "errors", "peak gorout", "peak heap")
+	for _, m := range modes {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		switch m {
+		case "perconn":
+			go servePerConn(ln, *work)
+		case "pool":
+			go servePool(ln, *work, *workers, *queue)
+		default:
+			fmt.Printf("unknown mode %q\n", m)
+			os.Exit(1)
+		}
+
+		runtime.GC()
+		var peakGoroutines int
+		var peakHeap uint64
+		stop := make(chan struct{})
+		sampled := make(chan struct{})
+		go func() {
+			defer close(sampled)
+			t := time.NewTicker(10 * time.Millisecond)
+			defer t.Stop()
+			var ms runtime.MemStats
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+					peakGoroutines = max(peakGoroutines, runtime.NumGoroutine())
+					runtime.ReadMemStats(&ms)
+					peakHeap = max(peakHeap, ms.HeapInuse+ms.StackInuse)
+				}
+			}
+		}()
+
+		c := newClient(ln.Addr().String(), *conns, *reqs)
+		cfg := loadgen.Config{Concurrency: *conns, Requests: *conns * *reqs, Cleanup: c.hangup}
+		if *open {
+			cfg.Mode, cfg.Rate = loadgen.Open, *rate
+		}
+		res, err := loadgen.Run(context.Background(), cfg, c.request)
+		close(stop)
+		<-sampled
+		ln.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%-8s %10.0f %10v %10v %10v %8v %12v %9.1fM\n", m, res.Throughput(),
+			res.Latency.Percentile(50).Round(time.Microsecond),
+			res.Latency.Percentile(99).Round(time.Microsecond),
+			res.Latency.Max().Round(time.Microsecond),
+			res.Errors, peakGoroutines, float64(peakHeap)/(1<<20))
+	}
+}
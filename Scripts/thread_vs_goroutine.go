@@ -0,0 +1,47 @@
+//go:build linux
+
+/*
+OS threads vs goroutines: what does each one cost to create, in memory and to switch
+between? The measurements are in package threadcost.
+
+usage: go run Scripts/thread_vs_goroutine.go -n 1000 -switches 100000
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/threadcost"
+)
+
+func main() {
+	n := flag.Int("n", 1000, "number of threads/goroutines to create")
+	switches := flag.Int("switches", 100000, "context switches in the ping-pong test")
+	flag.Parse()
+
+	fmt.Printf("GOMAXPROCS=%v n=%v\n\n", runtime.GOMAXPROCS(0), *n)
+
+	g := threadcost.Goroutines(*n)
+	t, err := threadcost.Threads(*n)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	gs := threadcost.PingPong(*switches, false)
+	ts := threadcost.PingPong(*switches, true)
+
+	per := func(d time.Duration) time.Duration { return d / time.Duration(*n) }
+	fmt.Printf("%-12s %14s %12s %14s %12s\n", "", "create total", "create each", "rss each", "switch")
+	fmt.Printf("%-12s %14v %12v %13.1fK %12v\n", "goroutine", g.Create.Round(time.Microsecond), per(g.Create),
+		float64(g.RSSKB)/float64(*n), gs)
+	fmt.Printf("%-12s %14v %12v %13.1fK %12v\n", "os thread", t.Create.Round(time.Microsecond), per(t.Create),
+		float64(t.RSSKB)/float64(*n), ts)
+	fmt.Printf("\nthread/goroutine: create %.0fx, switch %.1fx\n",
+		float64(t.Create)/float64(max(g.Create, 1)), float64(ts)/float64(max(gs, 1)))
+	fmt.Println("(rss only counts touched pages, a C pthread also reserves 8M of stack address space by default)")
+}
@@ -0,0 +1,217 @@
+/*
+Word frequencies from parallel file readers with a count-min sketch.
+
+A corpus of -files files is generated first (word frequencies follow a Zipf law, like
+real text), or point -dir at your own. Every file gets its own reader goroutine,
+which counts into a countmin.Local buffer that merges into the shared sketch every
+-buffer distinct words. An exact map count is done as well, as the ground truth.
+
+The sketch is run for a few error bounds ε to show the trade-off: memory goes up
+with 1/ε and the error goes down with it. For each one the report shows the memory,
+the average and worst over-count across all distinct words, the guaranteed bound
+ε·N and how many of the true top -k the sketch's heavy hitter list got.
+
+usage: go run Scripts/word_freq.go [-files 8] [-words 500000] [-k 10] [-dir path]
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/countmin"
+)
+
+// word turns a rank into a made up word, short ones for the frequent ranks.
+func word(rank uint64) string {
+	const letters = "etaoinshrdlcumwfgypbvkjxqz"
+	b := []byte{}
+	for r := rank; ; r = r/26 - 1 {
+		b = append(b, letters[r%26])
+		if r < 26 {
+			break
+		}
+	}
+	return string(b)
+}
+
+func generate(dir string, files, words int) ([]string, error) {
+	var paths []string
+	for i := 0; i < files; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("part-%02d.txt", i))
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		w := bufio.NewWriter(f)
+		zipf := rand.NewZipf(rand.New(rand.NewSource(int64(i))), 1.1, 1, 200000)
+		for n := 0; n < words; n++ {
+			w.WriteString(word(zipf.Uint64()))
+			if n%16 == 15 {
+				w.WriteByte('\n')
+			} else {
+				w.WriteByte(' ')
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+		f.Close()
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// each reads every file in its own goroutine and calls fn for every word. fn gets
+// the reader's index so it can keep per reader state.
+func each(paths []string, start func(reader int), fn func(reader int, w string), done func(reader int)) {
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+			defer f.Close()
+			start(i)
+			s := bufio.NewScanner(f)
+			s.Split(bufio.ScanWords)
+			for s.Scan() {
+				fn(i, s.Text())
+			}
+			done(i)
+		}(i, path)
+	}
+	wg.Wait()
+}
+
+func exactCount(paths []string) map[string]uint64 {
+	locals := make([]map[string]uint64, len(paths))
+	each(paths,
+		func(r int) { locals[r] = map[string]uint64{} },
+		func(r int, w string) { locals[r][w]++ },
+		func(int) {})
+	total := map[string]uint64{}
+	for _, m := range locals {
+		for w, n := range m {
+			total[w] += n
+		}
+	}
+	return total
+}
+
+func topOf(counts map[string]uint64, k int) []string {
+	words := make([]string, 0, len(counts))
+	for w := range counts {
+		words = append(words, w)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	return words[:min(k, len(words))]
+}
+
+func heapBytes() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+func main() {
+	dir := flag.String("dir", "", "count the files in this directory instead of a generated corpus")
+	files := flag.Int("files", 8, "files to generate")
+	words := flag.Int("words", 500000, "words per generated file")
+	k := flag.Int("k", 10, "heavy hitters to track")
+	buffer := flag.Int("buffer", 1024, "distinct words a reader buffers before merging")
+	flag.Parse()
+
+	var paths []string
+	if *dir != "" {
+		matches, err := filepath.Glob(filepath.Join(*dir, "*"))
+		if err != nil {
+			panic(err)
+		}
+		for _, m := range matches {
+			if st, err := os.Stat(m); err == nil && st.Mode().IsRegular() {
+				paths = append(paths, m)
+			}
+		}
+	} else {
+		tmp, err := os.MkdirTemp("", "word_freq")
+		if err != nil {
+			panic(err)
+		}
+		defer os.RemoveAll(tmp)
+		if paths, err = generate(tmp, *files, *words); err != nil {
+			panic(err)
+		}
+	}
+
+	base := heapBytes()
+	start := time.Now()
+	exact := exactCount(paths)
+	exactTime := time.Since(start)
+	exactMem := heapBytes() - base
+	var total uint64
+	for _, n := range exact {
+		total += n
+	}
+	trueTop := topOf(exact, *k)
+	fmt.Printf("%v readers, %v words, %v distinct\n", len(paths), total, len(exact))
+	fmt.Printf("exact map: %.1fMB in %v\n\n", float64(exactMem)/(1<<20), exactTime.Round(time.Millisecond))
+
+	fmt.Printf("%-8s %12s %10s %10s %10s %10s %8s %10s\n", "epsilon", "width×depth", "memory", "avg over", "max over", "bound εN", "top-k", "elapsed")
+	var best *countmin.Sketch
+	for _, eps := range []float64{0.01, 0.001, 0.0001} {
+		s := countmin.New(countmin.Config{Epsilon: eps, Delta: 0.01, TopK: *k})
+		locals := make([]*countmin.Local, len(paths))
+		start := time.Now()
+		each(paths,
+			func(r int) { locals[r] = s.Local(*buffer) },
+			func(r int, w string) { locals[r].Add(w) },
+			func(r int) { locals[r].Flush() })
+		elapsed := time.Since(start)
+
+		var sum, worst uint64
+		for w, n := range exact {
+			over := s.Estimate(w) - n
+			sum += over
+			worst = max(worst, over)
+		}
+		found := map[string]bool{}
+		for _, e := range s.Top() {
+			found[e.Key] = true
+		}
+		hits := 0
+		for _, w := range trueTop {
+			if found[w] {
+				hits++
+			}
+		}
+		fmt.Printf("%-8v %12v %8.1fKB %10.1f %10v %10.0f %5v/%-2v %10v\n", eps,
+			fmt.Sprintf("%v×%v", s.Width(), s.Depth()), float64(s.Bytes())/1024,
+			float64(sum)/float64(len(exact)), worst, eps*float64(total), hits, len(trueTop), elapsed.Round(time.Millisecond))
+		best = s
+	}
+
+	fmt.Printf("\ntop %v with ε=0.0001:\n", *k)
+	for _, e := range best.Top() {
+		fmt.Printf("  %-8v %9v (true %v)\n", e.Key, e.Count, exact[e.Key])
+	}
+}
@@ -0,0 +1,257 @@
+// Package actor attributes work and waiting to whoever did it: a demo's teller,
+// philosopher or worker.
+//
+// Go deliberately has no goroutine ids, and there's no goroutine local storage to
+// hang per worker counters on. Instead the worker is carried in the context:
+// WithActor starts an actor, code that takes the context records on From(ctx), and
+// the actor's Group lists everybody for the final report. Every actor only ever
+// touches its own counters, so there's no global map keyed by worker and no lock
+// all the workers fight over while they're being measured.
+//
+// A nil *Actor records nothing, code can call From(ctx) unconditionally and a
+// context without an actor costs nothing.
+package actor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	actorKey ctxKey = iota
+	groupKey
+)
+
+// Hooks are called over an actor's life, both are optional.
+type Hooks struct {
+	Start func(*Actor) // when WithActor creates it
+	End   func(*Actor) // on its first Done
+}
+
+// Group collects the actors created under it.
+type Group struct {
+	hooks Hooks
+
+	mu     sync.Mutex
+	actors []*Actor
+}
+
+// NewGroup returns an empty group.
+func NewGroup(hooks Hooks) *Group {
+	return &Group{hooks: hooks}
+}
+
+// WithGroup returns a context whose actors join g.
+func WithGroup(ctx context.Context, g *Group) context.Context {
+	return context.WithValue(ctx, groupKey, g)
+}
+
+// Stat is one named accumulator of an actor.
+type Stat struct {
+	Name  string
+	Count int64
+	// Timed stats also add up durations.
+	Timed bool
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Actor is one worker's storage.
+type Actor struct {
+	ID string
+	// Parent is the actor of the context WithActor was called with, if any.
+	Parent *Actor
+
+	group   *Group
+	started time.Time
+
+	// only contended by a report running while the actor works
+	mu    sync.Mutex
+	stats []Stat
+	ended time.Time
+}
+
+// WithActor starts an actor named id and returns a context carrying it. It joins
+// the context's group, if there is one.
+func WithActor(ctx context.Context, id string) context.Context {
+	a := &Actor{ID: id, Parent: From(ctx), started: time.Now()}
+	if g, ok := ctx.Value(groupKey).(*Group); ok {
+		a.group = g
+		g.mu.Lock()
+		g.actors = append(g.actors, a)
+		g.mu.Unlock()
+		if g.hooks.Start != nil {
+			g.hooks.Start(a)
+		}
+	}
+	return context.WithValue(ctx, actorKey, a)
+}
+
+// From returns the context's actor, nil if it has none.
+func From(ctx context.Context) *Actor {
+	a, _ := ctx.Value(actorKey).(*Actor)
+	return a
+}
+
+// stat finds or adds name, a.mu must be held. An actor has a handful of stats, a
+// slice beats a map.
+func (a *Actor) stat(name string) *Stat {
+	for i := range a.stats {
+		if a.stats[i].Name == name {
+			return &a.stats[i]
+		}
+	}
+	a.stats = append(a.stats, Stat{Name: name})
+	return &a.stats[len(a.stats)-1]
+}
+
+// Add adds n to the counter name.
+func (a *Actor) Add(name string, n int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.stat(name).Count += n
+	a.mu.Unlock()
+}
+
+// Observe records one occurrence of name that took d.
+func (a *Actor) Observe(name string, d time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	s := a.stat(name)
+	s.Timed = true
+	s.Count++
+	s.Total += d
+	s.Max = max(s.Max, d)
+	a.mu.Unlock()
+}
+
+// Wait runs fn, typically something that blocks such as taking a lock, and
+// records how long it took under name.
+func (a *Actor) Wait(name string, fn func()) {
+	if a == nil {
+		fn()
+		return
+	}
+	start := time.Now()
+	fn()
+	a.Observe(name, time.Since(start))
+}
+
+// Done ends the actor's life, calls after the first do nothing.
+func (a *Actor) Done() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	first := a.ended.IsZero()
+	if first {
+		a.ended = time.Now()
+	}
+	a.mu.Unlock()
+	if first && a.group != nil && a.group.hooks.End != nil {
+		a.group.hooks.End(a)
+	}
+}
+
+// Lifetime is how long the actor has been (or was) around.
+func (a *Actor) Lifetime() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ended.IsZero() {
+		return time.Since(a.started)
+	}
+	return a.ended.Sub(a.started)
+}
+
+// Running reports whether Done hasn't been called yet.
+func (a *Actor) Running() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ended.IsZero()
+}
+
+// Stats returns a copy of the actor's stats, in the order they were first used.
+func (a *Actor) Stats() []Stat {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Stat(nil), a.stats...)
+}
+
+// Actors returns the group's actors in the order they were started.
+func (g *Group) Actors() []*Actor {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*Actor(nil), g.actors...)
+}
+
+// Report writes a table with a row per actor and a column per stat: counters show
+// their count, timed stats count/total/max.
+func (g *Group) Report(w io.Writer) {
+	actors := g.Actors()
+	var names []string
+	timed := map[string]bool{}
+	rows := make([][]Stat, len(actors))
+	for i, a := range actors {
+		rows[i] = a.Stats()
+		for _, s := range rows[i] {
+			if _, ok := timed[s.Name]; !ok {
+				names = append(names, s.Name)
+			}
+			timed[s.Name] = timed[s.Name] || s.Timed
+		}
+	}
+
+	header := []string{"actor", "lifetime"}
+	for _, name := range names {
+		if timed[name] {
+			name += " (n / total / max)"
+		}
+		header = append(header, name)
+	}
+	cells := [][]string{header}
+	for i, a := range actors {
+		life := a.Lifetime().Round(time.Millisecond).String()
+		if a.Running() {
+			life += " (running)"
+		}
+		row := []string{a.ID, life}
+		for _, name := range names {
+			cell := ""
+			for _, s := range rows[i] {
+				if s.Name != name {
+					continue
+				}
+				if timed[name] {
+					cell = fmt.Sprintf("%v / %v / %v", s.Count, s.Total.Round(time.Microsecond), s.Max.Round(time.Microsecond))
+				} else {
+					cell = fmt.Sprint(s.Count)
+				}
+			}
+			row = append(row, cell)
+		}
+		cells = append(cells, row)
+	}
+	widths := make([]int, len(cells[0]))
+	for _, row := range cells {
+		for j, c := range row {
+			widths[j] = max(widths[j], len(c))
+		}
+	}
+	for _, row := range cells {
+		var b strings.Builder
+		for j, c := range row {
+			fmt.Fprintf(&b, "%-*s  ", widths[j], c)
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+}
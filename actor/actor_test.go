@@ -0,0 +1,120 @@
+package actor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordsPerActor(t *testing.T) {
+	g := NewGroup(Hooks{})
+	ctx := WithGroup(context.Background(), g)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			a := From(ctx)
+			defer a.Done()
+			for j := 0; j < 100; j++ {
+				a.Add("ops", 1)
+			}
+			a.Observe("wait", time.Millisecond)
+			a.Observe("wait", 3*time.Millisecond)
+		}(WithActor(ctx, fmt.Sprint("w", i)))
+	}
+	wg.Wait()
+	actors := g.Actors()
+	if len(actors) != 4 {
+		t.Fatalf("%v actors in the group, want 4", len(actors))
+	}
+	for i, a := range actors {
+		if a.ID != fmt.Sprint("w", i) || a.Running() {
+			t.Errorf("actor %v: id %v running %v", i, a.ID, a.Running())
+		}
+		st := a.Stats()
+		if len(st) != 2 || st[0] != (Stat{Name: "ops", Count: 100}) ||
+			st[1] != (Stat{Name: "wait", Count: 2, Timed: true, Total: 4 * time.Millisecond, Max: 3 * time.Millisecond}) {
+			t.Errorf("%v: stats %+v", a.ID, st)
+		}
+	}
+}
+
+func TestNilActor(t *testing.T) {
+	a := From(context.Background())
+	if a != nil {
+		t.Fatal("actor from a bare context")
+	}
+	// none of these may panic
+	a.Add("x", 1)
+	a.Observe("x", time.Second)
+	ran := false
+	a.Wait("x", func() { ran = true })
+	a.Done()
+	if !ran {
+		t.Fatal("nil actor's Wait didn't run fn")
+	}
+}
+
+func TestWaitTimes(t *testing.T) {
+	ctx := WithActor(context.Background(), "a")
+	a := From(ctx)
+	a.Wait("sleep", func() { time.Sleep(5 * time.Millisecond) })
+	st := a.Stats()
+	if len(st) != 1 || st[0].Count != 1 || st[0].Total < 5*time.Millisecond || !st[0].Timed {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestParentAndHooks(t *testing.T) {
+	var started, ended []string
+	g := NewGroup(Hooks{
+		Start: func(a *Actor) { started = append(started, a.ID) },
+		End:   func(a *Actor) { ended = append(ended, a.ID) },
+	})
+	ctx := WithActor(WithGroup(context.Background(), g), "parent")
+	child := From(WithActor(ctx, "child"))
+	if child.Parent != From(ctx) {
+		t.Fatal("child's parent isn't the actor it was started under")
+	}
+	child.Done()
+	child.Done()
+	if fmt.Sprint(started) != "[parent child]" || fmt.Sprint(ended) != "[child]" {
+		t.Fatalf("started %v ended %v", started, ended)
+	}
+	life := child.Lifetime()
+	time.Sleep(time.Millisecond)
+	if child.Lifetime() != life {
+		t.Error("lifetime still growing after Done")
+	}
+}
+
+func TestReport(t *testing.T) {
+	g := NewGroup(Hooks{})
+	ctx := WithGroup(context.Background(), g)
+	a := From(WithActor(ctx, "alice"))
+	b := From(WithActor(ctx, "bob"))
+	a.Add("transfers", 3)
+	b.Observe("lock", 2*time.Millisecond)
+	a.Done()
+	var buf bytes.Buffer
+	g.Report(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("report:\n%v", buf.String())
+	}
+	header := strings.Fields(lines[0])
+	if fmt.Sprint(header) != "[actor lifetime transfers lock (n / total / max)]" {
+		t.Errorf("header %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "alice") || !strings.Contains(lines[1], " 3") || strings.Contains(lines[1], "running") {
+		t.Errorf("alice's row %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "(running)") || !strings.Contains(lines[2], "1 / 2ms / 2ms") {
+		t.Errorf("bob's row %q", lines[2])
+	}
+}
@@ -0,0 +1,326 @@
+// Package adaptive sizes a worker pool from what the downstream it calls is doing,
+// instead of a fixed limit somebody guessed once.
+//
+// A fixed limit is wrong most of the time: too high and the extra calls just queue
+// in the downstream, adding latency without adding throughput, too low and capacity
+// sits idle. And the right number moves whenever the downstream gets faster or
+// slower, scales up or loses a replica. A Controller watches the latency and the
+// failures of the calls it admits and moves the limit after every sampling window:
+//
+//	AIMD     - additive increase, multiplicative decrease, what TCP does with its
+//	           congestion window: one more slot per window while latency stays
+//	           under Target and nothing failed, the limit times Backoff as soon as
+//	           it doesn't.
+//	Gradient - Little's law. While the limit is in use throughput is limit / rtt,
+//	           so the concurrency the downstream absorbs without queueing is
+//	           throughput × the no load rtt = limit × minRTT / rtt. The limit moves
+//	           (smoothed) towards that plus sqrt(limit) of headroom to keep probing
+//	           for more. There's no target to configure, the no load rtt is learned.
+//
+// Every call takes a slot with Acquire and hands it back with Token.Done, so with
+// workers that take a slot per job the limit is the number of workers that run.
+package adaptive
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Algorithm picks how the limit is adjusted.
+type Algorithm int
+
+const (
+	AIMD Algorithm = iota
+	Gradient
+)
+
+func (a Algorithm) String() string {
+	if a == Gradient {
+		return "gradient"
+	}
+	return "aimd"
+}
+
+// ParseAlgorithm is the inverse of String.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch s {
+	case "aimd":
+		return AIMD, nil
+	case "gradient":
+		return Gradient, nil
+	}
+	return 0, fmt.Errorf("adaptive: unknown algorithm %q (aimd, gradient)", s)
+}
+
+// Config describes the controller, the zero value is AIMD with the defaults below.
+// Setting Min and Max to the same value gives a fixed limit, for comparison.
+type Config struct {
+	Algorithm Algorithm
+	Initial   int // default 10, kept within [Min, Max]
+	Min       int // default 1
+	Max       int // default 1000
+	// Window is how often the limit is adjusted, default 100ms. A window also needs
+	// MinSamples completed calls (default 10), a quiet window is extended instead of
+	// judged on a handful of calls.
+	Window     time.Duration
+	MinSamples int
+	// Target is the latency AIMD backs off above, default twice the no load rtt.
+	Target time.Duration
+	// History is how many windows the no load rtt is the lowest rtt of, default
+	// 100. Old lows have to age out: a downstream that got slower for good is
+	// learned again once they have.
+	History int
+	// Backoff is AIMD's decrease factor, default 0.9.
+	Backoff float64
+	// Smoothing is how far Gradient moves towards its new estimate per window,
+	// default 0.2.
+	Smoothing float64
+	// OnUpdate, if set, is called after every window.
+	OnUpdate func(Update)
+}
+
+// Update describes one window and what the controller made of it.
+type Update struct {
+	Time     time.Time
+	Previous int
+	Limit    int
+	Peak     int // most calls in flight during the window
+	Samples  int
+	Failed   int
+	RTT      time.Duration // the window's mean
+	MinRTT   time.Duration // the no load estimate
+	// Throughput is completed calls per second.
+	Throughput float64
+}
+
+func (u Update) String() string {
+	return fmt.Sprintf("limit %v -> %v peak=%v samples=%v failed=%v rtt=%v min=%v %.0f/s",
+		u.Previous, u.Limit, u.Peak, u.Samples, u.Failed,
+		u.RTT.Round(time.Microsecond), u.MinRTT.Round(time.Microsecond), u.Throughput)
+}
+
+// Controller admits calls up to a limit it adjusts. It is safe for concurrent use.
+type Controller struct {
+	cfg Config
+
+	mu       sync.Mutex
+	limit    float64 // fractional so small gradient steps add up
+	inFlight int
+	// calls waiting for a slot, first come first served. A slot is handed over by
+	// closing the waiter's channel, so a newcomer can't grab it first.
+	waiters []chan struct{}
+	// the lowest rtt of each of the last History windows, a ring
+	lows   []time.Duration
+	next   int
+	minRTT time.Duration
+
+	// the current window
+	start   time.Time
+	samples int
+	failed  int
+	rttSum  time.Duration
+	rttMin  time.Duration
+	peak    int
+}
+
+// New returns a controller for cfg.
+func New(cfg Config) *Controller {
+	if cfg.Min <= 0 {
+		cfg.Min = 1
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = 1000
+	}
+	cfg.Max = max(cfg.Max, cfg.Min)
+	if cfg.Initial <= 0 {
+		cfg.Initial = 10
+	}
+	cfg.Initial = min(max(cfg.Initial, cfg.Min), cfg.Max)
+	if cfg.Window <= 0 {
+		cfg.Window = 100 * time.Millisecond
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 10
+	}
+	if cfg.History <= 0 {
+		cfg.History = 100
+	}
+	if cfg.Backoff <= 0 || cfg.Backoff >= 1 {
+		cfg.Backoff = 0.9
+	}
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = 0.2
+	}
+	return &Controller{
+		cfg:   cfg,
+		limit: float64(cfg.Initial),
+		lows:  make([]time.Duration, cfg.History),
+		start: time.Now(),
+	}
+}
+
+// Token is a slot taken by Acquire.
+type Token struct {
+	c     *Controller
+	start time.Time
+}
+
+// Acquire waits for a slot, or until ctx is done.
+func (c *Controller) Acquire(ctx context.Context) (Token, error) {
+	c.mu.Lock()
+	if len(c.waiters) == 0 && c.inFlight < int(c.limit) {
+		c.take()
+		c.mu.Unlock()
+		return Token{c: c, start: time.Now()}, nil
+	}
+	ready := make(chan struct{})
+	c.waiters = append(c.waiters, ready)
+	c.mu.Unlock()
+
+	select {
+	case <-ready:
+		return Token{c: c, start: time.Now()}, nil
+	case <-ctx.Done():
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w == ready {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return Token{}, ctx.Err()
+		}
+	}
+	// handed a slot just as ctx gave up, pass it on
+	c.inFlight--
+	c.grant()
+	return Token{}, ctx.Err()
+}
+
+// take occupies a slot, c.mu must be held.
+func (c *Controller) take() {
+	c.inFlight++
+	c.peak = max(c.peak, c.inFlight)
+}
+
+// grant hands free slots to the waiters in order, c.mu must be held.
+func (c *Controller) grant() {
+	for len(c.waiters) > 0 && c.inFlight < int(c.limit) {
+		c.take()
+		close(c.waiters[0])
+		c.waiters = c.waiters[1:]
+	}
+}
+
+// Done gives the slot back. failed tells the controller the call didn't succeed
+// (an error, a timeout, a rejection), which counts as a sign of overload.
+func (t Token) Done(failed bool) {
+	c := t.c
+	now := time.Now()
+	rtt := now.Sub(t.start)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.samples++
+	c.rttSum += rtt
+	if c.rttMin == 0 || rtt < c.rttMin {
+		c.rttMin = rtt
+	}
+	if failed {
+		c.failed++
+	}
+	var u Update
+	update := now.Sub(c.start) >= c.cfg.Window && c.samples >= c.cfg.MinSamples
+	if update {
+		u = c.adjust(now)
+	}
+	c.grant()
+	c.mu.Unlock()
+
+	if update && c.cfg.OnUpdate != nil {
+		c.cfg.OnUpdate(u)
+	}
+}
+
+// Do runs fn in a slot, an error from fn counts as a failed call.
+func (c *Controller) Do(ctx context.Context, fn func(context.Context) error) error {
+	t, err := c.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(ctx)
+	t.Done(err != nil)
+	return err
+}
+
+// adjust closes the window and moves the limit, c.mu must be held.
+func (c *Controller) adjust(now time.Time) Update {
+	rtt := c.rttSum / time.Duration(c.samples)
+	c.lows[c.next] = c.rttMin
+	c.next = (c.next + 1) % len(c.lows)
+	c.minRTT = c.rttMin
+	for _, low := range c.lows {
+		if low > 0 && low < c.minRTT {
+			c.minRTT = low
+		}
+	}
+	u := Update{
+		Time:       now,
+		Previous:   int(c.limit),
+		Peak:       c.peak,
+		Samples:    c.samples,
+		Failed:     c.failed,
+		RTT:        rtt,
+		MinRTT:     c.minRTT,
+		Throughput: float64(c.samples) / now.Sub(c.start).Seconds(),
+	}
+	// a limit that wasn't used says nothing about whether it's too low
+	used := 2*c.peak >= int(c.limit)
+
+	switch c.cfg.Algorithm {
+	case AIMD:
+		target := c.cfg.Target
+		if target <= 0 {
+			target = 2 * c.minRTT
+		}
+		if c.failed > 0 || rtt > target {
+			c.limit *= c.cfg.Backoff
+		} else if used {
+			c.limit++
+		}
+	case Gradient:
+		// 1 with no queueing, falling as calls start waiting downstream, and
+		// floored so one bad window can't take more than half the limit
+		gradient := math.Max(0.5, math.Min(1, float64(c.minRTT)/float64(rtt)))
+		if c.failed > 0 {
+			gradient = 0.5
+		}
+		next := c.limit*gradient + math.Sqrt(c.limit)
+		if !used {
+			next = math.Min(next, c.limit)
+		}
+		c.limit += (next - c.limit) * c.cfg.Smoothing
+	}
+	c.limit = math.Min(math.Max(c.limit, float64(c.cfg.Min)), float64(c.cfg.Max))
+	u.Limit = int(c.limit)
+
+	c.start, c.samples, c.failed, c.rttSum, c.rttMin = now, 0, 0, 0, 0
+	c.peak = c.inFlight
+	return u
+}
+
+// Limit returns the current limit.
+func (c *Controller) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// InFlight returns the calls holding a slot.
+func (c *Controller) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inFlight
+}
@@ -0,0 +1,165 @@
+package adaptive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// everyCall closes a window on every completed call, so each Done adjusts the limit.
+func everyCall(cfg Config) Config {
+	cfg.Window, cfg.MinSamples = time.Nanosecond, 1
+	return cfg
+}
+
+func acquire(t *testing.T, c *Controller, n int) []Token {
+	t.Helper()
+	toks := make([]Token, n)
+	for i := range toks {
+		var err error
+		if toks[i], err = c.Acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return toks
+}
+
+func TestAIMDIncreasesWhenUsed(t *testing.T) {
+	var updates []Update
+	c := New(everyCall(Config{Initial: 4, Target: time.Hour, OnUpdate: func(u Update) { updates = append(updates, u) }}))
+	for _, tok := range acquire(t, c, 4) {
+		tok.Done(false)
+	}
+	// 4 in flight uses a limit of 4, 3 uses 5, 2 of 6 is less than half: no probe
+	want := []int{5, 6, 6, 6}
+	if len(updates) != len(want) {
+		t.Fatalf("%v updates, want %v", len(updates), len(want))
+	}
+	for i, u := range updates {
+		if u.Limit != want[i] {
+			t.Errorf("update %v: limit %v, want %v (%v)", i, u.Limit, want[i], u)
+		}
+	}
+	if c.Limit() != 6 || c.InFlight() != 0 {
+		t.Errorf("limit %v in flight %v", c.Limit(), c.InFlight())
+	}
+}
+
+func TestAIMDBacksOff(t *testing.T) {
+	c := New(everyCall(Config{Initial: 10, Min: 8, Target: time.Hour}))
+	acquire(t, c, 1)[0].Done(true)
+	if c.Limit() != 9 {
+		t.Fatalf("limit %v after a failure, want 10 × 0.9", c.Limit())
+	}
+	for i := 0; i < 5; i++ {
+		acquire(t, c, 1)[0].Done(true)
+	}
+	if c.Limit() != 8 {
+		t.Fatalf("limit %v, want it held at Min", c.Limit())
+	}
+}
+
+func TestAIMDBacksOffOverTarget(t *testing.T) {
+	c := New(everyCall(Config{Initial: 10, Target: time.Microsecond}))
+	tok := acquire(t, c, 1)[0]
+	time.Sleep(time.Millisecond)
+	tok.Done(false)
+	if c.Limit() != 9 {
+		t.Fatalf("limit %v after a call over the target, want 9", c.Limit())
+	}
+}
+
+func TestGradient(t *testing.T) {
+	c := New(everyCall(Config{Algorithm: Gradient, Initial: 4, Smoothing: 1}))
+	// the whole limit in use and one call in the window: its rtt is the no load
+	// rtt, the gradient is 1 and the limit grows by sqrt(limit)
+	acquire(t, c, 4)[0].Done(false)
+	if c.Limit() != 6 {
+		t.Fatalf("limit %v, want 4 + sqrt(4)", c.Limit())
+	}
+
+	c = New(everyCall(Config{Algorithm: Gradient, Initial: 100, Smoothing: 1}))
+	acquire(t, c, 1)[0].Done(true)
+	// a failure halves it, plus the headroom: 100 × 0.5 + sqrt(100)
+	if c.Limit() != 60 {
+		t.Fatalf("limit %v after a failure, want 60", c.Limit())
+	}
+}
+
+func TestFixedLimit(t *testing.T) {
+	c := New(everyCall(Config{Min: 3, Max: 3, Initial: 10, Target: time.Hour}))
+	for i := 0; i < 10; i++ {
+		for _, tok := range acquire(t, c, 3) {
+			tok.Done(i%2 == 0)
+		}
+	}
+	if c.Limit() != 3 {
+		t.Fatalf("limit %v with Min = Max = 3", c.Limit())
+	}
+}
+
+func TestWaitersInOrder(t *testing.T) {
+	c := New(Config{Initial: 1, Window: time.Hour})
+	first := acquire(t, c, 1)[0]
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			tok, err := c.Acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- i
+			tok.Done(false)
+		}(i)
+		// queue them one after the other
+		for waiting(c) != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	first.Done(false)
+	for want := 0; want < 3; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("waiter %v got the slot, want %v", got, want)
+		}
+	}
+}
+
+func waiting(c *Controller) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	c := New(Config{Initial: 1, Window: time.Hour})
+	held := acquire(t, c, 1)[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire = %v, want the deadline", err)
+	}
+	if waiting(c) != 0 {
+		t.Fatal("canceled waiter left in the queue")
+	}
+	held.Done(false)
+	// the slot the canceled call gave up must still be there
+	if err := c.Do(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if c.InFlight() != 0 {
+		t.Fatalf("%v in flight after everything finished", c.InFlight())
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, a := range []Algorithm{AIMD, Gradient} {
+		if got, err := ParseAlgorithm(a.String()); err != nil || got != a {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", a.String(), got, err)
+		}
+	}
+	if _, err := ParseAlgorithm("vegas"); err == nil {
+		t.Error("ParseAlgorithm accepted an unknown algorithm")
+	}
+}
@@ -0,0 +1,215 @@
+// Package batcher collects items from many goroutines and hands them to a flush
+// function in batches.
+//
+// A batch is flushed when it reaches MaxSize items or when its oldest item has been
+// waiting for MaxAge, whichever comes first, so a busy producer gets big batches and a
+// quiet one still sees its items written within MaxAge.
+//
+// Flushes run one at a time on the batcher's own goroutine. While a flush is in
+// progress new items pile up in a queue of Queue items, once that's full Add blocks:
+// a slow sink pushes back on the producers instead of growing memory without bound.
+package batcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/clock"
+)
+
+// ErrClosed is returned by Add after Close has been called.
+var ErrClosed = errors.New("batcher: closed")
+
+// Config controls when batches are flushed.
+type Config struct {
+	MaxSize int
+	MaxAge  time.Duration
+	// Queue is how many items can wait while a flush is running, defaults to MaxSize.
+	Queue int
+	// Clock times MaxAge, nil means the wall clock.
+	Clock clock.Clock
+}
+
+// Stats counts flushes by the trigger that caused them.
+type Stats struct {
+	Items   int64
+	Batches int64
+	BySize  int64
+	ByAge   int64
+	ByDrain int64
+	Errors  int64
+}
+
+func (s Stats) String() string {
+	avg := 0.0
+	if s.Batches > 0 {
+		avg = float64(s.Items) / float64(s.Batches)
+	}
+	return fmt.Sprintf("items=%v batches=%v (size=%v age=%v drain=%v) avg batch=%.1f errors=%v",
+		s.Items, s.Batches, s.BySize, s.ByAge, s.ByDrain, avg, s.Errors)
+}
+
+// Batcher groups items of type T into batches.
+type Batcher[T any] struct {
+	cfg   Config
+	flush func([]T) error
+	queue chan T
+	done  chan struct{}
+
+	// mu makes Add and Close mutually safe: Add holds it shared while sending so Close
+	// can't close the queue under it
+	mu     sync.RWMutex
+	closed bool
+
+	statsMu sync.Mutex
+	stats   Stats
+	lastErr error
+}
+
+// New starts a batcher calling flush with every batch. The slice passed to flush is
+// only valid for the duration of the call.
+func New[T any](cfg Config, flush func([]T) error) *Batcher[T] {
+	if cfg.MaxSize < 1 {
+		cfg.MaxSize = 1
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = time.Second
+	}
+	if cfg.Queue < 1 {
+		cfg.Queue = cfg.MaxSize
+	}
+	cfg.Clock = clock.Or(cfg.Clock)
+	b := &Batcher[T]{
+		cfg:   cfg,
+		flush: flush,
+		queue: make(chan T, cfg.Queue),
+		done:  make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// Add queues v for the next batch. It blocks while the queue is full, until ctx is
+// done or the batcher is closed.
+func (b *Batcher[T]) Add(ctx context.Context, v T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	select {
+	case b.queue <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryAdd queues v if there is room right now, it never waits. It reports false if
+// the queue is full.
+func (b *Batcher[T]) TryAdd(v T) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false, ErrClosed
+	}
+	select {
+	case b.queue <- v:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// Close stops accepting items, flushes everything still queued and waits for the
+// last flush to finish. It returns the most recent flush error, if any.
+func (b *Batcher[T]) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.queue)
+	}
+	b.mu.Unlock()
+	<-b.done
+
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	return b.lastErr
+}
+
+// Stats returns the counters so far.
+func (b *Batcher[T]) Stats() Stats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	return b.stats
+}
+
+type trigger int
+
+const (
+	bySize trigger = iota
+	byAge
+	byDrain
+)
+
+func (b *Batcher[T]) loop() {
+	defer close(b.done)
+	batch := make([]T, 0, b.cfg.MaxSize)
+	// the timer only runs while there's a partial batch, it's armed by the first item
+	timer := b.cfg.Clock.NewTimer(b.cfg.MaxAge)
+	timer.Stop()
+	armed := false
+
+	emit := func(t trigger) {
+		if armed && !timer.Stop() {
+			<-timer.C()
+		}
+		armed = false
+		if len(batch) == 0 {
+			return
+		}
+		err := b.flush(batch)
+		b.statsMu.Lock()
+		b.stats.Items += int64(len(batch))
+		b.stats.Batches++
+		switch t {
+		case bySize:
+			b.stats.BySize++
+		case byAge:
+			b.stats.ByAge++
+		case byDrain:
+			b.stats.ByDrain++
+		}
+		if err != nil {
+			b.stats.Errors++
+			b.lastErr = err
+		}
+		b.statsMu.Unlock()
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case v, ok := <-b.queue:
+			if !ok {
+				emit(byDrain)
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(b.cfg.MaxAge)
+				armed = true
+			}
+			batch = append(batch, v)
+			if len(batch) >= b.cfg.MaxSize {
+				emit(bySize)
+			}
+		case <-timer.C():
+			// the timer fired and was drained by this receive, emit must not drain it again
+			armed = false
+			emit(byAge)
+		}
+	}
+}
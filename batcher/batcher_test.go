@@ -0,0 +1,70 @@
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/clock"
+)
+
+type flushed struct {
+	items []int
+	at    time.Duration // fake time since the start
+}
+
+func TestFlushTriggersWithFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := clock.NewFake(start)
+	flushes := make(chan flushed, 10)
+	b := New(Config{MaxSize: 3, MaxAge: 100 * time.Millisecond, Clock: f}, func(items []int) error {
+		flushes <- flushed{append([]int(nil), items...), f.Since(start)}
+		return nil
+	})
+	ctx := context.Background()
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-flushes:
+			if s := fmt.Sprintf("%v at %v", got.items, got.at); s != want {
+				t.Fatalf("flushed %v, expected %v", s, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no flush, expected %v", want)
+		}
+	}
+
+	// a lone item waits for MaxAge, the first item arms the timer
+	b.Add(ctx, 1)
+	f.BlockUntilDue(start.Add(100 * time.Millisecond))
+	f.Advance(99 * time.Millisecond)
+	f.Advance(time.Millisecond)
+	expect("[1] at 100ms")
+
+	// a full batch goes right away, no time passes
+	for i := 2; i <= 4; i++ {
+		b.Add(ctx, i)
+	}
+	expect("[2 3 4] at 100ms")
+
+	b.Add(ctx, 5)
+	f.BlockUntilDue(start.Add(200 * time.Millisecond))
+	f.Advance(100 * time.Millisecond)
+	expect("[5] at 200ms")
+
+	// Close drains what's left without waiting for the age
+	b.Add(ctx, 6)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expect("[6] at 200ms")
+
+	st := b.Stats()
+	if st.Items != 6 || st.Batches != 4 || st.BySize != 1 || st.ByAge != 2 || st.ByDrain != 1 {
+		t.Errorf("stats: %v", st)
+	}
+	if err := b.Add(ctx, 7); err != ErrClosed {
+		t.Errorf("Add after Close: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package bloom is a Bloom filter that's safe for concurrent use without locks.
+//
+// A Bloom filter answers "have I seen this?" with either "definitely not" or
+// "probably": every key sets k bits out of m, a key is reported present when all of
+// its bits are set, which other keys may have done between them. The false positive
+// rate p is picked up front, m and k follow from it and the expected number of keys n:
+//
+//	m = -n ln p / (ln 2)^2    k = m/n ln 2
+//
+// That's about 9.6 bits per key at 1%, whatever the keys look like, where an exact
+// set has to keep every key. The bits live in a []uint64 and are set with
+// compare-and-swap, so any number of goroutines can Add and Contains at once.
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Filter is a fixed size Bloom filter.
+type Filter struct {
+	words []uint64
+	m     uint64
+	k     int
+	seeds [2]maphash.Seed
+	added atomic.Int64
+}
+
+// New returns a filter sized for n keys at a false positive rate of p.
+func New(n int, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	return NewSize(m, max(k, 1))
+}
+
+// NewSize returns a filter of m bits using k hash functions.
+func NewSize(m uint64, k int) *Filter {
+	m = max(m, 64)
+	return &Filter{
+		words: make([]uint64, (m+63)/64),
+		m:     m,
+		k:     max(k, 1),
+		seeds: [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+// locations derives the k bit positions from two hashes (Kirsch and Mitzenmacher),
+// which is as good as k independent hashes and a lot cheaper.
+func (f *Filter) locations(key []byte, fn func(b uint64) bool) {
+	h1 := maphash.Bytes(f.seeds[0], key)
+	h2 := maphash.Bytes(f.seeds[1], key) | 1
+	for i := 0; i < f.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % f.m) {
+			return
+		}
+	}
+}
+
+// Add sets key's bits and reports whether they were all set already, that is
+// whether key was (probably) in the filter before. It's no exact test-and-set: when
+// several goroutines add the same new key at once each of them can be the first to
+// set a different one of its bits, and then more than one gets false. At least one
+// does.
+func (f *Filter) Add(key []byte) (present bool) {
+	present = true
+	f.locations(key, func(b uint64) bool {
+		w, mask := &f.words[b/64], uint64(1)<<(b%64)
+		for {
+			old := atomic.LoadUint64(w)
+			if old&mask != 0 {
+				return true
+			}
+			if atomic.CompareAndSwapUint64(w, old, old|mask) {
+				present = false
+				return true
+			}
+		}
+	})
+	if !present {
+		f.added.Add(1)
+	}
+	return present
+}
+
+// AddString is Add for a string key.
+func (f *Filter) AddString(key string) bool { return f.Add([]byte(key)) }
+
+// Contains reports whether key is probably in the filter. False is always right.
+func (f *Filter) Contains(key []byte) bool {
+	present := true
+	f.locations(key, func(b uint64) bool {
+		present = atomic.LoadUint64(&f.words[b/64])&(1<<(b%64)) != 0
+		return present
+	})
+	return present
+}
+
+// ContainsString is Contains for a string key.
+func (f *Filter) ContainsString(key string) bool { return f.Contains([]byte(key)) }
+
+// Bits returns m, the size of the filter in bits.
+func (f *Filter) Bits() uint64 { return f.m }
+
+// Hashes returns k, the number of bits per key.
+func (f *Filter) Hashes() int { return f.k }
+
+// Bytes returns the memory used by the bit array.
+func (f *Filter) Bytes() int { return len(f.words) * 8 }
+
+// Added returns how many Add calls set at least one new bit, a slight undercount
+// of the distinct keys once false positives kick in.
+func (f *Filter) Added() int64 { return f.added.Load() }
+
+// FalsePositiveRate estimates the current false positive rate from the fraction of
+// bits set: (set/m)^k.
+func (f *Filter) FalsePositiveRate() float64 {
+	set := 0
+	for i := range f.words {
+		set += bits.OnesCount64(atomic.LoadUint64(&f.words[i]))
+	}
+	return math.Pow(float64(set)/float64(f.m), float64(f.k))
+}
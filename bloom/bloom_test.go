@@ -0,0 +1,97 @@
+package bloom
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNoFalseNegatives(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.AddString(fmt.Sprint("key ", i))
+	}
+	for i := 0; i < 10000; i++ {
+		if !f.ContainsString(fmt.Sprint("key ", i)) {
+			t.Fatalf("key %v was added but isn't in the filter", i)
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const n, p = 10000, 0.01
+	f := New(n, p)
+	for i := 0; i < n; i++ {
+		f.AddString(fmt.Sprint("in ", i))
+	}
+	fp := 0
+	const probes = 100000
+	for i := 0; i < probes; i++ {
+		if f.ContainsString(fmt.Sprint("out ", i)) {
+			fp++
+		}
+	}
+	// the seeds are random, leave room for an unlucky draw
+	if rate := float64(fp) / probes; rate > 2*p {
+		t.Errorf("false positive rate %.4f, sized for %v", rate, p)
+	}
+	if est := f.FalsePositiveRate(); est > 2*p || est <= 0 {
+		t.Errorf("estimated rate %.4f, sized for %v", est, p)
+	}
+}
+
+func TestSizing(t *testing.T) {
+	f := New(1000, 0.01)
+	// m = -n ln p / (ln 2)^2 = 9586, k = m/n ln 2 = 7
+	if f.Bits() != 9586 || f.Hashes() != 7 {
+		t.Errorf("m=%v k=%v, want 9586 and 7", f.Bits(), f.Hashes())
+	}
+	if f.Bytes() != 150*8 {
+		t.Errorf("Bytes = %v, want %v", f.Bytes(), 150*8)
+	}
+	small := NewSize(1, 0)
+	if small.Bits() != 64 || small.Hashes() != 1 {
+		t.Errorf("NewSize(1, 0): m=%v k=%v, want 64 and 1", small.Bits(), small.Hashes())
+	}
+}
+
+func TestAddReportsPresent(t *testing.T) {
+	f := New(100, 0.01)
+	if f.AddString("a") {
+		t.Fatal("first Add of a reported it present")
+	}
+	if !f.AddString("a") {
+		t.Fatal("second Add of a reported it new")
+	}
+	if f.Added() != 1 {
+		t.Fatalf("Added = %v, want 1", f.Added())
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	f := New(1000, 0.01)
+	var first atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if !f.AddString(fmt.Sprint(i)) {
+					first.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 1000; i++ {
+		if !f.ContainsString(fmt.Sprint(i)) {
+			t.Fatalf("%v lost in a concurrent Add", i)
+		}
+	}
+	// every key is new to at least one goroutine, a false positive aside
+	if n := first.Load(); n < 900 {
+		t.Errorf("only %v Adds reported a new key out of 1000 keys", n)
+	}
+}
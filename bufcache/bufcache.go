@@ -0,0 +1,223 @@
+// Package bufcache models the block buffer cache sitting between a file system and
+// the disk: a fixed number of frames, an eviction policy (LRU or Clock) and a write
+// policy (write-back or write-through) with dirty tracking.
+//
+// Nothing is really stored, the cache only counts what would hit the disk, which is
+// all that's needed to compare policies on a trace.
+package bufcache
+
+import (
+	"container/list"
+	"fmt"
+)
+
+// WritePolicy decides when a written block reaches the disk.
+type WritePolicy int
+
+const (
+	// WriteBack marks the block dirty and writes it when it's evicted or synced,
+	// repeated writes to a hot block cost a single disk write.
+	WriteBack WritePolicy = iota
+	// WriteThrough writes every write to disk right away, the cache only helps reads.
+	WriteThrough
+)
+
+func (p WritePolicy) String() string {
+	if p == WriteThrough {
+		return "write-through"
+	}
+	return "write-back"
+}
+
+// Config describes a cache.
+type Config struct {
+	Frames   int
+	Eviction string // "lru" or "clock"
+	Write    WritePolicy
+	// SyncEvery flushes all dirty blocks every that many accesses (write-back
+	// only), like the periodic sync of a real kernel. 0 disables it.
+	SyncEvery int
+}
+
+// Stats counts logical accesses and the disk traffic they caused.
+type Stats struct {
+	Reads, Writes  int
+	Hits, Misses   int
+	DiskReads      int
+	DiskWrites     int
+	Evictions      int
+	DirtyEvictions int
+}
+
+// HitRate is hits over all accesses.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// WriteAmplification is disk writes per logical write, below 1 means writes were
+// absorbed by the cache.
+func (s Stats) WriteAmplification() float64 {
+	if s.Writes == 0 {
+		return 0
+	}
+	return float64(s.DiskWrites) / float64(s.Writes)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("reads=%v writes=%v hit rate=%.1f%% disk reads=%v disk writes=%v write amp=%.2f evictions=%v (%v dirty)",
+		s.Reads, s.Writes, 100*s.HitRate(), s.DiskReads, s.DiskWrites, s.WriteAmplification(), s.Evictions, s.DirtyEvictions)
+}
+
+type frame struct {
+	block int
+	dirty bool
+	ref   bool          // clock reference bit
+	elem  *list.Element // lru position
+}
+
+// evictor picks the frame to throw out.
+type evictor interface {
+	touch(f *frame)
+	insert(f *frame)
+	victim() *frame
+}
+
+type lru struct{ order *list.List }
+
+func (l *lru) touch(f *frame)  { l.order.MoveToFront(f.elem) }
+func (l *lru) insert(f *frame) { f.elem = l.order.PushFront(f) }
+
+func (l *lru) victim() *frame {
+	f := l.order.Remove(l.order.Back()).(*frame)
+	f.elem = nil
+	return f
+}
+
+// clock approximates LRU with a reference bit per frame and a hand sweeping over
+// them: a referenced frame gets its bit cleared and a second chance, the first
+// unreferenced one is the victim.
+type clock struct {
+	frames []*frame
+	hand   int
+}
+
+func (c *clock) touch(f *frame)  { f.ref = true }
+func (c *clock) insert(f *frame) { f.ref = true }
+
+func (c *clock) victim() *frame {
+	for {
+		f := c.frames[c.hand]
+		c.hand = (c.hand + 1) % len(c.frames)
+		if !f.ref {
+			return f
+		}
+		f.ref = false
+	}
+}
+
+// Cache is a simulated buffer cache. It is not safe for concurrent use.
+type Cache struct {
+	cfg    Config
+	frames map[int]*frame
+	ev     evictor
+	clock  *clock
+	ops    int
+	stats  Stats
+}
+
+// New returns an empty cache.
+func New(cfg Config) (*Cache, error) {
+	if cfg.Frames <= 0 {
+		return nil, fmt.Errorf("bufcache: need at least one frame")
+	}
+	c := &Cache{cfg: cfg, frames: make(map[int]*frame, cfg.Frames)}
+	switch cfg.Eviction {
+	case "lru", "":
+		c.ev = &lru{order: list.New()}
+	case "clock":
+		c.clock = &clock{}
+		c.ev = c.clock
+	default:
+		return nil, fmt.Errorf("bufcache: unknown eviction policy %q", cfg.Eviction)
+	}
+	return c, nil
+}
+
+// get returns the frame for block, loading it on a miss. load is false for full
+// block writes, there's no point reading what's about to be overwritten.
+func (c *Cache) get(block int, load bool) *frame {
+	if f, ok := c.frames[block]; ok {
+		c.stats.Hits++
+		c.ev.touch(f)
+		return f
+	}
+	c.stats.Misses++
+	if load {
+		c.stats.DiskReads++
+	}
+
+	if len(c.frames) < c.cfg.Frames {
+		f := &frame{block: block}
+		c.frames[block] = f
+		if c.clock != nil {
+			c.clock.frames = append(c.clock.frames, f)
+		}
+		c.ev.insert(f)
+		return f
+	}
+
+	// reuse the victim frame in place, the clock keeps its slot
+	f := c.ev.victim()
+	c.stats.Evictions++
+	if f.dirty {
+		c.stats.DirtyEvictions++
+		c.stats.DiskWrites++
+	}
+	delete(c.frames, f.block)
+	f.block, f.dirty = block, false
+	c.frames[block] = f
+	c.ev.insert(f)
+	return f
+}
+
+// Read accesses block for reading.
+func (c *Cache) Read(block int) {
+	c.stats.Reads++
+	c.get(block, true)
+	c.tick()
+}
+
+// Write overwrites block.
+func (c *Cache) Write(block int) {
+	c.stats.Writes++
+	f := c.get(block, false)
+	if c.cfg.Write == WriteThrough {
+		c.stats.DiskWrites++
+	} else {
+		f.dirty = true
+	}
+	c.tick()
+}
+
+func (c *Cache) tick() {
+	c.ops++
+	if c.cfg.SyncEvery > 0 && c.ops%c.cfg.SyncEvery == 0 {
+		c.Sync()
+	}
+}
+
+// Sync writes every dirty block to disk.
+func (c *Cache) Sync() {
+	for _, f := range c.frames {
+		if f.dirty {
+			f.dirty = false
+			c.stats.DiskWrites++
+		}
+	}
+}
+
+// Stats returns the counters so far.
+func (c *Cache) Stats() Stats { return c.stats }
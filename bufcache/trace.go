@@ -0,0 +1,75 @@
+package bufcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Access is one step of a block trace.
+type Access struct {
+	Write bool
+	Block int
+}
+
+// ParseTrace reads lines of "r <block>" or "w <block>".
+func ParseTrace(r io.Reader) ([]Access, error) {
+	var out []Access
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if len(f) != 2 || f[0] != "r" && f[0] != "w" {
+			return nil, fmt.Errorf("trace line %v: can't parse %q", line, s.Text())
+		}
+		b, err := strconv.Atoi(f[1])
+		if err != nil || b < 0 {
+			return nil, fmt.Errorf("trace line %v: bad block %q", line, f[1])
+		}
+		out = append(out, Access{Write: f[0] == "w", Block: b})
+	}
+	return out, s.Err()
+}
+
+// GenerateTrace makes n accesses over blocks blocks with a zipf distribution, a few
+// hot blocks get most of the traffic like real workloads. Every scanEvery accesses a
+// sequential scan over scanLen blocks is mixed in, scans are what hurts LRU.
+func GenerateTrace(seed int64, n, blocks int, writeRatio float64, scanEvery, scanLen int) []Access {
+	rng := rand.New(rand.NewSource(seed))
+	zipf := rand.NewZipf(rng, 1.1, 1, uint64(blocks-1))
+	out := make([]Access, 0, n)
+	for len(out) < n {
+		if scanEvery > 0 && len(out) > 0 && len(out)%scanEvery == 0 {
+			start := rng.Intn(blocks)
+			for i := 0; i < scanLen && len(out) < n; i++ {
+				out = append(out, Access{Block: (start + i) % blocks})
+			}
+			continue
+		}
+		out = append(out, Access{Write: rng.Float64() < writeRatio, Block: int(zipf.Uint64())})
+	}
+	return out
+}
+
+// Replay runs the trace through a cache built from cfg and syncs at the end, so
+// every dirty block is accounted for.
+func Replay(cfg Config, trace []Access) (Stats, error) {
+	c, err := New(cfg)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, a := range trace {
+		if a.Write {
+			c.Write(a.Block)
+		} else {
+			c.Read(a.Block)
+		}
+	}
+	c.Sync()
+	return c.Stats(), nil
+}
@@ -0,0 +1,109 @@
+// Package causal has the two classic logical clocks for message passing processes.
+//
+// A Lamport clock is a single counter. It guarantees that if a happened before b then
+// L(a) < L(b), but not the converse: two events with L(a) < L(b) may just as well be
+// unrelated. Sorting by (L, process) gives a total order that respects causality,
+// which is all mutual exclusion algorithms and the like need.
+//
+// A vector clock keeps one counter per process and captures causality exactly:
+// V(a) < V(b) if and only if a happened before b, and when neither is smaller the
+// events are concurrent, nothing either process saw connects them.
+package causal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lamport is a Lamport clock. The zero value is ready to use.
+type Lamport struct {
+	t uint64
+}
+
+// Now returns the current time without advancing it.
+func (l *Lamport) Now() uint64 { return l.t }
+
+// Tick advances the clock for a local or send event and returns the event's time.
+func (l *Lamport) Tick() uint64 {
+	l.t++
+	return l.t
+}
+
+// Recv merges the timestamp of a received message and returns the receive event's
+// time, which is later than both the sender's and everything seen locally.
+func (l *Lamport) Recv(t uint64) uint64 {
+	l.t = max(l.t, t) + 1
+	return l.t
+}
+
+// Vector is a vector clock for a fixed set of processes, indexed 0..n-1.
+type Vector []uint64
+
+// NewVector returns a zero vector clock for n processes.
+func NewVector(n int) Vector { return make(Vector, n) }
+
+// Copy returns an independent copy, to stamp an event or a message with.
+func (v Vector) Copy() Vector { return append(Vector(nil), v...) }
+
+// Tick advances process self's entry for a local or send event.
+func (v Vector) Tick(self int) { v[self]++ }
+
+// Recv merges a received message's clock, entry by entry maximum, then ticks self.
+func (v Vector) Recv(self int, msg Vector) {
+	for i := range v {
+		v[i] = max(v[i], msg[i])
+	}
+	v[self]++
+}
+
+// Order is how two vector timestamps relate.
+type Order int
+
+const (
+	Equal Order = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Order) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	}
+	return "concurrent"
+}
+
+// Compare reports whether a happened before b, after it, or concurrently.
+func Compare(a, b Vector) Order {
+	less, greater := false, false
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			less = true
+		case a[i] > b[i]:
+			greater = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+func (v Vector) String() string {
+	parts := make([]string, len(v))
+	for i, c := range v {
+		parts[i] = fmt.Sprint(c)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
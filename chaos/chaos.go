@@ -0,0 +1,215 @@
+// Package chaos injects failures into concurrent code: random latency, dropped
+// messages and panics, all driven by seeded random sources.
+//
+// An injector shared by several goroutines hands out its random draws in whatever
+// order the goroutines happen to ask, so the same seed gives the same mix of
+// failures but not the same failure at the same call. For replayable decisions give
+// every goroutine (or message stream) a site of its own with Site: a site draws from
+// its own source, seeded from the seed and its name, so it makes the same decisions
+// in the same order on every run with that seed, whatever the scheduling.
+//
+// A nil *Injector is valid and injects nothing, so demos can keep a single code path
+// and only create an injector when chaos is switched on.
+package chaos
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config controls what gets injected. Probabilities are in [0, 1].
+type Config struct {
+	Seed        int64
+	LatencyProb float64
+	MaxLatency  time.Duration
+	DropProb    float64
+	PanicProb   float64
+}
+
+// Enabled reports whether the config injects anything at all.
+func (c Config) Enabled() bool {
+	return c.LatencyProb > 0 || c.DropProb > 0 || c.PanicProb > 0
+}
+
+// RegisterFlags adds the -chaos-* flags to fs and returns the config they fill in.
+func RegisterFlags(fs *flag.FlagSet) *Config {
+	c := &Config{}
+	fs.Int64Var(&c.Seed, "chaos-seed", 1, "seed for the chaos random source")
+	fs.Float64Var(&c.LatencyProb, "chaos-latency", 0, "probability of injecting a delay")
+	fs.DurationVar(&c.MaxLatency, "chaos-max-latency", 10*time.Millisecond, "upper bound for injected delays")
+	fs.Float64Var(&c.DropProb, "chaos-drop", 0, "probability of dropping a message")
+	fs.Float64Var(&c.PanicProb, "chaos-panic", 0, "probability of panicking in a wrapped worker")
+	return c
+}
+
+// Panic is the value injected panics are raised with, so recover() can tell them
+// apart from real bugs.
+type Panic struct {
+	Seq int64
+}
+
+func (p Panic) Error() string {
+	return fmt.Sprintf("chaos: injected panic #%d", p.Seq)
+}
+
+// Stats counts what has been injected so far.
+type Stats struct {
+	Delays  int64
+	Drops   int64
+	Panics  int64
+	Delayed time.Duration
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("delays=%v (total %v) drops=%v panics=%v", s.Delays, s.Delayed, s.Drops, s.Panics)
+}
+
+// Injector makes the random decisions. It is safe for concurrent use.
+type Injector struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	// shared by an injector and all its sites
+	counts *counters
+}
+
+type counters struct {
+	delays  atomic.Int64
+	delayed atomic.Int64
+	drops   atomic.Int64
+	panics  atomic.Int64
+}
+
+// New returns an injector for cfg, or nil if cfg doesn't inject anything.
+func New(cfg Config) *Injector {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &Injector{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed)), counts: &counters{}}
+}
+
+// Site returns an injector with the same config and its own random source, seeded
+// from the seed and name. What it injects is counted in the parent's Stats. Sites
+// with the same name make the same decisions, give each one a name of its own.
+func (i *Injector) Site(name string) *Injector {
+	if i == nil {
+		return nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	seed := i.cfg.Seed ^ int64(h.Sum64())
+	return &Injector{cfg: i.cfg, rng: rand.New(rand.NewSource(seed)), counts: i.counts}
+}
+
+// roll returns true with probability p, plus a second random number for the caller.
+func (i *Injector) roll(p float64) (bool, float64) {
+	if p <= 0 {
+		return false, 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < p, i.rng.Float64()
+}
+
+// Delay sleeps for a random duration with probability LatencyProb.
+func (i *Injector) Delay() {
+	if d := i.Latency(); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Latency draws the delay Delay would sleep for, 0 for none, and counts it. It lets
+// the caller draw the decision now and sleep later, on another goroutine.
+func (i *Injector) Latency() time.Duration {
+	if i == nil {
+		return 0
+	}
+	hit, f := i.roll(i.cfg.LatencyProb)
+	if !hit || i.cfg.MaxLatency <= 0 {
+		return 0
+	}
+	d := time.Duration(f * float64(i.cfg.MaxLatency))
+	i.counts.delays.Add(1)
+	i.counts.delayed.Add(int64(d))
+	return d
+}
+
+// Drop reports whether the current message should be dropped.
+func (i *Injector) Drop() bool {
+	if i == nil {
+		return false
+	}
+	hit, _ := i.roll(i.cfg.DropProb)
+	if hit {
+		i.counts.drops.Add(1)
+	}
+	return hit
+}
+
+// MaybePanic panics with a Panic value with probability PanicProb.
+func (i *Injector) MaybePanic() {
+	if i == nil {
+		return
+	}
+	if hit, _ := i.roll(i.cfg.PanicProb); hit {
+		panic(Panic{Seq: i.counts.panics.Add(1)})
+	}
+}
+
+// Wrap returns fn with a possible delay and a possible panic injected before it runs.
+func (i *Injector) Wrap(fn func()) func() {
+	if i == nil {
+		return fn
+	}
+	return func() {
+		i.Delay()
+		i.MaybePanic()
+		fn()
+	}
+}
+
+// Stats returns a snapshot of the injection counters, of the injector and all its
+// sites.
+func (i *Injector) Stats() Stats {
+	if i == nil {
+		return Stats{}
+	}
+	return Stats{
+		Delays:  i.counts.delays.Load(),
+		Drops:   i.counts.drops.Load(),
+		Panics:  i.counts.panics.Load(),
+		Delayed: time.Duration(i.counts.delayed.Load()),
+	}
+}
+
+// Chan forwards everything from in to the returned channel, delaying and dropping
+// messages along the way. All decisions are made on one goroutine, with a site of
+// its own the stream's fate replays with the seed. The returned channel is closed once in is closed.
+// onDrop, if not nil, is called with every dropped message.
+func Chan[T any](i *Injector, in <-chan T, onDrop func(T)) <-chan T {
+	if i == nil {
+		return in
+	}
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for v := range in {
+			i.Delay()
+			if i.Drop() {
+				if onDrop != nil {
+					onDrop(v)
+				}
+				continue
+			}
+			out <- v
+		}
+	}()
+	return out
+}
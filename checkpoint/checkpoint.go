@@ -0,0 +1,187 @@
+// Package checkpoint takes consistent snapshots of a running concurrent program.
+//
+// Copying the state of a system while its goroutines keep changing it gives you a
+// mix of before and after. A World stops them first, the way the garbage collector
+// stops the world: every participating goroutine calls SafePoint regularly, at a
+// point where it holds no locks and the shared state is consistent. Stop raises a
+// flag, waits until every participant is parked at a safe point, runs the snapshot
+// and lets them all go again.
+//
+// A goroutine that blocks (waiting for a slot in a buffer, a fork, a job) would never
+// reach its next safe point, so blocking calls are wrapped in Blocked. A blocked
+// goroutine counts as stopped, like a goroutine in a system call does for the
+// runtime, and if it wakes up while the world is stopped it parks before touching
+// anything.
+//
+// Save and Load write a snapshot to a file and read it back. Save writes a temp
+// file, fsyncs it and renames it into place, so a crash never leaves half a
+// checkpoint behind.
+package checkpoint
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes the stops so far.
+type Stats struct {
+	Stops int
+	// Reach is the time from the stop request until every participant was parked,
+	// Pause the time the world was stopped in total. Both are for the last stop.
+	Reach, Pause time.Duration
+	// TotalPause adds up Pause over all stops.
+	TotalPause time.Duration
+}
+
+// World coordinates a set of participating goroutines.
+type World struct {
+	stopping atomic.Bool
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	stopper bool // a Stop is in progress, only one at a time
+	running int  // participants neither parked nor blocked
+	members int
+	stats   Stats
+}
+
+// NewWorld returns a world without participants.
+func NewWorld() *World {
+	w := &World{}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Join makes the calling goroutine a participant. It waits if the world is
+// stopped.
+func (w *World) Join() {
+	w.mu.Lock()
+	for w.stopping.Load() {
+		w.cond.Wait()
+	}
+	w.running++
+	w.members++
+	w.mu.Unlock()
+}
+
+// Leave ends the participation, typically deferred right after Join.
+func (w *World) Leave() {
+	w.mu.Lock()
+	w.running--
+	w.members--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// SafePoint parks the caller while the world is stopped. It costs an atomic load
+// when it isn't, so it can be called often.
+func (w *World) SafePoint() {
+	if !w.stopping.Load() {
+		return
+	}
+	w.mu.Lock()
+	w.running--
+	w.cond.Broadcast()
+	for w.stopping.Load() {
+		w.cond.Wait()
+	}
+	w.running++
+	w.mu.Unlock()
+}
+
+// Blocked runs fn, which may block indefinitely, with the caller counted as
+// stopped. Once fn returns the caller parks if the world is stopped. The caller
+// must be at a safe point, holding no locks the snapshot needs.
+func (w *World) Blocked(fn func()) {
+	w.mu.Lock()
+	w.running--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	fn()
+	w.mu.Lock()
+	for w.stopping.Load() {
+		w.cond.Wait()
+	}
+	w.running++
+	w.mu.Unlock()
+}
+
+// Stop stops the world, runs fn and starts it again. fn sees every participant
+// parked or blocked. It must not be called by a participant.
+func (w *World) Stop(fn func()) {
+	start := time.Now()
+	w.mu.Lock()
+	for w.stopper {
+		w.cond.Wait()
+	}
+	w.stopper = true
+	w.stopping.Store(true)
+	for w.running > 0 {
+		w.cond.Wait()
+	}
+	reached := time.Now()
+	w.mu.Unlock()
+
+	fn()
+
+	w.mu.Lock()
+	w.stopping.Store(false)
+	w.stopper = false
+	w.stats.Stops++
+	w.stats.Reach = reached.Sub(start)
+	w.stats.Pause = time.Since(start)
+	w.stats.TotalPause += w.stats.Pause
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// Stats returns the stop statistics.
+func (w *World) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Save gob encodes v into path atomically.
+func Save(path string, v any) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := gob.NewEncoder(tmp).Encode(v); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// the rename itself is only durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Load decodes the checkpoint at path into v.
+func Load(path string, v any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gob.NewDecoder(f).Decode(v)
+}
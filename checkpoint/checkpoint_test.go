@@ -0,0 +1,157 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotsAreConsistent(t *testing.T) {
+	w := NewWorld()
+	// every worker bumps a, then b: between safe points they're always equal. The
+	// counters are plain ints, Stop's handoff is what orders the snapshot after the
+	// writes, -race checks that.
+	const workers = 4
+	var a, b [workers]int
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		w.Join()
+		go func(i int) {
+			defer wg.Done()
+			defer w.Leave()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				a[i]++
+				b[i]++
+				w.SafePoint()
+			}
+		}(i)
+	}
+	for s := 0; s < 50; s++ {
+		w.Stop(func() {
+			for i := range a {
+				if a[i] != b[i] {
+					t.Errorf("snapshot %v caught worker %v mid-update: a=%v b=%v", s, i, a[i], b[i])
+				}
+			}
+		})
+	}
+	close(done)
+	wg.Wait()
+	if st := w.Stats(); st.Stops != 50 || st.TotalPause < st.Pause || st.Pause < st.Reach {
+		t.Errorf("stats don't add up: %+v", st)
+	}
+}
+
+func TestBlockedCountsAsStopped(t *testing.T) {
+	w := NewWorld()
+	wake := make(chan int)
+	var got int
+	resumed := make(chan struct{})
+	w.Join()
+	go func() {
+		defer w.Leave()
+		w.Blocked(func() { got = <-wake })
+		close(resumed)
+	}()
+
+	stopped := make(chan struct{})
+	release := make(chan struct{})
+	go w.Stop(func() {
+		close(stopped)
+		<-release
+	})
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop waited for a participant blocked outside any safe point")
+	}
+	// wake it while stopped: the blocking call returns but the participant parks
+	wake <- 1
+	select {
+	case <-resumed:
+		t.Fatal("participant ran on while the world was stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-resumed
+	if got != 1 {
+		t.Fatalf("got %v, want 1", got)
+	}
+}
+
+func TestJoinWaitsForStop(t *testing.T) {
+	w := NewWorld()
+	stopped := make(chan struct{})
+	release := make(chan struct{})
+	go w.Stop(func() {
+		close(stopped)
+		<-release
+	})
+	<-stopped
+	joined := make(chan struct{})
+	go func() {
+		w.Join()
+		close(joined)
+		w.Leave()
+	}()
+	select {
+	case <-joined:
+		t.Fatal("Join went through a stopped world")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-joined
+}
+
+type state struct {
+	Step  int
+	Names []string
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ckpt")
+	for step := 1; step <= 3; step++ {
+		if err := Save(path, state{step, []string{"a", "b"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got state
+	if err := Load(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Step != 3 || len(got.Names) != 2 {
+		t.Fatalf("loaded %+v, want the last save", got)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Fatalf("%v files in the directory, temp files left behind", len(ents))
+	}
+}
+
+func TestFailedSaveKeepsOld(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ckpt")
+	if err := Save(path, state{Step: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// gob can't encode a channel, the save fails half way through
+	if err := Save(path, struct{ C chan int }{make(chan int)}); err == nil {
+		t.Fatal("saving a channel succeeded")
+	}
+	var got state
+	if err := Load(path, &got); err != nil || got.Step != 1 {
+		t.Fatalf("after a failed save: %+v, %v; want the old checkpoint", got, err)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Fatalf("%v files in the directory, the failed save left its temp file", len(ents))
+	}
+}
@@ -0,0 +1,198 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a manually driven clock. Timers and tickers created from it fire only when
+// Advance (or Set) moves time past their deadline.
+//
+// Like the real ones, fake timer and ticker channels have a buffer of one and a
+// ticker that isn't being read drops ticks instead of queueing them.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer // pending timers and tickers, sorted by deadline
+	seq     int64        // creation order, breaks ties between equal deadlines
+}
+
+// NewFake returns a fake clock reading start.
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration { return f.Now().Sub(t) }
+
+// Sleep blocks until another goroutine advances the clock by at least d.
+func (f *Fake) Sleep(d time.Duration) { <-f.After(d) }
+
+func (f *Fake) After(d time.Duration) <-chan time.Time { return f.NewTimer(d).C() }
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, ch: make(chan time.Time, 1)}
+	f.mu.Lock()
+	f.schedule(t, d)
+	f.mu.Unlock()
+	return t
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{f: f, ch: make(chan time.Time, 1), period: d}
+	f.mu.Lock()
+	f.schedule(t, d)
+	f.mu.Unlock()
+	return (*fakeTicker)(t)
+}
+
+// Advance moves the clock forward by d, firing everything that comes due on the way
+// in deadline order. Each timer sees Now() equal to its own deadline when it fires.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	target := f.now.Add(d)
+	f.mu.Unlock()
+	f.Set(target)
+}
+
+// Set moves the clock to t, which must not be before the current time.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) > 0 && !f.waiters[0].when.After(t) {
+		w := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = w.when
+		w.fire()
+	}
+	if t.After(f.now) {
+		f.now = t
+	}
+	f.cond.Broadcast()
+}
+
+// Pending returns the number of timers and tickers waiting to fire.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers or tickers are pending. It's how a driver
+// knows the goroutine under test has reached its Sleep or armed its timer, so the next
+// Advance isn't lost by racing ahead of it.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// BlockUntilDue waits until the earliest pending timer or ticker is due at t. After
+// handing the goroutine under test an event that makes it rearm a timer, it's how a
+// driver knows the new deadline is in place: BlockUntil can't tell, the number of
+// pending timers stays the same.
+func (f *Fake) BlockUntilDue(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) == 0 || !f.waiters[0].when.Equal(t) {
+		f.cond.Wait()
+	}
+}
+
+// schedule (re)arms t to fire d from now. f.mu must be held.
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	f.remove(t)
+	f.seq++
+	t.when, t.seq = f.now.Add(d), f.seq
+	if d <= 0 {
+		// already due, like time.NewTimer(0)
+		t.fire()
+		return
+	}
+	i := sort.Search(len(f.waiters), func(i int) bool {
+		w := f.waiters[i]
+		return w.when.After(t.when) || (w.when.Equal(t.when) && w.seq > t.seq)
+	})
+	f.waiters = append(f.waiters, nil)
+	copy(f.waiters[i+1:], f.waiters[i:])
+	f.waiters[i] = t
+	f.cond.Broadcast()
+}
+
+// remove takes t off the pending list, reporting whether it was there. f.mu must be held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	f      *Fake
+	ch     chan time.Time
+	when   time.Time
+	seq    int64
+	period time.Duration // 0 for timers
+}
+
+// fire delivers the current time and reschedules tickers. f.mu must be held.
+func (t *fakeTimer) fire() {
+	select {
+	case t.ch <- t.when:
+	default:
+		// nobody read the previous value, drop this one like the runtime does
+	}
+	if t.period > 0 {
+		t.f.schedule(t, t.period)
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	was := t.f.remove(t)
+	t.f.schedule(t, d)
+	return was
+}
+
+type fakeTicker fakeTimer
+
+func (t *fakeTicker) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTicker) Stop() { (*fakeTimer)(t).Stop() }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.period = d
+	t.f.schedule((*fakeTimer)(t), d)
+}
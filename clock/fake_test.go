@@ -0,0 +1,153 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recv returns the value waiting on ch, failing the test if there is none. A fake
+// timer delivers during Advance, so the value is there by the time Advance returns.
+func recv(t *testing.T, ch <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	default:
+		t.Fatal("nothing delivered")
+		return time.Time{}
+	}
+}
+
+func empty(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		t.Fatalf("unexpected delivery of %v", v.Sub(start))
+	default:
+	}
+}
+
+func TestAdvanceFiresDueTimersInOrder(t *testing.T) {
+	f := NewFake(start)
+	a := f.NewTimer(30 * time.Millisecond)
+	b := f.NewTimer(10 * time.Millisecond)
+	c := f.NewTimer(20 * time.Millisecond)
+
+	f.Advance(25 * time.Millisecond)
+	if got := recv(t, b.C()); !got.Equal(start.Add(10 * time.Millisecond)) {
+		t.Errorf("b fired with %v, expected its deadline", got.Sub(start))
+	}
+	if got := recv(t, c.C()); !got.Equal(start.Add(20 * time.Millisecond)) {
+		t.Errorf("c fired with %v, expected its deadline", got.Sub(start))
+	}
+	empty(t, a.C())
+	if f.Now() != start.Add(25*time.Millisecond) {
+		t.Errorf("Now() = %v after Advance(25ms)", f.Now().Sub(start))
+	}
+	if f.Pending() != 1 {
+		t.Errorf("%v pending, expected a", f.Pending())
+	}
+
+	f.Set(start.Add(time.Hour))
+	recv(t, a.C())
+	if f.Now() != start.Add(time.Hour) || f.Pending() != 0 {
+		t.Errorf("after Set: Now() = %v, %v pending", f.Now().Sub(start), f.Pending())
+	}
+}
+
+func TestZeroTimerFiresAtOnce(t *testing.T) {
+	f := NewFake(start)
+	recv(t, f.After(0))
+}
+
+func TestTimerStopReset(t *testing.T) {
+	f := NewFake(start)
+	tm := f.NewTimer(10 * time.Millisecond)
+	if !tm.Stop() {
+		t.Fatal("Stop of a pending timer returned false")
+	}
+	if tm.Stop() {
+		t.Fatal("second Stop returned true")
+	}
+	f.Advance(time.Second)
+	empty(t, tm.C())
+
+	if tm.Reset(10 * time.Millisecond) {
+		t.Fatal("Reset of a stopped timer returned true")
+	}
+	f.Advance(9 * time.Millisecond)
+	empty(t, tm.C())
+	// a Reset while pending moves the deadline, counted from now
+	if !tm.Reset(10 * time.Millisecond) {
+		t.Fatal("Reset of a pending timer returned false")
+	}
+	f.Advance(9 * time.Millisecond)
+	empty(t, tm.C())
+	f.Advance(time.Millisecond)
+	if got := recv(t, tm.C()); !got.Equal(start.Add(time.Second + 19*time.Millisecond)) {
+		t.Errorf("fired with %v", got.Sub(start))
+	}
+	if tm.Stop() {
+		t.Error("Stop of a fired timer returned true")
+	}
+}
+
+func TestTickerDropsUnreadTicks(t *testing.T) {
+	f := NewFake(start)
+	tk := f.NewTicker(10 * time.Millisecond)
+	defer tk.Stop()
+
+	f.Advance(35 * time.Millisecond)
+	// three ticks came due, the channel holds the first and the rest were dropped
+	if got := recv(t, tk.C()); !got.Equal(start.Add(10 * time.Millisecond)) {
+		t.Errorf("first tick %v", got.Sub(start))
+	}
+	empty(t, tk.C())
+	f.Advance(5 * time.Millisecond)
+	if got := recv(t, tk.C()); !got.Equal(start.Add(40 * time.Millisecond)) {
+		t.Errorf("next tick %v", got.Sub(start))
+	}
+
+	tk.Reset(time.Second)
+	f.Advance(999 * time.Millisecond)
+	empty(t, tk.C())
+	f.Advance(time.Millisecond)
+	recv(t, tk.C())
+
+	tk.Stop()
+	f.Advance(time.Hour)
+	empty(t, tk.C())
+}
+
+func TestBlockUntilWaitsForSleeper(t *testing.T) {
+	f := NewFake(start)
+	done := make(chan time.Time)
+	go func() {
+		f.Sleep(time.Hour)
+		done <- f.Now()
+	}()
+	// without waiting the Advance could run before the Sleep and be lost
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	if got := <-done; !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("sleeper woke at %v", got.Sub(start))
+	}
+}
+
+func TestBlockUntilDueSeesRearm(t *testing.T) {
+	f := NewFake(start)
+	tm := f.NewTimer(10 * time.Millisecond)
+	rearm := make(chan struct{})
+	go func() {
+		<-rearm
+		tm.Reset(50 * time.Millisecond)
+	}()
+	close(rearm)
+	f.BlockUntilDue(start.Add(50 * time.Millisecond))
+	f.Advance(10 * time.Millisecond)
+	empty(t, tm.C())
+	f.Advance(40 * time.Millisecond)
+	recv(t, tm.C())
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/neilharia7/operating-systems-with-go/container"
+)
+
+func init() {
+	commands["container"] = command{
+		usage: "run a command in new namespaces with its own root: container -rootfs dir [-mem 64M] [-pids n] [-cpus f] cmd args",
+		run:   runContainer,
+	}
+	commands["container-init"] = command{
+		usage: "set up the inside of a container, started by container",
+		run:   func([]string) error { return container.Init() },
+	}
+}
+
+// parseBytes understands plain numbers and K/M/G suffixes.
+func parseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n * mult, err
+}
+
+func runContainer(args []string) error {
+	fs := flag.NewFlagSet("container", flag.ExitOnError)
+	rootfs := fs.String("rootfs", "", "directory to use as the container's /")
+	build := fs.Bool("build-rootfs", false, "populate -rootfs with a few host binaries and their libraries first")
+	hostname := fs.String("hostname", "container", "hostname inside the UTS namespace")
+	mem := fs.String("mem", "", "memory limit, e.g. 64M")
+	pids := fs.Int("pids", 0, "max number of processes")
+	cpus := fs.Float64("cpus", 0, "cpu quota in cores, e.g. 0.5")
+	fs.Parse(args)
+
+	if *rootfs == "" {
+		return fmt.Errorf("-rootfs is required")
+	}
+	if *build {
+		copied, err := container.BuildRootfs(*rootfs, container.DefaultBinaries)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rootfs %v: copied %v\n", *rootfs, strings.Join(copied, " "))
+	}
+	argv := fs.Args()
+	if len(argv) == 0 {
+		argv = []string{"sh"}
+	}
+	memBytes, err := parseBytes(*mem)
+	if err != nil {
+		return fmt.Errorf("bad -mem %q", *mem)
+	}
+
+	code, err := container.Run(container.Config{
+		Rootfs:   *rootfs,
+		Hostname: *hostname,
+		Args:     argv,
+		Limits:   container.Limits{MemoryBytes: memBytes, Pids: *pids, CPUs: *cpus},
+		InitArgs: []string{"container-init"},
+	})
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
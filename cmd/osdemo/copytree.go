@@ -0,0 +1,169 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/treecopy"
+)
+
+func init() {
+	commands["copytree"] = command{
+		usage: "copy a directory tree in parallel with copy_file_range/sendfile: copytree [-method auto|all] [-gen n] src dst",
+		run:   runCopytree,
+	}
+}
+
+// generateTree fills dir with n files spread over a few subdirectories, most of
+// them small and a few big ones, plus a symlink.
+func generateTree(dir string, n int) error {
+	rng := rand.New(rand.NewSource(1))
+	buf := make([]byte, 8<<20)
+	rng.Read(buf)
+	for i := 0; i < n; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("d%02d", i%10))
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			return err
+		}
+		size := 4<<10 + rng.Intn(60<<10)
+		if i%50 == 0 {
+			size = len(buf)
+		}
+		path := filepath.Join(sub, fmt.Sprintf("f%05d", i))
+		if err := os.WriteFile(path, buf[:size], 0o640); err != nil {
+			return err
+		}
+		// an old mtime, so a copy that loses it shows
+		old := time.Now().Add(-time.Duration(i+1) * time.Hour)
+		os.Chtimes(path, old, old)
+	}
+	return os.Symlink("d00/f00000", filepath.Join(dir, "first"))
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	return h.Sum(nil), err
+}
+
+// verifyTree checks that every entry of src is in dst with the same type, mode,
+// mtime and contents.
+func verifyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		a, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		b, err := os.Lstat(target)
+		if err != nil {
+			return err
+		}
+		if a.Mode() != b.Mode() {
+			return fmt.Errorf("%v: mode %v, copy has %v", rel, a.Mode(), b.Mode())
+		}
+		if a.Mode().IsRegular() {
+			if !a.ModTime().Equal(b.ModTime()) {
+				return fmt.Errorf("%v: mtime %v, copy has %v", rel, a.ModTime(), b.ModTime())
+			}
+			ha, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			hb, err := hashFile(target)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(ha, hb) {
+				return fmt.Errorf("%v: contents differ", rel)
+			}
+		}
+		return nil
+	})
+}
+
+func runCopytree(args []string) error {
+	fs := flag.NewFlagSet("copytree", flag.ExitOnError)
+	workers := fs.Int("workers", 8, "files copied in parallel")
+	maxOpen := fs.Int("max-open", 16, "most file descriptors open at once")
+	method := fs.String("method", "auto", "auto, copy_file_range, sendfile, buffered, or all to compare them")
+	gen := fs.Int("gen", 0, "fill src with this many generated files first")
+	verify := fs.Bool("verify", true, "compare the copy with the source afterwards")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: copytree [flags] src dst")
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+	if *gen > 0 {
+		if err := generateTree(src, *gen); err != nil {
+			return err
+		}
+	}
+
+	type run struct {
+		method treecopy.Method
+		dst    string
+	}
+	var runs []run
+	if *method == "all" {
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			return err
+		}
+		for m := treecopy.Auto; m <= treecopy.Buffered; m++ {
+			runs = append(runs, run{m, filepath.Join(dst, m.String())})
+		}
+	} else {
+		m, err := treecopy.ParseMethod(*method)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run{m, dst})
+	}
+
+	fmt.Printf("%-16s %7s %9s %10s %10s %6s  %v\n", "method", "files", "MB", "elapsed", "MB/s", "fds", "files by method used")
+	for _, r := range runs {
+		// write back dirty pages of the last run so they don't slow this one down
+		syscall.Sync()
+		st, err := treecopy.Copy(src, r.dst, treecopy.Options{Workers: *workers, MaxOpen: *maxOpen, Method: r.method})
+		if err != nil {
+			return err
+		}
+		used := ""
+		for m, n := range st.ByMethod {
+			if n > 0 {
+				used += fmt.Sprintf("%v:%v ", treecopy.Method(m), n)
+			}
+		}
+		fmt.Printf("%-16v %7v %9.1f %10v %10.1f %6v  %v\n", r.method, st.Files, float64(st.Bytes)/(1<<20),
+			st.Elapsed.Round(time.Millisecond), st.Throughput()/(1<<20), st.PeakOpen, used)
+		if *verify {
+			if err := verifyTree(src, r.dst); err != nil {
+				return fmt.Errorf("copy differs: %w", err)
+			}
+		}
+	}
+	if *verify {
+		fmt.Println("every copy matches the source: contents, modes, mtimes and symlinks")
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/election"
+	"github.com/neilharia7/operating-systems-with-go/supervisor"
+)
+
+func init() {
+	commands["elect"] = command{
+		usage: "elect a leader among processes with a file lock and kill it: elect [-n 3] [-failovers 3]",
+		run:   runElect,
+	}
+	commands["candidate"] = command{
+		usage: "an election candidate, started by elect: candidate -id name -file path",
+		run:   runCandidate,
+	}
+}
+
+// runCandidate campaigns and, once elected, does the leader's work: a tick per
+// interval stamped with its epoch. Followers just wait in Campaign and report who
+// they're following.
+func runCandidate(args []string) error {
+	fs := flag.NewFlagSet("candidate", flag.ExitOnError)
+	id := fs.String("id", "candidate", "candidate name")
+	path := fs.String("file", "", "election file")
+	tick := fs.Duration("tick", 200*time.Millisecond, "leader work interval")
+	fs.Parse(args)
+
+	e, err := election.New(*path, *id)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	if l, err := election.Current(*path); err == nil && l.Epoch > 0 {
+		fmt.Printf("[%v] standing by, last leader was %v\n", *id, l)
+	}
+	me, err := e.Campaign(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("[%v] elected leader, epoch %v\n", *id, me.Epoch)
+
+	ticker := time.NewTicker(*tick)
+	defer ticker.Stop()
+	for n := 1; ; n++ {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("[%v] SIGTERM, resigning\n", *id)
+			return e.Resign()
+		case <-ticker.C:
+			fmt.Printf("[%v] epoch %v: work tick %v\n", *id, me.Epoch, n)
+		}
+	}
+}
+
+func runElect(args []string) error {
+	fs := flag.NewFlagSet("elect", flag.ExitOnError)
+	n := fs.Int("n", 3, "number of candidate processes")
+	failovers := fs.Int("failovers", 3, "how many times the leader is killed")
+	lead := fs.Duration("lead", 600*time.Millisecond, "how long a leader leads before it's killed")
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "elect")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leader")
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// killed candidates are restarted, so there are always n of them in the race
+	sup := supervisor.New(2 * time.Second)
+	defer sup.StopAll()
+	for i := 1; i <= *n; i++ {
+		name := fmt.Sprintf("c%d", i)
+		err := sup.Start(supervisor.Spec{
+			Name:    name,
+			Path:    self,
+			Args:    []string{"candidate", "-id", name, "-file", path},
+			Restart: true,
+			Output:  os.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// waitLeader polls the record until a leader newer than epoch is in office
+	waitLeader := func(epoch uint64) (election.Leader, error) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if l, err := election.Current(path); err == nil && l.Epoch > epoch {
+				return l, nil
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return election.Leader{}, fmt.Errorf("no new leader after epoch %v", epoch)
+	}
+
+	leader, err := waitLeader(0)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < *failovers; i++ {
+		time.Sleep(*lead)
+		fmt.Printf("--- killing leader %v\n", leader)
+		killed := time.Now()
+		syscall.Kill(leader.Pid, syscall.SIGKILL)
+		next, err := waitLeader(leader.Epoch)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("--- failover to %v took %v\n", next, time.Since(killed).Round(time.Microsecond))
+		if next.Pid == leader.Pid {
+			return fmt.Errorf("killed leader pid %v still leads", leader.Pid)
+		}
+		leader = next
+	}
+	time.Sleep(*lead)
+	fmt.Printf("--- %v leadership changes, every one with a higher epoch\n", *failovers)
+	return nil
+}
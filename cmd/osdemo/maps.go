@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/neilharia7/operating-systems-with-go/procmaps"
+)
+
+func init() {
+	commands["maps"] = command{
+		usage: "show the memory layout of a process: maps [-all] <pid|self>",
+		run:   runMaps,
+	}
+}
+
+func runMaps(args []string) error {
+	fs := flag.NewFlagSet("maps", flag.ExitOnError)
+	all := fs.Bool("all", false, "list every region, not only the resident ones")
+	fs.Parse(args)
+
+	pid := "self"
+	if fs.NArg() > 0 {
+		pid = fs.Arg(0)
+	}
+	regions, err := procmaps.Read(pid)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-33s %-5s %-7s %10s %10s  %v\n", "address", "perms", "kind", "size", "rss", "path")
+	hidden := 0
+	for _, r := range regions {
+		if !*all && r.RSSKB == 0 && r.Kind != procmaps.Heap && r.Kind != procmaps.Stack {
+			hidden++
+			continue
+		}
+		fmt.Printf("%016x-%016x %-5s %-7s %9dK %9dK  %v\n", r.Start, r.End, r.Perms, r.Kind, r.SizeKB, r.RSSKB, r.Path)
+	}
+	if hidden > 0 {
+		fmt.Printf("(%v regions with nothing resident hidden, use -all)\n", hidden)
+	}
+
+	fmt.Printf("\n%-7s %8s %12s %12s\n", "kind", "regions", "virtual", "resident")
+	var size, rss int64
+	for _, s := range procmaps.Summarize(regions) {
+		fmt.Printf("%-7s %8d %11dK %11dK\n", s.Kind, s.Regions, s.SizeKB, s.RSSKB)
+		size += s.SizeKB
+		rss += s.RSSKB
+	}
+	fmt.Printf("%-7s %8d %11dK %11dK\n", "total", len(regions), size, rss)
+	return nil
+}
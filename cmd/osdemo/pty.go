@@ -0,0 +1,143 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/pty"
+)
+
+func init() {
+	commands["pty"] = command{
+		usage: "run commands under a pseudo-terminal: pty [-record file] cmd args | pty -multi 'cmd' 'cmd' | pty -replay file",
+		run:   runPty,
+	}
+}
+
+func runPty(args []string) error {
+	fs := flag.NewFlagSet("pty", flag.ExitOnError)
+	record := fs.String("record", "", "record the session to this asciicast file")
+	replay := fs.String("replay", "", "play back a recorded session")
+	speed := fs.Float64("speed", 1, "replay speed factor")
+	maxIdle := fs.Duration("max-idle", 2*time.Second, "cap on pauses during replay")
+	multi := fs.Bool("multi", false, "run every argument as a shell command, each under its own pty, and multiplex their output")
+	fs.Parse(args)
+
+	switch {
+	case *replay != "":
+		f, err := os.Open(*replay)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return pty.Replay(f, os.Stdout, *speed, *maxIdle)
+	case fs.NArg() == 0:
+		return errors.New("no command given")
+	case *multi:
+		return runMulti(fs.Args())
+	}
+	return runInteractive(fs.Args(), *record)
+}
+
+// copyOutput copies the pty's output to w until the child is gone. Reading the master
+// after the last slave fd is closed fails with EIO on linux, that's the normal end.
+func copyOutput(w io.Writer, master *os.File) error {
+	_, err := io.Copy(w, master)
+	if errors.Is(err, syscall.EIO) {
+		return nil
+	}
+	return err
+}
+
+func runInteractive(argv []string, record string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	master, err := pty.Start(cmd)
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	size := pty.Size{Rows: 24, Cols: 80}
+	if pty.IsTerminal(os.Stdin) {
+		size, _ = pty.GetSize(os.Stdin)
+		restore, err := pty.MakeRaw(os.Stdin)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+	pty.SetSize(master, size)
+
+	// keep the child's window in sync with ours
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			if s, err := pty.GetSize(os.Stdin); err == nil {
+				pty.SetSize(master, s)
+			}
+		}
+	}()
+
+	out := io.Writer(os.Stdout)
+	if record != "" {
+		f, err := os.Create(record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec, err := pty.NewRecorder(f, size, strings.Join(argv, " "))
+		if err != nil {
+			return err
+		}
+		out = io.MultiWriter(os.Stdout, rec)
+	}
+
+	// stdin -> child runs on its own, it stays blocked in Read when the child exits
+	// and dies with the process
+	go io.Copy(master, os.Stdin)
+	copyErr := copyOutput(out, master)
+	if err := cmd.Wait(); err != nil {
+		return err
+	}
+	return copyErr
+}
+
+func runMulti(commands []string) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(commands))
+	for i, c := range commands {
+		cmd := exec.Command("sh", "-c", c)
+		master, err := pty.Start(cmd)
+		if err != nil {
+			return err
+		}
+		pty.SetSize(master, pty.Size{Rows: 24, Cols: 100})
+		w := pty.NewPrefixWriter(&mu, os.Stdout, fmt.Sprintf("[%d %v]", i, c))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer master.Close()
+			errs[i] = copyOutput(w, master)
+			w.Close()
+			if err := cmd.Wait(); err != nil && errs[i] == nil {
+				errs[i] = fmt.Errorf("%q: %w", commands[i], err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
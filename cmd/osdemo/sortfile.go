@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/extsort"
+)
+
+func init() {
+	commands["sortfile"] = command{
+		usage: "sort a file bigger than memory with parallel chunk sorts and a k-way merge: sortfile [-mem 64M] [-workers n] [-gen lines] file",
+		run:   runSortfile,
+	}
+}
+
+// parseSize reads sizes like 512K, 64M or 1G.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n * mult, nil
+}
+
+// generateLines writes n random lines of 8 to 72 printable characters.
+func generateLines(path string, n int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	rng := rand.New(rand.NewSource(1))
+	line := make([]byte, 0, 80)
+	for i := 0; i < n; i++ {
+		line = line[:0]
+		for j := 8 + rng.Intn(64); j > 0; j-- {
+			line = append(line, byte('!'+rng.Intn(94)))
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkSorted verifies the output is in order and has the expected number of lines.
+func checkSorted(path string, lines int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 1<<20), 1<<30)
+	var prev string
+	var n int64
+	for s.Scan() {
+		if n > 0 && s.Text() < prev {
+			return fmt.Errorf("line %v is out of order", n+1)
+		}
+		prev = s.Text()
+		n++
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if n != lines {
+		return fmt.Errorf("output has %v lines, input had %v", n, lines)
+	}
+	return nil
+}
+
+func runSortfile(args []string) error {
+	fs := flag.NewFlagSet("sortfile", flag.ExitOnError)
+	mem := fs.String("mem", "64M", "memory budget for lines, e.g. 512K, 64M, 1G")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "parallel chunk sorts and merges")
+	fanIn := fs.Int("fanin", 128, "most runs merged at once")
+	out := fs.String("o", "", "output file, <file>.sorted by default")
+	gen := fs.Int("gen", 0, "write this many random lines to file first")
+	check := fs.Bool("check", true, "verify the output afterwards")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: sortfile [flags] file")
+	}
+	path := fs.Arg(0)
+	budget, err := parseSize(*mem)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = path + ".sorted"
+	}
+	if *gen > 0 {
+		if err := generateLines(path, *gen); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	dst, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	st, err := extsort.Sort(in, dst, extsort.Options{Memory: budget, Workers: *workers, MaxFanIn: *fanIn})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("sorted %v lines (%.1fMB) with a %v budget and %v workers into %v\n",
+		st.Lines, float64(st.Bytes)/(1<<20), *mem, *workers, *out)
+	if st.Runs == 0 {
+		fmt.Printf("  fit in memory, sorted in %v\n", st.SplitTime.Round(time.Millisecond))
+	} else {
+		fmt.Printf("  split: %v sorted runs spilled in %v\n", st.Runs, st.SplitTime.Round(time.Millisecond))
+		fmt.Printf("  merge: %v pass(es) in %v\n", st.MergePasses, st.MergeTime.Round(time.Millisecond))
+	}
+	if *check {
+		if err := checkSorted(*out, st.Lines); err != nil {
+			return err
+		}
+		fmt.Println("  output verified")
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/supervisor"
+)
+
+func init() {
+	commands["supervise"] = command{
+		usage: "run workers under a supervisor and pause/resume/reload them: supervise [-workers n] [-demo]",
+		run:   runSupervise,
+	}
+	commands["worker"] = command{
+		usage: "a supervised worker, started by supervise: worker -name n -config file",
+		run:   runWorker,
+	}
+}
+
+type workerConfig struct {
+	Version int `json:"version"`
+	JobMS   int `json:"job_ms"`
+}
+
+func writeConfig(path string, cfg workerConfig) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	// write and rename so a worker reloading mid-write never sees half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readConfig(path string) (*workerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &workerConfig{}
+	return cfg, json.Unmarshal(data, cfg)
+}
+
+// runWorker processes jobs with two goroutines. SIGUSR1 reloads the config in the
+// background: jobs already running finish with the config they started with, new
+// jobs pick up the new one. SIGTERM stops taking jobs and drains the running ones.
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	name := fs.String("name", "worker", "name used in the output")
+	path := fs.String("config", "", "config file")
+	fs.Parse(args)
+
+	cfg, err := readConfig(*path)
+	if err != nil {
+		return err
+	}
+	var current atomic.Pointer[workerConfig]
+	current.Store(cfg)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGTERM)
+	stop := make(chan struct{})
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGTERM {
+				close(stop)
+				return
+			}
+			go func() {
+				// pretend reloading takes a while, jobs keep running meanwhile
+				time.Sleep(50 * time.Millisecond)
+				cfg, err := readConfig(*path)
+				if err != nil {
+					fmt.Printf("[%v] reload failed, keeping v%v: %v\n", *name, current.Load().Version, err)
+					return
+				}
+				old := current.Swap(cfg)
+				fmt.Printf("[%v] reloaded config v%v -> v%v\n", *name, old.Version, cfg.Version)
+			}()
+		}
+	}()
+
+	var jobs atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := jobs.Add(1)
+				cfg := current.Load()
+				time.Sleep(time.Duration(cfg.JobMS) * time.Millisecond)
+				fmt.Printf("[%v] job %d done with config v%v\n", *name, id, cfg.Version)
+			}
+		}()
+	}
+	wg.Wait()
+	fmt.Printf("[%v] drained after %v jobs, exiting\n", *name, jobs.Load())
+	return nil
+}
+
+const superviseDemo = `status
+sleep 400ms
+pause w1
+status
+sleep 400ms
+bump
+reload all
+sleep 100ms
+resume w1
+status
+sleep 400ms
+stop w2
+status
+quit`
+
+func runSupervise(args []string) error {
+	fs := flag.NewFlagSet("supervise", flag.ExitOnError)
+	workers := fs.Int("workers", 2, "number of worker processes")
+	jobMS := fs.Int("job-ms", 150, "how long a job takes")
+	demo := fs.Bool("demo", false, "run a scripted session instead of reading commands from stdin")
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "supervise")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "config.json")
+	cfg := workerConfig{Version: 1, JobMS: *jobMS}
+	if err := writeConfig(cfgPath, cfg); err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	sup := supervisor.New(2 * time.Second)
+	defer sup.StopAll()
+	var names []string
+	for i := 1; i <= *workers; i++ {
+		name := fmt.Sprintf("w%d", i)
+		names = append(names, name)
+		err := sup.Start(supervisor.Spec{
+			Name:    name,
+			Path:    self,
+			Args:    []string{"worker", "-name", name, "-config", cfgPath},
+			Restart: true,
+			Output:  os.Stdout,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := func(arg string) []string {
+		if arg == "all" {
+			return names
+		}
+		return []string{arg}
+	}
+	run := func(line string) (quit bool, err error) {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			return false, nil
+		}
+		arg := ""
+		if len(f) > 1 {
+			arg = f[1]
+		}
+		switch f[0] {
+		case "status":
+			for _, st := range sup.Status() {
+				fmt.Printf("  %-4v pid %-7v %-8v restarts %v %v\n", st.Name, st.Pid, st.State, st.Restarts, st.LastExit)
+			}
+		case "pause", "resume", "reload", "stop":
+			for _, name := range targets(arg) {
+				switch f[0] {
+				case "pause":
+					err = sup.Pause(name)
+				case "resume":
+					err = sup.Resume(name)
+				case "reload":
+					err = sup.Reload(name)
+				case "stop":
+					err = sup.Stop(name)
+				}
+				if err != nil {
+					return false, err
+				}
+			}
+		case "bump":
+			cfg.Version++
+			return false, writeConfig(cfgPath, cfg)
+		case "sleep":
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return false, err
+			}
+			time.Sleep(d)
+		case "quit", "exit":
+			return true, nil
+		default:
+			return false, fmt.Errorf("unknown command %q (status pause resume reload stop bump sleep quit)", f[0])
+		}
+		return false, nil
+	}
+
+	if *demo {
+		for _, line := range strings.Split(superviseDemo, "\n") {
+			fmt.Println(">", line)
+			if quit, err := run(line); err != nil {
+				fmt.Println(err)
+			} else if quit {
+				break
+			}
+		}
+		return nil
+	}
+
+	fmt.Println("commands: status, pause/resume/reload/stop <name|all>, bump (new config version), sleep <d>, quit")
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		quit, err := run(in.Text())
+		if err != nil {
+			fmt.Println(err)
+		}
+		if quit {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/neilharia7/operating-systems-with-go/topology"
+)
+
+func init() {
+	commands["topology"] = command{
+		usage: "show CPU, cache and NUMA layout and measure memory bandwidth per node pair: topology [-bench] [-size 256]",
+		run:   runTopology,
+	}
+}
+
+func human(b int64) string {
+	switch {
+	case b >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(b)/(1<<30))
+	case b >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(b)/(1<<20))
+	case b >= 1<<10:
+		return fmt.Sprintf("%vK", b>>10)
+	}
+	return fmt.Sprint(b)
+}
+
+func printTopology(t *topology.Topology) {
+	fmt.Printf("%v package(s), %v core(s), %v logical CPU(s), %v NUMA node(s)\n\n", t.Packages(), t.Cores(), len(t.CPUs), len(t.Nodes))
+	fmt.Printf("%-5s %-8s %-5s %-5s %v\n", "cpu", "package", "core", "node", "siblings")
+	for _, c := range t.CPUs {
+		fmt.Printf("%-5v %-8v %-5v %-5v %v\n", c.ID, c.Package, c.Core, c.Node, topology.FormatList(c.Siblings))
+	}
+	fmt.Printf("\n%-6s %-12s %8s  %v\n", "cache", "type", "size", "shared by")
+	for _, c := range t.Caches {
+		fmt.Printf("L%-5v %-12v %8v  %v\n", c.Level, c.Type, human(c.Size), topology.FormatList(c.CPUs))
+	}
+	fmt.Printf("\n%-5s %-12s %9s %9s  %v\n", "node", "cpus", "memory", "free", "distances")
+	for _, n := range t.Nodes {
+		fmt.Printf("%-5v %-12v %9v %9v  %v\n", n.ID, topology.FormatList(n.CPUs), human(n.MemTotal), human(n.MemFree), n.Distance)
+	}
+}
+
+// onNode runs fn in a goroutine locked to an OS thread pinned to node's CPUs.
+func onNode(n topology.Node, fn func()) error {
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// never unlocked: the thread dies with the goroutine instead of going back
+		// to the scheduler with a pin on it
+		if err := topology.Pin(n.CPUs); err != nil {
+			errc <- err
+			return
+		}
+		fn()
+		errc <- nil
+	}()
+	return <-errc
+}
+
+// sum reads every word of buf, the loop is bandwidth bound once buf is much bigger
+// than the caches.
+func sum(buf []byte) uint64 {
+	words := unsafe.Slice((*uint64)(unsafe.Pointer(&buf[0])), len(buf)/8)
+	var a, b, c, d uint64
+	for i := 0; i+3 < len(words); i += 4 {
+		a += words[i]
+		b += words[i+1]
+		c += words[i+2]
+		d += words[i+3]
+	}
+	return a + b + c + d
+}
+
+// bandwidth places size bytes on memNode by first touch and reads them with
+// workers threads pinned to cpuNode, returning bytes per second.
+func bandwidth(memNode, cpuNode topology.Node, size, workers, passes int) (float64, map[int]int, error) {
+	// mmap'd outside the go heap, fresh pages every time: heap memory could already
+	// have been faulted in on some other node
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer syscall.Munmap(buf)
+
+	page := syscall.Getpagesize()
+	err = onNode(memNode, func() {
+		for i := 0; i < len(buf); i += page {
+			buf[i] = 1
+		}
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	where, _ := topology.PageNodes(buf, 256)
+
+	chunk := len(buf) / workers
+	var wg sync.WaitGroup
+	var sink uint64
+	var mu sync.Mutex
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		part := buf[w*chunk : (w+1)*chunk]
+		go func() {
+			defer wg.Done()
+			onNode(cpuNode, func() {
+				var s uint64
+				for p := 0; p < passes; p++ {
+					s += sum(part)
+				}
+				mu.Lock()
+				sink += s
+				mu.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	_ = sink
+	return float64(chunk*workers*passes) / elapsed.Seconds(), where, nil
+}
+
+func runTopology(args []string) error {
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	bench := fs.Bool("bench", false, "measure read bandwidth for every (cpu node, memory node) pair")
+	sizeMB := fs.Int("size", 256, "buffer size in MB, well above the last level cache")
+	workers := fs.Int("workers", 0, "reader threads, one per CPU of a node by default")
+	passes := fs.Int("passes", 4, "times each reader goes over its part")
+	fs.Parse(args)
+
+	t, err := topology.Read()
+	if err != nil {
+		return err
+	}
+	printTopology(t)
+	if !*bench {
+		return nil
+	}
+	if aff, err := topology.Affinity(); err == nil {
+		fmt.Printf("\nthis process may run on cpus %v\n", topology.FormatList(aff))
+	}
+
+	fmt.Printf("\nread bandwidth, %vMB placed on the memory node by first touch and read by threads pinned to the cpu node\n", *sizeMB)
+	fmt.Printf("%-9s %-9s %10s  %v\n", "cpu node", "mem node", "GB/s", "pages found on")
+	for _, cpuNode := range t.Nodes {
+		if len(cpuNode.CPUs) == 0 {
+			continue // memory only node
+		}
+		n := *workers
+		if n <= 0 {
+			n = len(cpuNode.CPUs)
+		}
+		for _, memNode := range t.Nodes {
+			if len(memNode.CPUs) == 0 {
+				continue // first touch needs a cpu there
+			}
+			bw, where, err := bandwidth(memNode, cpuNode, *sizeMB<<20, n, *passes)
+			if err != nil {
+				return err
+			}
+			var nodes []int
+			for id := range where {
+				nodes = append(nodes, id)
+			}
+			sort.Ints(nodes)
+			placed := ""
+			for _, id := range nodes {
+				placed += fmt.Sprintf("node%v:%v ", id, where[id])
+			}
+			if placed == "" {
+				placed = "? (move_pages not available)"
+			}
+			fmt.Printf("%-9v %-9v %10.2f  %v\n", cpuNode.ID, memNode.ID, bw/1e9, placed)
+		}
+	}
+	if len(t.Nodes) == 1 {
+		fmt.Println("\none node only: all memory is local here, on a multi socket machine the remote pairs are the slow ones")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package compute has the CPU bound work behind Scripts/compute_server.go. Each
+// function checks its context as it goes, so a request that runs out of time stops
+// burning CPU instead of finishing work nobody waits for.
+package compute
+
+import (
+	"context"
+	"slices"
+)
+
+// Fib is the naive recursion on purpose, it checks ctx every 64K calls.
+func Fib(ctx context.Context, n int) (uint64, error) {
+	calls, stopped := 0, false
+	var rec func(n int) uint64
+	rec = func(n int) uint64 {
+		// once stopped every call returns at once, so the whole tree unwinds
+		if stopped {
+			return 0
+		}
+		if calls++; calls&0xffff == 0 && ctx.Err() != nil {
+			stopped = true
+			return 0
+		}
+		if n < 2 {
+			return uint64(n)
+		}
+		return rec(n-1) + rec(n-2)
+	}
+	v := rec(n)
+	return v, ctx.Err()
+}
+
+// Primes sieves [0, n] in composite, which must be n+1 long.
+func Primes(ctx context.Context, n int, composite []bool) (count, largest int, err error) {
+	clear(composite)
+	for i := 2; i*i <= n; i++ {
+		if i&1023 == 0 && ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		if composite[i] {
+			continue
+		}
+		for j := i * i; j <= n; j += i {
+			composite[j] = true
+		}
+	}
+	for i := 2; i <= n; i++ {
+		if !composite[i] {
+			count, largest = count+1, i
+		}
+	}
+	return count, largest, ctx.Err()
+}
+
+// Sort sorts a in chunks and merges them pairwise, checking ctx between steps.
+func Sort(ctx context.Context, a []int) error {
+	const chunk = 1 << 16
+	for i := 0; i < len(a); i += chunk {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slices.Sort(a[i:min(i+chunk, len(a))])
+	}
+	orig, tmp := a, make([]int, len(a))
+	passes := 0
+	for width := chunk; width < len(a); width *= 2 {
+		for lo := 0; lo < len(a); lo += 2 * width {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			mid, hi := min(lo+width, len(a)), min(lo+2*width, len(a))
+			i, j, k := lo, mid, lo
+			for i < mid && j < hi {
+				if a[i] <= a[j] {
+					tmp[k], i = a[i], i+1
+				} else {
+					tmp[k], j = a[j], j+1
+				}
+				k++
+			}
+			k += copy(tmp[k:], a[i:mid])
+			copy(tmp[k:], a[j:hi])
+		}
+		a, tmp = tmp, a
+		passes++
+	}
+	// after an odd number of passes the result is in the scratch slice
+	if passes%2 == 1 {
+		copy(orig, a)
+	}
+	return nil
+}
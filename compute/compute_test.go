@@ -0,0 +1,67 @@
+package compute
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSort(t *testing.T) {
+	// one chunk is 1<<16: no merge, one, two and three passes, and uneven tails
+	for _, n := range []int{0, 1, 1000, 1 << 16, 100_000, 200_000, 300_000, 1<<18 + 5} {
+		a := make([]int, n)
+		for i := range a {
+			a[i] = rand.Intn(n + 1)
+		}
+		want := slices.Clone(a)
+		slices.Sort(want)
+		if err := Sort(context.Background(), a); err != nil {
+			t.Fatalf("n=%v: %v", n, err)
+		}
+		if !slices.Equal(a, want) {
+			t.Errorf("n=%v: the caller's slice isn't sorted", n)
+		}
+	}
+}
+
+func TestSortCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Sort(ctx, make([]int, 200_000)); err != context.Canceled {
+		t.Fatalf("err = %v, expected context.Canceled", err)
+	}
+}
+
+func TestPrimes(t *testing.T) {
+	tests := []struct{ n, count, largest int }{
+		{1, 0, 0},
+		{2, 1, 2},
+		{10, 4, 7},
+		{100, 25, 97},
+		{1_000_000, 78498, 999983},
+	}
+	sieve := make([]bool, 1_000_001)
+	for _, tt := range tests {
+		count, largest, err := Primes(context.Background(), tt.n, sieve[:tt.n+1])
+		if err != nil || count != tt.count || largest != tt.largest {
+			t.Errorf("Primes(%v) = %v, %v, %v, expected %v, %v", tt.n, count, largest, err, tt.count, tt.largest)
+		}
+	}
+}
+
+func TestFib(t *testing.T) {
+	if v, err := Fib(context.Background(), 30); v != 832040 || err != nil {
+		t.Fatalf("Fib(30) = %v, %v", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Fib(ctx, 90); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, expected the deadline", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Fib ran %v past a 10ms deadline", d)
+	}
+}
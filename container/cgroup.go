@@ -0,0 +1,115 @@
+//go:build linux
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Limits are the cgroup limits for a container, zero means unlimited.
+type Limits struct {
+	MemoryBytes int64
+	Pids        int
+	// CPUs is the CPU quota in cores, 0.5 means half a core.
+	CPUs float64
+}
+
+func (l Limits) empty() bool {
+	return l.MemoryBytes == 0 && l.Pids == 0 && l.CPUs == 0
+}
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroup is one group per container, on cgroup v2 that's a single directory, on v1
+// it's a directory in every controller hierarchy used.
+type cgroup struct {
+	dirs []string
+}
+
+// cpuPeriod is the CFS period the CPU quota is expressed in, in microseconds.
+const cpuPeriod = 100000
+
+func newCgroup(name string, l Limits) (*cgroup, error) {
+	if l.empty() {
+		return &cgroup{}, nil
+	}
+	cg := &cgroup{}
+	type setting struct{ controller, file, value string }
+	var settings []setting
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		// v2: one unified hierarchy
+		dir := filepath.Join(cgroupRoot, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("container: cgroup: %w", err)
+		}
+		cg.dirs = []string{dir}
+		if l.MemoryBytes > 0 {
+			settings = append(settings, setting{"", "memory.max", strconv.FormatInt(l.MemoryBytes, 10)})
+			settings = append(settings, setting{"", "memory.swap.max", "0"})
+		}
+		if l.Pids > 0 {
+			settings = append(settings, setting{"", "pids.max", strconv.Itoa(l.Pids)})
+		}
+		if l.CPUs > 0 {
+			settings = append(settings, setting{"", "cpu.max", fmt.Sprintf("%d %d", int(l.CPUs*cpuPeriod), cpuPeriod)})
+		}
+	} else {
+		// v1: a hierarchy per controller
+		if l.MemoryBytes > 0 {
+			settings = append(settings, setting{"memory", "memory.limit_in_bytes", strconv.FormatInt(l.MemoryBytes, 10)})
+		}
+		if l.Pids > 0 {
+			settings = append(settings, setting{"pids", "pids.max", strconv.Itoa(l.Pids)})
+		}
+		if l.CPUs > 0 {
+			settings = append(settings, setting{"cpu", "cpu.cfs_period_us", strconv.Itoa(cpuPeriod)})
+			settings = append(settings, setting{"cpu", "cpu.cfs_quota_us", strconv.Itoa(int(l.CPUs * cpuPeriod))})
+		}
+		seen := map[string]bool{}
+		for _, s := range settings {
+			if seen[s.controller] {
+				continue
+			}
+			seen[s.controller] = true
+			dir := filepath.Join(cgroupRoot, s.controller, name)
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				cg.remove()
+				return nil, fmt.Errorf("container: cgroup: %w", err)
+			}
+			cg.dirs = append(cg.dirs, dir)
+		}
+	}
+
+	for _, s := range settings {
+		path := filepath.Join(cgroupRoot, s.controller, name, s.file)
+		if err := os.WriteFile(path, []byte(s.value), 0o644); err != nil {
+			// memory.swap.max is missing when swap accounting is off, that's fine
+			if s.file == "memory.swap.max" && os.IsNotExist(err) {
+				continue
+			}
+			cg.remove()
+			return nil, fmt.Errorf("container: cgroup %v: %w", s.file, err)
+		}
+	}
+	return cg, nil
+}
+
+func (cg *cgroup) add(pid int) error {
+	for _, dir := range cg.dirs {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0o644); err != nil {
+			return fmt.Errorf("container: join cgroup: %w", err)
+		}
+	}
+	return nil
+}
+
+// remove deletes the cgroup directories, they must be empty by then.
+func (cg *cgroup) remove() {
+	for _, dir := range cg.dirs {
+		os.Remove(dir)
+	}
+}
@@ -0,0 +1,175 @@
+//go:build linux
+
+// Package container is a teaching sized container runtime: a command is started in
+// new PID, UTS and mount namespaces, its root is switched to a given directory with
+// pivot_root and it can be put into a cgroup with memory, process count and CPU
+// limits. That's most of what a container is, minus images, networking, user
+// namespaces and seccomp.
+//
+// Namespaces are set up with the re-exec trick: the parent starts its own binary
+// again in the new namespaces, and that child (Init) finishes the setup from the
+// inside before exec'ing the real command.
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// Config describes one container.
+type Config struct {
+	Rootfs   string
+	Hostname string
+	Args     []string
+	Limits   Limits
+	// InitArgs are the arguments that make our own binary call Init, e.g.
+	// []string{"container-init"} for osdemo.
+	InitArgs []string
+}
+
+const configEnv = "OSDEMO_CONTAINER_CONFIG"
+
+// Run starts the container, waits for it and returns the command's exit code.
+func Run(cfg Config) (int, error) {
+	if len(cfg.Args) == 0 {
+		return -1, errors.New("container: no command")
+	}
+	root, err := filepath.Abs(cfg.Rootfs)
+	if err != nil {
+		return -1, err
+	}
+	if st, err := os.Stat(root); err != nil || !st.IsDir() {
+		return -1, fmt.Errorf("container: rootfs %v is not a directory", cfg.Rootfs)
+	}
+	cfg.Rootfs = root
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return -1, err
+	}
+
+	// the child blocks on this pipe until it's been put into the cgroup, otherwise
+	// it could exec and start allocating before the limits apply
+	ready, release, err := os.Pipe()
+	if err != nil {
+		return -1, err
+	}
+	defer ready.Close()
+	defer release.Close()
+
+	cmd := exec.Command("/proc/self/exe", cfg.InitArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), configEnv+"="+string(data))
+	cmd.ExtraFiles = []*os.File{ready} // fd 3 in the child
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWNS,
+	}
+	if err := cmd.Start(); err != nil {
+		return -1, fmt.Errorf("container: start: %w (this needs root)", err)
+	}
+
+	cg, err := newCgroup(fmt.Sprintf("osdemo-%d", cmd.Process.Pid), cfg.Limits)
+	if err == nil {
+		err = cg.add(cmd.Process.Pid)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if cg != nil {
+			cg.remove()
+		}
+		return -1, err
+	}
+	defer cg.remove()
+	release.Close()
+
+	err = cmd.Wait()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// Init runs inside the new namespaces, as pid 1 of the new PID namespace. It never
+// returns on success, the process becomes the container's command.
+func Init() error {
+	var cfg Config
+	if err := json.Unmarshal([]byte(os.Getenv(configEnv)), &cfg); err != nil {
+		return fmt.Errorf("container: init called without a config: %w", err)
+	}
+	ready := os.NewFile(3, "ready")
+	buf := make([]byte, 1)
+	ready.Read(buf) // returns EOF once the parent closed its end
+	ready.Close()
+
+	if cfg.Hostname != "" {
+		if err := syscall.Sethostname([]byte(cfg.Hostname)); err != nil {
+			return fmt.Errorf("container: sethostname: %w", err)
+		}
+	}
+	if err := pivotRoot(cfg.Rootfs); err != nil {
+		return err
+	}
+	// a fresh /proc, mounted from inside the PID namespace, only shows our processes
+	os.MkdirAll("/proc", 0o555)
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("container: mount /proc: %w", err)
+	}
+
+	path, err := lookPath(cfg.Args[0])
+	if err != nil {
+		return err
+	}
+	env := []string{"PATH=/bin:/usr/bin:/sbin:/usr/sbin", "HOME=/", "TERM=" + os.Getenv("TERM")}
+	return syscall.Exec(path, cfg.Args, env)
+}
+
+// pivotRoot makes rootfs the new / and detaches the old root entirely, unlike chroot
+// which leaves the old tree reachable.
+func pivotRoot(rootfs string) error {
+	// stop our mount changes from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("container: make / private: %w", err)
+	}
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(rootfs, rootfs, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("container: bind rootfs: %w", err)
+	}
+	old := filepath.Join(rootfs, ".oldroot")
+	if err := os.MkdirAll(old, 0o700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(rootfs, old); err != nil {
+		return fmt.Errorf("container: pivot_root: %w", err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("container: unmount old root: %w", err)
+	}
+	return os.Remove("/.oldroot")
+}
+
+// lookPath resolves a command inside the new root, exec.LookPath would use the
+// host's PATH.
+func lookPath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	for _, dir := range []string{"/bin", "/usr/bin", "/sbin", "/usr/sbin"} {
+		p := filepath.Join(dir, name)
+		if st, err := os.Stat(p); err == nil && st.Mode()&0o111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("container: %v not found in rootfs", name)
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// initFailed is the test binary's exit code when Init fails, to tell a sandbox that
+// won't let us set up namespaces apart from the command's own exit code.
+const initFailed = 125
+
+func TestMain(m *testing.M) {
+	// Run re-executes the test binary, in the new namespaces that copy becomes Init
+	if os.Getenv(configEnv) != "" {
+		err := Init()
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(initFailed)
+	}
+	os.Exit(m.Run())
+}
+
+func rootfs(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh on the host")
+	}
+	dir := t.TempDir()
+	copied, err := BuildRootfs(dir, []string{"sh", "hostname", "no-such-binary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) == 0 || copied[0] != "sh" {
+		t.Fatalf("copied %v", copied)
+	}
+	return dir
+}
+
+func TestBuildRootfs(t *testing.T) {
+	dir := rootfs(t)
+	st, err := os.Stat(filepath.Join(dir, "bin", "sh"))
+	if err != nil || st.Mode()&0o111 == 0 {
+		t.Fatalf("bin/sh: %v, %v", st, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bin", "no-such-binary")); err == nil {
+		t.Error("a binary missing on the host was created")
+	}
+	sh, _ := exec.LookPath("sh")
+	sh, _ = filepath.EvalSymlinks(sh)
+	libs, err := sharedLibs(sh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lib := range libs {
+		if _, err := os.Stat(filepath.Join(dir, lib)); err != nil {
+			t.Errorf("shared library %v of sh not copied: %v", lib, err)
+		}
+	}
+	for _, d := range []string{"proc", "tmp", "etc/passwd"} {
+		if _, err := os.Stat(filepath.Join(dir, d)); err != nil {
+			t.Errorf("rootfs lacks %v", d)
+		}
+	}
+}
+
+func TestRunChecksConfig(t *testing.T) {
+	if _, err := Run(Config{Rootfs: t.TempDir()}); err == nil {
+		t.Error("Run without a command succeeded")
+	}
+	if _, err := Run(Config{Rootfs: filepath.Join(t.TempDir(), "missing"), Args: []string{"sh"}}); err == nil {
+		t.Error("Run with a missing rootfs succeeded")
+	}
+}
+
+func TestNoLimitsNoCgroup(t *testing.T) {
+	cg, err := newCgroup("unused", Limits{})
+	if err != nil || len(cg.dirs) != 0 {
+		t.Fatalf("newCgroup without limits = %v, %v", cg.dirs, err)
+	}
+	if err := cg.add(os.Getpid()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := rootfs(t)
+	run := func(script string) int {
+		t.Helper()
+		code, err := Run(Config{Rootfs: dir, Hostname: "box", Args: []string{"sh", "-c", script}})
+		if err != nil {
+			t.Skip("can't start a container here:", err)
+		}
+		if code == initFailed {
+			t.Skip("can't set up the namespaces here")
+		}
+		return code
+	}
+	// pid 1 of its own PID namespace, its own hostname, and only the rootfs to see
+	if code := run(`test $$ = 1 && test "$(hostname)" = box && test ! -e /.oldroot && test -e /etc/passwd`); code != 0 {
+		t.Errorf("container checks exited %v", code)
+	}
+	if code := run("exit 7"); code != 7 {
+		t.Errorf("exit code %v, want the command's 7", code)
+	}
+	if h, _ := os.Hostname(); h == "box" {
+		t.Error("the container's hostname leaked to the host")
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultBinaries is what BuildRootfs copies when asked for nothing specific.
+var DefaultBinaries = []string{"sh", "ls", "ps", "cat", "hostname", "mount", "id", "sleep"}
+
+// BuildRootfs makes a minimal root file system in dir by copying the given host
+// binaries together with the shared libraries ldd says they need. Binaries missing
+// on the host are skipped. It returns the binaries copied.
+func BuildRootfs(dir string, binaries []string) ([]string, error) {
+	for _, d := range []string{"bin", "proc", "tmp", "etc"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	var copied []string
+	for _, name := range binaries {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		path, _ = filepath.EvalSymlinks(path)
+		if err := copyFile(path, filepath.Join(dir, "bin", name)); err != nil {
+			return copied, err
+		}
+		libs, err := sharedLibs(path)
+		if err != nil {
+			return copied, err
+		}
+		for _, lib := range libs {
+			if err := copyFile(lib, filepath.Join(dir, lib)); err != nil {
+				return copied, err
+			}
+		}
+		copied = append(copied, name)
+	}
+	os.WriteFile(filepath.Join(dir, "etc", "passwd"), []byte("root:x:0:0:root:/:/bin/sh\n"), 0o644)
+	return copied, nil
+}
+
+// sharedLibs parses ldd output, lines look like
+//
+//	libc.so.6 => /lib/x86_64-linux-gnu/libc.so.6 (0x...)
+//	/lib64/ld-linux-x86-64.so.2 (0x...)
+func sharedLibs(binary string) ([]string, error) {
+	out, err := exec.Command("ldd", binary).Output()
+	if err != nil {
+		// statically linked binaries make ldd fail, they need nothing
+		return nil, nil
+	}
+	var libs []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		for _, f := range strings.Fields(s.Text()) {
+			if strings.HasPrefix(f, "/") {
+				libs = append(libs, f)
+				break
+			}
+		}
+	}
+	return libs, s.Err()
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copy %v: %w", src, err)
+	}
+	return out.Close()
+}
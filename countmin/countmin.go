@@ -0,0 +1,215 @@
+// Package countmin counts how often keys occur in a stream in fixed memory, with a
+// count-min sketch, and keeps track of the most frequent ones.
+//
+// The sketch is depth rows of width counters. A key bumps one counter per row,
+// picked by a per-row hash, and its estimate is the smallest of those counters.
+// Collisions only ever add, so an estimate is never below the true count, and with
+// width = e/ε and depth = ln(1/δ) it's above it by at most ε·N (N the total count)
+// with probability 1-δ. Memory depends on ε and δ, not on the number of keys.
+//
+// Counters are updated atomically. Hot loops should still not hit them directly:
+// a Local buffers counts in a plain map owned by one goroutine and merges them into
+// the sketch every so many keys, so the shared cache lines are touched once per
+// distinct key per flush instead of once per occurrence.
+package countmin
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Config sizes a sketch. Width and Depth win over Epsilon and Delta when set.
+type Config struct {
+	Epsilon float64 // error bound as a fraction of the total count, 0.001 by default
+	Delta   float64 // probability of exceeding it, 0.01 by default
+	Width   int
+	Depth   int
+	// TopK, when not zero, tracks the K keys with the highest estimates.
+	TopK int
+}
+
+// Sketch is a count-min sketch, safe for concurrent use.
+type Sketch struct {
+	width, depth int
+	counters     []uint64 // depth rows of width
+	seeds        []maphash.Seed
+	total        atomic.Uint64
+
+	topMu sync.Mutex
+	top   *topK
+}
+
+// New returns an empty sketch.
+func New(cfg Config) *Sketch {
+	if cfg.Epsilon <= 0 {
+		cfg.Epsilon = 0.001
+	}
+	if cfg.Delta <= 0 || cfg.Delta >= 1 {
+		cfg.Delta = 0.01
+	}
+	if cfg.Width <= 0 {
+		cfg.Width = int(math.Ceil(math.E / cfg.Epsilon))
+	}
+	if cfg.Depth <= 0 {
+		cfg.Depth = int(math.Ceil(math.Log(1 / cfg.Delta)))
+	}
+	s := &Sketch{
+		width:    cfg.Width,
+		depth:    cfg.Depth,
+		counters: make([]uint64, cfg.Width*cfg.Depth),
+		seeds:    make([]maphash.Seed, cfg.Depth),
+	}
+	for i := range s.seeds {
+		s.seeds[i] = maphash.MakeSeed()
+	}
+	if cfg.TopK > 0 {
+		s.top = &topK{k: cfg.TopK, index: map[string]int{}}
+	}
+	return s
+}
+
+// Width returns the number of counters per row.
+func (s *Sketch) Width() int { return s.width }
+
+// Depth returns the number of rows.
+func (s *Sketch) Depth() int { return s.depth }
+
+// Bytes returns the memory used by the counters.
+func (s *Sketch) Bytes() int { return len(s.counters) * 8 }
+
+// Total returns the sum of all counts added.
+func (s *Sketch) Total() uint64 { return s.total.Load() }
+
+// Add counts n occurrences of key and returns its new estimate.
+func (s *Sketch) Add(key string, n uint64) uint64 {
+	est := uint64(math.MaxUint64)
+	for row, seed := range s.seeds {
+		c := &s.counters[row*s.width+int(maphash.String(seed, key)%uint64(s.width))]
+		est = min(est, atomic.AddUint64(c, n))
+	}
+	s.total.Add(n)
+	if s.top != nil {
+		s.topMu.Lock()
+		s.top.offer(key, est)
+		s.topMu.Unlock()
+	}
+	return est
+}
+
+// Estimate returns key's count, never less than the true one.
+func (s *Sketch) Estimate(key string) uint64 {
+	est := uint64(math.MaxUint64)
+	for row, seed := range s.seeds {
+		est = min(est, atomic.LoadUint64(&s.counters[row*s.width+int(maphash.String(seed, key)%uint64(s.width))]))
+	}
+	return est
+}
+
+// Entry is a key and its estimated count.
+type Entry struct {
+	Key   string
+	Count uint64
+}
+
+// Top returns the tracked heavy hitters, most frequent first. It's empty unless
+// the sketch was created with Config.TopK.
+func (s *Sketch) Top() []Entry {
+	if s.top == nil {
+		return nil
+	}
+	s.topMu.Lock()
+	out := append([]Entry(nil), s.top.entries...)
+	s.topMu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// Local buffers counts for one goroutine. It isn't safe for concurrent use, every
+// goroutine gets its own.
+type Local struct {
+	s     *Sketch
+	max   int
+	buf   map[string]uint64
+	flush int
+}
+
+// Local returns a buffer that merges into s once it holds maxKeys distinct keys.
+func (s *Sketch) Local(maxKeys int) *Local {
+	return &Local{s: s, max: max(maxKeys, 1), buf: make(map[string]uint64, maxKeys)}
+}
+
+// Add counts one occurrence of key.
+func (l *Local) Add(key string) {
+	l.buf[key]++
+	if len(l.buf) >= l.max {
+		l.Flush()
+	}
+}
+
+// Flush merges the buffered counts into the sketch. Call it once the goroutine is
+// done, counts still in the buffer aren't visible to Estimate.
+func (l *Local) Flush() {
+	for k, n := range l.buf {
+		l.s.Add(k, n)
+	}
+	clear(l.buf)
+	l.flush++
+}
+
+// Flushes returns the number of merges done so far.
+func (l *Local) Flushes() int { return l.flush }
+
+// topK is a min-heap of the k best estimates seen, the weakest on top so it's the
+// one pushed out.
+type topK struct {
+	k       int
+	entries []Entry
+	index   map[string]int // key -> position in entries
+}
+
+func (t *topK) offer(key string, est uint64) {
+	if i, ok := t.index[key]; ok {
+		t.entries[i].Count = est
+		heap.Fix(t, i)
+		return
+	}
+	if len(t.entries) < t.k {
+		heap.Push(t, Entry{key, est})
+		return
+	}
+	if est <= t.entries[0].Count {
+		return
+	}
+	delete(t.index, t.entries[0].Key)
+	t.entries[0] = Entry{key, est}
+	t.index[key] = 0
+	heap.Fix(t, 0)
+}
+
+func (t *topK) Len() int           { return len(t.entries) }
+func (t *topK) Less(i, j int) bool { return t.entries[i].Count < t.entries[j].Count }
+func (t *topK) Swap(i, j int) {
+	t.entries[i], t.entries[j] = t.entries[j], t.entries[i]
+	t.index[t.entries[i].Key] = i
+	t.index[t.entries[j].Key] = j
+}
+func (t *topK) Push(x any) {
+	e := x.(Entry)
+	t.index[e.Key] = len(t.entries)
+	t.entries = append(t.entries, e)
+}
+func (t *topK) Pop() any {
+	e := t.entries[len(t.entries)-1]
+	t.entries = t.entries[:len(t.entries)-1]
+	delete(t.index, e.Key)
+	return e
+}
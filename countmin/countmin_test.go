@@ -0,0 +1,127 @@
+package countmin
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// zipf returns a skewed stream of keys and their exact counts.
+func zipf(n int, seed int64) ([]string, map[string]uint64) {
+	z := rand.NewZipf(rand.New(rand.NewSource(seed)), 1.2, 1, 10000)
+	keys := make([]string, n)
+	exact := map[string]uint64{}
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", z.Uint64())
+		exact[keys[i]]++
+	}
+	return keys, exact
+}
+
+func TestSizing(t *testing.T) {
+	s := New(Config{Epsilon: 0.01, Delta: 0.01})
+	// width = e/ε, depth = ln(1/δ)
+	if s.Width() != 272 || s.Depth() != 5 {
+		t.Errorf("width %v depth %v, want 272 and 5", s.Width(), s.Depth())
+	}
+	if s.Bytes() != 272*5*8 {
+		t.Errorf("Bytes = %v", s.Bytes())
+	}
+	s = New(Config{Width: 10, Depth: 2, Epsilon: 0.5})
+	if s.Width() != 10 || s.Depth() != 2 {
+		t.Errorf("explicit size ignored: width %v depth %v", s.Width(), s.Depth())
+	}
+}
+
+func TestErrorBound(t *testing.T) {
+	const eps = 0.001
+	s := New(Config{Epsilon: eps, Delta: 0.01})
+	keys, exact := zipf(200000, 1)
+	for _, k := range keys {
+		s.Add(k, 1)
+	}
+	if s.Total() != uint64(len(keys)) {
+		t.Fatalf("Total = %v, want %v", s.Total(), len(keys))
+	}
+	bound := uint64(eps * float64(len(keys)))
+	over := 0
+	for k, n := range exact {
+		est := s.Estimate(k)
+		if est < n {
+			t.Fatalf("%v: estimate %v below the true count %v", k, est, n)
+		}
+		if est-n > bound {
+			over++
+		}
+	}
+	// the bound holds for each key with probability 1-δ
+	if limit := len(exact)/50 + 1; over > limit {
+		t.Errorf("%v of %v keys over by more than εN = %v", over, len(exact), bound)
+	}
+}
+
+func TestTopK(t *testing.T) {
+	s := New(Config{TopK: 5})
+	keys, exact := zipf(100000, 2)
+	for _, k := range keys {
+		s.Add(k, 1)
+	}
+	top := s.Top()
+	if len(top) != 5 {
+		t.Fatalf("%v entries, want 5", len(top))
+	}
+	// zipf keys are k0, k1, ... from most to least frequent
+	for i, e := range top {
+		if want := fmt.Sprint("k", i); e.Key != want {
+			t.Errorf("top[%v] = %v, want %v (exact counts %v, %v)", i, e.Key, want, exact[e.Key], exact[want])
+		}
+		if i > 0 && e.Count > top[i-1].Count {
+			t.Errorf("top not sorted: %v", top)
+		}
+	}
+	if New(Config{}).Top() != nil {
+		t.Error("Top without TopK should be nil")
+	}
+}
+
+func TestLocalMatchesDirect(t *testing.T) {
+	keys, exact := zipf(50000, 3)
+	s := New(Config{})
+	var wg sync.WaitGroup
+	const workers = 4
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(part []string) {
+			defer wg.Done()
+			l := s.Local(64)
+			for _, k := range part {
+				l.Add(k)
+			}
+			l.Flush()
+		}(keys[w*len(keys)/workers : (w+1)*len(keys)/workers])
+	}
+	wg.Wait()
+	if s.Total() != uint64(len(keys)) {
+		t.Fatalf("Total = %v after flushing every Local, want %v", s.Total(), len(keys))
+	}
+	for k, n := range exact {
+		if s.Estimate(k) < n {
+			t.Fatalf("%v: estimate %v below the true count %v", k, s.Estimate(k), n)
+		}
+	}
+}
+
+func TestLocalFlushes(t *testing.T) {
+	s := New(Config{})
+	l := s.Local(2)
+	l.Add("a")
+	l.Add("a")
+	if l.Flushes() != 0 || s.Estimate("a") != 0 {
+		t.Fatalf("flushed with one distinct key buffered: flushes %v, estimate %v", l.Flushes(), s.Estimate("a"))
+	}
+	l.Add("b")
+	if l.Flushes() != 1 || s.Estimate("a") != 2 || s.Estimate("b") != 1 {
+		t.Fatalf("after the second key: flushes %v, a %v, b %v", l.Flushes(), s.Estimate("a"), s.Estimate("b"))
+	}
+}
@@ -0,0 +1,250 @@
+// Package cowmap is a concurrent map with cheap point-in-time snapshots.
+//
+// The map is split into shards, each one a plain Go map behind its own mutex. Taking
+// a snapshot doesn't copy anything: it locks every shard for a moment and pins the
+// maps they hold right now. A writer that later finds its shard's map pinned copies
+// that one shard and writes to the copy (copy on write), the snapshot keeps the old
+// map untouched. So a snapshot costs O(shards), the first write to each shard after a
+// snapshot costs a copy of that shard, and snapshots that nobody writes under cost
+// nothing at all. Once a snapshot is released its maps can be written in place again.
+//
+// Readers iterating a snapshot see one consistent state of the whole map while
+// writers keep going, holding a read lock for the length of the iteration would
+// stall every writer instead.
+package cowmap
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// table is one version of a shard's contents. pins counts the live snapshots that
+// hold it, a pinned table is never written again.
+type table[K comparable, V any] struct {
+	m    map[K]V
+	pins atomic.Int32
+}
+
+type shard[K comparable, V any] struct {
+	mu sync.Mutex
+	t  *table[K, V]
+}
+
+// Stats describes how much copying the snapshots have caused.
+type Stats struct {
+	Snapshots     int64
+	Copies        int64 // shards copied because a write hit a pinned table
+	EntriesCopied int64
+}
+
+// Map is a sharded map of K to V, safe for concurrent use.
+type Map[K comparable, V any] struct {
+	hash   func(K) uint64
+	shards []shard[K, V]
+
+	snapshots, copies, copied atomic.Int64
+}
+
+// New returns an empty map with the given number of shards, hash spreads keys over
+// them (see HashString and HashInt).
+func New[K comparable, V any](shards int, hash func(K) uint64) *Map[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	m := &Map[K, V]{hash: hash, shards: make([]shard[K, V], shards)}
+	for i := range m.shards {
+		m.shards[i].t = &table[K, V]{m: make(map[K]V)}
+	}
+	return m
+}
+
+var seed = maphash.MakeSeed()
+
+// HashString hashes string keys.
+func HashString(s string) uint64 { return maphash.String(seed, s) }
+
+// HashInt hashes int keys.
+func HashInt(i int) uint64 {
+	// splitmix64 finalizer, consecutive ints land on different shards
+	x := uint64(i)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func (m *Map[K, V]) shard(k K) *shard[K, V] {
+	return &m.shards[m.hash(k)%uint64(len(m.shards))]
+}
+
+// writable returns s's table, copying it first if a snapshot has it pinned.
+// s.mu must be held.
+func (m *Map[K, V]) writable(s *shard[K, V]) map[K]V {
+	if s.t.pins.Load() == 0 {
+		return s.t.m
+	}
+	c := make(map[K]V, len(s.t.m))
+	for k, v := range s.t.m {
+		c[k] = v
+	}
+	m.copies.Add(1)
+	m.copied.Add(int64(len(c)))
+	s.t = &table[K, V]{m: c}
+	return c
+}
+
+// Get returns the current value for k.
+func (m *Map[K, V]) Get(k K) (V, bool) {
+	s := m.shard(k)
+	s.mu.Lock()
+	v, ok := s.t.m[k]
+	s.mu.Unlock()
+	return v, ok
+}
+
+// Set stores v under k.
+func (m *Map[K, V]) Set(k K, v V) {
+	s := m.shard(k)
+	s.mu.Lock()
+	m.writable(s)[k] = v
+	s.mu.Unlock()
+}
+
+// Delete removes k.
+func (m *Map[K, V]) Delete(k K) {
+	s := m.shard(k)
+	s.mu.Lock()
+	if _, ok := s.t.m[k]; ok {
+		delete(m.writable(s), k)
+	}
+	s.mu.Unlock()
+}
+
+// Update replaces the values of all keys with fn's result atomically: a snapshot
+// sees either none or all of the changes. fn gets the current values (with ok false
+// for missing keys) and returns the new ones, or nil to leave the map alone.
+func (m *Map[K, V]) Update(keys []K, fn func(vals []V, ok []bool) []V) {
+	// lock the shards involved in index order so concurrent Updates can't deadlock
+	idx := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, k := range keys {
+		i := int(m.hash(k) % uint64(len(m.shards)))
+		if !seen[i] {
+			seen[i] = true
+			idx = append(idx, i)
+		}
+	}
+	sortInts(idx)
+	for _, i := range idx {
+		m.shards[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range idx {
+			m.shards[i].mu.Unlock()
+		}
+	}()
+
+	vals := make([]V, len(keys))
+	oks := make([]bool, len(keys))
+	for i, k := range keys {
+		vals[i], oks[i] = m.shard(k).t.m[k]
+	}
+	out := fn(vals, oks)
+	if out == nil {
+		return
+	}
+	for i, k := range keys {
+		m.writable(m.shard(k))[k] = out[i]
+	}
+}
+
+func sortInts(a []int) {
+	for i := 1; i < len(a); i++ {
+		for j := i; j > 0 && a[j] < a[j-1]; j-- {
+			a[j], a[j-1] = a[j-1], a[j]
+		}
+	}
+}
+
+// Len returns the number of entries. Shards are counted one at a time, use a
+// snapshot for an exact count under concurrent writes.
+func (m *Map[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		n += len(s.t.m)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Snapshot returns a frozen view of the whole map. It must be released when done,
+// until then every shard written to gets copied once.
+func (m *Map[K, V]) Snapshot() *Snapshot[K, V] {
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	snap := &Snapshot[K, V]{m: m, tables: make([]*table[K, V], len(m.shards))}
+	for i := range m.shards {
+		t := m.shards[i].t
+		t.pins.Add(1)
+		snap.tables[i] = t
+	}
+	for i := range m.shards {
+		m.shards[i].mu.Unlock()
+	}
+	m.snapshots.Add(1)
+	return snap
+}
+
+// Stats returns the copying done so far.
+func (m *Map[K, V]) Stats() Stats {
+	return Stats{Snapshots: m.snapshots.Load(), Copies: m.copies.Load(), EntriesCopied: m.copied.Load()}
+}
+
+// Snapshot is a read only, point in time view of a Map. It's safe for concurrent
+// readers.
+type Snapshot[K comparable, V any] struct {
+	m        *Map[K, V]
+	tables   []*table[K, V]
+	released atomic.Bool
+}
+
+// Get returns k's value at the time of the snapshot.
+func (s *Snapshot[K, V]) Get(k K) (V, bool) {
+	v, ok := s.tables[s.m.hash(k)%uint64(len(s.tables))].m[k]
+	return v, ok
+}
+
+// Len returns the number of entries in the snapshot.
+func (s *Snapshot[K, V]) Len() int {
+	n := 0
+	for _, t := range s.tables {
+		n += len(t.m)
+	}
+	return n
+}
+
+// Range calls fn for every entry until it returns false.
+func (s *Snapshot[K, V]) Range(fn func(K, V) bool) {
+	for _, t := range s.tables {
+		for k, v := range t.m {
+			if !fn(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Release unpins the snapshot's tables. The snapshot must not be used afterwards.
+func (s *Snapshot[K, V]) Release() {
+	if s.released.Swap(true) {
+		return
+	}
+	for _, t := range s.tables {
+		t.pins.Add(-1)
+	}
+}
@@ -0,0 +1,225 @@
+package cowmap
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/neilharia7/operating-systems-with-go/linearize"
+)
+
+type mIn struct {
+	Op   byte // 'g'et, 's'et, 'd'elete or 'S'napshot
+	K, V int
+}
+
+type mOut struct {
+	V  int
+	Ok bool
+}
+
+type cell struct {
+	V  int
+	Ok bool
+}
+
+// mapModel is a single key's register, the history is partitioned by key: a map is
+// linearizable if every key's history is. Snapshots aren't part of it.
+func mapModel() linearize.Model[cell, mIn, mOut] {
+	return linearize.Model[cell, mIn, mOut]{
+		Init: func() cell { return cell{} },
+		Step: func(c cell, in mIn, out mOut) (bool, cell) {
+			switch in.Op {
+			case 's':
+				return true, cell{in.V, true}
+			case 'd':
+				return true, cell{}
+			}
+			return out.Ok == c.Ok && (!c.Ok || out.V == c.V), c
+		},
+		Key: func(c cell) string { return fmt.Sprint(c) },
+		Partition: func(ops []linearize.Op[mIn, mOut]) [][]linearize.Op[mIn, mOut] {
+			byKey := map[int][]linearize.Op[mIn, mOut]{}
+			for _, op := range ops {
+				byKey[op.Input.K] = append(byKey[op.Input.K], op)
+			}
+			var parts [][]linearize.Op[mIn, mOut]
+			for _, p := range byKey {
+				parts = append(parts, p)
+			}
+			return parts
+		},
+		Describe: func(in mIn, out mOut) string {
+			switch in.Op {
+			case 's':
+				return fmt.Sprintf("set(%v, %v)", in.K, in.V)
+			case 'd':
+				return fmt.Sprintf("delete(%v)", in.K)
+			}
+			return fmt.Sprintf("get(%v) -> %v, %v", in.K, out.V, out.Ok)
+		},
+	}
+}
+
+// decode turns fuzz input into at most 256 operations on 8 keys, values are unique
+// to the operation.
+func decode(data []byte) []mIn {
+	ops := make([]mIn, 0, min(len(data), 256))
+	for i, b := range data[:min(len(data), 256)] {
+		in := mIn{Op: "gsdS"[b%4], K: int(b>>2) % 8}
+		if in.Op == 's' {
+			in.V = i
+		}
+		ops = append(ops, in)
+	}
+	return ops
+}
+
+func snapshotEquals(s *Snapshot[int, int], want map[int]int) error {
+	if s.Len() != len(want) {
+		return fmt.Errorf("snapshot has %v entries, the map had %v", s.Len(), len(want))
+	}
+	for k, v := range want {
+		if got, ok := s.Get(k); !ok || got != v {
+			return fmt.Errorf("snapshot has %v = %v, %v, the map had %v", k, got, ok, v)
+		}
+	}
+	n := 0
+	s.Range(func(k, v int) bool {
+		n++
+		return want[k] == v
+	})
+	if n != len(want) {
+		return fmt.Errorf("range stopped after %v of %v entries at a wrong value", n, len(want))
+	}
+	return nil
+}
+
+// checkSequential compares the map with a plain map after every operation. Every
+// snapshot taken is kept to the end and must still show the map as it was.
+func checkSequential(t *testing.T, ops []mIn) {
+	m := New[int, int](4, HashInt)
+	model := map[int]int{}
+	type taken struct {
+		s    *Snapshot[int, int]
+		want map[int]int
+		at   int
+	}
+	var snaps []taken
+	for i, in := range ops {
+		switch in.Op {
+		case 'g':
+			v, ok := m.Get(in.K)
+			if w, wok := model[in.K]; ok != wok || v != w {
+				t.Fatalf("op %v: get(%v) = %v, %v, expected %v, %v", i, in.K, v, ok, w, wok)
+			}
+		case 's':
+			m.Set(in.K, in.V)
+			model[in.K] = in.V
+		case 'd':
+			m.Delete(in.K)
+			delete(model, in.K)
+		case 'S':
+			want := make(map[int]int, len(model))
+			for k, v := range model {
+				want[k] = v
+			}
+			snaps = append(snaps, taken{m.Snapshot(), want, i})
+		}
+		if m.Len() != len(model) {
+			t.Fatalf("op %v: Len() = %v, expected %v", i, m.Len(), len(model))
+		}
+	}
+	for _, s := range snaps {
+		if err := snapshotEquals(s.s, s.want); err != nil {
+			t.Fatalf("snapshot from op %v: %v", s.at, err)
+		}
+		s.s.Release()
+	}
+}
+
+// runConcurrent deals ops out round robin to clients running at once and returns
+// the history of gets, sets and deletes. Snapshots are taken and released right
+// away, they force copies under the other clients' feet.
+func runConcurrent(m *Map[int, int], clients int, ops []mIn) []linearize.Op[mIn, mOut] {
+	var r linearize.Recorder[mIn, mOut]
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := c; i < len(ops); i += clients {
+				in := ops[i]
+				if in.Op == 'S' {
+					m.Snapshot().Release()
+					continue
+				}
+				call := r.Begin(c, in)
+				if i%3 == 0 {
+					runtime.Gosched()
+				}
+				var out mOut
+				switch in.Op {
+				case 'g':
+					out.V, out.Ok = m.Get(in.K)
+				case 's':
+					m.Set(in.K, in.V)
+				case 'd':
+					m.Delete(in.K)
+				}
+				call.End(out)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return r.History()
+}
+
+func FuzzCowmap(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1, 3, 0, 1, 2, 0, 3, 0})
+	f.Add([]byte{5, 9, 13, 3, 4, 8, 12, 7, 6, 10, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops := decode(data)
+		checkSequential(t, ops)
+		m := mapModel()
+		res := linearize.Check(m, runConcurrent(New[int, int](4, HashInt), 3, ops))
+		if !res.Ok {
+			t.Fatalf("not linearizable, operations stuck: %v", len(res.Stuck))
+		}
+	})
+}
+
+// TestStress runs longer histories than the fuzz corpus: half gets, mostly sets
+// otherwise, a snapshot every sixteen operations.
+func TestStress(t *testing.T) {
+	rounds := 10
+	if testing.Short() {
+		rounds = 2
+	}
+	for round := 0; round < rounds; round++ {
+		rng := rand.New(rand.NewSource(int64(round)))
+		ops := make([]mIn, 800)
+		for i := range ops {
+			in := mIn{K: rng.Intn(8)}
+			switch n := rng.Intn(10); {
+			case i%16 == 0:
+				in.Op = 'S'
+			case n < 5:
+				in.Op = 'g'
+			case n < 9:
+				in.Op, in.V = 's', i
+			default:
+				in.Op = 'd'
+			}
+			ops[i] = in
+		}
+		m := mapModel()
+		res := linearize.Check(m, runConcurrent(New[int, int](4, HashInt), 4, ops))
+		if !res.Ok {
+			t.Fatalf("round %v not linearizable, operations stuck: %v", round, len(res.Stuck))
+		}
+	}
+}
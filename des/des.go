@@ -0,0 +1,140 @@
+// Package des is a small discrete-event simulation engine.
+//
+// Instead of sleeping, a simulated process schedules a callback at some point in
+// simulated time and returns. The engine keeps the pending events in a priority queue
+// and runs them in time order, jumping the clock straight from one event to the next,
+// so an hour of simulated activity takes as long as its callbacks take to run.
+// Events at the same instant run in the order they were scheduled, which together
+// with a seeded random source makes every run exactly reproducible.
+package des
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Event is a scheduled callback.
+type Event struct {
+	at    time.Duration
+	seq   uint64
+	fn    func()
+	index int // position in the heap, -1 once it ran or was cancelled
+}
+
+// At returns the simulated time the event is scheduled for.
+func (e *Event) At() time.Duration { return e.at }
+
+// Sim holds the simulated clock and the event queue. It is not safe for concurrent
+// use, everything happens on the goroutine calling Run.
+type Sim struct {
+	now     time.Duration
+	queue   eventQueue
+	seq     uint64
+	stopped bool
+	events  int
+}
+
+// New returns a simulation at time zero with nothing scheduled.
+func New() *Sim { return &Sim{} }
+
+// Now returns the current simulated time.
+func (s *Sim) Now() time.Duration { return s.now }
+
+// Events returns the number of events run so far.
+func (s *Sim) Events() int { return s.events }
+
+// Pending returns the number of events waiting to run.
+func (s *Sim) Pending() int { return len(s.queue) }
+
+// After schedules fn to run d from now. A negative d is treated as zero, the past
+// can't be scheduled.
+func (s *Sim) After(d time.Duration, fn func()) *Event {
+	if d < 0 {
+		d = 0
+	}
+	return s.At(s.now+d, fn)
+}
+
+// At schedules fn to run at simulated time t, or now if t has already passed.
+func (s *Sim) At(t time.Duration, fn func()) *Event {
+	if t < s.now {
+		t = s.now
+	}
+	s.seq++
+	e := &Event{at: t, seq: s.seq, fn: fn}
+	heap.Push(&s.queue, e)
+	return e
+}
+
+// Cancel removes e from the queue, reporting whether it was still pending.
+func (s *Sim) Cancel(e *Event) bool {
+	if e.index < 0 {
+		return false
+	}
+	heap.Remove(&s.queue, e.index)
+	return true
+}
+
+// Step runs the next event, reporting false when there is none.
+func (s *Sim) Step() bool {
+	if len(s.queue) == 0 {
+		return false
+	}
+	e := heap.Pop(&s.queue).(*Event)
+	s.now = e.at
+	s.events++
+	e.fn()
+	return true
+}
+
+// Run runs events until the queue is empty, Stop is called or the next event is
+// after until. A non-positive until means no limit. If it stops because of until the
+// clock is moved to until, otherwise it's left at the last event that ran: a queue
+// that drains early tells when the simulation ran dry (every process waiting on
+// another one, say).
+func (s *Sim) Run(until time.Duration) {
+	s.stopped = false
+	for !s.stopped && len(s.queue) > 0 {
+		if until > 0 && s.queue[0].at > until {
+			s.now = until
+			return
+		}
+		s.Step()
+	}
+}
+
+// Stop makes Run return after the current event.
+func (s *Sim) Stop() { s.stopped = true }
+
+// eventQueue is a min heap on (at, seq).
+type eventQueue []*Event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*Event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,164 @@
+//go:build unix
+
+// Package election elects a leader among processes on the same machine with an
+// advisory file lock.
+//
+// Whoever holds the exclusive flock on the election file is the leader, everybody
+// else keeps trying to take it. There is no heartbeat and no lease to expire: the
+// kernel drops a flock when the file is closed, and a process that dies has all its
+// files closed, so once the leader is killed the next follower to try gets the lock. That only works on one machine (and not on NFS), which is the trade-off
+// against a consensus protocol.
+//
+// The new leader writes a record into the same file: a fencing epoch that goes up by
+// one with every leadership change, plus its id and pid. Followers read it to know who
+// leads, and a leader can stamp its epoch on the work it does so a store can reject
+// writes from a leader that has since been replaced.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/neilharia7/operating-systems-with-go/filelock"
+)
+
+// Leader is the record the current leader keeps in the election file.
+type Leader struct {
+	Epoch uint64
+	ID    string
+	Pid   int
+}
+
+func (l Leader) String() string {
+	return fmt.Sprintf("%v (pid %v, epoch %v)", l.ID, l.Pid, l.Epoch)
+}
+
+// ErrNotLeader is returned by Resign when the elector isn't leading.
+var ErrNotLeader = errors.New("election: not the leader")
+
+// Elector is one candidate. It isn't safe for concurrent use.
+type Elector struct {
+	path    string
+	id      string
+	lock    *filelock.Lock
+	leading *Leader
+}
+
+// recordSize is the fixed size of the leader record. Every record overwrites the
+// whole previous one in a single write, there's no truncate that a crash could come
+// right after.
+const recordSize = 128
+
+// New opens the election file at path for a candidate named id, which can't contain
+// whitespace and has to fit the record with room to spare (64 bytes).
+func New(path, id string) (*Elector, error) {
+	if id == "" || len(id) > 64 || strings.ContainsAny(id, " \t\r\n") {
+		return nil, fmt.Errorf("election: bad candidate id %q", id)
+	}
+	l, err := filelock.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Elector{path: path, id: id, lock: l}, nil
+}
+
+// Campaign waits until this candidate is the leader or ctx is done, then writes the
+// new leader record and returns it. A record it can't read is an error and the
+// lock is let go again: leading without knowing the last epoch could send the
+// epochs backwards.
+func (e *Elector) Campaign(ctx context.Context) (Leader, error) {
+	if e.leading != nil {
+		return *e.leading, nil
+	}
+	// poll rather than block in flock: a blocked flock can't be interrupted, it would
+	// outlive a cancelled Campaign and could take the lock on a closed and reused fd
+	if err := e.lock.LockContext(ctx, filelock.Exclusive); err != nil {
+		if ctx.Err() != nil {
+			return Leader{}, ctx.Err()
+		}
+		return Leader{}, err
+	}
+	prev, err := parse(e.lock.File())
+	if err != nil {
+		e.lock.Unlock()
+		return Leader{}, err
+	}
+	me := Leader{Epoch: prev.Epoch + 1, ID: e.id, Pid: os.Getpid()}
+	if err := write(e.lock.File(), me); err != nil {
+		e.lock.Unlock()
+		return Leader{}, err
+	}
+	e.leading = &me
+	return me, nil
+}
+
+// Leading reports whether this candidate currently holds the leadership.
+func (e *Elector) Leading() bool { return e.leading != nil }
+
+// Resign gives up the leadership, one of the waiting candidates takes over. The
+// record is left in place so the next leader continues the epochs.
+func (e *Elector) Resign() error {
+	if e.leading == nil {
+		return ErrNotLeader
+	}
+	e.leading = nil
+	return e.lock.Unlock()
+}
+
+// Close resigns if leading and closes the election file.
+func (e *Elector) Close() error {
+	e.leading = nil
+	return e.lock.Close()
+}
+
+// Current reads the leader record in the election file at path. The record stays
+// behind when a leader dies, so it names the last leader, which may be gone and whose
+// successor may not have written its record yet.
+func Current(path string) (Leader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Leader{}, err
+	}
+	defer f.Close()
+	return parse(f)
+}
+
+func parse(f *os.File) (Leader, error) {
+	buf := make([]byte, recordSize)
+	n, err := f.ReadAt(buf, 0)
+	if n == 0 && err == io.EOF {
+		// a fresh file
+		return Leader{}, nil
+	}
+	if n < recordSize {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Leader{}, fmt.Errorf("election: bad leader record: %w", err)
+	}
+	var l Leader
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(buf[:n])), "%d %s %d", &l.Epoch, &l.ID, &l.Pid); err != nil {
+		return Leader{}, fmt.Errorf("election: bad leader record: %w", err)
+	}
+	return l, nil
+}
+
+// write replaces the record in place, padded to recordSize. A crash before the
+// write leaves the old record, one after it the new one: well below a disk sector,
+// the write isn't torn.
+func write(f *os.File, l Leader) error {
+	rec := fmt.Sprintf("%d %s %d", l.Epoch, l.ID, l.Pid)
+	if len(rec) >= recordSize {
+		return fmt.Errorf("election: leader record %q too long", rec)
+	}
+	rec += strings.Repeat(" ", recordSize-1-len(rec)) + "\n"
+	if _, err := f.WriteAt([]byte(rec), 0); err != nil {
+		return err
+	}
+	// the epoch must never go backwards, even across a machine crash
+	return f.Sync()
+}
@@ -0,0 +1,187 @@
+//go:build unix
+
+package election
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/filelock"
+)
+
+func newElector(t *testing.T, path, id string) *Elector {
+	t.Helper()
+	e, err := New(path, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func campaign(t *testing.T, e *Elector) Leader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := e.Campaign(ctx)
+	if err != nil {
+		t.Fatalf("%v: Campaign: %v", e.id, err)
+	}
+	return l
+}
+
+func TestEpochsGoUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	a, b := newElector(t, path, "a"), newElector(t, path, "b")
+
+	if l := campaign(t, a); l.Epoch != 1 || l.ID != "a" || l.Pid != os.Getpid() {
+		t.Fatalf("first leader %v", l)
+	}
+	if l := campaign(t, a); l.Epoch != 1 {
+		t.Errorf("campaigning again while leading gave %v", l)
+	}
+
+	// b waits while a leads, and takes over the moment a resigns
+	elected := make(chan Leader, 1)
+	go func() {
+		l, _ := b.Campaign(context.Background())
+		elected <- l
+	}()
+	select {
+	case l := <-elected:
+		t.Fatalf("b elected while a leads: %v", l)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := a.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Leading() {
+		t.Error("a still leading after Resign")
+	}
+	if err := a.Resign(); err != ErrNotLeader {
+		t.Errorf("second Resign: %v", err)
+	}
+	if l := <-elected; l.Epoch != 2 || l.ID != "b" {
+		t.Fatalf("after a resigned: %v", l)
+	}
+	if cur, err := Current(path); err != nil || cur.Epoch != 2 || cur.ID != "b" {
+		t.Fatalf("Current = %v, %v", cur, err)
+	}
+
+	// closing is how a dying leader lets go, the record stays behind for the next one
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := campaign(t, a); l.Epoch != 3 || l.ID != "a" {
+		t.Fatalf("after b closed: %v", l)
+	}
+	// a new elector on the file starts from the record, not from zero
+	a.Close()
+	if l := campaign(t, newElector(t, path, "c")); l.Epoch != 4 {
+		t.Fatalf("fresh elector: %v", l)
+	}
+}
+
+func TestCampaignCancel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	a, b := newElector(t, path, "a"), newElector(t, path, "b")
+	campaign(t, a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Campaign(ctx)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("cancelled Campaign: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Campaign didn't return after its context was cancelled")
+	}
+	if b.Leading() || b.lock.Mode() != filelock.Unlocked {
+		t.Fatal("a cancelled Campaign left b holding the lock")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Campaign(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Campaign past its deadline: %v", err)
+	}
+
+	// nothing is left trying for the lock in the background: once a resigns and b
+	// is closed, a third candidate gets it
+	a.Resign()
+	b.Close()
+	if l := campaign(t, newElector(t, path, "c")); l.Epoch != 2 || l.ID != "c" {
+		t.Fatalf("after the cancelled campaigns: %v", l)
+	}
+}
+
+func TestBadRecordRefusesToLead(t *testing.T) {
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{"garbage", "not a leader record at all, just some text that ends up in here\n"},
+		{"partial", "7 a 12"},
+		{"missing fields", "7" + string(make([]byte, recordSize-1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "leader")
+			if err := os.WriteFile(path, []byte(tt.record), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			e := newElector(t, path, "a")
+			if l, err := e.Campaign(context.Background()); err == nil {
+				t.Fatalf("elected %v over a bad record", l)
+			}
+			if e.Leading() {
+				t.Fatal("leading after a failed Campaign")
+			}
+			if _, err := Current(path); err == nil {
+				t.Error("Current accepted the bad record")
+			}
+			// the lock was let go
+			other, err := filelock.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+			if ok, err := other.TryLock(); !ok || err != nil {
+				t.Fatalf("lock still held after the failed Campaign: %v, %v", ok, err)
+			}
+			// and the record wasn't overwritten
+			got, _ := os.ReadFile(path)
+			if string(got) != tt.record {
+				t.Errorf("record changed to %q", got)
+			}
+		})
+	}
+}
+
+func TestCurrentOfFreshFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	newElector(t, path, "a")
+	if l, err := Current(path); err != nil || l != (Leader{}) {
+		t.Fatalf("Current of an empty file = %v, %v", l, err)
+	}
+}
+
+func TestNewRejectsBadIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	for _, id := range []string{"", "two words", "tab\there", string(make([]byte, 65))} {
+		if _, err := New(path, id); err == nil {
+			t.Errorf("New accepted id %q", id)
+		}
+	}
+}
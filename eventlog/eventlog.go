@@ -0,0 +1,103 @@
+// Package eventlog records what the goroutines of a small demo do (reads, writes,
+// lock operations, channel operations, spawns) so the run can be analysed afterwards.
+//
+// Goroutines have no usable identity in Go, so every call names its actor explicitly.
+package eventlog
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Kind is the type of a recorded event.
+type Kind int
+
+const (
+	Read Kind = iota
+	Write
+	Acquire
+	Release
+	Send
+	Recv
+	Spawn
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	case Acquire:
+		return "acquire"
+	case Release:
+		return "release"
+	case Send:
+		return "send"
+	case Recv:
+		return "recv"
+	case Spawn:
+		return "spawn"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Event is one recorded operation.
+type Event struct {
+	// ID is the position of the event in the log.
+	ID    int
+	Actor string
+	Kind  Kind
+	// Object is the variable, lock or channel the event touches. For Spawn it's
+	// the name of the new actor.
+	Object string
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v: %v %v", e.Actor, e.Kind, e.Object)
+}
+
+// Log is a concurrency safe, append only list of events.
+type Log struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// New returns an empty log.
+func New() *Log {
+	return &Log{}
+}
+
+func (l *Log) record(actor string, kind Kind, object string) {
+	l.mu.Lock()
+	l.events = append(l.events, Event{ID: len(l.events), Actor: actor, Kind: kind, Object: object})
+	l.mu.Unlock()
+}
+
+// Read records actor reading variable v.
+func (l *Log) Read(actor, v string) { l.record(actor, Read, v) }
+
+// Write records actor writing variable v.
+func (l *Log) Write(actor, v string) { l.record(actor, Write, v) }
+
+// Acquire records actor taking lock m. Call it after the lock is held.
+func (l *Log) Acquire(actor, m string) { l.record(actor, Acquire, m) }
+
+// Release records actor dropping lock m. Call it before the lock is released.
+func (l *Log) Release(actor, m string) { l.record(actor, Release, m) }
+
+// Send records actor sending on channel ch. Call it before the send.
+func (l *Log) Send(actor, ch string) { l.record(actor, Send, ch) }
+
+// Recv records actor receiving from channel ch. Call it after the receive.
+func (l *Log) Recv(actor, ch string) { l.record(actor, Recv, ch) }
+
+// Spawn records actor starting the goroutine child. Call it before the go statement.
+func (l *Log) Spawn(actor, child string) { l.record(actor, Spawn, child) }
+
+// Events returns a copy of everything recorded so far.
+func (l *Log) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
@@ -0,0 +1,164 @@
+package eventlog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRaces(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		run   func(l *Log)
+		races int
+	}{
+		{"unsynchronized writes", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Spawn("main", "b")
+			l.Write("a", "x")
+			l.Write("b", "x")
+		}, 1},
+		{"read and write", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Read("a", "x")
+			l.Write("main", "x")
+		}, 1},
+		{"reads only", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Read("a", "x")
+			l.Read("main", "x")
+		}, 0},
+		{"different variables", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Write("a", "x")
+			l.Write("main", "y")
+		}, 0},
+		{"before the spawn", func(l *Log) {
+			l.Write("main", "x")
+			l.Spawn("main", "a")
+			l.Write("a", "x")
+		}, 0},
+		{"lock", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Spawn("main", "b")
+			l.Acquire("a", "mu")
+			l.Write("a", "x")
+			l.Release("a", "mu")
+			l.Acquire("b", "mu")
+			l.Write("b", "x")
+			l.Release("b", "mu")
+		}, 0},
+		{"different locks", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Spawn("main", "b")
+			l.Acquire("a", "mu1")
+			l.Write("a", "x")
+			l.Release("a", "mu1")
+			l.Acquire("b", "mu2")
+			l.Write("b", "x")
+			l.Release("b", "mu2")
+		}, 1},
+		{"channel", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Write("a", "x")
+			l.Send("a", "done")
+			l.Recv("main", "done")
+			l.Read("main", "x")
+		}, 0},
+		{"written after the send", func(l *Log) {
+			l.Spawn("main", "a")
+			l.Send("a", "done")
+			l.Write("a", "x")
+			l.Recv("main", "done")
+			l.Read("main", "x")
+		}, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := New()
+			tc.run(l)
+			g := Build(l.Events())
+			if len(g.Races) != tc.races {
+				t.Errorf("%v races, want %v: %v", len(g.Races), tc.races, g.Races)
+			}
+		})
+	}
+}
+
+func TestHappensBefore(t *testing.T) {
+	l := New()
+	l.Write("main", "x") // 0
+	l.Spawn("main", "a") // 1
+	l.Write("a", "y")    // 2
+	l.Send("a", "c")     // 3
+	l.Write("main", "z") // 4
+	l.Recv("main", "c")  // 5
+	g := Build(l.Events())
+	for _, tc := range []struct {
+		a, b int
+		want bool
+	}{
+		{0, 2, true},  // through the spawn
+		{2, 5, true},  // through the channel
+		{4, 5, true},  // program order
+		{2, 4, false}, // concurrent
+		{4, 2, false},
+		{5, 0, false},
+		{3, 3, false},
+		{0, 99, false}, // not in the graph
+	} {
+		if got := g.HappensBefore(tc.a, tc.b); got != tc.want {
+			t.Errorf("HappensBefore(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+	var synced int
+	for _, e := range g.Edges {
+		if e.Sync {
+			synced++
+		}
+	}
+	if synced != 2 {
+		t.Errorf("%v synchronisation edges, want spawn and send", synced)
+	}
+}
+
+func TestConcurrentRecording(t *testing.T) {
+	l := New()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				l.Read(fmt.Sprint("g", g), "x")
+			}
+		}(g)
+	}
+	wg.Wait()
+	events := l.Events()
+	if len(events) != 400 {
+		t.Fatalf("%v events, want 400", len(events))
+	}
+	for i, e := range events {
+		if e.ID != i {
+			t.Fatalf("event %v has ID %v", i, e.ID)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	l := New()
+	l.Spawn("main", "a")
+	l.Write("a", "x")
+	l.Write("main", "x")
+	var b strings.Builder
+	if err := Build(l.Events()).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+	for _, want := range []string{"digraph happensbefore", `label="a"`, "e0 -> e1 [color=blue", "e1 -> e2 [color=red"} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output lacks %q:\n%v", want, dot)
+		}
+	}
+}
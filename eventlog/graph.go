@@ -0,0 +1,183 @@
+package eventlog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Edge is a happens-before edge between two events.
+type Edge struct {
+	From, To int
+	// Sync is false for program order edges (same actor) and true for edges
+	// created by synchronisation (unlock->lock, send->recv, spawn->start).
+	Sync bool
+}
+
+// Race is a pair of conflicting accesses not ordered by happens-before.
+type Race struct {
+	A, B Event
+}
+
+// Graph is the happens-before relation of a recorded run.
+type Graph struct {
+	Events []Event
+	Edges  []Edge
+	Races  []Race
+
+	actors []string
+	clocks map[int][]int // vector clock by event ID
+}
+
+// Build computes the happens-before graph of the events with vector clocks.
+//
+// The rules are the ones from the Go memory model, simplified:
+//   - events of one actor are ordered by program order
+//   - a release of a lock happens before the next acquire of it
+//   - the n-th send on a channel happens before the n-th receive completes
+//   - a spawn happens before the first event of the spawned actor
+//
+// The events don't have to be a whole log, any subset in log order will do, as long
+// as their IDs are unique.
+func Build(events []Event) *Graph {
+	g := &Graph{Events: events}
+
+	index := map[string]int{}
+	actorOf := func(name string) int {
+		i, ok := index[name]
+		if !ok {
+			i = len(g.actors)
+			index[name] = i
+			g.actors = append(g.actors, name)
+		}
+		return i
+	}
+	for _, e := range events {
+		actorOf(e.Actor)
+	}
+
+	current := make([][]int, len(g.actors)) // running vector clock per actor
+	for i := range current {
+		current[i] = make([]int, len(g.actors))
+	}
+	last := make([]int, len(g.actors)) // last event id per actor, -1 for none
+	for i := range last {
+		last[i] = -1
+	}
+	pendingSpawn := map[string]int{} // child actor -> spawn event
+	lastRelease := map[string]int{}  // lock -> last release event
+	sends := map[string][]int{}      // channel -> unmatched send events
+	g.clocks = make(map[int][]int, len(events))
+
+	join := func(dst, src []int) {
+		for i := range dst {
+			if src[i] > dst[i] {
+				dst[i] = src[i]
+			}
+		}
+	}
+
+	for _, e := range events {
+		a := index[e.Actor]
+		vc := current[a]
+
+		if last[a] >= 0 {
+			g.Edges = append(g.Edges, Edge{From: last[a], To: e.ID})
+		} else if s, ok := pendingSpawn[e.Actor]; ok {
+			join(vc, g.clocks[s])
+			g.Edges = append(g.Edges, Edge{From: s, To: e.ID, Sync: true})
+		}
+
+		switch e.Kind {
+		case Acquire:
+			if r, ok := lastRelease[e.Object]; ok {
+				join(vc, g.clocks[r])
+				g.Edges = append(g.Edges, Edge{From: r, To: e.ID, Sync: true})
+			}
+		case Recv:
+			if q := sends[e.Object]; len(q) > 0 {
+				join(vc, g.clocks[q[0]])
+				g.Edges = append(g.Edges, Edge{From: q[0], To: e.ID, Sync: true})
+				sends[e.Object] = q[1:]
+			}
+		}
+
+		vc[a]++
+		g.clocks[e.ID] = append([]int(nil), vc...)
+		last[a] = e.ID
+
+		switch e.Kind {
+		case Release:
+			lastRelease[e.Object] = e.ID
+		case Send:
+			sends[e.Object] = append(sends[e.Object], e.ID)
+		case Spawn:
+			pendingSpawn[e.Object] = e.ID
+		}
+	}
+
+	for i, x := range events {
+		for _, y := range events[i+1:] {
+			if conflicting(x, y) && !g.HappensBefore(x.ID, y.ID) && !g.HappensBefore(y.ID, x.ID) {
+				g.Races = append(g.Races, Race{A: x, B: y})
+			}
+		}
+	}
+	return g
+}
+
+func conflicting(a, b Event) bool {
+	if a.Actor == b.Actor || a.Object != b.Object {
+		return false
+	}
+	if a.Kind != Read && a.Kind != Write || b.Kind != Read && b.Kind != Write {
+		return false
+	}
+	return a.Kind == Write || b.Kind == Write
+}
+
+// HappensBefore reports whether event a happens before event b, false if either
+// isn't in the graph.
+func (g *Graph) HappensBefore(a, b int) bool {
+	ca, okA := g.clocks[a]
+	cb, okB := g.clocks[b]
+	if a == b || !okA || !okB {
+		return false
+	}
+	for i := range ca {
+		if ca[i] > cb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteDOT renders the graph in graphviz format, one cluster per actor.
+// Program order edges are black, synchronisation edges blue and races red.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph happensbefore {\n")
+	b.WriteString("  rankdir=TB;\n  node [shape=box, fontname=monospace];\n")
+	for i, actor := range g.actors {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%q;\n", i, actor)
+		for _, e := range g.Events {
+			if e.Actor == actor {
+				fmt.Fprintf(&b, "    e%d [label=%q];\n", e.ID, fmt.Sprintf("#%d %v %v", e.ID, e.Kind, e.Object))
+			}
+		}
+		b.WriteString("  }\n")
+	}
+	for _, e := range g.Edges {
+		if e.Sync {
+			fmt.Fprintf(&b, "  e%d -> e%d [color=blue, style=dashed];\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "  e%d -> e%d;\n", e.From, e.To)
+		}
+	}
+	for _, r := range g.Races {
+		fmt.Fprintf(&b, "  e%d -> e%d [color=red, dir=none, constraint=false, label=\"race\"];\n", r.A.ID, r.B.ID)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
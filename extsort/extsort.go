@@ -0,0 +1,307 @@
+// Package extsort sorts line oriented input that doesn't fit in memory.
+//
+// It's the classic external merge sort in two phases:
+//
+//	split - the input is read into chunks that fit the memory budget. Each chunk is
+//	        sorted in memory by one of the worker goroutines and spilled to a temp
+//	        file (a run). Reading the next chunk overlaps with sorting the last ones.
+//	merge - the sorted runs are merged with a k-way merge: a heap holds the head line
+//	        of every run and the smallest one is written out and replaced by the
+//	        next line of its run. With more runs than MaxFanIn the runs are first
+//	        merged in groups, in parallel, into fewer longer runs.
+//
+// Input that fits in a single chunk is sorted in memory and never touches disk.
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options tune a sort. The zero value uses 64MB, GOMAXPROCS workers and os.TempDir.
+type Options struct {
+	// Memory bounds the lines held in memory at once, across all workers.
+	Memory int64
+	// Workers sort chunks (and merge groups of runs) in parallel.
+	Workers int
+	// MaxFanIn is the most runs merged in one go, each one needs an open file and a
+	// read buffer.
+	MaxFanIn int
+	TempDir  string
+}
+
+// Stats describes a finished sort.
+type Stats struct {
+	Lines       int64
+	Bytes       int64
+	Runs        int // runs spilled by the split phase
+	MergePasses int
+	SplitTime   time.Duration
+	MergeTime   time.Duration
+}
+
+// lineOverhead is roughly what a line costs in memory on top of its bytes, the
+// string header in the chunk slice.
+const lineOverhead = 16
+
+func (o *Options) defaults() {
+	if o.Memory <= 0 {
+		o.Memory = 64 << 20
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.MaxFanIn < 2 {
+		o.MaxFanIn = 128
+	}
+}
+
+type result struct {
+	path string
+	err  error
+}
+
+// Sort reads lines from in and writes them to out in byte order. A last line
+// without a trailing newline gets one.
+func Sort(in io.Reader, out io.Writer, opts Options) (Stats, error) {
+	opts.defaults()
+	var st Stats
+	dir, err := os.MkdirTemp(opts.TempDir, "extsort")
+	if err != nil {
+		return st, err
+	}
+	defer os.RemoveAll(dir)
+
+	// a chunk is being filled while every worker sorts one, they share the budget
+	chunkBytes := opts.Memory / int64(opts.Workers+1)
+	start := time.Now()
+	r := bufio.NewReaderSize(in, 64<<10)
+
+	chunks := make(chan []string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				sort.Strings(chunk)
+				path, err := spill(dir, chunk)
+				results <- result{path, err}
+			}
+		}()
+	}
+	var runs []string
+	var firstErr error
+	collected := make(chan struct{})
+	go func() {
+		for res := range results {
+			if res.err != nil && firstErr == nil {
+				firstErr = res.err
+			}
+			if res.path != "" {
+				runs = append(runs, res.path)
+			}
+		}
+		close(collected)
+	}()
+
+	var chunk []string
+	var size int64
+	var readErr error
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line += "\n"
+			}
+			chunk = append(chunk, line)
+			size += int64(len(line)) + lineOverhead
+			st.Lines++
+			st.Bytes += int64(len(line))
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+		if size >= chunkBytes {
+			chunks <- chunk
+			chunk, size = nil, 0
+			st.Runs++
+		}
+	}
+
+	if st.Runs == 0 && readErr == nil {
+		// it all fit, no need for the disk
+		close(chunks)
+		wg.Wait()
+		close(results)
+		<-collected
+		sort.Strings(chunk)
+		st.SplitTime = time.Since(start)
+		mstart := time.Now()
+		err := writeLines(out, chunk)
+		st.MergeTime = time.Since(mstart)
+		return st, err
+	}
+	if len(chunk) > 0 {
+		chunks <- chunk
+		st.Runs++
+	}
+	close(chunks)
+	wg.Wait()
+	close(results)
+	<-collected
+	st.SplitTime = time.Since(start)
+	if readErr != nil {
+		return st, readErr
+	}
+	if firstErr != nil {
+		return st, firstErr
+	}
+
+	start = time.Now()
+	// merge groups of runs until one pass can take them all
+	for len(runs) > opts.MaxFanIn {
+		st.MergePasses++
+		if runs, err = mergePass(dir, runs, opts); err != nil {
+			return st, err
+		}
+	}
+	st.MergePasses++
+	err = mergeRuns(out, runs, bufSize(opts.Memory, len(runs)))
+	st.MergeTime = time.Since(start)
+	return st, err
+}
+
+// bufSize splits the memory budget between the read buffers of n runs.
+func bufSize(memory int64, n int) int {
+	return int(max(min(memory/int64(max(n, 1)), 1<<20), 4<<10))
+}
+
+func spill(dir string, lines []string) (string, error) {
+	f, err := os.CreateTemp(dir, "run")
+	if err != nil {
+		return "", err
+	}
+	err = writeLines(f, lines)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
+
+func writeLines(out io.Writer, lines []string) error {
+	w := bufio.NewWriterSize(out, 64<<10)
+	for _, l := range lines {
+		if _, err := w.WriteString(l); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// mergePass merges runs in groups of MaxFanIn, Workers groups at a time, and
+// returns the new, fewer runs.
+func mergePass(dir string, runs []string, opts Options) ([]string, error) {
+	var groups [][]string
+	for len(runs) > 0 {
+		n := min(opts.MaxFanIn, len(runs))
+		groups = append(groups, runs[:n])
+		runs = runs[n:]
+	}
+	out := make([]string, len(groups))
+	errs := make([]error, len(groups))
+	sem := make(chan struct{}, opts.Workers)
+	var wg sync.WaitGroup
+	for i, g := range groups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, g []string) {
+			defer func() { <-sem; wg.Done() }()
+			f, err := os.CreateTemp(dir, "merged")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			// every concurrent merge gets its share of the budget
+			err = mergeRuns(f, g, bufSize(opts.Memory/int64(opts.Workers), len(g)))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			for _, path := range g {
+				os.Remove(path)
+			}
+			out[i], errs[i] = f.Name(), err
+		}(i, g)
+	}
+	wg.Wait()
+	return out, errors.Join(errs...)
+}
+
+type head struct {
+	line string
+	r    *bufio.Reader
+}
+
+type heads []head
+
+func (h heads) Len() int           { return len(h) }
+func (h heads) Less(i, j int) bool { return h[i].line < h[j].line }
+func (h heads) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *heads) Push(x any)        { *h = append(*h, x.(head)) }
+func (h *heads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergeRuns k-way merges the sorted run files into out.
+func mergeRuns(out io.Writer, runs []string, buf int) error {
+	h := make(heads, 0, len(runs))
+	for _, path := range runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r := bufio.NewReaderSize(f, buf)
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line != "" {
+			h = append(h, head{line, r})
+		}
+	}
+	heap.Init(&h)
+
+	w := bufio.NewWriterSize(out, 64<<10)
+	for len(h) > 0 {
+		if _, err := w.WriteString(h[0].line); err != nil {
+			return err
+		}
+		line, err := h[0].r.ReadString('\n')
+		switch {
+		case line != "":
+			h[0].line = line
+			heap.Fix(&h, 0)
+		case err == io.EOF:
+			heap.Pop(&h)
+		default:
+			return fmt.Errorf("extsort: reading run: %w", err)
+		}
+	}
+	return w.Flush()
+}
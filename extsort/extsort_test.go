@@ -0,0 +1,123 @@
+package extsort
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func lines(n int, seed int64) []string {
+	rng := rand.New(rand.NewSource(seed))
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("%x\n", rng.Int63n(1<<(rng.Intn(40)+1)))
+	}
+	return out
+}
+
+func check(t *testing.T, in []string, opts Options) Stats {
+	t.Helper()
+	var out bytes.Buffer
+	st, err := Sort(strings.NewReader(strings.Join(in, "")), &out, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]string(nil), in...)
+	sort.Strings(want)
+	if got := out.String(); got != strings.Join(want, "") {
+		t.Fatalf("output isn't the sorted input: %v bytes, want %v", len(got), len(strings.Join(want, "")))
+	}
+	if st.Lines != int64(len(in)) {
+		t.Errorf("Lines = %v, want %v", st.Lines, len(in))
+	}
+	return st
+}
+
+func TestInMemory(t *testing.T) {
+	st := check(t, lines(1000, 1), Options{TempDir: t.TempDir()})
+	if st.Runs != 0 {
+		t.Errorf("spilled %v runs for input that fits in memory", st.Runs)
+	}
+}
+
+func TestSpills(t *testing.T) {
+	st := check(t, lines(20000, 2), Options{Memory: 64 << 10, Workers: 3, TempDir: t.TempDir()})
+	if st.Runs < 2 || st.MergePasses != 1 {
+		t.Errorf("%v runs in %v merge passes, want several runs merged once", st.Runs, st.MergePasses)
+	}
+}
+
+func TestMergePasses(t *testing.T) {
+	st := check(t, lines(20000, 3), Options{Memory: 32 << 10, Workers: 2, MaxFanIn: 2, TempDir: t.TempDir()})
+	// every pass halves the runs, the last one merges two
+	want := 1
+	for n := st.Runs; n > 2; n = (n + 1) / 2 {
+		want++
+	}
+	if st.MergePasses != want {
+		t.Errorf("%v runs took %v merge passes with a fan-in of 2, want %v", st.Runs, st.MergePasses, want)
+	}
+}
+
+func TestEdgeCases(t *testing.T) {
+	for name, in := range map[string]string{
+		"empty":      "",
+		"no newline": "b\na",
+		"blank":      "\n\n\n",
+		"duplicates": "x\nx\na\nx\n",
+	} {
+		for _, memory := range []int64{1 << 20, 1} {
+			var out bytes.Buffer
+			if _, err := Sort(strings.NewReader(in), &out, Options{Memory: memory, TempDir: t.TempDir()}); err != nil {
+				t.Fatalf("%v: %v", name, err)
+			}
+			var want []string
+			if in != "" {
+				want = strings.SplitAfter(strings.TrimSuffix(in, "\n")+"\n", "\n")
+				want = want[:len(want)-1]
+			}
+			sort.Strings(want)
+			if out.String() != strings.Join(want, "") {
+				t.Errorf("%v (memory %v): got %q, want %q", name, memory, out.String(), strings.Join(want, ""))
+			}
+		}
+	}
+}
+
+func TestCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	check(t, lines(5000, 4), Options{Memory: 16 << 10, MaxFanIn: 3, TempDir: dir})
+	if ents, _ := os.ReadDir(dir); len(ents) != 0 {
+		t.Errorf("%v entries left in the temp dir", len(ents))
+	}
+}
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestReadError(t *testing.T) {
+	boom := errors.New("boom")
+	for _, memory := range []int64{1 << 20, 1 << 10} {
+		in := &failingReader{strings.NewReader(strings.Join(lines(1000, 5), "")), boom}
+		_, err := Sort(in, io.Discard, Options{Memory: memory, TempDir: t.TempDir()})
+		if !errors.Is(err, boom) {
+			t.Errorf("memory %v: err = %v, want the read error", memory, err)
+		}
+	}
+}
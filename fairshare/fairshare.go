@@ -0,0 +1,286 @@
+// Package fairshare is a worker pool shared between tenants, scheduled the way an
+// OS shares a CPU between users.
+//
+// With a plain FIFO pool whoever submits the most gets the most: a tenant dumping a
+// thousand batch jobs makes everybody else wait behind them. The Fair policy keeps a
+// queue per tenant and hands workers out by weighted fair queuing instead:
+//
+//   - every tenant has a virtual time, the worker time it has used divided by its
+//     weight. A free worker takes the next job of the tenant with the smallest
+//     virtual time, so over any busy period tenants get worker time in proportion
+//     to their weights. Jobs are charged an estimate when they start (the tenant's
+//     average job time) and corrected by the real run time when they finish.
+//   - a tenant that was idle starts at the current virtual time, not at the old
+//     value it left with: being idle doesn't bank credit to flood with later.
+//   - a quota caps the jobs a tenant runs at once, whatever its share.
+//   - starvation protection: a tenant with queued jobs that hasn't been given a
+//     worker for MaxWait gets the next one, ahead of the fair share order
+//     (aging). Weighted fair queuing never starves anyone completely, but with a
+//     weight of 1 against 50 the gaps between two jobs of the small tenant get long.
+package fairshare
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+)
+
+// Policy picks the next job.
+type Policy int
+
+const (
+	// FIFO runs jobs in submission order and ignores weights and quotas, a plain
+	// worker pool to compare with.
+	FIFO Policy = iota
+	// Fair is weighted fair queuing between tenants.
+	Fair
+)
+
+func (p Policy) String() string {
+	switch p {
+	case FIFO:
+		return "fifo"
+	case Fair:
+		return "fair"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+var (
+	ErrClosed        = errors.New("fairshare: closed")
+	ErrUnknownTenant = errors.New("fairshare: unknown tenant")
+	ErrQueueFull     = errors.New("fairshare: tenant queue full")
+)
+
+// Config sets up a scheduler.
+type Config struct {
+	Workers int
+	Policy  Policy
+	// MaxWait, when not zero, is how long a backlogged tenant can go without a
+	// worker before it jumps the fair share order.
+	MaxWait time.Duration
+}
+
+// TenantConfig is one tenant's share.
+type TenantConfig struct {
+	Weight   int // share of worker time relative to the others, 1 by default
+	Quota    int // most jobs running at once, 0 for no limit
+	MaxQueue int // most jobs queued, 0 for no limit
+}
+
+// TenantStats describes a tenant's jobs.
+type TenantStats struct {
+	Name                string
+	Weight, Quota       int
+	Submitted, Rejected int64
+	Completed           int64
+	Aged                int64 // jobs run early by the starvation protection
+	Queued, Running     int
+	Busy                time.Duration // worker time used
+	Wait, Run           *histogram.Snapshot
+}
+
+type job struct {
+	t      *tenant
+	fn     func()
+	queued time.Time
+	charge float64
+}
+
+type tenant struct {
+	name    string
+	cfg     TenantConfig
+	queue   []*job
+	running int
+	vtime   float64 // worker seconds used divided by weight
+	avgCost float64 // seconds, moving average of the job run time
+	// last dispatch, or when the queue last went from empty to not, whichever is later
+	served time.Time
+
+	submitted, rejected, completed, aged int64
+	busy                                 time.Duration
+	wait, run                            *histogram.Histogram
+}
+
+// Scheduler runs tenants' jobs on a fixed set of workers. It is safe for
+// concurrent use.
+type Scheduler struct {
+	cfg Config
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	tenants map[string]*tenant
+	order   []*tenant
+	fifo    []*job
+	vclock  float64 // virtual time of the last fair dispatch
+	queued  int
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// New starts a scheduler's workers.
+func New(cfg Config) *Scheduler {
+	cfg.Workers = max(cfg.Workers, 1)
+	s := &Scheduler{cfg: cfg, tenants: map[string]*tenant{}}
+	s.cond = sync.NewCond(&s.mu)
+	for w := 0; w < cfg.Workers; w++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s
+}
+
+// AddTenant registers a tenant, or updates its share if it exists.
+func (s *Scheduler) AddTenant(name string, cfg TenantConfig) {
+	cfg.Weight = max(cfg.Weight, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tenants[name]; ok {
+		t.cfg = cfg
+		s.cond.Broadcast()
+		return
+	}
+	t := &tenant{name: name, cfg: cfg, avgCost: 0.001, vtime: s.vclock, wait: histogram.New(), run: histogram.New()}
+	s.tenants[name] = t
+	s.order = append(s.order, t)
+}
+
+// Submit queues fn to run on behalf of tenant. It doesn't wait for it to run.
+func (s *Scheduler) Submit(tenant string, fn func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	t, ok := s.tenants[tenant]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownTenant, tenant)
+	}
+	if t.cfg.MaxQueue > 0 && len(t.queue) >= t.cfg.MaxQueue {
+		t.rejected++
+		return ErrQueueFull
+	}
+	if len(t.queue) == 0 {
+		t.served = time.Now()
+		if t.running == 0 {
+			// back from idle, no credit for the time away
+			t.vtime = max(t.vtime, s.vclock)
+		}
+	}
+	j := &job{t: t, fn: fn, queued: time.Now()}
+	t.queue = append(t.queue, j)
+	t.submitted++
+	s.queued++
+	if s.cfg.Policy == FIFO {
+		s.fifo = append(s.fifo, j)
+	}
+	s.cond.Signal()
+	return nil
+}
+
+// next picks the job to run, or nil. s.mu must be held.
+func (s *Scheduler) next() *job {
+	if s.cfg.Policy == FIFO {
+		if len(s.fifo) == 0 {
+			return nil
+		}
+		j := s.fifo[0]
+		s.fifo = s.fifo[1:]
+		j.t.queue = j.t.queue[1:]
+		return j
+	}
+
+	var best, starved *tenant
+	for _, t := range s.order {
+		if len(t.queue) == 0 || (t.cfg.Quota > 0 && t.running >= t.cfg.Quota) {
+			continue
+		}
+		if best == nil || t.vtime < best.vtime {
+			best = t
+		}
+		if starved == nil || t.served.Before(starved.served) {
+			starved = t
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	if s.cfg.MaxWait > 0 && starved != best && time.Since(starved.served) > s.cfg.MaxWait {
+		// out of turn, its virtual time may be far ahead and mustn't move the clock
+		best = starved
+		best.aged++
+	} else {
+		s.vclock = max(s.vclock, best.vtime)
+	}
+	j := best.queue[0]
+	best.queue = best.queue[1:]
+	best.served = time.Now()
+	j.charge = best.avgCost / float64(best.cfg.Weight)
+	best.vtime += j.charge
+	return j
+}
+
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+	s.mu.Lock()
+	for {
+		j := s.next()
+		if j == nil {
+			if s.closed && s.queued == 0 {
+				s.mu.Unlock()
+				return
+			}
+			s.cond.Wait()
+			continue
+		}
+		t := j.t
+		t.running++
+		s.queued--
+		s.mu.Unlock()
+
+		start := time.Now()
+		t.wait.Record(start.Sub(j.queued))
+		j.fn()
+		took := time.Since(start)
+		t.run.Record(took)
+
+		s.mu.Lock()
+		t.running--
+		t.completed++
+		t.busy += took
+		// replace the estimate by what it really cost
+		t.vtime += took.Seconds()/float64(t.cfg.Weight) - j.charge
+		t.avgCost = 0.8*t.avgCost + 0.2*took.Seconds()
+		// a freed quota slot may make another worker's tenant runnable
+		s.cond.Broadcast()
+	}
+}
+
+// Close stops taking jobs, waits until every queued job has run and stops the
+// workers.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Stats returns every tenant's statistics, in the order they were added.
+func (s *Scheduler) Stats() []TenantStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TenantStats, 0, len(s.order))
+	for _, t := range s.order {
+		out = append(out, TenantStats{
+			Name: t.name, Weight: t.cfg.Weight, Quota: t.cfg.Quota,
+			Submitted: t.submitted, Rejected: t.rejected, Completed: t.completed, Aged: t.aged,
+			Queued: len(t.queue), Running: t.running, Busy: t.busy,
+			Wait: t.wait.Snapshot(), Run: t.run.Snapshot(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package fairshare
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder logs which tenant each job ran for, in order.
+type recorder struct {
+	mu  sync.Mutex
+	ran []string
+}
+
+func (r *recorder) job(tenant string, d time.Duration) func() {
+	return func() {
+		time.Sleep(d)
+		r.mu.Lock()
+		r.ran = append(r.ran, tenant)
+		r.mu.Unlock()
+	}
+}
+
+func (r *recorder) count(tenant string, first int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, t := range r.ran[:min(first, len(r.ran))] {
+		if t == tenant {
+			n++
+		}
+	}
+	return n
+}
+
+// hold occupies the only worker of s until the returned func is called, so the
+// jobs submitted in between queue up and the policy alone decides their order.
+func hold(t *testing.T, s *Scheduler) func() {
+	t.Helper()
+	s.AddTenant("gate", TenantConfig{})
+	started, release := make(chan struct{}), make(chan struct{})
+	if err := s.Submit("gate", func() { close(started); <-release }); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return func() { close(release) }
+}
+
+func submit(t *testing.T, s *Scheduler, tenant string, n int, fn func()) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := s.Submit(tenant, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWeights(t *testing.T) {
+	s := New(Config{Workers: 1, Policy: Fair})
+	s.AddTenant("a", TenantConfig{Weight: 3})
+	s.AddTenant("b", TenantConfig{Weight: 1})
+	var r recorder
+	release := hold(t, s)
+	submit(t, s, "a", 100, r.job("a", time.Millisecond))
+	submit(t, s, "b", 100, r.job("b", time.Millisecond))
+	release()
+	s.Close()
+	// both backlogged the whole time: a gets three jobs for every one of b's
+	if n := r.count("a", 80); n < 50 || n > 70 {
+		t.Errorf("a ran %v of the first 80 jobs, want about 60", n)
+	}
+	for _, st := range s.Stats() {
+		if st.Name != "gate" && (st.Completed != 100 || st.Queued != 0) {
+			t.Errorf("%v: %v completed, %v queued", st.Name, st.Completed, st.Queued)
+		}
+	}
+}
+
+func TestFIFOIgnoresWeights(t *testing.T) {
+	s := New(Config{Workers: 1, Policy: FIFO})
+	s.AddTenant("a", TenantConfig{Weight: 1})
+	s.AddTenant("b", TenantConfig{Weight: 100})
+	var r recorder
+	release := hold(t, s)
+	submit(t, s, "a", 20, r.job("a", 0))
+	submit(t, s, "b", 20, r.job("b", 0))
+	release()
+	s.Close()
+	if n := r.count("a", 20); n != 20 {
+		t.Errorf("a ran %v of the first 20 jobs, FIFO should run all of its jobs first", n)
+	}
+}
+
+func TestIdleBanksNoCredit(t *testing.T) {
+	s := New(Config{Workers: 1, Policy: Fair})
+	s.AddTenant("a", TenantConfig{})
+	s.AddTenant("b", TenantConfig{})
+	var r recorder
+	// a works alone for a while, b sits idle
+	submit(t, s, "a", 50, r.job("a", time.Millisecond))
+	for r.count("a", 50) < 50 {
+		time.Sleep(time.Millisecond)
+	}
+	release := hold(t, s)
+	submit(t, s, "b", 50, r.job("b", time.Millisecond))
+	submit(t, s, "a", 50, r.job("a", time.Millisecond))
+	release()
+	s.Close()
+	// had b kept its old virtual time it would run all 50 of its jobs first
+	if n := r.count("a", 50+40) - 50; n < 10 {
+		t.Errorf("a got %v of the 40 jobs after b came back, want about half", n)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	s := New(Config{Workers: 4, Policy: Fair})
+	s.AddTenant("a", TenantConfig{Quota: 2})
+	var mu sync.Mutex
+	running, peak := 0, 0
+	submit(t, s, "a", 20, func() {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	s.Close()
+	if peak != 2 {
+		t.Errorf("peak of %v jobs running at once, quota is 2", peak)
+	}
+}
+
+func TestAging(t *testing.T) {
+	s := New(Config{Workers: 1, Policy: Fair, MaxWait: 5 * time.Millisecond})
+	s.AddTenant("big", TenantConfig{Weight: 1000})
+	s.AddTenant("small", TenantConfig{Weight: 1})
+	var r recorder
+	release := hold(t, s)
+	submit(t, s, "big", 100, r.job("big", time.Millisecond))
+	submit(t, s, "small", 10, r.job("small", time.Millisecond))
+	release()
+	s.Close()
+	var aged int64
+	for _, st := range s.Stats() {
+		if st.Name == "small" {
+			aged = st.Aged
+		}
+	}
+	// by weight alone small would get a job in every thousand
+	if aged == 0 || r.count("small", 60) < 3 {
+		t.Errorf("small aged %v times and ran %v of the first 60 jobs", aged, r.count("small", 60))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := New(Config{Workers: 1, Policy: Fair})
+	s.AddTenant("a", TenantConfig{MaxQueue: 2})
+	release := hold(t, s)
+	submit(t, s, "a", 2, func() {})
+	if err := s.Submit("a", func() {}); err != ErrQueueFull {
+		t.Errorf("third job in a queue of 2: %v", err)
+	}
+	if err := s.Submit("nobody", func() {}); !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("unknown tenant: %v", err)
+	}
+	release()
+	s.Close()
+	if err := s.Submit("a", func() {}); err != ErrClosed {
+		t.Errorf("after Close: %v", err)
+	}
+	st := s.Stats()[0]
+	if st.Submitted != 2 || st.Rejected != 1 || st.Completed != 2 {
+		t.Errorf("stats %+v", st)
+	}
+}
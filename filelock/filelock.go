@@ -0,0 +1,200 @@
+//go:build unix
+
+// Package filelock provides advisory file locks between processes, built on
+// flock(2).
+//
+// The locks are advisory: they only keep out processes that also take the lock,
+// nothing stops someone from just writing to the file. flock locks belong to the
+// open file description, so two Lock values for the same path conflict even inside
+// one process, which makes them usable between goroutines as well.
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Mode is the kind of lock held.
+type Mode int
+
+const (
+	Unlocked Mode = iota
+	Shared
+	Exclusive
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Shared:
+		return "shared"
+	case Exclusive:
+		return "exclusive"
+	}
+	return "unlocked"
+}
+
+// ErrTimeout is returned when a lock couldn't be taken before the deadline.
+var ErrTimeout = errors.New("filelock: timed out waiting for lock")
+
+// pollInterval bounds the backoff between non blocking attempts when waiting with
+// a timeout, flock itself has no timeout.
+const pollInterval = 10 * time.Millisecond
+
+// Lock is an advisory lock on a file. It is not safe for concurrent use, open one
+// Lock per goroutine.
+type Lock struct {
+	f    *os.File
+	mode Mode
+}
+
+// Open opens (creating it if necessary) the file at path for locking.
+func Open(path string) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// File returns the underlying file, e.g. to use the lock file itself as the data.
+func (l *Lock) File() *os.File { return l.f }
+
+// Mode reports the mode currently held.
+func (l *Lock) Mode() Mode { return l.mode }
+
+func how(m Mode) int {
+	if m == Exclusive {
+		return syscall.LOCK_EX
+	}
+	return syscall.LOCK_SH
+}
+
+func (l *Lock) flock(op int) error {
+	for {
+		err := syscall.Flock(int(l.f.Fd()), op)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// Lock blocks until the exclusive lock is held.
+func (l *Lock) Lock() error { return l.acquire(Exclusive) }
+
+// RLock blocks until the shared lock is held.
+func (l *Lock) RLock() error { return l.acquire(Shared) }
+
+func (l *Lock) acquire(m Mode) error {
+	if err := l.flock(how(m)); err != nil {
+		return fmt.Errorf("filelock: %v %v: %w", m, l.f.Name(), err)
+	}
+	l.mode = m
+	return nil
+}
+
+// TryLock takes the exclusive lock if it's free, it never blocks.
+func (l *Lock) TryLock() (bool, error) { return l.try(Exclusive) }
+
+// TryRLock takes the shared lock if no one holds it exclusively, it never blocks.
+func (l *Lock) TryRLock() (bool, error) { return l.try(Shared) }
+
+func (l *Lock) try(m Mode) (bool, error) {
+	err := l.flock(how(m) | syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("filelock: %v %v: %w", m, l.f.Name(), err)
+	}
+	l.mode = m
+	return true, nil
+}
+
+// LockContext waits for the lock in mode m until ctx is done.
+func (l *Lock) LockContext(ctx context.Context, m Mode) error {
+	wait := time.Millisecond
+	for {
+		ok, err := l.try(m)
+		if err != nil || ok {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrTimeout
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+		wait = min(2*wait, pollInterval)
+	}
+}
+
+// LockTimeout waits up to d for the lock in mode m, ErrTimeout if it's not free by then.
+func (l *Lock) LockTimeout(m Mode, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return l.LockContext(ctx, m)
+}
+
+// Upgrade turns a held shared lock into an exclusive one, waiting at most d.
+//
+// flock can't upgrade atomically: the kernel drops the shared lock before it tries
+// for the exclusive one, so another writer can get in between. Anything read under
+// the shared lock has to be re-validated after Upgrade returns, whatever it returns.
+// On timeout the shared lock is taken again before returning, which waits for a
+// writer that got in meanwhile. If even that fails the error says so and the lock
+// is left Unlocked.
+func (l *Lock) Upgrade(d time.Duration) error {
+	if l.mode != Shared {
+		return fmt.Errorf("filelock: upgrade needs a shared lock, holding %v", l.mode)
+	}
+	err := l.LockTimeout(Exclusive, d)
+	if err == nil {
+		return nil
+	}
+	// the first failed conversion already gave up the shared lock
+	l.mode = Unlocked
+	if rerr := l.acquire(Shared); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+// Downgrade turns a held exclusive lock into a shared one.
+//
+// Like Upgrade this isn't atomic: flock drops the exclusive lock before it takes the
+// shared one, a writer that was waiting can get in between and Downgrade then waits
+// for it to finish. Whatever was written under the exclusive lock may have changed
+// by the time Downgrade returns. If it fails the lock is left Unlocked.
+func (l *Lock) Downgrade() error {
+	if l.mode != Exclusive {
+		return fmt.Errorf("filelock: downgrade needs an exclusive lock, holding %v", l.mode)
+	}
+	if err := l.acquire(Shared); err != nil {
+		l.mode = Unlocked
+		return err
+	}
+	return nil
+}
+
+// Unlock releases whatever lock is held.
+func (l *Lock) Unlock() error {
+	if err := l.flock(syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("filelock: unlock %v: %w", l.f.Name(), err)
+	}
+	l.mode = Unlocked
+	return nil
+}
+
+// Close releases the lock and closes the file.
+func (l *Lock) Close() error {
+	l.mode = Unlocked
+	return l.f.Close()
+}
@@ -0,0 +1,168 @@
+//go:build unix
+
+package filelock
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// locks opens n locks on the same file, flock makes them conflict like n processes.
+func locks(t *testing.T, n int) []*Lock {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lock")
+	ls := make([]*Lock, n)
+	for i := range ls {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		ls[i] = l
+	}
+	return ls
+}
+
+func try(t *testing.T, l *Lock, m Mode) bool {
+	t.Helper()
+	var ok bool
+	var err error
+	if m == Exclusive {
+		ok, err = l.TryLock()
+	} else {
+		ok, err = l.TryRLock()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestExclusive(t *testing.T) {
+	ls := locks(t, 2)
+	a, b := ls[0], ls[1]
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Mode() != Exclusive {
+		t.Fatalf("mode %v after Lock", a.Mode())
+	}
+	if try(t, b, Exclusive) || try(t, b, Shared) {
+		t.Fatal("got a lock while the other one is held exclusively")
+	}
+	if b.Mode() != Unlocked {
+		t.Errorf("failed try left mode %v", b.Mode())
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if !try(t, b, Exclusive) {
+		t.Fatal("lock not free after Unlock")
+	}
+}
+
+func TestShared(t *testing.T) {
+	ls := locks(t, 3)
+	if err := ls[0].RLock(); err != nil {
+		t.Fatal(err)
+	}
+	if !try(t, ls[1], Shared) {
+		t.Fatal("a second shared lock was refused")
+	}
+	if try(t, ls[2], Exclusive) {
+		t.Fatal("exclusive lock taken under two shared ones")
+	}
+}
+
+func TestCloseReleases(t *testing.T) {
+	ls := locks(t, 2)
+	ls[0].Lock()
+	ls[0].Close()
+	if !try(t, ls[1], Exclusive) {
+		t.Fatal("lock still held after Close")
+	}
+}
+
+func TestWaiting(t *testing.T) {
+	ls := locks(t, 2)
+	a, b := ls[0], ls[1]
+	a.Lock()
+
+	start := time.Now()
+	if err := b.LockTimeout(Exclusive, 30*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("LockTimeout on a held lock: %v", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("gave up after %v, before the timeout", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.LockContext(ctx, Shared); !errors.Is(err, context.Canceled) {
+		t.Fatalf("LockContext after cancel: %v", err)
+	}
+
+	got := make(chan error, 1)
+	go func() { got <- b.LockTimeout(Exclusive, 5*time.Second) }()
+	time.Sleep(20 * time.Millisecond)
+	a.Unlock()
+	if err := <-got; err != nil {
+		t.Fatalf("LockTimeout once the lock was let go: %v", err)
+	}
+	if b.Mode() != Exclusive {
+		t.Errorf("mode %v", b.Mode())
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	ls := locks(t, 2)
+	a, b := ls[0], ls[1]
+	if err := a.Upgrade(time.Second); err == nil {
+		t.Fatal("Upgrade without a shared lock")
+	}
+	a.RLock()
+	if err := a.Upgrade(time.Second); err != nil {
+		t.Fatalf("Upgrade of the only reader: %v", err)
+	}
+	if a.Mode() != Exclusive || try(t, b, Shared) {
+		t.Fatal("not exclusive after Upgrade")
+	}
+
+	a.Unlock()
+	a.RLock()
+	b.RLock()
+	// b's shared lock keeps the upgrade out, a gets its shared lock back
+	if err := a.Upgrade(30 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("Upgrade next to another reader: %v", err)
+	}
+	if a.Mode() != Shared {
+		t.Fatalf("mode %v after a failed Upgrade, expected shared again", a.Mode())
+	}
+	b.Unlock()
+	if try(t, b, Exclusive) {
+		t.Fatal("a lost its shared lock in the failed Upgrade")
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	ls := locks(t, 3)
+	a := ls[0]
+	if err := a.Downgrade(); err == nil {
+		t.Fatal("Downgrade without the exclusive lock")
+	}
+	a.Lock()
+	if err := a.Downgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Mode() != Shared {
+		t.Fatalf("mode %v after Downgrade", a.Mode())
+	}
+	if !try(t, ls[1], Shared) {
+		t.Fatal("readers still kept out after Downgrade")
+	}
+	if try(t, ls[2], Exclusive) {
+		t.Fatal("writer got in after Downgrade")
+	}
+}
@@ -0,0 +1,215 @@
+package fssim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrNoSpace means the allocator couldn't find the blocks it needed.
+var ErrNoSpace = errors.New("fssim: no space left on device")
+
+// Allocator is a block allocation strategy. It decides where a file's blocks go on
+// disk and how the file finds them again.
+type Allocator interface {
+	Name() string
+	// Grow adds n data blocks to the end of the file.
+	Grow(d *Disk, ino *Inode, n int) error
+	// Free releases every block of the file, data and metadata.
+	Free(d *Disk, ino *Inode)
+	// Capacity is how many bytes of file data one data block holds.
+	Capacity(d *Disk) int
+}
+
+// NewAllocator returns the allocator with the given name.
+func NewAllocator(name string) (Allocator, error) {
+	switch name {
+	case "contiguous":
+		return Contiguous{}, nil
+	case "linked":
+		return Linked{}, nil
+	case "indexed":
+		return Indexed{}, nil
+	case "fat":
+		return &FAT{}, nil
+	}
+	return nil, fmt.Errorf("fssim: unknown allocator %q (contiguous, linked, indexed, fat)", name)
+}
+
+// formatter is implemented by allocators that need on-disk structures of their own
+// set up when the file system is created.
+type formatter interface {
+	Format(d *Disk)
+	// Reserved is the number of blocks taken by those structures.
+	Reserved() int
+}
+
+// Contiguous keeps every file in one run of blocks. Reads are as fast as it gets,
+// but growing a file needs free space right after it, otherwise the whole file is
+// moved to a bigger hole. The free space shatters into small holes over time
+// (external fragmentation).
+type Contiguous struct{}
+
+func (Contiguous) Name() string             { return "contiguous" }
+func (Contiguous) Capacity(d *Disk) int     { return d.BlockSize }
+func (Contiguous) Free(d *Disk, ino *Inode) { freeAll(d, ino) }
+
+func (Contiguous) Grow(d *Disk, ino *Inode, n int) error {
+	if len(ino.Blocks) > 0 {
+		// try extending in place first
+		next := ino.Blocks[len(ino.Blocks)-1] + 1
+		ok := next+n <= d.NumBlocks()
+		for b := next; ok && b < next+n; b++ {
+			ok = !d.used[b]
+		}
+		if ok {
+			for b := next; b < next+n; b++ {
+				d.mark(b)
+				ino.Blocks = append(ino.Blocks, b)
+			}
+			return nil
+		}
+	}
+
+	total := len(ino.Blocks) + n
+	start := d.findRun(total)
+	if start < 0 {
+		return ErrNoSpace
+	}
+	// relocate: copy the old blocks into the new run and free the old ones
+	moved := make([]int, 0, total)
+	for i := 0; i < total; i++ {
+		b := start + i
+		d.mark(b)
+		if i < len(ino.Blocks) {
+			copy(d.blocks[b], d.blocks[ino.Blocks[i]])
+		}
+		moved = append(moved, b)
+	}
+	for _, b := range ino.Blocks {
+		d.release(b)
+	}
+	ino.Relocations++
+	ino.Blocks = moved
+	return nil
+}
+
+// Linked chains the blocks of a file: each block ends with the number of the next
+// one. Any free block will do, so there's no external fragmentation, but every block
+// loses a few bytes to the pointer and reaching byte N means walking the chain.
+type Linked struct{}
+
+const pointerSize = 4
+
+func (Linked) Name() string             { return "linked" }
+func (Linked) Capacity(d *Disk) int     { return d.BlockSize - pointerSize }
+func (Linked) Free(d *Disk, ino *Inode) { freeAll(d, ino) }
+
+func (Linked) Grow(d *Disk, ino *Inode, n int) error {
+	if d.FreeBlocks() < n {
+		return ErrNoSpace
+	}
+	for i := 0; i < n; i++ {
+		b := d.firstFree()
+		d.mark(b)
+		if len(ino.Blocks) > 0 {
+			last := d.blocks[ino.Blocks[len(ino.Blocks)-1]]
+			binary.LittleEndian.PutUint32(last[len(last)-pointerSize:], uint32(b)+1)
+		}
+		ino.Blocks = append(ino.Blocks, b)
+	}
+	return nil
+}
+
+// Indexed gives every file index blocks holding the numbers of its data blocks, the
+// way unix inodes do (minus the direct/indirect split). Random access is cheap and
+// any free block will do, the price is the index blocks themselves.
+type Indexed struct{}
+
+func (Indexed) Name() string         { return "indexed" }
+func (Indexed) Capacity(d *Disk) int { return d.BlockSize }
+
+func (Indexed) Free(d *Disk, ino *Inode) {
+	for _, b := range ino.Index {
+		d.release(b)
+	}
+	ino.Index = nil
+	freeAll(d, ino)
+}
+
+func (Indexed) Grow(d *Disk, ino *Inode, n int) error {
+	perIndex := d.BlockSize / pointerSize
+	total := len(ino.Blocks) + n
+	needIndex := (total+perIndex-1)/perIndex - len(ino.Index)
+	if d.FreeBlocks() < n+needIndex {
+		return ErrNoSpace
+	}
+	for i := 0; i < needIndex; i++ {
+		b := d.firstFree()
+		d.mark(b)
+		ino.Index = append(ino.Index, b)
+	}
+	for i := 0; i < n; i++ {
+		b := d.firstFree()
+		d.mark(b)
+		slot := len(ino.Blocks)
+		index := d.blocks[ino.Index[slot/perIndex]]
+		binary.LittleEndian.PutUint32(index[(slot%perIndex)*pointerSize:], uint32(b)+1)
+		ino.Blocks = append(ino.Blocks, b)
+	}
+	return nil
+}
+
+// FAT is linked allocation with the links pulled out of the data blocks into a file
+// allocation table at the start of the disk, one entry per block. Blocks keep their
+// full capacity and the table can be cached in memory, so following a chain doesn't
+// cost disk reads. The table costs a fixed number of blocks no matter how many files
+// there are.
+type FAT struct {
+	table    []int32
+	reserved int
+}
+
+const fatEnd = -1
+
+func (f *FAT) Name() string         { return "fat" }
+func (f *FAT) Capacity(d *Disk) int { return d.BlockSize }
+func (f *FAT) Reserved() int        { return f.reserved }
+
+func (f *FAT) Format(d *Disk) {
+	f.table = make([]int32, d.NumBlocks())
+	f.reserved = (d.NumBlocks()*pointerSize + d.BlockSize - 1) / d.BlockSize
+	for b := 0; b < f.reserved; b++ {
+		d.mark(b)
+	}
+}
+
+func (f *FAT) Grow(d *Disk, ino *Inode, n int) error {
+	if d.FreeBlocks() < n {
+		return ErrNoSpace
+	}
+	for i := 0; i < n; i++ {
+		b := d.firstFree()
+		d.mark(b)
+		f.table[b] = fatEnd
+		if len(ino.Blocks) > 0 {
+			f.table[ino.Blocks[len(ino.Blocks)-1]] = int32(b)
+		}
+		ino.Blocks = append(ino.Blocks, b)
+	}
+	return nil
+}
+
+func (f *FAT) Free(d *Disk, ino *Inode) {
+	for _, b := range ino.Blocks {
+		f.table[b] = 0
+	}
+	freeAll(d, ino)
+}
+
+func freeAll(d *Disk, ino *Inode) {
+	for _, b := range ino.Blocks {
+		d.release(b)
+	}
+	ino.Blocks = nil
+}
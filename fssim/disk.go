@@ -0,0 +1,100 @@
+package fssim
+
+// Disk is an array of fixed size blocks plus a free map.
+type Disk struct {
+	BlockSize int
+	blocks    [][]byte
+	used      []bool
+	free      int
+}
+
+// NewDisk returns an empty disk of n blocks.
+func NewDisk(n, blockSize int) *Disk {
+	d := &Disk{BlockSize: blockSize, blocks: make([][]byte, n), used: make([]bool, n), free: n}
+	for i := range d.blocks {
+		d.blocks[i] = make([]byte, blockSize)
+	}
+	return d
+}
+
+// NumBlocks is the size of the disk in blocks.
+func (d *Disk) NumBlocks() int { return len(d.blocks) }
+
+// FreeBlocks is the number of unallocated blocks.
+func (d *Disk) FreeBlocks() int { return d.free }
+
+func (d *Disk) mark(b int) {
+	d.used[b] = true
+	d.free--
+}
+
+func (d *Disk) release(b int) {
+	d.used[b] = false
+	d.free++
+	clear(d.blocks[b])
+}
+
+// firstFree returns the lowest numbered free block, -1 if the disk is full.
+func (d *Disk) firstFree() int {
+	for i, u := range d.used {
+		if !u {
+			return i
+		}
+	}
+	return -1
+}
+
+// findRun returns the start of the first run of n free blocks (first fit), -1 if
+// there isn't one.
+func (d *Disk) findRun(n int) int {
+	run := 0
+	for i, u := range d.used {
+		if u {
+			run = 0
+			continue
+		}
+		run++
+		if run == n {
+			return i - n + 1
+		}
+	}
+	return -1
+}
+
+// Extent is a run of consecutive blocks.
+type Extent struct {
+	Start, Len int
+}
+
+// FreeExtents lists the runs of free blocks in disk order.
+func (d *Disk) FreeExtents() []Extent {
+	var out []Extent
+	start := -1
+	for i, u := range d.used {
+		switch {
+		case !u && start < 0:
+			start = i
+		case u && start >= 0:
+			out = append(out, Extent{start, i - start})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, Extent{start, len(d.used) - start})
+	}
+	return out
+}
+
+// Map renders the disk as one character per block: '.' free, otherwise the
+// character given by owner (e.g. the inode number of the file using it).
+func (d *Disk) Map(owner func(block int) byte) string {
+	b := make([]byte, len(d.used))
+	for i, u := range d.used {
+		if !u {
+			b[i] = '.'
+		} else {
+			b[i] = owner(i)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,407 @@
+// Package fssim is a small in-memory file system for playing with the data
+// structures a real one is made of: inodes, directories, a system wide open file
+// table pointing at inodes, per process file descriptor tables pointing into it, and
+// a block allocator that can be swapped between contiguous, linked and indexed
+// allocation to compare how they fragment.
+package fssim
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotExist = errors.New("fssim: no such file or directory")
+	ErrExist    = errors.New("fssim: file exists")
+	ErrNotDir   = errors.New("fssim: not a directory")
+	ErrIsDir    = errors.New("fssim: is a directory")
+	ErrNotEmpty = errors.New("fssim: directory not empty")
+	ErrBadFD    = errors.New("fssim: bad file descriptor")
+	ErrInvalid  = errors.New("fssim: invalid argument")
+)
+
+// Inode is the on-"disk" description of a file or directory.
+type Inode struct {
+	Num  int
+	Dir  bool
+	Size int
+	// Blocks are the data blocks in file order.
+	Blocks []int
+	// Index are the index blocks, only used by indexed allocation.
+	Index []int
+	// Relocations counts how often contiguous allocation had to move the file.
+	Relocations int
+
+	entries map[string]int // directories only
+	links   int
+	opens   int
+}
+
+// OpenFile is an entry of the system wide open file table. Several descriptors
+// (e.g. after a dup or a fork) can share one entry, and with it the offset.
+type OpenFile struct {
+	ino    *Inode
+	offset int
+	refs   int
+}
+
+// Process owns a file descriptor table.
+type Process struct {
+	fs  *FS
+	fds map[int]*OpenFile
+}
+
+// FS is the file system. All methods are safe for concurrent use.
+type FS struct {
+	mu     sync.Mutex
+	disk   *Disk
+	alloc  Allocator
+	inodes map[int]*Inode
+	next   int
+	open   []*OpenFile
+}
+
+// New formats a file system on a fresh disk of n blocks.
+func New(alloc Allocator, blocks, blockSize int) *FS {
+	fs := &FS{disk: NewDisk(blocks, blockSize), alloc: alloc, inodes: map[int]*Inode{}, next: 1}
+	if f, ok := alloc.(formatter); ok {
+		f.Format(fs.disk)
+	}
+	root := fs.newInode(true)
+	root.links = 1
+	return fs
+}
+
+// Allocator is the allocation strategy the file system was formatted with.
+func (fs *FS) Allocator() Allocator { return fs.alloc }
+
+// Disk gives access to the underlying disk, for reporting.
+func (fs *FS) Disk() *Disk { return fs.disk }
+
+func (fs *FS) newInode(dir bool) *Inode {
+	ino := &Inode{Num: fs.next, Dir: dir}
+	if dir {
+		ino.entries = map[string]int{}
+	}
+	fs.inodes[ino.Num] = ino
+	fs.next++
+	return ino
+}
+
+func split(p string) []string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+// walk resolves a path to its inode.
+func (fs *FS) walk(p string) (*Inode, error) {
+	ino := fs.inodes[1]
+	for _, name := range split(p) {
+		if !ino.Dir {
+			return nil, ErrNotDir
+		}
+		num, ok := ino.entries[name]
+		if !ok {
+			return nil, ErrNotExist
+		}
+		ino = fs.inodes[num]
+	}
+	return ino, nil
+}
+
+// parent resolves everything but the last element of p.
+func (fs *FS) parent(p string) (*Inode, string, error) {
+	parts := split(p)
+	if len(parts) == 0 {
+		return nil, "", ErrExist
+	}
+	dir, err := fs.walk(strings.Join(parts[:len(parts)-1], "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.Dir {
+		return nil, "", ErrNotDir
+	}
+	return dir, parts[len(parts)-1], nil
+}
+
+// Mkdir creates a directory.
+func (fs *FS) Mkdir(p string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, name, err := fs.parent(p)
+	if err != nil {
+		return fmt.Errorf("mkdir %v: %w", p, err)
+	}
+	if _, ok := dir.entries[name]; ok {
+		return fmt.Errorf("mkdir %v: %w", p, ErrExist)
+	}
+	ino := fs.newInode(true)
+	ino.links = 1
+	dir.entries[name] = ino.Num
+	return nil
+}
+
+// Remove unlinks a file or an empty directory. Like on unix, the blocks of a file
+// that is still open are only freed when the last descriptor is closed.
+func (fs *FS) Remove(p string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, name, err := fs.parent(p)
+	if err != nil {
+		return fmt.Errorf("remove %v: %w", p, err)
+	}
+	num, ok := dir.entries[name]
+	if !ok {
+		return fmt.Errorf("remove %v: %w", p, ErrNotExist)
+	}
+	ino := fs.inodes[num]
+	if ino.Dir && len(ino.entries) > 0 {
+		return fmt.Errorf("remove %v: %w", p, ErrNotEmpty)
+	}
+	delete(dir.entries, name)
+	ino.links--
+	fs.maybeFree(ino)
+	return nil
+}
+
+func (fs *FS) maybeFree(ino *Inode) {
+	if ino.links > 0 || ino.opens > 0 {
+		return
+	}
+	fs.alloc.Free(fs.disk, ino)
+	delete(fs.inodes, ino.Num)
+}
+
+// DirEntry is one line of a directory listing.
+type DirEntry struct {
+	Name   string
+	Inode  int
+	Dir    bool
+	Size   int
+	Blocks int
+}
+
+// ReadDir lists a directory sorted by name.
+func (fs *FS) ReadDir(p string) ([]DirEntry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dir, err := fs.walk(p)
+	if err != nil {
+		return nil, fmt.Errorf("ls %v: %w", p, err)
+	}
+	if !dir.Dir {
+		return nil, fmt.Errorf("ls %v: %w", p, ErrNotDir)
+	}
+	out := make([]DirEntry, 0, len(dir.entries))
+	for name, num := range dir.entries {
+		ino := fs.inodes[num]
+		out = append(out, DirEntry{Name: name, Inode: num, Dir: ino.Dir, Size: ino.Size, Blocks: len(ino.Blocks) + len(ino.Index)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Stat returns a copy of the inode at p.
+func (fs *FS) Stat(p string) (Inode, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	ino, err := fs.walk(p)
+	if err != nil {
+		return Inode{}, fmt.Errorf("stat %v: %w", p, err)
+	}
+	c := *ino
+	c.Blocks = append([]int(nil), ino.Blocks...)
+	c.Index = append([]int(nil), ino.Index...)
+	return c, nil
+}
+
+// NewProcess returns a process with an empty descriptor table.
+func (fs *FS) NewProcess() *Process {
+	return &Process{fs: fs, fds: map[int]*OpenFile{}}
+}
+
+// Open opens p, creating it when create is set, and returns the lowest free
+// descriptor. truncate empties the file first.
+func (pr *Process) Open(p string, create, truncate bool) (int, error) {
+	fs := pr.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ino, err := fs.walk(p)
+	if errors.Is(err, ErrNotExist) && create {
+		dir, name, perr := fs.parent(p)
+		if perr != nil {
+			return -1, fmt.Errorf("open %v: %w", p, perr)
+		}
+		ino = fs.newInode(false)
+		ino.links = 1
+		dir.entries[name] = ino.Num
+		err = nil
+	}
+	if err != nil {
+		return -1, fmt.Errorf("open %v: %w", p, err)
+	}
+	if ino.Dir {
+		return -1, fmt.Errorf("open %v: %w", p, ErrIsDir)
+	}
+	if truncate {
+		fs.alloc.Free(fs.disk, ino)
+		ino.Size = 0
+	}
+
+	of := &OpenFile{ino: ino, refs: 1}
+	ino.opens++
+	fs.open = append(fs.open, of)
+	fd := 0
+	for pr.fds[fd] != nil {
+		fd++
+	}
+	pr.fds[fd] = of
+	return fd, nil
+}
+
+// Dup makes a second descriptor for the same open file entry, sharing the offset.
+func (pr *Process) Dup(fd int) (int, error) {
+	pr.fs.mu.Lock()
+	defer pr.fs.mu.Unlock()
+	of, ok := pr.fds[fd]
+	if !ok {
+		return -1, ErrBadFD
+	}
+	nfd := 0
+	for pr.fds[nfd] != nil {
+		nfd++
+	}
+	of.refs++
+	pr.fds[nfd] = of
+	return nfd, nil
+}
+
+// Close releases a descriptor. The open file entry goes away with its last
+// descriptor, the inode's blocks with its last link and last open.
+func (pr *Process) Close(fd int) error {
+	fs := pr.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	of, ok := pr.fds[fd]
+	if !ok {
+		return ErrBadFD
+	}
+	delete(pr.fds, fd)
+	if of.refs--; of.refs > 0 {
+		return nil
+	}
+	for i, o := range fs.open {
+		if o == of {
+			fs.open = append(fs.open[:i], fs.open[i+1:]...)
+			break
+		}
+	}
+	of.ino.opens--
+	fs.maybeFree(of.ino)
+	return nil
+}
+
+// Seek sets the offset of the open file entry behind fd. Seeking past the end is
+// fine, a negative offset is ErrInvalid.
+func (pr *Process) Seek(fd, offset int) error {
+	if offset < 0 {
+		return ErrInvalid
+	}
+	pr.fs.mu.Lock()
+	defer pr.fs.mu.Unlock()
+	of, ok := pr.fds[fd]
+	if !ok {
+		return ErrBadFD
+	}
+	of.offset = offset
+	return nil
+}
+
+// Write writes data at the current offset, growing the file as needed.
+func (pr *Process) Write(fd int, data []byte) (int, error) {
+	fs := pr.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	of, ok := pr.fds[fd]
+	if !ok {
+		return 0, ErrBadFD
+	}
+	ino, capacity := of.ino, fs.alloc.Capacity(fs.disk)
+
+	end := of.offset + len(data)
+	need := (end+capacity-1)/capacity - len(ino.Blocks)
+	if need > 0 {
+		if err := fs.alloc.Grow(fs.disk, ino, need); err != nil {
+			return 0, err
+		}
+	}
+	written := 0
+	for written < len(data) {
+		pos := of.offset + written
+		block := fs.disk.blocks[ino.Blocks[pos/capacity]]
+		written += copy(block[pos%capacity:capacity], data[written:])
+	}
+	of.offset = end
+	ino.Size = max(ino.Size, end)
+	return written, nil
+}
+
+// Read reads up to n bytes from the current offset.
+func (pr *Process) Read(fd, n int) ([]byte, error) {
+	fs := pr.fs
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	of, ok := pr.fds[fd]
+	if !ok {
+		return nil, ErrBadFD
+	}
+	ino, capacity := of.ino, fs.alloc.Capacity(fs.disk)
+	n = min(n, ino.Size-of.offset)
+	out := make([]byte, 0, max(n, 0))
+	for len(out) < n {
+		pos := of.offset + len(out)
+		block := fs.disk.blocks[ino.Blocks[pos/capacity]]
+		chunk := block[pos%capacity : capacity]
+		out = append(out, chunk[:min(len(chunk), n-len(out))]...)
+	}
+	of.offset += len(out)
+	return out, nil
+}
+
+// OpenFileEntry describes one entry of the system wide open file table.
+type OpenFileEntry struct {
+	Inode  int
+	Offset int
+	Refs   int
+}
+
+// OpenFiles returns the system wide open file table.
+func (fs *FS) OpenFiles() []OpenFileEntry {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	out := make([]OpenFileEntry, 0, len(fs.open))
+	for _, of := range fs.open {
+		out = append(out, OpenFileEntry{Inode: of.ino.Num, Offset: of.offset, Refs: of.refs})
+	}
+	return out
+}
+
+// Descriptors returns the process' descriptor table as fd -> inode number.
+func (pr *Process) Descriptors() map[int]int {
+	pr.fs.mu.Lock()
+	defer pr.fs.mu.Unlock()
+	out := map[int]int{}
+	for fd, of := range pr.fds {
+		out[fd] = of.ino.Num
+	}
+	return out
+}
@@ -0,0 +1,296 @@
+package fssim
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var allocators = []string{"contiguous", "linked", "indexed", "fat"}
+
+func newFS(t *testing.T, alloc string, blocks int) *FS {
+	t.Helper()
+	a, err := NewAllocator(alloc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(a, blocks, 64)
+}
+
+func pattern(n int, seed byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = seed + byte(i%251)
+	}
+	return b
+}
+
+// write appends data to p.
+func write(t *testing.T, pr *Process, p string, data []byte) {
+	t.Helper()
+	fd, err := pr.Open(p, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close(fd)
+	st, _ := pr.fs.Stat(p)
+	pr.Seek(fd, st.Size)
+	if _, err := pr.Write(fd, data); err != nil {
+		t.Fatalf("write %v: %v", p, err)
+	}
+}
+
+func read(t *testing.T, pr *Process, p string) []byte {
+	t.Helper()
+	fd, err := pr.Open(p, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close(fd)
+	data, err := pr.Read(fd, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// accounted checks every block is free, data, metadata or reserved, exactly once.
+func accounted(t *testing.T, fs *FS) {
+	t.Helper()
+	u := fs.Usage()
+	if u.Free+u.DataBlocks+u.MetaBlocks != u.Blocks {
+		t.Fatalf("%v: %v free + %v data + %v meta != %v blocks", u.Allocator, u.Free, u.DataBlocks, u.MetaBlocks, u.Blocks)
+	}
+}
+
+func TestReadBack(t *testing.T) {
+	for _, alloc := range allocators {
+		t.Run(alloc, func(t *testing.T) {
+			fs := newFS(t, alloc, 256)
+			pr := fs.NewProcess()
+			a, b := pattern(1000, 1), pattern(700, 2)
+			// interleaved appends: a contiguous file has to move to keep growing
+			for i := 0; i < 1000; i += 100 {
+				write(t, pr, "/a", a[i:i+100])
+				if i < 700 {
+					write(t, pr, "/b", b[i:i+100])
+				}
+			}
+			if got := read(t, pr, "/a"); !bytes.Equal(got, a) {
+				t.Errorf("/a read back wrong (%v bytes)", len(got))
+			}
+			if got := read(t, pr, "/b"); !bytes.Equal(got, b) {
+				t.Errorf("/b read back wrong (%v bytes)", len(got))
+			}
+			st, _ := fs.Stat("/a")
+			if alloc == "contiguous" {
+				if st.Relocations == 0 {
+					t.Error("contiguous file grew past its neighbour without moving")
+				}
+				if countExtents(st.Blocks) != 1 {
+					t.Errorf("contiguous file in %v pieces", countExtents(st.Blocks))
+				}
+			}
+			accounted(t, fs)
+		})
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	for _, alloc := range allocators {
+		fs := newFS(t, alloc, 64)
+		pr := fs.NewProcess()
+		write(t, pr, "/f", pattern(200, 0))
+		fd, _ := pr.Open("/f", false, false)
+		pr.Seek(fd, 50)
+		pr.Write(fd, []byte("hello"))
+		pr.Close(fd)
+		want := pattern(200, 0)
+		copy(want[50:], "hello")
+		if got := read(t, pr, "/f"); !bytes.Equal(got, want) {
+			t.Errorf("%v: overwrite in the middle didn't stick", alloc)
+		}
+	}
+}
+
+func TestDeleteFreesEverything(t *testing.T) {
+	for _, alloc := range allocators {
+		fs := newFS(t, alloc, 256)
+		free := fs.Disk().FreeBlocks()
+		pr := fs.NewProcess()
+		for _, name := range []string{"/x", "/y", "/z"} {
+			write(t, pr, name, pattern(2000, 3))
+		}
+		for _, name := range []string{"/y", "/x", "/z"} {
+			if err := fs.Remove(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := fs.Disk().FreeBlocks(); got != free {
+			t.Errorf("%v: %v blocks free after deleting everything, want %v", alloc, got, free)
+		}
+		if ext := fs.Disk().FreeExtents(); len(ext) != 1 {
+			t.Errorf("%v: free space in %v holes with nothing allocated", alloc, len(ext))
+		}
+	}
+}
+
+func TestRemoveWhileOpen(t *testing.T) {
+	fs := newFS(t, "indexed", 64)
+	free := fs.Disk().FreeBlocks()
+	pr := fs.NewProcess()
+	write(t, pr, "/f", pattern(300, 4))
+	fd, _ := pr.Open("/f", false, false)
+	if err := fs.Remove("/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/f"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Stat after remove: %v", err)
+	}
+	// unlinked but open: the data is still there for the descriptor
+	if data, _ := pr.Read(fd, 1000); !bytes.Equal(data, pattern(300, 4)) {
+		t.Fatal("open file lost its data when it was removed")
+	}
+	if fs.Disk().FreeBlocks() == free {
+		t.Fatal("blocks freed while the file is still open")
+	}
+	pr.Close(fd)
+	if fs.Disk().FreeBlocks() != free {
+		t.Fatal("blocks not freed with the last close")
+	}
+}
+
+func TestDupSharesOffset(t *testing.T) {
+	fs := newFS(t, "fat", 64)
+	pr := fs.NewProcess()
+	write(t, pr, "/f", []byte("abcdef"))
+	fd, _ := pr.Open("/f", false, false)
+	fd2, err := pr.Dup(fd)
+	if err != nil || fd2 == fd {
+		t.Fatalf("Dup = %v, %v", fd2, err)
+	}
+	a, _ := pr.Read(fd, 2)
+	b, _ := pr.Read(fd2, 2)
+	if string(a) != "ab" || string(b) != "cd" {
+		t.Fatalf("reads %q %q, dup'd descriptors should share the offset", a, b)
+	}
+	if of := fs.OpenFiles(); len(of) != 1 || of[0].Refs != 2 || of[0].Offset != 4 {
+		t.Fatalf("open file table %+v", of)
+	}
+	pr.Close(fd)
+	if c, _ := pr.Read(fd2, 10); string(c) != "ef" {
+		t.Fatalf("read %q after closing the first descriptor", c)
+	}
+	pr.Close(fd2)
+	if len(fs.OpenFiles()) != 0 {
+		t.Fatal("open file entry outlived its last descriptor")
+	}
+	if _, err := pr.Read(fd2, 1); err != ErrBadFD {
+		t.Fatalf("read on a closed descriptor: %v", err)
+	}
+}
+
+func TestLowestDescriptor(t *testing.T) {
+	fs := newFS(t, "linked", 64)
+	pr := fs.NewProcess()
+	var fds []int
+	for _, name := range []string{"/a", "/b", "/c"} {
+		fd, _ := pr.Open(name, true, false)
+		fds = append(fds, fd)
+	}
+	pr.Close(fds[1])
+	if fd, _ := pr.Open("/d", true, false); fd != fds[1] {
+		t.Fatalf("got fd %v, want the lowest free one, %v", fd, fds[1])
+	}
+	if n := len(pr.Descriptors()); n != 3 {
+		t.Fatalf("%v descriptors, want 3", n)
+	}
+}
+
+func TestNoSpace(t *testing.T) {
+	for _, alloc := range allocators {
+		fs := newFS(t, alloc, 16)
+		pr := fs.NewProcess()
+		fd, _ := pr.Open("/big", true, false)
+		if _, err := pr.Write(fd, make([]byte, 64*17)); !errors.Is(err, ErrNoSpace) {
+			t.Errorf("%v: writing more than the disk: %v", alloc, err)
+		}
+		pr.Close(fd)
+		accounted(t, fs)
+	}
+}
+
+func TestDirectories(t *testing.T) {
+	fs := newFS(t, "indexed", 64)
+	pr := fs.NewProcess()
+	if err := fs.Mkdir("/d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/d"); !errors.Is(err, ErrExist) {
+		t.Errorf("second mkdir: %v", err)
+	}
+	write(t, pr, "/d/f", []byte("x"))
+	if err := fs.Mkdir("/d/f/g"); !errors.Is(err, ErrNotDir) {
+		t.Errorf("mkdir under a file: %v", err)
+	}
+	if _, err := pr.Open("/d", false, false); !errors.Is(err, ErrIsDir) {
+		t.Errorf("open of a directory: %v", err)
+	}
+	if _, err := pr.Open("/nope/f", true, false); !errors.Is(err, ErrNotExist) {
+		t.Errorf("create in a missing directory: %v", err)
+	}
+	if err := fs.Remove("/d"); !errors.Is(err, ErrNotEmpty) {
+		t.Errorf("remove of a full directory: %v", err)
+	}
+	ents, err := fs.ReadDir("/d")
+	if err != nil || len(ents) != 1 || ents[0].Name != "f" || ents[0].Size != 1 || ents[0].Dir {
+		t.Fatalf("ReadDir = %+v, %v", ents, err)
+	}
+	if err := fs.Remove("/d/f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/d"); err != nil {
+		t.Fatal(err)
+	}
+	if ents, _ := fs.ReadDir("/"); len(ents) != 0 {
+		t.Fatalf("root still lists %+v", ents)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ops := GenerateTrace(1, 2000, 20, 300)
+	for _, alloc := range allocators {
+		fs := newFS(t, alloc, 512)
+		res := fs.Replay(ops)
+		if res.Ops != len(ops) || res.Errors != 0 {
+			t.Errorf("%v: %+v", alloc, res)
+		}
+		accounted(t, fs)
+		u := fs.Usage()
+		if alloc == "contiguous" && u.FileFragments > 1 {
+			t.Errorf("contiguous files in %v pieces on average", u.FileFragments)
+		}
+		if alloc == "fat" && u.MetaBlocks != 512*pointerSize/64 {
+			t.Errorf("fat reserves %v blocks, want %v", u.MetaBlocks, 512*pointerSize/64)
+		}
+	}
+}
+
+func TestParseTrace(t *testing.T) {
+	ops, err := ParseTrace(strings.NewReader("# a trace\ncreate a\n\nappend a 10\ndelete a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 3 || ops[1] != (Op{"append", "a", 10}) || ops[2].String() != "delete a" {
+		t.Fatalf("ops %v", ops)
+	}
+	for _, bad := range []string{"append a", "append a -1", "append a x", "rename a b", "create"} {
+		if _, err := ParseTrace(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseTrace(%q) succeeded", bad)
+		}
+	}
+	if _, err := NewAllocator("buddy"); err == nil {
+		t.Error("NewAllocator accepted an unknown allocator")
+	}
+}
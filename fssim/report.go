@@ -0,0 +1,162 @@
+package fssim
+
+import "fmt"
+
+// Usage is what df reports, plus the fragmentation numbers.
+type Usage struct {
+	Allocator  string
+	Blocks     int
+	BlockSize  int
+	Free       int
+	DataBlocks int
+	// MetaBlocks are blocks holding file system bookkeeping (index blocks).
+	MetaBlocks int
+	Files      int
+	FileBytes  int
+
+	// FreeExtents is the number of holes the free space is split into and
+	// LargestFree the biggest one, in blocks.
+	FreeExtents int
+	LargestFree int
+	// ExternalFrag is 1 - largest hole / free space: 0 when all free space is
+	// one run, close to 1 when it's shattered into single blocks.
+	ExternalFrag float64
+	// InternalFrag is the bytes allocated to files but not used by them (the
+	// unused tail of last blocks, plus per block pointers for linked allocation).
+	InternalFrag int
+	// FileFragments is the average number of separate extents per file, 1 means
+	// every file is contiguous.
+	FileFragments float64
+
+	// Seeks and SeekDistance estimate the cost of reading every file once from
+	// start to end: a seek for every jump to a non adjacent block (index blocks
+	// included) and the total distance of those jumps in blocks.
+	Seeks        int
+	SeekDistance int
+}
+
+// Usage computes the current space usage.
+func (fs *FS) Usage() Usage {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	u := Usage{
+		Allocator: fs.alloc.Name(),
+		Blocks:    fs.disk.NumBlocks(),
+		BlockSize: fs.disk.BlockSize,
+		Free:      fs.disk.FreeBlocks(),
+	}
+	if f, ok := fs.alloc.(formatter); ok {
+		u.MetaBlocks += f.Reserved()
+	}
+	extents := 0
+	for _, ino := range fs.inodes {
+		if ino.Dir {
+			continue
+		}
+		u.Files++
+		u.FileBytes += ino.Size
+		u.DataBlocks += len(ino.Blocks)
+		u.MetaBlocks += len(ino.Index)
+		u.InternalFrag += len(ino.Blocks)*fs.disk.BlockSize - ino.Size
+		extents += countExtents(ino.Blocks)
+		seeks, dist := seekCost(readPath(ino, fs.disk.BlockSize/pointerSize))
+		u.Seeks += seeks
+		u.SeekDistance += dist
+	}
+	if u.Files > 0 {
+		u.FileFragments = float64(extents) / float64(u.Files)
+	}
+	free := fs.disk.FreeExtents()
+	u.FreeExtents = len(free)
+	for _, e := range free {
+		u.LargestFree = max(u.LargestFree, e.Len)
+	}
+	if u.Free > 0 {
+		u.ExternalFrag = 1 - float64(u.LargestFree)/float64(u.Free)
+	}
+	return u
+}
+
+func countExtents(blocks []int) int {
+	if len(blocks) == 0 {
+		return 0
+	}
+	n := 1
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i] != blocks[i-1]+1 {
+			n++
+		}
+	}
+	return n
+}
+
+// readPath is the order in which blocks are visited to read the file sequentially.
+// For indexed files every index block is read right before the data it points to.
+func readPath(ino *Inode, perIndex int) []int {
+	if len(ino.Index) == 0 {
+		return ino.Blocks
+	}
+	path := make([]int, 0, len(ino.Blocks)+len(ino.Index))
+	for i, b := range ino.Blocks {
+		if i%perIndex == 0 {
+			path = append(path, ino.Index[i/perIndex])
+		}
+		path = append(path, b)
+	}
+	return path
+}
+
+// seekCost counts the jumps along a path of blocks, the first block always costs a seek.
+func seekCost(path []int) (seeks, distance int) {
+	for i, b := range path {
+		if i == 0 {
+			seeks++
+			continue
+		}
+		if d := b - path[i-1]; d != 1 {
+			seeks++
+			distance += max(d, -d)
+		}
+	}
+	return seeks, distance
+}
+
+func (u Usage) String() string {
+	used := u.Blocks - u.Free
+	return fmt.Sprintf(
+		"allocator %v: %v blocks of %vB, %v used (%v data, %v meta), %v free\n"+
+			"files %v, %vB of file data\n"+
+			"free space in %v holes, largest %v blocks, external fragmentation %.0f%%\n"+
+			"internal fragmentation %vB, %.2f extents per file\n"+
+			"sequential read of every file: %v seeks, %v blocks of seek distance",
+		u.Allocator, u.Blocks, u.BlockSize, used, u.DataBlocks, u.MetaBlocks, u.Free,
+		u.Files, u.FileBytes,
+		u.FreeExtents, u.LargestFree, 100*u.ExternalFrag,
+		u.InternalFrag, u.FileFragments,
+		u.Seeks, u.SeekDistance)
+}
+
+// BlockMap renders the disk one character per block: '.' free, '#' metadata,
+// otherwise a letter per file so fragmented files are easy to spot.
+func (fs *FS) BlockMap() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	owner := map[int]byte{}
+	for _, ino := range fs.inodes {
+		c := byte('a' + (ino.Num-2)%26)
+		for _, b := range ino.Blocks {
+			owner[b] = c
+		}
+		for _, b := range ino.Index {
+			owner[b] = '#'
+		}
+	}
+	return fs.disk.Map(func(b int) byte {
+		if c, ok := owner[b]; ok {
+			return c
+		}
+		// reserved by the allocator, e.g. the FAT itself
+		return '#'
+	})
+}
@@ -0,0 +1,128 @@
+package fssim
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Op is one step of a file system trace.
+type Op struct {
+	Kind  string // create, append or delete
+	Name  string
+	Bytes int
+}
+
+func (o Op) String() string {
+	if o.Kind == "append" {
+		return fmt.Sprintf("append %v %v", o.Name, o.Bytes)
+	}
+	return o.Kind + " " + o.Name
+}
+
+// ParseTrace reads a trace with one op per line:
+//
+//	create <name>
+//	append <name> <bytes>
+//	delete <name>
+//
+// Blank lines and lines starting with # are skipped.
+func ParseTrace(r io.Reader) ([]Op, error) {
+	var ops []Op
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		f := strings.Fields(s.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		op := Op{Kind: f[0]}
+		switch {
+		case (op.Kind == "create" || op.Kind == "delete") && len(f) == 2:
+			op.Name = f[1]
+		case op.Kind == "append" && len(f) == 3:
+			n, err := strconv.Atoi(f[2])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("trace line %v: bad size %q", line, f[2])
+			}
+			op.Name, op.Bytes = f[1], n
+		default:
+			return nil, fmt.Errorf("trace line %v: can't parse %q", line, s.Text())
+		}
+		ops = append(ops, op)
+	}
+	return ops, s.Err()
+}
+
+// GenerateTrace makes a random trace of n ops over at most files live files. Files
+// grow by appends of up to maxAppend bytes and get deleted now and then, which is
+// what breaks free space into holes.
+func GenerateTrace(seed int64, n, files, maxAppend int) []Op {
+	rng := rand.New(rand.NewSource(seed))
+	var live []string
+	next := 0
+	ops := make([]Op, 0, n)
+	for len(ops) < n {
+		r := rng.Float64()
+		switch {
+		case len(live) == 0 || r < 0.2 && len(live) < files:
+			name := fmt.Sprintf("f%d", next)
+			next++
+			live = append(live, name)
+			ops = append(ops, Op{Kind: "create", Name: name})
+		case r < 0.85:
+			ops = append(ops, Op{Kind: "append", Name: live[rng.Intn(len(live))], Bytes: 1 + rng.Intn(maxAppend)})
+		default:
+			i := rng.Intn(len(live))
+			ops = append(ops, Op{Kind: "delete", Name: live[i]})
+			live = append(live[:i], live[i+1:]...)
+		}
+	}
+	return ops
+}
+
+// ReplayResult counts how a trace went.
+type ReplayResult struct {
+	Ops int
+	// NoSpace counts appends that failed for lack of (suitable) free space.
+	NoSpace int
+	Errors  int
+}
+
+// Replay runs a trace against the file system, all files live in the root directory.
+func (fs *FS) Replay(ops []Op) ReplayResult {
+	var res ReplayResult
+	proc := fs.NewProcess()
+	for _, op := range ops {
+		res.Ops++
+		var err error
+		path := "/" + op.Name
+		switch op.Kind {
+		case "create":
+			var fd int
+			if fd, err = proc.Open(path, true, true); err == nil {
+				proc.Close(fd)
+			}
+		case "append":
+			var fd int
+			if fd, err = proc.Open(path, true, false); err == nil {
+				st, _ := fs.Stat(path)
+				proc.Seek(fd, st.Size)
+				_, err = proc.Write(fd, make([]byte, op.Bytes))
+				proc.Close(fd)
+			}
+		case "delete":
+			err = fs.Remove(path)
+		}
+		switch {
+		case errors.Is(err, ErrNoSpace):
+			res.NoSpace++
+		case err != nil:
+			res.Errors++
+		}
+	}
+	return res
+}
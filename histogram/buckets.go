@@ -0,0 +1,38 @@
+package histogram
+
+import "math/bits"
+
+// Values are bucketed HDR style: by their power of two and then linearly inside it,
+// so the precision is relative to the value and the memory is fixed no matter how
+// many values are recorded. 16 sub buckets keep the relative error under ~6%.
+const (
+	subBucketBits = 4
+	subBuckets    = 1 << subBucketBits
+	numBuckets    = (64 - subBucketBits + 1) * subBuckets
+)
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return (shift+1)*subBuckets + int(v>>shift) - subBuckets
+}
+
+// lowerBound is the smallest value that lands in bucket i.
+func lowerBound(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := i/subBuckets - 1
+	return int64(i%subBuckets+subBuckets) << shift
+}
+
+// upperBound is the largest value that lands in bucket i.
+func upperBound(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := i/subBuckets - 1
+	return lowerBound(i) + (int64(1) << shift) - 1
+}
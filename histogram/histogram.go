@@ -0,0 +1,91 @@
+// Package histogram records latencies from many goroutines and reports percentiles.
+//
+// Recording is kept cheap by giving every goroutine its own Recorder: values go into
+// a small local buffer guarded by the recorder's own (practically uncontended) mutex
+// and are only merged into the shared buckets when the buffer fills up or somebody
+// asks for a Snapshot.
+package histogram
+
+import (
+	"sync"
+	"time"
+)
+
+const bufferSize = 256
+
+// Histogram is the shared side, it owns the merged buckets and knows its recorders.
+type Histogram struct {
+	mu        sync.Mutex
+	merged    Snapshot
+	recorders []*Recorder
+}
+
+// New returns an empty histogram.
+func New() *Histogram {
+	return &Histogram{merged: newSnapshot()}
+}
+
+// Recorder is a per goroutine buffer feeding a Histogram. A recorder must only be
+// used by one goroutine at a time, get one per goroutine with NewRecorder.
+type Recorder struct {
+	h   *Histogram
+	mu  sync.Mutex
+	buf []int64
+}
+
+// NewRecorder returns a new recorder attached to h.
+func (h *Histogram) NewRecorder() *Recorder {
+	r := &Recorder{h: h, buf: make([]int64, 0, bufferSize)}
+	h.mu.Lock()
+	h.recorders = append(h.recorders, r)
+	h.mu.Unlock()
+	return r
+}
+
+// Record adds one observation to the recorder's buffer.
+func (r *Recorder) Record(d time.Duration) {
+	r.mu.Lock()
+	r.buf = append(r.buf, int64(d))
+	if len(r.buf) == cap(r.buf) {
+		r.h.mu.Lock()
+		r.flushLocked()
+		r.h.mu.Unlock()
+	}
+	r.mu.Unlock()
+}
+
+// flushLocked moves the buffer into the shared buckets, both locks must be held.
+func (r *Recorder) flushLocked() {
+	for _, v := range r.buf {
+		r.h.merged.add(v)
+	}
+	r.buf = r.buf[:0]
+}
+
+// Record adds one observation straight to the shared buckets. Fine for the odd
+// value, hot paths should use a Recorder.
+func (h *Histogram) Record(d time.Duration) {
+	h.mu.Lock()
+	h.merged.add(int64(d))
+	h.mu.Unlock()
+}
+
+// Snapshot flushes every recorder and returns a copy of the merged data.
+func (h *Histogram) Snapshot() *Snapshot {
+	h.mu.Lock()
+	recorders := append([]*Recorder(nil), h.recorders...)
+	h.mu.Unlock()
+
+	// recorder locks are always taken before the histogram lock, same as Record
+	for _, r := range recorders {
+		r.mu.Lock()
+		h.mu.Lock()
+		r.flushLocked()
+		h.mu.Unlock()
+		r.mu.Unlock()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.merged.Clone()
+}
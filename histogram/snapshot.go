@@ -0,0 +1,154 @@
+package histogram
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Snapshot is a point in time copy of a histogram, safe to read and share. The
+// zero value is an empty snapshot to Merge others into. Copying a Snapshot by value
+// shares its buckets, Merge into one copy changes the other, use Clone instead.
+type Snapshot struct {
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func newSnapshot() Snapshot {
+	return Snapshot{counts: make([]int64, numBuckets), min: math.MaxInt64}
+}
+
+func (s *Snapshot) add(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	s.counts[bucketOf(v)]++
+	s.count++
+	s.sum += v
+	s.min = min(s.min, v)
+	s.max = max(s.max, v)
+}
+
+// Clone returns a copy of s that shares nothing with it.
+func (s *Snapshot) Clone() *Snapshot {
+	c := *s
+	c.counts = append([]int64(nil), s.counts...)
+	return &c
+}
+
+// Merge adds the data of o to s, e.g. to combine the histograms of several runs.
+func (s *Snapshot) Merge(o *Snapshot) {
+	if o.count == 0 {
+		return
+	}
+	if s.counts == nil {
+		s.counts = make([]int64, numBuckets)
+	}
+	if s.count == 0 {
+		s.min = o.min
+	}
+	for i, c := range o.counts {
+		s.counts[i] += c
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.min = min(s.min, o.min)
+	s.max = max(s.max, o.max)
+}
+
+// Count is the number of recorded values.
+func (s *Snapshot) Count() int64 { return s.count }
+
+// Min is the smallest recorded value.
+func (s *Snapshot) Min() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	return time.Duration(s.min)
+}
+
+// Max is the largest recorded value.
+func (s *Snapshot) Max() time.Duration { return time.Duration(s.max) }
+
+// Mean is the average of the recorded values.
+func (s *Snapshot) Mean() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	return time.Duration(s.sum / s.count)
+}
+
+// Percentile returns the value below which p percent of the observations fall,
+// accurate to the bucket the value lands in.
+func (s *Snapshot) Percentile(p float64) time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+	if p >= 100 {
+		return s.Max()
+	}
+	rank := max(int64(math.Ceil(p/100*float64(s.count))), 1)
+	var seen int64
+	for i, c := range s.counts {
+		seen += c
+		if seen >= rank {
+			// clamp, the bucket bounds can lie outside the real min/max
+			return time.Duration(min(max(lowerBound(i), s.min), s.max))
+		}
+	}
+	return s.Max()
+}
+
+func (s *Snapshot) String() string {
+	return fmt.Sprintf("n=%v min=%v p50=%v p90=%v p99=%v p99.9=%v max=%v",
+		s.Count(), s.Min(), s.Percentile(50), s.Percentile(90), s.Percentile(99), s.Percentile(99.9), s.Max())
+}
+
+// Percentiles reported by WriteText.
+var Percentiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+// WriteText writes a human readable summary with a percentile table.
+func (s *Snapshot) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "count %v  min %v  mean %v  max %v\n", s.Count(), s.Min(), s.Mean(), s.Max()); err != nil {
+		return err
+	}
+	for _, p := range Percentiles {
+		if _, err := fmt.Fprintf(w, "  p%-5v %v\n", p, s.Percentile(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes one row per non empty bucket: lower and upper bound in
+// nanoseconds, count and cumulative fraction.
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"low_ns", "high_ns", "count", "cumulative"}); err != nil {
+		return err
+	}
+	var seen int64
+	for i, c := range s.counts {
+		if c == 0 {
+			continue
+		}
+		seen += c
+		err := cw.Write([]string{
+			strconv.FormatInt(lowerBound(i), 10),
+			strconv.FormatInt(upperBound(i), 10),
+			strconv.FormatInt(c, 10),
+			strconv.FormatFloat(float64(seen)/float64(s.count), 'f', 6, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,34 @@
+package histogram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMergeIntoZeroSnapshot(t *testing.T) {
+	h := New()
+	h.Record(3 * time.Millisecond)
+	h.Record(5 * time.Millisecond)
+
+	var total Snapshot
+	total.Merge(h.Snapshot())
+	total.Merge(&Snapshot{}) // an empty one changes nothing
+	total.Merge(h.Snapshot())
+	if total.Count() != 4 || total.Min() != 3*time.Millisecond || total.Max() != 5*time.Millisecond {
+		t.Fatalf("merged: %v", &total)
+	}
+}
+
+func TestCloneDoesNotShareBuckets(t *testing.T) {
+	h := New()
+	h.Record(time.Millisecond)
+	a := h.Snapshot()
+	b := a.Clone()
+	b.Merge(a)
+	if a.Count() != 1 || a.Percentile(100) != time.Millisecond {
+		t.Fatalf("merging into the clone changed the original: %v", a)
+	}
+	if b.Count() != 2 {
+		t.Fatalf("clone after merge: %v", b)
+	}
+}
@@ -0,0 +1,260 @@
+// Package httpmw has middleware that keeps an HTTP server from drowning under
+// overload.
+//
+// Without limits every request gets a goroutine and they all compete for the same
+// CPU, memory and backend, so under overload everybody gets slow and most clients
+// time out anyway. The limiter admits a fixed number of requests at a time, lets a
+// bounded number wait for a slot and turns everything else away immediately with a
+// 503: the requests it does serve stay fast, and the ones it rejects find out at
+// once instead of after a timeout. The rate limiter keeps a single client from
+// taking all of that capacity for itself.
+package httpmw
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies mws to h, the first one ends up outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// LimiterStats counts the limiter's decisions.
+type LimiterStats struct {
+	InFlight int64
+	Queued   int64
+	Served   int64
+	// Rejected found the queue full, QueueTimeout waited too long for a slot,
+	// Canceled gave up (client went away) while queued.
+	Rejected     int64
+	QueueTimeout int64
+	Canceled     int64
+	MaxQueued    int64
+}
+
+func (s LimiterStats) String() string {
+	return fmt.Sprintf("served=%v rejected=%v queue timeouts=%v canceled=%v max queued=%v",
+		s.Served, s.Rejected, s.QueueTimeout, s.Canceled, s.MaxQueued)
+}
+
+// Limiter caps the requests being handled at once, with a bounded wait queue.
+type Limiter struct {
+	slots     chan struct{} // a buffered channel as a counting semaphore
+	maxQueue  int64
+	queueWait time.Duration
+
+	inFlight, queued, maxQueued              atomic.Int64
+	served, rejected, queueTimeout, canceled atomic.Int64
+}
+
+// NewLimiter admits concurrency requests at a time and lets up to queue more wait at
+// most wait for a slot (zero waits as long as the request's context allows).
+func NewLimiter(concurrency, queue int, wait time.Duration) *Limiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Limiter{slots: make(chan struct{}, concurrency), maxQueue: int64(max(queue, 0)), queueWait: wait}
+}
+
+// Handler wraps next with the limit.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(w, r) {
+			return
+		}
+		l.inFlight.Add(1)
+		defer func() {
+			l.inFlight.Add(-1)
+			<-l.slots
+		}()
+		l.served.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, or writes the rejection and reports false.
+func (l *Limiter) acquire(w http.ResponseWriter, r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	// no free slot, join the queue if there's room in it
+	n := l.queued.Add(1)
+	defer l.queued.Add(-1)
+	if n > l.maxQueue {
+		l.rejected.Add(1)
+		reject(w, "server busy")
+		return false
+	}
+	for {
+		m := l.maxQueued.Load()
+		if n <= m || l.maxQueued.CompareAndSwap(m, n) {
+			break
+		}
+	}
+
+	ctx := r.Context()
+	if l.queueWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.queueWait)
+		defer cancel()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		if r.Context().Err() != nil {
+			// the client is gone, nobody to answer
+			l.canceled.Add(1)
+			return false
+		}
+		l.queueTimeout.Add(1)
+		reject(w, "timed out waiting for a slot")
+		return false
+	}
+}
+
+func reject(w http.ResponseWriter, msg string) {
+	// tell well behaved clients to back off instead of retrying right away
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
+
+// Stats returns the counters so far.
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		InFlight:     l.inFlight.Load(),
+		Queued:       l.queued.Load(),
+		Served:       l.served.Load(),
+		Rejected:     l.rejected.Load(),
+		QueueTimeout: l.queueTimeout.Load(),
+		Canceled:     l.canceled.Load(),
+		MaxQueued:    l.maxQueued.Load(),
+	}
+}
+
+// Timeout gives every request a deadline of d. Handlers see it through the request
+// context and should stop when it's done, a handler that ignores it still has its
+// response replaced with a 503 once d is up.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, "request timed out")
+	}
+}
+
+// RateStats counts the rate limiter's decisions.
+type RateStats struct {
+	Allowed int64
+	Limited int64
+	Clients int // clients with a bucket right now
+}
+
+func (s RateStats) String() string {
+	return fmt.Sprintf("allowed=%v limited=%v clients=%v", s.Allowed, s.Limited, s.Clients)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter lets every client make rate requests per second, with bursts of up
+// to burst, and answers the requests over that with a 429. Where the Limiter
+// protects the server from everybody at once, this keeps one greedy client from
+// using up the Limiter's slots and queue on its own.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	key   func(*http.Request) string
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+
+	allowed, limited atomic.Int64
+}
+
+// NewRateLimiter tells clients apart by key, nil means by the remote address
+// without the port.
+func NewRateLimiter(rate float64, burst int, key func(*http.Request) string) *RateLimiter {
+	if key == nil {
+		key = func(r *http.Request) string {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				return r.RemoteAddr
+			}
+			return host
+		}
+	}
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(max(burst, 1)),
+		key:       key,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket, false if it's empty.
+func (l *RateLimiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > time.Minute {
+		// a full bucket is the same as no bucket, forget clients that went quiet
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		l.limited.Add(1)
+		return false
+	}
+	b.tokens--
+	l.allowed.Add(1)
+	return true
+}
+
+// Handler wraps next with the rate limit.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(l.key(r)) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats returns the counters so far.
+func (l *RateLimiter) Stats() RateStats {
+	l.mu.Lock()
+	clients := len(l.buckets)
+	l.mu.Unlock()
+	return RateStats{Allowed: l.allowed.Load(), Limited: l.limited.Load(), Clients: clients}
+}
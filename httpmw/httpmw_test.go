@@ -0,0 +1,194 @@
+package httpmw
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blocking is a handler that holds its slot until release is closed.
+func blocking(release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("ok"))
+	})
+}
+
+// serve runs h on a fresh request in the background and delivers the response.
+func serve(ctx context.Context, h http.Handler) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		done <- rec
+	}()
+	return done
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterQueuesThenRejects(t *testing.T) {
+	l := NewLimiter(1, 1, 0)
+	release := make(chan struct{})
+	h := l.Handler(blocking(release))
+	first := serve(context.Background(), h)
+	waitFor(t, "the first request to run", func() bool { return l.Stats().InFlight == 1 })
+	second := serve(context.Background(), h)
+	waitFor(t, "the second request to queue", func() bool { return l.Stats().Queued == 1 })
+
+	// slot taken, queue full: turned away at once
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third request: %v %q, want a 503 with Retry-After", rec.Code, rec.Body.String())
+	}
+
+	close(release)
+	for _, done := range []<-chan *httptest.ResponseRecorder{first, second} {
+		if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("queued request: %v %q", rec.Code, rec.Body.String())
+		}
+	}
+	st := l.Stats()
+	if st.Served != 2 || st.Rejected != 1 || st.MaxQueued != 1 || st.InFlight != 0 || st.Queued != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	l := NewLimiter(1, 5, 10*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	h := l.Handler(blocking(release))
+	serve(context.Background(), h)
+	waitFor(t, "the first request to run", func() bool { return l.Stats().InFlight == 1 })
+	rec := <-serve(context.Background(), h)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "timed out") {
+		t.Fatalf("queued past the wait: %v %q", rec.Code, rec.Body.String())
+	}
+	if st := l.Stats(); st.QueueTimeout != 1 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestLimiterClientGone(t *testing.T) {
+	l := NewLimiter(1, 5, 0)
+	release := make(chan struct{})
+	defer close(release)
+	h := l.Handler(blocking(release))
+	serve(context.Background(), h)
+	waitFor(t, "the first request to run", func() bool { return l.Stats().InFlight == 1 })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := serve(ctx, h)
+	waitFor(t, "the second request to queue", func() bool { return l.Stats().Queued == 1 })
+	cancel()
+	// nobody left to answer: nothing written
+	if rec := <-done; rec.Body.Len() != 0 {
+		t.Errorf("answered a client that went away: %v %q", rec.Code, rec.Body.String())
+	}
+	if st := l.Stats(); st.Canceled != 1 || st.QueueTimeout != 0 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(0.001, 3, nil)
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("request %v of a burst of 3 limited", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Fatal("fourth request of a burst of 3 allowed")
+	}
+	if !l.Allow("b") {
+		t.Fatal("b limited by a's requests")
+	}
+	st := l.Stats()
+	if st.Allowed != 4 || st.Limited != 1 || st.Clients != 2 {
+		t.Errorf("stats %+v", st)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := NewRateLimiter(1000, 1, nil)
+	l.Allow("a")
+	time.Sleep(5 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Fatal("bucket didn't refill at 1000/s")
+	}
+}
+
+func TestRateLimiterForgetsQuietClients(t *testing.T) {
+	l := NewRateLimiter(1000, 2, nil)
+	l.Allow("quiet")
+	time.Sleep(5 * time.Millisecond)
+	l.mu.Lock()
+	l.lastSweep = time.Now().Add(-2 * time.Minute)
+	l.mu.Unlock()
+	l.Allow("new")
+	// quiet's bucket refilled and was dropped, new's was just taken from
+	if st := l.Stats(); st.Clients != 1 {
+		t.Fatalf("%v buckets after a sweep, want 1", st.Clients)
+	}
+}
+
+func TestRateLimiterHandler(t *testing.T) {
+	l := NewRateLimiter(0.001, 1, nil)
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := []int{}
+	for _, addr := range []string{"10.0.0.1:1000", "10.0.0.1:2000", "10.0.0.2:1000"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	// the port doesn't make another client
+	want := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("codes %v, want %v", codes, want)
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "h") }), mw("a"), mw("b"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if strings.Join(order, " ") != "a b h" {
+		t.Fatalf("order %v, want the first middleware outermost", order)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("slow handler answered %v, want 503", rec.Code)
+	}
+}
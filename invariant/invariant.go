@@ -0,0 +1,191 @@
+// Package invariant checks a program's invariants while it runs.
+//
+// A demo registers predicates over its state ("the total balance is constant",
+// "nobody holds fork 3 twice") and a checker goroutine evaluates all of them every
+// so often. Predicates look at state other goroutines are changing, so they're
+// evaluated at a safe point: the checker stops the world (checkpoint.World), runs
+// them with every participant parked and lets the world go again. A predicate can
+// therefore read the state without taking its locks, which matters because some
+// of those locks may be held by goroutines that are stopped (or deadlocked).
+//
+// The first violation fails fast: the checker writes what went wrong and a dump of
+// the state, taken in the same stop, and calls OnViolation, which exits the
+// process by default.
+package invariant
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/checkpoint"
+)
+
+// Violation is a predicate that didn't hold.
+type Violation struct {
+	Name  string
+	Err   error
+	At    time.Time
+	Check int // number of the check that found it
+	Dump  string
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("invariant %q violated: %v", v.Name, v.Err)
+}
+
+// Config sets a checker up.
+type Config struct {
+	// World is stopped around every check. Without one the predicates run
+	// concurrently with everything else and have to synchronize themselves.
+	World *checkpoint.World
+	Every time.Duration // 10ms by default
+	// Output gets the violation report, os.Stderr by default.
+	Output io.Writer
+	// OnViolation is called after the report is written, the default exits with
+	// status 3. It's called once, the checker stops afterwards.
+	OnViolation func(*Violation)
+}
+
+type predicate struct {
+	name string
+	fn   func() error
+}
+
+// Checker evaluates registered predicates periodically.
+type Checker struct {
+	cfg Config
+
+	mu     sync.Mutex
+	preds  []predicate
+	dumps  []func(io.Writer)
+	checks int
+	failed *Violation
+
+	stop, stopped chan struct{}
+}
+
+// New returns a checker, call Start to have it run.
+func New(cfg Config) *Checker {
+	if cfg.Every <= 0 {
+		cfg.Every = 10 * time.Millisecond
+	}
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	if cfg.OnViolation == nil {
+		cfg.OnViolation = func(*Violation) { os.Exit(3) }
+	}
+	return &Checker{cfg: cfg}
+}
+
+// Register adds a predicate. fn returns nil when the invariant holds and an error
+// describing what's wrong otherwise.
+func (c *Checker) Register(name string, fn func() error) {
+	c.mu.Lock()
+	c.preds = append(c.preds, predicate{name, fn})
+	c.mu.Unlock()
+}
+
+// Dump adds a function that writes (part of) the state for violation reports.
+func (c *Checker) Dump(fn func(io.Writer)) {
+	c.mu.Lock()
+	c.dumps = append(c.dumps, fn)
+	c.mu.Unlock()
+}
+
+// That returns nil if cond holds and a formatted error otherwise, it makes short
+// predicates read like assertions.
+func That(cond bool, format string, args ...any) error {
+	if cond {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// Check evaluates every predicate once, at a safe point, and returns the first
+// violation. It doesn't call OnViolation.
+func (c *Checker) Check() *Violation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks++
+	var v *Violation
+	eval := func() {
+		for _, p := range c.preds {
+			if err := p.fn(); err != nil {
+				v = &Violation{Name: p.name, Err: err, At: time.Now(), Check: c.checks}
+				break
+			}
+		}
+		if v != nil {
+			var buf bytes.Buffer
+			for _, d := range c.dumps {
+				d(&buf)
+			}
+			v.Dump = buf.String()
+		}
+	}
+	if c.cfg.World != nil {
+		c.cfg.World.Stop(eval)
+	} else {
+		eval()
+	}
+	return v
+}
+
+// Checks returns how many checks have run.
+func (c *Checker) Checks() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checks
+}
+
+// Start runs checks every Config.Every in a goroutine until Stop.
+func (c *Checker) Start() {
+	c.stop, c.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(c.stopped)
+		t := time.NewTicker(c.cfg.Every)
+		defer t.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-t.C:
+			}
+			if v := c.Check(); v != nil {
+				c.report(v)
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) report(v *Violation) {
+	c.mu.Lock()
+	c.failed = v
+	c.mu.Unlock()
+	fmt.Fprintf(c.cfg.Output, "%v (check %v)\n", v, v.Check)
+	if v.Dump != "" {
+		fmt.Fprintf(c.cfg.Output, "state at the time:\n%v", v.Dump)
+	}
+	c.cfg.OnViolation(v)
+}
+
+// Stop ends the checker goroutine and returns the violation it found, if any.
+func (c *Checker) Stop() error {
+	if c.stop == nil {
+		return nil
+	}
+	close(c.stop)
+	<-c.stopped
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed == nil {
+		return nil // not a nil *Violation in a non-nil error
+	}
+	return c.failed
+}
@@ -0,0 +1,131 @@
+package invariant
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/checkpoint"
+)
+
+func TestHoldsUnderWorld(t *testing.T) {
+	w := checkpoint.NewWorld()
+	// money moves between accounts without locks, the total only holds at safe points
+	accounts := []int{100, 100, 100, 100}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	w.Join()
+	go func() {
+		defer wg.Done()
+		defer w.Leave()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			accounts[i%4]--
+			accounts[(i+1)%4]++
+			w.SafePoint()
+		}
+	}()
+	var failed []*Violation
+	c := New(Config{World: w, Every: time.Millisecond, Output: io.Discard,
+		OnViolation: func(v *Violation) { failed = append(failed, v) }})
+	c.Register("total is 400", func() error {
+		sum := 0
+		for _, a := range accounts {
+			sum += a
+		}
+		return That(sum == 400, "total is %v", sum)
+	})
+	c.Start()
+	for c.Checks() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	err := c.Stop()
+	close(done)
+	wg.Wait()
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("invariant that holds at every safe point was violated: %v", err)
+	}
+}
+
+func TestViolation(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	n := 0
+	calls := 0
+	reported := make(chan *Violation, 10)
+	c := New(Config{Every: time.Millisecond, Output: &out, OnViolation: func(v *Violation) { reported <- v }})
+	c.Register("fine", func() error { return nil })
+	c.Register("n < 5", func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		n++
+		return That(n < 5, "n is %v", n)
+	})
+	c.Dump(func(w io.Writer) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "n=%v\n", n)
+	})
+	c.Start()
+	var v *Violation
+	select {
+	case v = <-reported:
+	case <-time.After(5 * time.Second):
+		t.Fatal("violation not reported")
+	}
+	err := c.Stop()
+	var got *Violation
+	if !errors.As(err, &got) || got != v {
+		t.Fatalf("Stop returned %v, want the reported violation", err)
+	}
+	if v.Name != "n < 5" || v.Check != 5 || v.Dump != "n=5\n" {
+		t.Errorf("violation %+v", v)
+	}
+	if s := out.String(); !strings.Contains(s, `invariant "n < 5" violated: n is 5 (check 5)`) || !strings.Contains(s, "n=5") {
+		t.Errorf("report:\n%v", s)
+	}
+	// the checker stops at the first violation
+	time.Sleep(10 * time.Millisecond)
+	if len(reported) != 0 || calls != 5 {
+		t.Errorf("checker went on after the violation: %v more reports, %v calls", len(reported), calls)
+	}
+}
+
+func TestCheckDoesntReport(t *testing.T) {
+	called := false
+	c := New(Config{Output: io.Discard, OnViolation: func(*Violation) { called = true }})
+	c.Register("never", func() error { return errors.New("no") })
+	if v := c.Check(); v == nil || v.Name != "never" {
+		t.Fatalf("Check = %v", v)
+	}
+	if called {
+		t.Error("Check called OnViolation")
+	}
+	if err := c.Stop(); err != nil {
+		t.Errorf("Stop without Start = %v", err)
+	}
+}
+
+func TestStopWithoutViolationIsNil(t *testing.T) {
+	c := New(Config{Every: time.Millisecond})
+	c.Register("ok", func() error { return nil })
+	c.Start()
+	for c.Checks() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	// a nil *Violation wrapped in the error interface would not be == nil
+	if err := c.Stop(); err != nil {
+		t.Fatalf("Stop = %v", err)
+	}
+}
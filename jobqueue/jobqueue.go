@@ -0,0 +1,344 @@
+// Package jobqueue is a job queue that survives crashes, persisted in a wal.WAL.
+//
+// Every state change is a log record: a job was enqueued, handed to a worker,
+// acknowledged or given back. Reopening the queue replays the log, so after a crash
+// every job that was enqueued and not acknowledged is pending again, including the
+// ones a worker was in the middle of. That makes delivery at-least-once: a worker that
+// finished a job but died before its Ack reached the log will see the job run again,
+// so jobs should be idempotent (or the side effect and the Ack made atomic).
+//
+// The log only grows while the queue is open. Open compacts it when most of it
+// describes finished jobs: the pending jobs are written to a new log which is then
+// renamed over the old one, rename being atomic, a crash during compaction leaves
+// either the old log or the new one, never a mix.
+package jobqueue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/neilharia7/operating-systems-with-go/wal"
+)
+
+// ErrClosed is returned once the queue is draining or closed.
+var ErrClosed = errors.New("jobqueue: closed")
+
+// Job is a unit of work. Attempts counts how many times it has been handed out,
+// including the current one, across restarts.
+type Job struct {
+	ID       uint64
+	Payload  []byte
+	Attempts int
+}
+
+// Recovery describes what Open found in the log.
+type Recovery struct {
+	wal.ReplayResult
+	Pending int
+	// Interrupted counts jobs that were handed out and never acked or nacked, work
+	// that was in progress when the previous process died.
+	Interrupted int
+	Compacted   bool
+}
+
+const (
+	opEnqueue byte = iota + 1
+	opStart
+	opAck
+	opNack
+)
+
+// Queue is an open job queue, safe for concurrent use.
+type Queue struct {
+	w *wal.WAL
+
+	mu       sync.Mutex
+	pending  []*Job // FIFO
+	inflight map[uint64]*Job
+	nextID   uint64
+	draining bool
+	// notify is closed (and replaced) whenever a job becomes available or the queue
+	// starts draining, waiting Dequeues and Drain select on it
+	notify chan struct{}
+}
+
+// Open opens the queue at path, creating it if needed, and recovers its state.
+func Open(path string, opts wal.Options) (*Queue, Recovery, error) {
+	var rec Recovery
+	jobs, order, maxID, err := load(path, &rec)
+	if err != nil {
+		return nil, rec, err
+	}
+
+	// compact when the log is mostly history, a few hundred records aren't worth it
+	if rec.Records > 256 && rec.Records > 4*len(order) {
+		if err := compact(path, order, jobs, maxID); err != nil {
+			return nil, rec, fmt.Errorf("jobqueue: compact: %w", err)
+		}
+		rec.Compacted = true
+	}
+
+	w, res, err := wal.Open(path, opts)
+	if err != nil {
+		return nil, rec, err
+	}
+	if !rec.Compacted {
+		rec.ReplayResult = res
+	}
+
+	q := &Queue{w: w, inflight: make(map[uint64]*Job), nextID: maxID, notify: make(chan struct{})}
+	for _, id := range order {
+		q.pending = append(q.pending, jobs[id])
+	}
+	rec.Pending = len(q.pending)
+	return q, rec, nil
+}
+
+// load replays the log into the set of unfinished jobs, in enqueue order, and the
+// highest id handed out so far.
+func load(path string, rec *Recovery) (map[uint64]*Job, []uint64, uint64, error) {
+	jobs := make(map[uint64]*Job)
+	started := make(map[uint64]bool)
+	var order []uint64
+	var maxID uint64
+	res, err := wal.Replay(path, func(seq uint64, data []byte) error {
+		op, id, payload, err := decode(data)
+		if err != nil {
+			return fmt.Errorf("jobqueue: record %v: %w", seq, err)
+		}
+		maxID = max(maxID, id)
+		switch op {
+		case opEnqueue:
+			jobs[id] = &Job{ID: id, Payload: payload}
+			order = append(order, id)
+		case opStart:
+			if j := jobs[id]; j != nil {
+				j.Attempts++
+				started[id] = true
+			}
+		case opAck:
+			delete(jobs, id)
+			delete(started, id)
+		case opNack:
+			delete(started, id)
+		}
+		return nil
+	})
+	rec.ReplayResult = res
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	live := order[:0]
+	for _, id := range order {
+		if jobs[id] != nil {
+			live = append(live, id)
+		}
+	}
+	rec.Interrupted = len(started)
+	return jobs, live, maxID, nil
+}
+
+func compact(path string, order []uint64, jobs map[uint64]*Job, maxID uint64) error {
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	w, _, err := wal.Open(tmp, wal.Options{Sync: wal.SyncNever})
+	if err != nil {
+		return err
+	}
+	write := func(data []byte) {
+		if err == nil {
+			_, err = w.Append(data)
+		}
+	}
+	for _, id := range order {
+		j := jobs[id]
+		write(encode(opEnqueue, id, j.Payload))
+		for i := 0; i < j.Attempts; i++ {
+			write(encode(opStart, id, nil))
+			write(encode(opNack, id, nil))
+		}
+	}
+	if len(order) == 0 || order[len(order)-1] != maxID {
+		// ids must keep growing after a restart, if the newest job is already done
+		// keep its id around as an enqueue+ack pair
+		write(encode(opEnqueue, maxID, nil))
+		write(encode(opAck, maxID, nil))
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// the new log has to be on disk before the rename makes it the log
+	if err := syncPath(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// and the rename itself only survives a crash once the directory is synced
+	return syncPath(filepath.Dir(path))
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func encode(op byte, id uint64, payload []byte) []byte {
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(payload))
+	buf = append(buf, op)
+	buf = binary.AppendUvarint(buf, id)
+	return append(buf, payload...)
+}
+
+func decode(data []byte) (op byte, id uint64, payload []byte, err error) {
+	if len(data) < 2 {
+		return 0, 0, nil, errors.New("short record")
+	}
+	id, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return 0, 0, nil, errors.New("bad job id")
+	}
+	return data[0], id, data[1+n:], nil
+}
+
+// Enqueue adds a job, it's durable (per the WAL's sync policy) once Enqueue returns.
+func (q *Queue) Enqueue(payload []byte) (uint64, error) {
+	q.mu.Lock()
+	if q.draining {
+		q.mu.Unlock()
+		return 0, ErrClosed
+	}
+	q.nextID++
+	id := q.nextID
+	q.mu.Unlock()
+
+	if _, err := q.w.Append(encode(opEnqueue, id, payload)); err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	q.pending = append(q.pending, &Job{ID: id, Payload: payload})
+	q.wake()
+	q.mu.Unlock()
+	return id, nil
+}
+
+// Dequeue waits for a job and hands it out. The job must be finished with Ack, or
+// given back with Nack. It returns ErrClosed once the queue is draining.
+func (q *Queue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		q.mu.Lock()
+		if q.draining {
+			q.mu.Unlock()
+			return Job{}, ErrClosed
+		}
+		if len(q.pending) > 0 {
+			j := q.pending[0]
+			q.pending = q.pending[1:]
+			j.Attempts++
+			q.inflight[j.ID] = j
+			q.mu.Unlock()
+			// logged after taking it off the queue: if this record is lost, replay
+			// simply sees a job that was never started
+			if _, err := q.w.Append(encode(opStart, j.ID, nil)); err != nil {
+				// not handed out after all, back to the head of the queue, or Drain
+				// would wait for it forever
+				q.mu.Lock()
+				delete(q.inflight, j.ID)
+				j.Attempts--
+				q.pending = append([]*Job{j}, q.pending...)
+				q.wake()
+				q.mu.Unlock()
+				return Job{}, err
+			}
+			return *j, nil
+		}
+		notify := q.notify
+		q.mu.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		}
+	}
+}
+
+// Ack marks a job as done, it won't be handed out again.
+func (q *Queue) Ack(id uint64) error {
+	return q.finish(id, opAck)
+}
+
+// Nack gives a job back, it goes to the end of the queue.
+func (q *Queue) Nack(id uint64) error {
+	return q.finish(id, opNack)
+}
+
+func (q *Queue) finish(id uint64, op byte) error {
+	q.mu.Lock()
+	j, ok := q.inflight[id]
+	q.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("jobqueue: job %v is not in flight", id)
+	}
+	if _, err := q.w.Append(encode(op, id, nil)); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	delete(q.inflight, id)
+	if op == opNack && !q.draining {
+		q.pending = append(q.pending, j)
+	}
+	q.wake()
+	q.mu.Unlock()
+	return nil
+}
+
+// wake releases everybody waiting on notify. q.mu must be held.
+func (q *Queue) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// Len returns the number of pending and in flight jobs.
+func (q *Queue) Len() (pending, inflight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending), len(q.inflight)
+}
+
+// Drain stops handing out jobs and waits until every in flight job has been acked or
+// nacked, or ctx is done. Pending jobs stay in the log for the next Open.
+func (q *Queue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.draining = true
+	q.wake()
+	for len(q.inflight) > 0 {
+		notify := q.notify
+		q.mu.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	q.mu.Unlock()
+	return nil
+}
+
+// Close drains the queue with no deadline and closes the log.
+func (q *Queue) Close() error {
+	q.Drain(context.Background())
+	return q.w.Close()
+}
@@ -0,0 +1,279 @@
+package jobqueue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/wal"
+)
+
+func open(t *testing.T, path string) (*Queue, Recovery) {
+	t.Helper()
+	q, rec, err := Open(path, wal.Options{Sync: wal.SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, rec
+}
+
+// crash stops the queue the way a killed process does: the log stays as it is, no
+// drain, in flight jobs are never acked.
+func crash(t *testing.T, q *Queue) {
+	t.Helper()
+	if err := q.w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func enqueue(t *testing.T, q *Queue, payloads ...string) []uint64 {
+	t.Helper()
+	var ids []uint64
+	for _, p := range payloads {
+		id, err := q.Enqueue([]byte(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func dequeue(t *testing.T, q *Queue) Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	j, err := q.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func expectJob(t *testing.T, j Job, id uint64, payload string, attempts int) {
+	t.Helper()
+	if j.ID != id || string(j.Payload) != payload || j.Attempts != attempts {
+		t.Fatalf("job %v %q attempt %v, expected %v %q attempt %v", j.ID, j.Payload, j.Attempts, id, payload, attempts)
+	}
+}
+
+func TestRedeliveryAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	ids := enqueue(t, q, "a", "b", "c", "d")
+	expectJob(t, dequeue(t, q), ids[0], "a", 1)
+	if err := q.Ack(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	expectJob(t, dequeue(t, q), ids[1], "b", 1)
+	// b is being worked on when the process dies
+	crash(t, q)
+
+	q, rec := open(t, path)
+	defer q.Close()
+	if rec.Pending != 3 || rec.Interrupted != 1 || rec.Compacted {
+		t.Fatalf("recovery %+v, expected b, c and d pending with b interrupted", rec)
+	}
+	expectJob(t, dequeue(t, q), ids[1], "b", 2)
+	expectJob(t, dequeue(t, q), ids[2], "c", 1)
+	expectJob(t, dequeue(t, q), ids[3], "d", 1)
+	if id := enqueue(t, q, "e")[0]; id <= ids[3] {
+		t.Errorf("new job got id %v after %v, ids went backwards", id, ids[3])
+	}
+	for _, id := range ids[1:] {
+		q.Ack(id)
+	}
+}
+
+func TestNackRedelivers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	ids := enqueue(t, q, "a", "b")
+	expectJob(t, dequeue(t, q), ids[0], "a", 1)
+	if err := q.Nack(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	// a goes to the back of the line
+	expectJob(t, dequeue(t, q), ids[1], "b", 1)
+	expectJob(t, dequeue(t, q), ids[0], "a", 2)
+	if err := q.Ack(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(ids[0]); err == nil {
+		t.Error("a job acked twice")
+	}
+	if err := q.Nack(99); err == nil {
+		t.Error("an unknown job nacked")
+	}
+	q.Nack(ids[1])
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the attempts survive a restart
+	q, rec := open(t, path)
+	defer q.Close()
+	if rec.Pending != 1 || rec.Interrupted != 0 {
+		t.Fatalf("recovery %+v", rec)
+	}
+	expectJob(t, dequeue(t, q), ids[1], "b", 2)
+	q.Ack(ids[1])
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	var payloads []string
+	for i := 0; i < 300; i++ {
+		payloads = append(payloads, fmt.Sprint("job ", i))
+	}
+	ids := enqueue(t, q, payloads...)
+	// everything but the last ten is done, job 290 is tried twice and job 299 is in
+	// flight when the process dies
+	for i := 0; i < 300; i++ {
+		j := dequeue(t, q)
+		switch {
+		case i < 290:
+			q.Ack(j.ID)
+		case i < 299:
+			q.Nack(j.ID)
+		}
+	}
+	j := dequeue(t, q)
+	expectJob(t, j, ids[290], "job 290", 2)
+	q.Nack(j.ID)
+	crash(t, q)
+	before, _ := os.Stat(path)
+
+	q, rec := open(t, path)
+	if !rec.Compacted || rec.Pending != 10 || rec.Interrupted != 1 {
+		t.Fatalf("recovery %+v, expected a compaction with 10 jobs pending", rec)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/4 {
+		t.Errorf("compacted log is %v bytes, was %v", after.Size(), before.Size())
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compaction left its temporary file: %v", err)
+	}
+	// same jobs, same order, attempts carried over
+	for i := 290; i < 300; i++ {
+		attempts := 2
+		if i == 290 {
+			attempts = 3
+		}
+		expectJob(t, dequeue(t, q), ids[i], payloads[i], attempts)
+	}
+	if id := enqueue(t, q, "new")[0]; id != 301 {
+		t.Errorf("first job after compaction got id %v, expected 301", id)
+	}
+	crash(t, q)
+
+	// a compacted log replays like any other
+	q, rec = open(t, path)
+	defer q.Close()
+	if rec.Compacted || rec.Pending != 11 || rec.Interrupted != 10 {
+		t.Fatalf("recovery after the compaction %+v", rec)
+	}
+}
+
+func TestCompactionKeepsIDsGrowing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	for i := 0; i < 300; i++ {
+		enqueue(t, q, "x")
+		q.Ack(dequeue(t, q).ID)
+	}
+	q.Close()
+	// a crash during an earlier compaction left this behind, it must not be used
+	os.WriteFile(path+".compact", []byte("half written"), 0o644)
+
+	q, rec := open(t, path)
+	defer q.Close()
+	if !rec.Compacted || rec.Pending != 0 {
+		t.Fatalf("recovery %+v", rec)
+	}
+	if id := enqueue(t, q, "y")[0]; id != 301 {
+		t.Fatalf("id %v after compacting 300 finished jobs, expected 301", id)
+	}
+	q.Ack(dequeue(t, q).ID)
+}
+
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	enqueue(t, q, "a", "b")
+	crash(t, q)
+	// the crash cut the last enqueue short
+	fi, _ := os.Stat(path)
+	if err := os.Truncate(path, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	q, rec := open(t, path)
+	defer q.Close()
+	if !rec.Torn || rec.Pending != 1 {
+		t.Fatalf("recovery %+v, expected only a", rec)
+	}
+	expectJob(t, dequeue(t, q), 1, "a", 1)
+	if id := enqueue(t, q, "c")[0]; id != 2 {
+		t.Errorf("id %v, the torn job's id was never acknowledged and can be reused", id)
+	}
+	q.Ack(1)
+	q.Ack(dequeue(t, q).ID)
+}
+
+func TestDrain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs")
+	q, _ := open(t, path)
+	ids := enqueue(t, q, "a", "b")
+	j := dequeue(t, q)
+
+	drained := make(chan error, 1)
+	go func() { drained <- q.Drain(context.Background()) }()
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned with a job in flight: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := q.Dequeue(context.Background()); err != ErrClosed {
+		t.Errorf("Dequeue while draining: %v", err)
+	}
+	if _, err := q.Enqueue(nil); err != ErrClosed {
+		t.Errorf("Enqueue while draining: %v", err)
+	}
+	q.Ack(j.ID)
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b was never handed out, it's waiting in the log
+	q, rec := open(t, path)
+	defer q.Close()
+	if rec.Pending != 1 {
+		t.Fatalf("recovery %+v", rec)
+	}
+	expectJob(t, dequeue(t, q), ids[1], "b", 1)
+	q.Ack(ids[1])
+}
+
+func TestDequeueWaits(t *testing.T) {
+	q, _ := open(t, filepath.Join(t.TempDir(), "jobs"))
+	defer q.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Dequeue(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Dequeue on an empty queue: %v", err)
+	}
+	got := make(chan Job, 1)
+	go func() { got <- dequeue(t, q) }()
+	time.Sleep(10 * time.Millisecond)
+	id := enqueue(t, q, "late")[0]
+	expectJob(t, <-got, id, "late", 1)
+	q.Ack(id)
+}
@@ -0,0 +1,112 @@
+// Package keyedmutex locks by key: Lock("alice") and Lock("bob") don't wait for each
+// other, two Lock("alice") calls do.
+//
+// Keys are hashed onto a fixed number of stripes. In plain striped mode the stripe's
+// mutex is the key's lock, which is cheap and needs no memory per key, but two keys
+// that hash to the same stripe serialize with each other for no reason. With per-key
+// locks the stripe only guards a small map of reference counted mutexes, one per key
+// currently locked or waited on, so unrelated keys never contend beyond the brief map
+// lookup. An entry is dropped when its last holder or waiter unlocks, so the map only
+// grows with the number of keys in use, not the number ever seen.
+package keyedmutex
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+type entry struct {
+	mu   sync.Mutex
+	refs int // holders plus waiters, guarded by the stripe
+}
+
+type stripe struct {
+	mu   sync.Mutex
+	keys map[string]*entry
+}
+
+// Mutex is a set of locks indexed by string keys. The zero value is not usable, use New.
+type Mutex struct {
+	seed    maphash.Seed
+	stripes []stripe
+	perKey  bool
+}
+
+// New returns a keyed mutex with the given number of stripes. With perKey every key
+// gets its own reference counted lock, otherwise keys sharing a stripe share a lock.
+func New(stripes int, perKey bool) *Mutex {
+	if stripes < 1 {
+		stripes = 1
+	}
+	m := &Mutex{seed: maphash.MakeSeed(), stripes: make([]stripe, stripes), perKey: perKey}
+	if perKey {
+		for i := range m.stripes {
+			m.stripes[i].keys = make(map[string]*entry)
+		}
+	}
+	return m
+}
+
+// Stripe returns the index of the stripe key maps to.
+func (m *Mutex) Stripe(key string) int {
+	return int(maphash.String(m.seed, key) % uint64(len(m.stripes)))
+}
+
+// Lock locks key, blocking while another goroutine holds it.
+func (m *Mutex) Lock(key string) {
+	s := &m.stripes[m.Stripe(key)]
+	if !m.perKey {
+		s.mu.Lock()
+		return
+	}
+	s.mu.Lock()
+	e := s.keys[key]
+	if e == nil {
+		e = &entry{}
+		s.keys[key] = e
+	}
+	// take a reference before letting go of the stripe, so the entry can't be
+	// dropped between here and the Lock below
+	e.refs++
+	s.mu.Unlock()
+	e.mu.Lock()
+}
+
+// Unlock unlocks key. Like sync.Mutex it's a run-time error if key isn't locked.
+func (m *Mutex) Unlock(key string) {
+	s := &m.stripes[m.Stripe(key)]
+	if !m.perKey {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	e := s.keys[key]
+	if e == nil {
+		s.mu.Unlock()
+		panic("keyedmutex: unlock of unlocked key " + key)
+	}
+	e.refs--
+	if e.refs == 0 {
+		// nobody is waiting, whoever locks this key next starts a fresh entry
+		delete(s.keys, key)
+	}
+	s.mu.Unlock()
+	e.mu.Unlock()
+}
+
+// Keys returns how many keys currently have an entry (held or waited on). It's always
+// zero for a plain striped mutex.
+func (m *Mutex) Keys() int {
+	if !m.perKey {
+		// the stripe mutexes are the key locks here, don't wait on them
+		return 0
+	}
+	n := 0
+	for i := range m.stripes {
+		s := &m.stripes[i]
+		s.mu.Lock()
+		n += len(s.keys)
+		s.mu.Unlock()
+	}
+	return n
+}
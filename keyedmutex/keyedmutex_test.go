@@ -0,0 +1,128 @@
+package keyedmutex
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// locked reports whether Lock(key) is still blocked after a short while.
+func locked(m *Mutex, key string) bool {
+	got := make(chan struct{})
+	go func() {
+		m.Lock(key)
+		close(got)
+		m.Unlock(key)
+	}()
+	select {
+	case <-got:
+		return false
+	case <-time.After(50 * time.Millisecond):
+		return true
+	}
+}
+
+func TestExcludes(t *testing.T) {
+	for _, perKey := range []bool{false, true} {
+		t.Run(fmt.Sprint("perKey=", perKey), func(t *testing.T) {
+			m := New(8, perKey)
+			var counts [4]int
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 1000; i++ {
+						key := fmt.Sprint("k", i%4)
+						m.Lock(key)
+						// unsynchronized: -race catches two goroutines in here with the same key
+						counts[i%4]++
+						m.Unlock(key)
+					}
+				}()
+			}
+			wg.Wait()
+			for k, n := range counts {
+				if n != 2000 {
+					t.Errorf("k%v: %v increments, want 2000", k, n)
+				}
+			}
+		})
+	}
+}
+
+func TestSameKeyWaits(t *testing.T) {
+	m := New(4, true)
+	m.Lock("alice")
+	if !locked(m, "alice") {
+		t.Fatal("second Lock(alice) didn't wait")
+	}
+	m.Unlock("alice")
+}
+
+func TestOtherKeyDoesntWait(t *testing.T) {
+	// one stripe, so every key shares it: only per-key locks keep them apart
+	m := New(1, true)
+	m.Lock("alice")
+	if locked(m, "bob") {
+		t.Fatal("Lock(bob) waited for alice")
+	}
+	m.Unlock("alice")
+
+	striped := New(1, false)
+	striped.Lock("alice")
+	if !locked(striped, "bob") {
+		t.Fatal("striped Lock(bob) didn't wait for alice on the only stripe")
+	}
+	striped.Unlock("alice")
+}
+
+func TestEntriesDropped(t *testing.T) {
+	m := New(4, true)
+	m.Lock("a")
+	m.Lock("b")
+	if n := m.Keys(); n != 2 {
+		t.Fatalf("Keys = %v with two held, want 2", n)
+	}
+	waiting := make(chan struct{})
+	go func() {
+		m.Lock("a")
+		m.Unlock("a")
+		close(waiting)
+	}()
+	m.Unlock("b")
+	// the waiter still holds a reference to a, its entry has to survive this unlock
+	for m.Keys() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Unlock("a")
+	<-waiting
+	if n := m.Keys(); n != 0 {
+		t.Fatalf("Keys = %v with nothing held, want 0", n)
+	}
+}
+
+func TestUnlockUnlockedPanics(t *testing.T) {
+	m := New(4, true)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Unlock of an unlocked key didn't panic")
+		}
+	}()
+	m.Unlock("nobody")
+}
+
+func TestStripeStable(t *testing.T) {
+	m := New(16, false)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		s := m.Stripe(key)
+		if s < 0 || s >= 16 || m.Stripe(key) != s {
+			t.Fatalf("Stripe(%q) = %v, not stable or not in [0, 16)", key, s)
+		}
+	}
+	if New(0, false).Stripe("x") != 0 {
+		t.Fatal("New(0) should give one stripe")
+	}
+}
@@ -0,0 +1,292 @@
+// Package linearize checks whether a concurrent history is linearizable.
+//
+// A concurrent object is linearizable if every operation appears to take effect at
+// a single instant between its call and its return, in an order a sequential
+// version of the object (the Model) would accept. Recording a stress run gives a
+// history of calls and returns, Check searches for such an order.
+//
+// The search is the Wing and Gong algorithm with Lowe's memoization, the way
+// porcupine does it: walk the history in time order, try to linearize each pending
+// call as early as possible, backtrack when a return is reached whose operation
+// couldn't be placed, and remember which (set of linearized operations, model
+// state) pairs have been tried so the same dead end is never explored twice. The
+// worst case is still exponential, keep histories at a few thousand operations and
+// split them with Model.Partition where the object allows it: a map is
+// linearizable if the history of every key is.
+package linearize
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Op is one completed operation. Call and Return are logical timestamps, an
+// operation that returned before another was called must be ordered before it.
+type Op[I, O any] struct {
+	Client       int
+	Input        I
+	Output       O
+	Call, Return int64
+}
+
+// Model is the sequential specification of an object with state S, inputs I and
+// outputs O.
+type Model[S, I, O any] struct {
+	Init func() S
+	// Step applies in to state and reports whether out is what the sequential
+	// object would have returned, along with the new state. It must not modify
+	// state in place, the checker backtracks to old states.
+	Step func(state S, in I, out O) (bool, S)
+	// Key identifies a state for memoization, equal states must have equal keys.
+	Key func(S) string
+	// Partition optionally splits a history into independent ones that are
+	// checked separately.
+	Partition func([]Op[I, O]) [][]Op[I, O]
+	// Describe optionally formats an operation for reports.
+	Describe func(I, O) string
+}
+
+// Result of a check.
+type Result[I, O any] struct {
+	Ok       bool
+	Ops      int
+	Explored int // linearization steps tried, a measure of the search's work
+	// When not linearizable: the partition that failed, the longest order found
+	// for it and the operations that couldn't be placed after it.
+	Failed  []Op[I, O]
+	Longest []Op[I, O]
+	Stuck   []Op[I, O]
+}
+
+// entry is a call or return event in a doubly linked list.
+type entry struct {
+	op         int // index into the history
+	call       bool
+	match      *entry // the call's return and vice versa
+	prev, next *entry
+}
+
+// Check reports whether history is linearizable with respect to m.
+func Check[S, I, O any](m Model[S, I, O], history []Op[I, O]) Result[I, O] {
+	res := Result[I, O]{Ok: true, Ops: len(history)}
+	parts := [][]Op[I, O]{history}
+	if m.Partition != nil {
+		parts = m.Partition(history)
+	}
+	for _, part := range parts {
+		ok, explored, longest, stuck := checkOne(m, part)
+		res.Explored += explored
+		if !ok {
+			res.Ok = false
+			res.Failed = part
+			for _, i := range longest {
+				res.Longest = append(res.Longest, part[i])
+			}
+			for _, i := range stuck {
+				res.Stuck = append(res.Stuck, part[i])
+			}
+			return res
+		}
+	}
+	return res
+}
+
+func buildList[I, O any](history []Op[I, O]) *entry {
+	type event struct {
+		t    int64
+		call bool
+		op   int
+	}
+	events := make([]event, 0, 2*len(history))
+	for i, op := range history {
+		events = append(events, event{op.Call, true, i}, event{op.Return, false, i})
+	}
+	// returns sort before calls at equal times: an operation that returned at the
+	// instant another was called counts as finished before it, the stricter reading.
+	// A Recorder never hands out the same time twice, this is for histories
+	// written by hand.
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].t != events[j].t {
+			return events[i].t < events[j].t
+		}
+		return !events[i].call && events[j].call
+	})
+	head := &entry{op: -1}
+	calls := make([]*entry, len(history))
+	prev := head
+	for _, ev := range events {
+		e := &entry{op: ev.op, call: ev.call, prev: prev}
+		prev.next = e
+		prev = e
+		if ev.call {
+			calls[ev.op] = e
+		} else {
+			e.match = calls[ev.op]
+			calls[ev.op].match = e
+		}
+	}
+	return head
+}
+
+// lift takes a call and its return out of the list, unlift puts them back.
+func lift(call *entry) {
+	call.prev.next = call.next
+	if call.next != nil {
+		call.next.prev = call.prev
+	}
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+func unlift(call *entry) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	if call.next != nil {
+		call.next.prev = call
+	}
+}
+
+type bitset []uint64
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+// memoKey hashes the linearized set and the state key. Only the 128 bit hash is
+// kept, storing the sets themselves would take hundreds of bytes per state on long
+// histories. A collision would wrongly prune a branch, at these sizes that's
+// astronomically unlikely.
+func memoKey(seeds *[2]maphash.Seed, b bitset, state string) [2]uint64 {
+	var out [2]uint64
+	for i := range seeds {
+		var h maphash.Hash
+		h.SetSeed(seeds[i])
+		for _, w := range b {
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], w)
+			h.Write(buf[:])
+		}
+		h.WriteString(state)
+		out[i] = h.Sum64()
+	}
+	return out
+}
+
+func checkOne[S, I, O any](m Model[S, I, O], history []Op[I, O]) (ok bool, explored int, longest, stuck []int) {
+	head := buildList(history)
+	type frame struct {
+		call  *entry
+		state S
+	}
+	var stack []frame
+	state := m.Init()
+	done := make(bitset, (len(history)+63)/64)
+	seen := map[[2]uint64]bool{}
+	seeds := [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()}
+
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			op := history[e.op]
+			explored++
+			if ok, next := m.Step(state, op.Input, op.Output); ok {
+				done.set(e.op)
+				key := memoKey(&seeds, done, m.Key(next))
+				if !seen[key] {
+					seen[key] = true
+					stack = append(stack, frame{e, state})
+					state = next
+					lift(e)
+					if len(stack) > len(longest) {
+						longest = longest[:0]
+						for _, f := range stack {
+							longest = append(longest, f.call.op)
+						}
+					}
+					e = head.next
+					continue
+				}
+				done.clear(e.op)
+			}
+			e = e.next
+			continue
+		}
+		// a return whose call couldn't be linearized before it: backtrack
+		if len(stack) == 0 {
+			return false, explored, longest, stuckAfter(history, longest)
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		done.clear(top.call.op)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true, explored, nil, nil
+}
+
+// stuckAfter lists the operations that could have come next after the longest
+// linearization: those left out that were called before the first of them returned.
+// None of them fit the model's state at that point.
+func stuckAfter[I, O any](history []Op[I, O], longest []int) []int {
+	in := make([]bool, len(history))
+	for _, i := range longest {
+		in[i] = true
+	}
+	first := int64(-1)
+	for i, op := range history {
+		if !in[i] && (first < 0 || op.Return < first) {
+			first = op.Return
+		}
+	}
+	var out []int
+	for i, op := range history {
+		if !in[i] && op.Call < first {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Recorder collects a history from concurrent clients.
+type Recorder[I, O any] struct {
+	clock atomic.Int64
+	mu    sync.Mutex
+	ops   []Op[I, O]
+}
+
+// Call is an operation in progress.
+type Call[I, O any] struct {
+	r  *Recorder[I, O]
+	op Op[I, O]
+}
+
+// Begin notes the call of an operation, right before the object is invoked.
+func (r *Recorder[I, O]) Begin(client int, in I) Call[I, O] {
+	return Call[I, O]{r, Op[I, O]{Client: client, Input: in, Call: r.clock.Add(1)}}
+}
+
+// End notes the return, right after the object returned out.
+func (c Call[I, O]) End(out O) {
+	c.op.Output = out
+	c.op.Return = c.r.clock.Add(1)
+	c.r.mu.Lock()
+	c.r.ops = append(c.r.ops, c.op)
+	c.r.mu.Unlock()
+}
+
+// History returns the completed operations.
+func (r *Recorder[I, O]) History() []Op[I, O] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Op[I, O](nil), r.ops...)
+}
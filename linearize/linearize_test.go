@@ -0,0 +1,155 @@
+package linearize
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// register is a single int register: writes return nothing, reads the value.
+type regIn struct {
+	Write bool
+	V     int
+}
+
+func registerModel() Model[int, regIn, int] {
+	return Model[int, regIn, int]{
+		Init: func() int { return 0 },
+		Step: func(state int, in regIn, out int) (bool, int) {
+			if in.Write {
+				return true, in.V
+			}
+			return out == state, state
+		},
+		Key: func(state int) string { return fmt.Sprint(state) },
+	}
+}
+
+func write(client, v int, call, ret int64) Op[regIn, int] {
+	return Op[regIn, int]{Client: client, Input: regIn{Write: true, V: v}, Call: call, Return: ret}
+}
+
+func read(client, out int, call, ret int64) Op[regIn, int] {
+	return Op[regIn, int]{Client: client, Input: regIn{}, Output: out, Call: call, Return: ret}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []Op[regIn, int]
+		ok      bool
+	}{
+		{"empty", nil, true},
+		{"sequential", []Op[regIn, int]{
+			write(0, 1, 1, 2), read(1, 1, 3, 4), write(0, 2, 5, 6), read(1, 2, 7, 8),
+		}, true},
+		{"stale read after the write returned", []Op[regIn, int]{
+			write(0, 1, 1, 2), read(1, 0, 3, 4),
+		}, false},
+		{"overlapping read may see either value", []Op[regIn, int]{
+			write(0, 1, 1, 4), read(1, 0, 2, 3), read(2, 1, 2, 5),
+		}, true},
+		// both reads overlap the write, but once a read saw the new value a later
+		// read can't see the old one again
+		{"new then old", []Op[regIn, int]{
+			write(0, 1, 1, 10), read(1, 1, 2, 3), read(2, 0, 4, 5),
+		}, false},
+		// an operation returning at the instant another is called comes first
+		{"touching ops are ordered", []Op[regIn, int]{
+			write(0, 1, 1, 2), read(1, 0, 2, 3),
+		}, false},
+		{"out of order in the slice", []Op[regIn, int]{
+			read(1, 2, 7, 8), write(0, 2, 5, 6), read(1, 1, 3, 4), write(0, 1, 1, 2),
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Check(registerModel(), tt.history)
+			if res.Ok != tt.ok {
+				t.Fatalf("Ok = %v, expected %v", res.Ok, tt.ok)
+			}
+			if !res.Ok && len(res.Stuck) == 0 {
+				t.Error("a failed check names no stuck operations")
+			}
+		})
+	}
+}
+
+func TestCheckReportsStuckOps(t *testing.T) {
+	stale := read(1, 0, 3, 4)
+	res := Check(registerModel(), []Op[regIn, int]{write(0, 1, 1, 2), stale})
+	if res.Ok {
+		t.Fatal("stale read accepted")
+	}
+	if len(res.Longest) != 1 || !res.Longest[0].Input.Write {
+		t.Errorf("longest order %v, expected just the write", res.Longest)
+	}
+	if len(res.Stuck) != 1 || res.Stuck[0] != stale {
+		t.Errorf("stuck %v, expected the stale read", res.Stuck)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	// two registers, told apart by client: each one is fine on its own, a single
+	// register couldn't do it
+	m := registerModel()
+	m.Partition = func(ops []Op[regIn, int]) [][]Op[regIn, int] {
+		parts := make([][]Op[regIn, int], 2)
+		for _, op := range ops {
+			parts[op.Client%2] = append(parts[op.Client%2], op)
+		}
+		return parts
+	}
+	history := []Op[regIn, int]{
+		write(0, 1, 1, 2), write(1, 2, 3, 4), read(0, 1, 5, 6), read(1, 2, 7, 8),
+	}
+	if res := Check(m, history); !res.Ok {
+		t.Fatal("partitioned history rejected")
+	}
+	if res := Check(registerModel(), history); res.Ok {
+		t.Fatal("the same history accepted as one register")
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	var r Recorder[regIn, int]
+	var mu sync.Mutex
+	value := 0
+	var wg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if i%2 == 0 {
+					call := r.Begin(c, regIn{Write: true, V: c*100 + i})
+					mu.Lock()
+					value = c*100 + i
+					mu.Unlock()
+					call.End(0)
+					continue
+				}
+				call := r.Begin(c, regIn{})
+				mu.Lock()
+				v := value
+				mu.Unlock()
+				call.End(v)
+			}
+		}(c)
+	}
+	wg.Wait()
+	h := r.History()
+	if len(h) != 200 {
+		t.Fatalf("%v operations recorded, expected 200", len(h))
+	}
+	seen := map[int64]bool{}
+	for _, op := range h {
+		if op.Call >= op.Return || seen[op.Call] || seen[op.Return] {
+			t.Fatalf("bad timestamps %v, %v", op.Call, op.Return)
+		}
+		seen[op.Call], seen[op.Return] = true, true
+	}
+	if res := Check(registerModel(), h); !res.Ok {
+		t.Fatal("a mutex protected register isn't linearizable")
+	}
+}
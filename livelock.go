@@ -15,72 +15,116 @@ resource’s pointer reference.
 
 For the output, try to print something that indicates that spouse one is picking up resource x,
 checking if the other spouse is hungry, and leaving the resource.
-*/
 
+Nobody is blocked in a livelock, both spouses keep running, so it doesn't end on its
+own and the runtime never reports a deadlock. A watchdog (see the watchdog package)
+notices instead: a spouse beats only when they actually eat, passing the spoon isn't
+progress. After -misses beats without a meal it reports them with how often they
+passed the spoon, dumps the goroutine stacks (-stacks) and cancels the dinner, exit
+status 2. With -patience n a spouse eats anyway after passing the spoon n times and
+dinner ends.
+
+usage: go run livelock.go -patience 0|n
+*/
 
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/watchdog"
 )
 
-func main() {
-	runtime.GOMAXPROCS(4)
-	type value struct {
-		sync.Mutex
-		id     string
-		value1 string
-		value2 string
-		locked bool
-	}
+type spouse struct {
+	id     string
+	hungry atomic.Bool
+}
 
-	lock := func(v *value) {
-		v.Lock()
-		v.locked = true
-	}
-	unlock := func(v *value) {
-		v.Unlock()
-		v.locked = false
-	}
+type spoon struct {
+	sync.Mutex
+	owner *spouse
+}
+
+func main() {
+	patience := flag.Int("patience", 0, "times a spouse passes the spoon before eating anyway, 0 for forever (livelock)")
+	think := flag.Duration("think", 10*time.Millisecond, "processing between picking up the spoon and checking on the spouse")
+	beat := flag.Duration("beat", 100*time.Millisecond, "watchdog interval")
+	misses := flag.Int("misses", 3, "missed beats before a spouse counts as stalled")
+	stacks := flag.Bool("stacks", true, "dump the goroutine stacks on a stall")
+	flag.Parse()
 
-	move := func(wg *sync.WaitGroup, v1, v2 *value) {
+	runtime.GOMAXPROCS(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wd := watchdog.New(watchdog.Config{
+		Interval: *beat,
+		Misses:   *misses,
+		Output:   os.Stdout,
+		Stacks:   *stacks,
+		Cancel:   cancel,
+	})
+	wd.Start()
+
+	eat := func(wg *sync.WaitGroup, s *spoon, me, other *spouse) {
 		defer wg.Done()
+		wk := wd.Register(me.id)
+		defer wk.Done()
 
-		for i := 0; ; i++ {
-			if i > 3 {
-				fmt.Println("canceling goroutine...")
+		for passes := 0; me.hungry.Load(); {
+			if ctx.Err() != nil {
+				fmt.Printf("%v: canceling goroutine...\n", me.id)
 				return
 			}
-
-			fmt.Printf("%v: %v \n", v1.id, v1.value1)
-			lock(v1)
-
-			fmt.Printf("%v: Checking if %v \n", v1.id, v2.value2)
-			if v2.locked {
-				fmt.Printf("%v: Leaving spoon \n", v1.id)
-				unlock(v1)
+			s.Lock()
+			if s.owner != me {
+				// the other one has it, ask again in a moment
+				s.Unlock()
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			fmt.Printf("%v: i am picking up the spoon\n", me.id)
+			time.Sleep(*think)
+
+			fmt.Printf("%v: checking if %v is hungry\n", me.id, other.id)
+			if other.hungry.Load() && (*patience == 0 || passes < *patience) {
+				fmt.Printf("%v: leaving the spoon, you eat first %v\n", me.id, other.id)
+				s.owner = other
+				s.Unlock()
+				passes++
+				wk.Note("passed the spoon %v times", passes)
 				continue
 			}
+			fmt.Printf("%v: eating, passed the spoon %v times first\n", me.id, passes)
+			me.hungry.Store(false)
+			s.owner = other
+			s.Unlock()
+			wk.Beat()
 		}
 	}
 
-	alice, bob := value{
-		id:     "alice",
-		value1: "i am picking up the spoon",
-		value2: "alice is hungry",
-	}, value{
-		id:     "bob",
-		value1: "i am picking up the spoon",
-		value2: "bob is hungry",
-	}
+	alice, bob := &spouse{id: "alice"}, &spouse{id: "bob"}
+	alice.hungry.Store(true)
+	bob.hungry.Store(true)
+	s := &spoon{owner: alice}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go move(&wg, &alice, &bob)
-	go move(&wg, &bob, &alice)
+	go eat(&wg, s, alice, bob)
+	go eat(&wg, s, bob, alice)
 
 	wg.Wait()
+	wd.Stop()
+	if ctx.Err() != nil {
+		fmt.Println("livelock: both spouses kept passing the spoon, nobody ate")
+		os.Exit(2)
+	}
+	fmt.Println("both spouses ate")
 }
@@ -0,0 +1,205 @@
+// Package loadgen drives a function with load and measures its latency, so the
+// server and pipeline demos report numbers the same way.
+//
+// Two classic modes are supported:
+//
+//	closed loop - Concurrency workers each send a request, wait for the answer and
+//	              send the next one. The offered load drops as the target slows
+//	              down, which hides how bad things get under overload.
+//	open loop   - requests are started at a fixed Rate no matter how the target is
+//	              doing, latency is measured from the time a request *should* have
+//	              started, so queueing delay isn't silently left out
+//	              (coordinated omission).
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+)
+
+// Mode selects closed or open loop load.
+type Mode int
+
+const (
+	Closed Mode = iota
+	Open
+)
+
+func (m Mode) String() string {
+	if m == Open {
+		return "open"
+	}
+	return "closed"
+}
+
+// Func is one request. worker identifies the goroutine making it, in the range
+// [0, Concurrency), so the target can keep per worker state such as a connection.
+type Func func(ctx context.Context, worker int) error
+
+// Config describes the load to generate. Either Requests or Duration (or both)
+// must be set, the run stops at whichever comes first.
+type Config struct {
+	Mode Mode
+	// Concurrency is the number of workers. In open loop it caps the requests in
+	// flight, requests over the cap wait and that wait counts as latency.
+	Concurrency int
+	// Rate is the target requests per second, open loop only.
+	Rate     float64
+	Requests int
+	Duration time.Duration
+	// Cleanup, if set, is called by each worker once it has no more requests to
+	// make, e.g. to close the worker's connection.
+	Cleanup func(worker int)
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Mode      Mode
+	Completed int64
+	Errors    int64
+	Elapsed   time.Duration
+	Latency   *histogram.Snapshot
+}
+
+// Throughput is completed requests per second.
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Completed) / r.Elapsed.Seconds()
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("%v loop: %v ok, %v errors in %v (%.0f req/s)\n  latency %v",
+		r.Mode, r.Completed, r.Errors, r.Elapsed.Round(time.Millisecond), r.Throughput(), r.Latency)
+}
+
+// Run generates load against fn until the config's limit is reached or ctx is done.
+func Run(ctx context.Context, cfg Config, fn Func) (Report, error) {
+	if cfg.Concurrency <= 0 {
+		return Report{}, fmt.Errorf("loadgen: concurrency must be positive, got %v", cfg.Concurrency)
+	}
+	if cfg.Requests <= 0 && cfg.Duration <= 0 {
+		return Report{}, fmt.Errorf("loadgen: set Requests or Duration")
+	}
+	if cfg.Mode == Open && cfg.Rate <= 0 {
+		return Report{}, fmt.Errorf("loadgen: open loop needs a positive Rate")
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	r := &runner{cfg: cfg, fn: fn, hist: histogram.New()}
+	start := time.Now()
+	if cfg.Mode == Open {
+		r.open(ctx, start)
+	} else {
+		r.closed(ctx)
+	}
+	return Report{
+		Mode:      cfg.Mode,
+		Completed: r.completed.Load(),
+		Errors:    r.errors.Load(),
+		Elapsed:   time.Since(start),
+		Latency:   r.hist.Snapshot(),
+	}, nil
+}
+
+type runner struct {
+	cfg  Config
+	fn   Func
+	hist *histogram.Histogram
+
+	issued    atomic.Int64
+	completed atomic.Int64
+	errors    atomic.Int64
+}
+
+// claim reserves the next request, false once the request budget is used up.
+func (r *runner) claim() bool {
+	if r.cfg.Requests <= 0 {
+		return true
+	}
+	return r.issued.Add(1) <= int64(r.cfg.Requests)
+}
+
+func (r *runner) cleanup(worker int) {
+	if r.cfg.Cleanup != nil {
+		r.cfg.Cleanup(worker)
+	}
+}
+
+func (r *runner) do(ctx context.Context, rec *histogram.Recorder, worker int, intended time.Time) {
+	err := r.fn(ctx, worker)
+	rec.Record(time.Since(intended))
+	if err != nil {
+		r.errors.Add(1)
+		return
+	}
+	r.completed.Add(1)
+}
+
+func (r *runner) closed(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(r.cfg.Concurrency)
+	for w := 0; w < r.cfg.Concurrency; w++ {
+		go func(w int) {
+			defer wg.Done()
+			defer r.cleanup(w)
+			rec := r.hist.NewRecorder()
+			for ctx.Err() == nil && r.claim() {
+				r.do(ctx, rec, w, time.Now())
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func (r *runner) open(ctx context.Context, start time.Time) {
+	interval := time.Duration(float64(time.Second) / r.cfg.Rate)
+	schedule := make(chan time.Time, r.cfg.Concurrency)
+
+	var wg sync.WaitGroup
+	wg.Add(r.cfg.Concurrency)
+	for w := 0; w < r.cfg.Concurrency; w++ {
+		go func(w int) {
+			defer wg.Done()
+			defer r.cleanup(w)
+			rec := r.hist.NewRecorder()
+			for intended := range schedule {
+				r.do(ctx, rec, w, intended)
+			}
+		}(w)
+	}
+
+	// the schedule is computed from the start time, not from when the previous
+	// request went out, so a slow target can't slow the load down
+	for i := 0; ctx.Err() == nil && r.claim(); i++ {
+		intended := start.Add(time.Duration(i) * interval)
+		if d := time.Until(intended); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+			case <-t.C:
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		select {
+		case schedule <- intended:
+		case <-ctx.Done():
+		}
+	}
+	close(schedule)
+	wg.Wait()
+}
@@ -0,0 +1,418 @@
+// Package logpipe gets log lines from many goroutines onto disk without a slow disk
+// being able to take the whole program down with it:
+//
+//	producers -> bounded queue -> one writer goroutine -> file (fsync, rotation)
+//
+// Lines are queued in a batcher and written a batch per write(2). When the disk
+// can't keep up the queue fills, and the Policy decides who pays for it:
+//
+//	Block - Log waits for room (at most MaxBlock, if set, then the line is dropped).
+//	        Backpressure reaches the producers: nothing is lost, but a stalled disk
+//	        stalls everybody who logs.
+//	Drop  - Log never waits, a line that finds the queue full is dropped and
+//	        counted. The writer notes the gap in the file ("logpipe: dropped N
+//	        lines") so whoever reads it knows lines are missing, and how many.
+//
+// Written lines are fsynced within SyncEvery, and the file is rotated before it
+// would grow past MaxSize: path becomes path.1, path.1 becomes path.2 and so on,
+// MaxFiles old files are kept.
+package logpipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/batcher"
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+	"github.com/neilharia7/operating-systems-with-go/histogram"
+)
+
+var (
+	// ErrClosed is returned by Log after Close.
+	ErrClosed = errors.New("logpipe: closed")
+	// ErrDropped is returned by Log when the line was dropped, it's counted in
+	// Stats and noted in the file, most callers can ignore it.
+	ErrDropped = errors.New("logpipe: queue full, line dropped")
+)
+
+// Policy says what Log does when the queue is full.
+type Policy int
+
+const (
+	Block Policy = iota
+	Drop
+)
+
+func (p Policy) String() string {
+	if p == Drop {
+		return "drop"
+	}
+	return "block"
+}
+
+// ParsePolicy is the inverse of String.
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "block":
+		return Block, nil
+	case "drop":
+		return Drop, nil
+	}
+	return 0, fmt.Errorf("logpipe: unknown policy %q (block, drop)", s)
+}
+
+// Config describes the pipeline, only Path is required.
+type Config struct {
+	Path   string
+	Policy Policy
+	// MaxBlock bounds how long Block waits for room, zero waits as long as the
+	// context allows.
+	MaxBlock time.Duration
+	// Queue is how many lines can be waiting for the writer, default 1024.
+	Queue int
+	// BatchSize and BatchAge are when a batch is written, default 256 lines or 10ms.
+	BatchSize int
+	BatchAge  time.Duration
+	// SyncEvery is how often written lines are fsynced, zero syncs after every
+	// batch, negative leaves it to the kernel.
+	SyncEvery time.Duration
+	// MaxSize is the size the file is rotated at, zero never rotates. MaxFiles old
+	// files are kept, default 5.
+	MaxSize  int64
+	MaxFiles int
+	// Chaos, if set, delays writes, to play a slow or stalling disk.
+	Chaos *chaos.Injector
+}
+
+// Stats counts what the pipeline has done.
+type Stats struct {
+	Lines   int64 // written to the file
+	Bytes   int64
+	Dropped int64
+	// Blocked is how many Log calls had to wait for room, BlockedTime how long
+	// they waited in total.
+	Blocked     int64
+	BlockedTime time.Duration
+	Batches     int64
+	Syncs       int64
+	Rotations   int64
+	// Latency is from Log to the write(2) with the line returning.
+	Latency *histogram.Snapshot
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("lines=%v bytes=%v dropped=%v blocked=%v (%v) batches=%v syncs=%v rotations=%v",
+		s.Lines, s.Bytes, s.Dropped, s.Blocked, s.BlockedTime.Round(time.Microsecond),
+		s.Batches, s.Syncs, s.Rotations)
+}
+
+type entry struct {
+	text   string
+	queued time.Time
+}
+
+// Logger is the pipeline. It is safe for concurrent use.
+type Logger struct {
+	cfg     Config
+	b       *batcher.Batcher[entry]
+	latency *histogram.Histogram
+
+	// mu guards the file, the writer holds it for writes and rotation
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	dirty bool // written since the last fsync
+	buf   []byte
+
+	dropped    atomic.Int64
+	unreported atomic.Int64 // drops not noted in the file yet
+	lines      atomic.Int64
+	bytes      atomic.Int64
+	blocked    atomic.Int64
+	blockedFor atomic.Int64
+	syncs      atomic.Int64
+	rotations  atomic.Int64
+
+	closed   atomic.Bool
+	stop     chan struct{}
+	syncDone chan struct{}
+}
+
+// Open appends to cfg.Path, creating it if needed, and starts the writer.
+func Open(cfg Config) (*Logger, error) {
+	if cfg.Queue <= 0 {
+		cfg.Queue = 1024
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 256
+	}
+	if cfg.BatchAge <= 0 {
+		cfg.BatchAge = 10 * time.Millisecond
+	}
+	if cfg.MaxFiles <= 0 {
+		cfg.MaxFiles = 5
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l := &Logger{
+		cfg:      cfg,
+		latency:  histogram.New(),
+		f:        f,
+		size:     fi.Size(),
+		stop:     make(chan struct{}),
+		syncDone: make(chan struct{}),
+	}
+	l.b = batcher.New(batcher.Config{MaxSize: cfg.BatchSize, MaxAge: cfg.BatchAge, Queue: cfg.Queue}, l.write)
+	if cfg.SyncEvery > 0 {
+		go l.syncer()
+	} else {
+		close(l.syncDone)
+	}
+	return l, nil
+}
+
+// Log queues line, a newline is added if it doesn't end with one. What happens
+// when the queue is full depends on the policy.
+func (l *Logger) Log(ctx context.Context, line string) error {
+	e := entry{text: line, queued: time.Now()}
+	ok, err := l.b.TryAdd(e)
+	if err != nil {
+		return ErrClosed
+	}
+	if ok {
+		return nil
+	}
+	if l.cfg.Policy == Drop {
+		l.drop()
+		return ErrDropped
+	}
+
+	l.blocked.Add(1)
+	wait := ctx
+	if l.cfg.MaxBlock > 0 {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, l.cfg.MaxBlock)
+		defer cancel()
+	}
+	err = l.b.Add(wait, e)
+	l.blockedFor.Add(int64(time.Since(e.queued)))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, batcher.ErrClosed):
+		return ErrClosed
+	}
+	// lost either way, whether the caller gave up or MaxBlock ran out
+	l.drop()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return ErrDropped
+}
+
+// Printf formats a line and logs it, waiting as long as the policy says.
+func (l *Logger) Printf(format string, args ...any) error {
+	return l.Log(context.Background(), fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) drop() {
+	l.dropped.Add(1)
+	l.unreported.Add(1)
+}
+
+// write is the batcher's flush, it runs on the writer goroutine. Lines that don't
+// make it to the file because a write or a rotation failed are counted as drops,
+// and noted as such in the file once it can be written again.
+func (l *Logger) write(batch []entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = l.buf[:0]
+	noted := l.noteDrops()
+	// written lines are in the file, the ones from written to buffered in l.buf
+	written, buffered := 0, 0
+	fail := func(err error) error {
+		lost := int64(len(batch) - written)
+		l.dropped.Add(lost)
+		// a marker that didn't make it out has to be noted again as well
+		l.unreported.Add(lost + noted)
+		l.record(batch[:written])
+		return err
+	}
+	for _, e := range batch {
+		n := int64(len(e.text))
+		if !strings.HasSuffix(e.text, "\n") {
+			n++
+		}
+		if l.cfg.MaxSize > 0 && l.size+int64(len(l.buf))+n > l.cfg.MaxSize && l.size+int64(len(l.buf)) > 0 {
+			// lines never straddle two files
+			if err := l.flushBuf(); err != nil {
+				return fail(err)
+			}
+			written, noted = buffered, 0
+			if err := l.rotate(); err != nil {
+				return fail(err)
+			}
+		}
+		l.buf = append(l.buf, e.text...)
+		if !strings.HasSuffix(e.text, "\n") {
+			l.buf = append(l.buf, '\n')
+		}
+		buffered++
+	}
+	if err := l.flushBuf(); err != nil {
+		return fail(err)
+	}
+	l.record(batch)
+	if l.cfg.SyncEvery == 0 && l.dirty {
+		l.dirty = false
+		l.syncs.Add(1)
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// record counts lines that were written.
+func (l *Logger) record(written []entry) {
+	now := time.Now()
+	for _, e := range written {
+		l.latency.Record(now.Sub(e.queued))
+	}
+	l.lines.Add(int64(len(written)))
+}
+
+// noteDrops adds the drop marker to the buffer and returns the drops it notes, l.mu
+// must be held.
+func (l *Logger) noteDrops() int64 {
+	n := l.unreported.Swap(0)
+	if n > 0 {
+		l.buf = fmt.Appendf(l.buf, "logpipe: dropped %d lines\n", n)
+	}
+	return n
+}
+
+// flushBuf writes out the buffer, l.mu must be held.
+func (l *Logger) flushBuf() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	l.cfg.Chaos.Delay()
+	n, err := l.f.Write(l.buf)
+	l.size += int64(n)
+	l.bytes.Add(int64(n))
+	l.dirty = true
+	l.buf = l.buf[:0]
+	return err
+}
+
+func (l *Logger) rotated(i int) string {
+	return fmt.Sprintf("%v.%d", l.cfg.Path, i)
+}
+
+// rotate moves the file out of the way and starts a new one, l.mu must be held.
+func (l *Logger) rotate() error {
+	// the old file is complete on disk before it's renamed
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.syncs.Add(1)
+	l.f.Close()
+	for i := l.cfg.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.cfg.Path, l.rotated(1)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f, l.size, l.dirty = f, 0, false
+	l.rotations.Add(1)
+	// make the renames durable too
+	if d, err := os.Open(filepath.Dir(l.cfg.Path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// syncer fsyncs what was written every SyncEvery. The fsync itself runs without
+// l.mu so the writer can keep writing meanwhile.
+func (l *Logger) syncer() {
+	defer close(l.syncDone)
+	ticker := time.NewTicker(l.cfg.SyncEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		f, dirty := l.f, l.dirty
+		l.dirty = false
+		l.mu.Unlock()
+		if dirty {
+			// a rotation can close f under us, it synced it before closing
+			f.Sync()
+			l.syncs.Add(1)
+		}
+	}
+}
+
+// Close writes everything still queued, syncs and closes the file.
+func (l *Logger) Close() error {
+	if !l.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	err := l.b.Close()
+	close(l.stop)
+	<-l.syncDone
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = l.buf[:0]
+	l.noteDrops()
+	if werr := l.flushBuf(); err == nil {
+		err = werr
+	}
+	if serr := l.f.Sync(); err == nil {
+		err = serr
+	}
+	l.syncs.Add(1)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Stats returns the counters so far.
+func (l *Logger) Stats() Stats {
+	return Stats{
+		Lines:       l.lines.Load(),
+		Bytes:       l.bytes.Load(),
+		Dropped:     l.dropped.Load(),
+		Blocked:     l.blocked.Load(),
+		BlockedTime: time.Duration(l.blockedFor.Load()),
+		Batches:     l.b.Stats().Batches,
+		Syncs:       l.syncs.Load(),
+		Rotations:   l.rotations.Load(),
+		Latency:     l.latency.Snapshot(),
+	}
+}
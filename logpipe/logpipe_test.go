@@ -0,0 +1,216 @@
+package logpipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, cfg Config) *Logger {
+	t.Helper()
+	if cfg.Path == "" {
+		cfg.Path = filepath.Join(t.TempDir(), "log")
+	}
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if data[len(data)-1] != '\n' {
+		t.Fatalf("%v doesn't end in a newline", path)
+	}
+	return strings.Split(string(data[:len(data)-1]), "\n")
+}
+
+func TestLines(t *testing.T) {
+	l := open(t, Config{})
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprint("line ", i)
+		if i%2 == 0 {
+			line += "\n"
+		}
+		if err := l.Printf("%s", line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Printf("late"); err != ErrClosed {
+		t.Errorf("Log after Close: %v", err)
+	}
+	lines := readLines(t, l.cfg.Path)
+	if len(lines) != 100 {
+		t.Fatalf("%v lines in the file, logged 100", len(lines))
+	}
+	for i, line := range lines {
+		if line != fmt.Sprint("line ", i) {
+			t.Fatalf("line %v is %q", i, line)
+		}
+	}
+	if st := l.Stats(); st.Lines != 100 || st.Dropped != 0 || st.Bytes != int64(len(strings.Join(lines, "\n"))+1) {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	l := open(t, Config{MaxSize: 100, MaxFiles: 3, BatchSize: 7})
+	for i := 0; i < 100; i++ {
+		l.Printf("line %03d", i) // 9 bytes with the newline
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(l.rotated(4)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("more than MaxFiles old files kept: %v", err)
+	}
+	// oldest first, the lines have to continue across the files
+	var all []string
+	for _, path := range []string{l.rotated(3), l.rotated(2), l.rotated(1), l.cfg.Path} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 100 {
+			t.Errorf("%v grew to %v bytes, past MaxSize", path, fi.Size())
+		}
+		all = append(all, readLines(t, path)...)
+	}
+	last := 100 - len(all)
+	for i, line := range all {
+		if line != fmt.Sprintf("line %03d", last+i) {
+			t.Fatalf("line %q where line %03d belongs", line, last+i)
+		}
+	}
+	if st := l.Stats(); st.Rotations != 9 {
+		t.Errorf("%v rotations, expected 9 for 100 lines in files of 11", st.Rotations)
+	}
+}
+
+// stall keeps the writer from writing until the returned func is called.
+func stall(l *Logger) func() {
+	l.mu.Lock()
+	return l.mu.Unlock
+}
+
+func TestDrop(t *testing.T) {
+	l := open(t, Config{Policy: Drop, Queue: 4, BatchSize: 1})
+	resume := stall(l)
+	accepted, dropped := 0, 0
+	for i := 0; i < 20; i++ {
+		switch err := l.Printf("line %v", i); err {
+		case nil:
+			accepted++
+		case ErrDropped:
+			dropped++
+		default:
+			t.Fatal(err)
+		}
+	}
+	resume()
+	if dropped < 15 {
+		t.Fatalf("%v of 20 lines dropped with the writer stalled and room for 5", dropped)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the marker goes out with the next batch written after the drops
+	lines := readLines(t, l.cfg.Path)
+	marker := fmt.Sprintf("logpipe: dropped %d lines", dropped)
+	if len(lines) != accepted+1 || !slices.Contains(lines, marker) {
+		t.Errorf("file has %q, expected %v lines and %q", lines, accepted, marker)
+	}
+	if st := l.Stats(); st.Dropped != int64(dropped) || st.Lines != int64(accepted) {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestBlockWaitsAtMostMaxBlock(t *testing.T) {
+	l := open(t, Config{Policy: Block, MaxBlock: 20 * time.Millisecond, Queue: 1, BatchSize: 1})
+	resume := stall(l)
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		err = l.Printf("line %v", i)
+	}
+	if err != ErrDropped {
+		t.Fatalf("Log on a full queue: %v, expected ErrDropped after MaxBlock", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Log(ctx, "canceled"); err != context.Canceled {
+		t.Errorf("Log with a canceled context: %v", err)
+	}
+	resume()
+	l.Close()
+	st := l.Stats()
+	if st.Blocked < 2 || st.BlockedTime < 20*time.Millisecond || st.Dropped != 2 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestFailedWritesCountAsDrops(t *testing.T) {
+	l := open(t, Config{Queue: 16, BatchSize: 4, BatchAge: time.Millisecond})
+	// swap in a file that can't be written, under l.mu like the writer
+	good := l.f
+	ro, err := os.Open(l.cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	l.mu.Lock()
+	l.f = ro
+	l.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		if err := l.Printf("lost %v", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Stats().Dropped < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("lines that failed to write not counted as drops: %v", l.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	l.mu.Lock()
+	l.f = good
+	l.mu.Unlock()
+	l.Printf("after")
+	l.Close()
+	lines := readLines(t, l.cfg.Path)
+	if len(lines) != 2 || lines[0] != "logpipe: dropped 10 lines" || lines[1] != "after" {
+		t.Fatalf("file has %q, expected the drop marker and the line after", lines)
+	}
+	if st := l.Stats(); st.Lines != 1 || st.Dropped != 10 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{Block, Drop} {
+		if got, err := ParsePolicy(p.String()); got != p || err != nil {
+			t.Errorf("ParsePolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePolicy("spill"); err == nil {
+		t.Error("ParsePolicy accepted spill")
+	}
+}
@@ -0,0 +1,209 @@
+// Package memmodel runs classic memory model litmus tests.
+//
+// A litmus test is a tiny program with two threads touching a couple of shared
+// variables. Running it millions of times and counting the outcomes shows which
+// reorderings the hardware / compiler actually produce. Every test here runs in two
+// flavours: plain loads and stores (a data race, so the Go memory model makes no
+// promises at all) and sync/atomic operations (sequentially consistent in Go, so the
+// "forbidden" outcome must never show up).
+package memmodel
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Outcome is the pair of values read by the test's two registers.
+type Outcome struct {
+	R1, R2 int64
+}
+
+func (o Outcome) String() string {
+	return fmt.Sprintf("r1=%v r2=%v", o.R1, o.R2)
+}
+
+// state is shared by the two threads of one litmus test. The padding keeps x and y on
+// different cache lines so the races aren't hidden by false sharing.
+type state struct {
+	x  int64
+	_  [56]byte
+	y  int64
+	_  [56]byte
+	r1 int64
+	r2 int64
+}
+
+// Test describes a single litmus test.
+type Test struct {
+	Name        string
+	Description string
+	// Forbidden is the outcome sequential consistency rules out.
+	Forbidden Outcome
+
+	plain  [2]func(s *state)
+	atomic [2]func(s *state)
+}
+
+// Tests returns the available litmus tests.
+func Tests() []Test {
+	return []Test{messagePassing, storeBuffering, coherence}
+}
+
+// Lookup finds a test by name.
+func Lookup(name string) (Test, bool) {
+	for _, t := range Tests() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Test{}, false
+}
+
+// message passing: T1 writes data then a flag, T2 reads the flag then the data.
+// Seeing the flag but not the data means the writes (or the reads) got reordered.
+var messagePassing = Test{
+	Name:        "mp",
+	Description: "T1: x=1; y=1   T2: r1=y; r2=x",
+	Forbidden:   Outcome{1, 0},
+	plain: [2]func(s *state){
+		func(s *state) { s.x = 1; s.y = 1 },
+		func(s *state) { s.r1 = s.y; s.r2 = s.x },
+	},
+	atomic: [2]func(s *state){
+		func(s *state) { atomic.StoreInt64(&s.x, 1); atomic.StoreInt64(&s.y, 1) },
+		func(s *state) { s.r1 = atomic.LoadInt64(&s.y); s.r2 = atomic.LoadInt64(&s.x) },
+	},
+}
+
+// store buffering: each thread writes its variable then reads the other one.
+// Both reading 0 is what a store buffer does (x86 included), each store is still
+// sitting in the local buffer when the other thread's load runs.
+var storeBuffering = Test{
+	Name:        "sb",
+	Description: "T1: x=1; r1=y   T2: y=1; r2=x",
+	Forbidden:   Outcome{0, 0},
+	plain: [2]func(s *state){
+		func(s *state) { s.x = 1; s.r1 = s.y },
+		func(s *state) { s.y = 1; s.r2 = s.x },
+	},
+	atomic: [2]func(s *state){
+		func(s *state) { atomic.StoreInt64(&s.x, 1); s.r1 = atomic.LoadInt64(&s.y) },
+		func(s *state) { atomic.StoreInt64(&s.y, 1); s.r2 = atomic.LoadInt64(&s.x) },
+	},
+}
+
+// coherence (CoRR): two reads of the same variable by one thread can't go
+// backwards in time, once the new value is seen the old one can't come back.
+var coherence = Test{
+	Name:        "corr",
+	Description: "T1: x=1   T2: r1=x; r2=x",
+	Forbidden:   Outcome{1, 0},
+	plain: [2]func(s *state){
+		func(s *state) { s.x = 1 },
+		func(s *state) { s.r1 = s.x; s.r2 = s.x },
+	},
+	atomic: [2]func(s *state){
+		func(s *state) { atomic.StoreInt64(&s.x, 1) },
+		func(s *state) { s.r1 = atomic.LoadInt64(&s.x); s.r2 = atomic.LoadInt64(&s.x) },
+	},
+}
+
+// Result holds the outcome histogram of one run.
+type Result struct {
+	Test       Test
+	Atomic     bool
+	Iterations int
+	Counts     map[Outcome]int
+}
+
+// ForbiddenCount is how many times the forbidden outcome was observed.
+func (r Result) ForbiddenCount() int {
+	return r.Counts[r.Test.Forbidden]
+}
+
+func (r Result) String() string {
+	var b strings.Builder
+	kind := "plain"
+	if r.Atomic {
+		kind = "atomic"
+	}
+	fmt.Fprintf(&b, "%v (%v) %v, %v iterations\n", r.Test.Name, kind, r.Test.Description, r.Iterations)
+
+	outcomes := make([]Outcome, 0, len(r.Counts))
+	for o := range r.Counts {
+		outcomes = append(outcomes, o)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].R1 != outcomes[j].R1 {
+			return outcomes[i].R1 < outcomes[j].R1
+		}
+		return outcomes[i].R2 < outcomes[j].R2
+	})
+	for _, o := range outcomes {
+		mark := ""
+		if o == r.Test.Forbidden {
+			mark = "  <- forbidden under sequential consistency"
+		}
+		fmt.Fprintf(&b, "  %v: %10d%v\n", o, r.Counts[o], mark)
+	}
+	return b.String()
+}
+
+// Run executes the test for the given number of iterations.
+//
+// The two threads are long lived goroutines pinned to OS threads, every iteration
+// they spin on a shared epoch counter so they start as close together as possible.
+// Starting fresh goroutines per iteration would make the interesting interleavings
+// practically impossible to hit.
+func Run(t Test, iterations int, useAtomics bool) Result {
+	threads := t.plain
+	if useAtomics {
+		threads = t.atomic
+	}
+
+	var (
+		s     state
+		epoch atomic.Int64
+		done  atomic.Int64
+		wg    sync.WaitGroup
+	)
+
+	worker := func(fn func(*state)) {
+		defer wg.Done()
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		for i := int64(1); i <= int64(iterations); i++ {
+			for spins := 0; epoch.Load() < i; spins++ {
+				if spins > 1000 {
+					runtime.Gosched()
+				}
+			}
+			fn(&s)
+			done.Add(1)
+		}
+	}
+
+	wg.Add(2)
+	go worker(threads[0])
+	go worker(threads[1])
+
+	counts := make(map[Outcome]int)
+	for i := int64(1); i <= int64(iterations); i++ {
+		s.x, s.y, s.r1, s.r2 = 0, 0, 0, 0
+		// the atomic store publishes the reset above to both workers
+		epoch.Store(i)
+		for spins := 0; done.Load() < 2*i; spins++ {
+			if spins > 1000 {
+				runtime.Gosched()
+			}
+		}
+		counts[Outcome{s.r1, s.r2}]++
+	}
+	wg.Wait()
+
+	return Result{Test: t, Atomic: useAtomics, Iterations: iterations, Counts: counts}
+}
@@ -0,0 +1,99 @@
+// Package parallel fans work out to a fixed number of workers and fans the results
+// back in, in the same order the inputs arrived.
+//
+// Workers finish in whatever order they like, every input is tagged with a sequence
+// number and the reassembler holds early results back until the ones before them are
+// in. Results are still streamed: the first one is delivered as soon as it's done, not
+// when the whole input has been processed.
+package parallel
+
+import "sync"
+
+type item[T any] struct {
+	seq int
+	v   T
+}
+
+// Map applies fn to every value received on in using workers goroutines and returns a
+// channel delivering fn's results in input order. The channel is closed once in is
+// closed and every result has been delivered.
+//
+// At most window inputs are in flight (being worked on or waiting to be reassembled),
+// so a single slow item can't make the reassembler buffer the rest of the input. A
+// window smaller than workers would leave workers idle and is raised to 2*workers.
+func Map[T, R any](in <-chan T, workers, window int, fn func(T) R) <-chan R {
+	if workers < 1 {
+		workers = 1
+	}
+	if window < workers {
+		window = 2 * workers
+	}
+
+	// a slot is taken when an input is dispatched and given back once its result has
+	// been delivered, that's what bounds the reorder buffer
+	slots := make(chan struct{}, window)
+	jobs := make(chan item[T])
+	done := make(chan item[R], window)
+	out := make(chan R)
+
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for v := range in {
+			slots <- struct{}{}
+			jobs <- item[T]{seq, v}
+			seq++
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				done <- item[R]{j.seq, fn(j.v)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	go func() {
+		defer close(out)
+		pending := make(map[int]R, window)
+		next := 0
+		for r := range done {
+			pending[r.seq] = r.v
+			for {
+				v, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				out <- v
+				<-slots
+				next++
+			}
+		}
+	}()
+	return out
+}
+
+// Slice is Map over a slice, collecting the results into a slice of the same length.
+func Slice[T, R any](in []T, workers int, fn func(T) R) []R {
+	src := make(chan T)
+	go func() {
+		defer close(src)
+		for _, v := range in {
+			src <- v
+		}
+	}()
+	out := make([]R, 0, len(in))
+	for r := range Map(src, workers, 0, fn) {
+		out = append(out, r)
+	}
+	return out
+}
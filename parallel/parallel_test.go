@@ -0,0 +1,133 @@
+package parallel
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSliceKeepsOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	delays := make([]time.Duration, 200)
+	for i := range delays {
+		delays[i] = time.Duration(rng.Intn(500)) * time.Microsecond
+	}
+	in := make([]int, len(delays))
+	for i := range in {
+		in[i] = i
+	}
+	out := Slice(in, 8, func(i int) int {
+		time.Sleep(delays[i])
+		return i * i
+	})
+	if len(out) != len(in) {
+		t.Fatalf("%v results for %v inputs", len(out), len(in))
+	}
+	for i, v := range out {
+		if v != i*i {
+			t.Fatalf("out[%v] = %v, want %v", i, v, i*i)
+		}
+	}
+}
+
+func TestSliceEmpty(t *testing.T) {
+	if out := Slice([]int(nil), 4, func(i int) int { return i }); len(out) != 0 {
+		t.Fatalf("Slice(nil) = %v", out)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	in := make(chan int)
+	out := Map(in, 2, 4, func(i int) int { return i + 1 })
+	// the first result has to arrive while the input is still open
+	in <- 1
+	select {
+	case v := <-out:
+		if v != 2 {
+			t.Fatalf("got %v, want 2", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first result held back until the input is closed")
+	}
+	close(in)
+	if _, ok := <-out; ok {
+		t.Fatal("output not closed after the input")
+	}
+}
+
+func TestWindowBoundsInFlight(t *testing.T) {
+	const workers, window = 4, 8
+	in := make(chan int)
+	block := make(chan struct{})
+	out := Map(in, workers, window, func(i int) int {
+		if i == 0 {
+			// the head of the line is slow, everything after it piles up behind it
+			<-block
+		}
+		return i
+	})
+	sent := make(chan int)
+	go func() {
+		defer close(in)
+		n := 0
+		for i := 0; i < 100; i++ {
+			select {
+			case in <- i:
+				n++
+			case <-time.After(200 * time.Millisecond):
+				sent <- n
+				<-block
+				for ; i < 100; i++ {
+					in <- i
+				}
+				return
+			}
+		}
+		sent <- n
+	}()
+	// Map reads one more input ahead of the slots it can take
+	if n := <-sent; n > window+1 {
+		t.Fatalf("%v inputs taken with the first one stuck, window is %v", n, window)
+	}
+	close(block)
+	next := 0
+	for v := range out {
+		if v != next {
+			t.Fatalf("got %v, want %v", v, next)
+		}
+		next++
+	}
+	if next != 100 {
+		t.Fatalf("%v results, want 100", next)
+	}
+}
+
+func TestSmallWindowRaised(t *testing.T) {
+	in := make(chan int)
+	var running, peak atomic.Int64
+	out := Map(in, 4, 1, func(i int) int {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return i
+	})
+	go func() {
+		defer close(in)
+		for i := 0; i < 16; i++ {
+			in <- i
+		}
+	}()
+	for range out {
+	}
+	// with a window of 1 only one worker would ever have anything to do
+	if peak.Load() < 2 {
+		t.Fatalf("peak concurrency %v, the window wasn't raised", peak.Load())
+	}
+}
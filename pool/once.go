@@ -0,0 +1,90 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPanicked is what the callers waiting in OncePerKey.Do get when the fn they
+// waited for panicked.
+var ErrPanicked = errors.New("pool: initialization panicked")
+
+// OncePerKey runs an initialization function at most once per key, like a map of
+// sync.Once. Concurrent callers asking for the same key wait for the one running it
+// and get its result, callers for different keys don't wait for each other.
+//
+// Unlike sync.Once a failed initialization isn't remembered: the error goes to every
+// caller that waited for that attempt and the next call tries again. A panic counts
+// as a failure: the waiters get ErrPanicked and the panic goes on up the caller's
+// stack.
+type OncePerKey[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+type call[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// Do returns the value for key, calling fn to create it if this is the first call
+// for key (or the previous attempts failed).
+func (o *OncePerKey[K, V]) Do(key K, fn func() (V, error)) (V, error) {
+	o.mu.Lock()
+	if o.calls == nil {
+		o.calls = make(map[K]*call[V])
+	}
+	if c, ok := o.calls[key]; ok {
+		o.mu.Unlock()
+		<-c.done
+		return c.v, c.err
+	}
+	c := &call[V]{done: make(chan struct{})}
+	o.calls[key] = c
+	o.mu.Unlock()
+
+	returned := false
+	defer func() {
+		// deferred so the waiters are let go even if fn panics
+		if !returned {
+			c.err = ErrPanicked
+		}
+		if c.err != nil {
+			o.mu.Lock()
+			delete(o.calls, key)
+			o.mu.Unlock()
+		}
+		close(c.done)
+	}()
+	c.v, c.err = fn()
+	returned = true
+	return c.v, c.err
+}
+
+// Forget drops the value for key, the next Do runs fn again.
+func (o *OncePerKey[K, V]) Forget(key K) {
+	o.mu.Lock()
+	delete(o.calls, key)
+	o.mu.Unlock()
+}
+
+// Range calls f for every key initialized successfully so far.
+func (o *OncePerKey[K, V]) Range(f func(K, V)) {
+	o.mu.Lock()
+	done := make(map[K]V, len(o.calls))
+	for k, c := range o.calls {
+		select {
+		case <-c.done:
+			if c.err == nil {
+				done[k] = c.v
+			}
+		default:
+			// still being initialized
+		}
+	}
+	o.mu.Unlock()
+	for k, v := range done {
+		f(k, v)
+	}
+}
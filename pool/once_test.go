@@ -0,0 +1,103 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOncePerKey(t *testing.T) {
+	var o OncePerKey[string, int]
+	var calls sync.Map
+	var wg sync.WaitGroup
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := fmt.Sprint("k", g%3)
+			v, err := o.Do(key, func() (int, error) {
+				n, _ := calls.LoadOrStore(key, new(atomic.Int64))
+				n.(*atomic.Int64).Add(1)
+				time.Sleep(5 * time.Millisecond) // the others pile up behind it
+				return len(key) + g%3, nil
+			})
+			if err != nil || v != 2+g%3 {
+				t.Errorf("Do(%v) = %v, %v", key, v, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+	calls.Range(func(k, n any) bool {
+		if n := n.(*atomic.Int64).Load(); n != 1 {
+			t.Errorf("%v initialized %v times", k, n)
+		}
+		return true
+	})
+
+	got := map[string]int{}
+	o.Range(func(k string, v int) { got[k] = v })
+	if len(got) != 3 || got["k2"] != 4 {
+		t.Errorf("Range saw %v", got)
+	}
+	o.Forget("k2")
+	if v, _ := o.Do("k2", func() (int, error) { return 40, nil }); v != 40 {
+		t.Errorf("Do after Forget = %v, fn didn't run again", v)
+	}
+}
+
+func TestOncePerKeyRetriesErrors(t *testing.T) {
+	var o OncePerKey[int, int]
+	boom := errors.New("backend down")
+	if _, err := o.Do(1, func() (int, error) { return 0, boom }); err != boom {
+		t.Fatalf("Do = %v", err)
+	}
+	o.Range(func(k, v int) { t.Errorf("Range saw the failed key %v", k) })
+	if v, err := o.Do(1, func() (int, error) { return 7, nil }); v != 7 || err != nil {
+		t.Fatalf("Do after a failure = %v, %v, expected a fresh attempt", v, err)
+	}
+}
+
+func TestOncePerKeyPanic(t *testing.T) {
+	var o OncePerKey[int, int]
+	started, release := make(chan struct{}), make(chan struct{})
+	caller := make(chan any, 1)
+	go func() {
+		defer func() { caller <- recover() }()
+		o.Do(1, func() (int, error) {
+			close(started)
+			<-release
+			panic("init blew up")
+		})
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := o.Do(1, func() (int, error) {
+			t.Error("a second fn ran while the first was in flight")
+			return 0, nil
+		})
+		waiter <- err
+	}()
+	// give the waiter time to find the call in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if r := <-caller; r != "init blew up" {
+		t.Fatalf("the caller recovered %v, expected its own panic", r)
+	}
+	select {
+	case err := <-waiter:
+		if err != ErrPanicked {
+			t.Fatalf("waiter got %v, expected ErrPanicked", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter stuck after fn panicked")
+	}
+	if v, err := o.Do(1, func() (int, error) { return 3, nil }); v != 3 || err != nil {
+		t.Fatalf("Do after the panic = %v, %v, expected a fresh attempt", v, err)
+	}
+}
@@ -0,0 +1,335 @@
+// Package pool keeps expensive resources (connections, mostly) around for reuse.
+//
+// A Pool opens at most MaxOpen items. Get hands out an idle one if there is one,
+// opens a new one if the limit allows, and otherwise waits, until a Put releases an
+// item or the context gives up. Idle items are health checked before being handed out
+// and closed once they've been idle for longer than MaxIdleTime, so a client never
+// gets a connection the other side has long since dropped.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Get once the pool has been closed.
+var ErrClosed = errors.New("pool: closed")
+
+// Config describes how items are created and managed. Only New is required.
+type Config[T any] struct {
+	New   func(ctx context.Context) (T, error)
+	Close func(T)
+	// Check is called on an idle item before Get hands it out, an error discards it.
+	Check       func(T) error
+	MaxOpen     int // default 1
+	MaxIdle     int // default MaxOpen
+	MaxIdleTime time.Duration
+}
+
+// Stats counts what the pool has been doing.
+type Stats struct {
+	Open      int   // items currently open, idle or in use
+	Idle      int   // items currently idle
+	Created   int64 // calls to New that succeeded
+	Reused    int64 // Gets served from the idle list
+	Waits     int64 // Gets that had to wait for an item
+	WaitTime  time.Duration
+	Unhealthy int64 // idle items that failed Check
+	Expired   int64 // idle items closed for exceeding MaxIdleTime
+	Discarded int64 // items given back with Discard
+	Canceled  int64 // Gets that gave up because their context was done
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("open=%v idle=%v created=%v reused=%v waits=%v (%v) unhealthy=%v expired=%v discarded=%v canceled=%v",
+		s.Open, s.Idle, s.Created, s.Reused, s.Waits, s.WaitTime.Round(time.Microsecond),
+		s.Unhealthy, s.Expired, s.Discarded, s.Canceled)
+}
+
+type idleItem[T any] struct {
+	v     T
+	since time.Time
+}
+
+// handoff is what a waiting Get receives: an item released by someone else,
+// permission to open a new one because someone discarded theirs, or the news that
+// the pool was closed.
+type handoff[T any] struct {
+	v      T
+	open   bool
+	closed bool
+}
+
+// Pool is a bounded pool of T.
+type Pool[T any] struct {
+	cfg Config[T]
+
+	mu      sync.Mutex
+	idle    []idleItem[T] // most recently used last
+	open    int
+	waiters []chan handoff[T]
+	closed  bool
+	stats   Stats
+
+	stop chan struct{}
+}
+
+// New returns a pool for cfg. With a MaxIdleTime a background goroutine closes
+// expired idle items, Close stops it.
+func New[T any](cfg Config[T]) *Pool[T] {
+	if cfg.MaxOpen < 1 {
+		cfg.MaxOpen = 1
+	}
+	if cfg.MaxIdle < 1 || cfg.MaxIdle > cfg.MaxOpen {
+		cfg.MaxIdle = cfg.MaxOpen
+	}
+	p := &Pool[T]{cfg: cfg, stop: make(chan struct{})}
+	if cfg.MaxIdleTime > 0 {
+		go p.reaper()
+	}
+	return p
+}
+
+// Get returns an item from the pool. It must be given back with Put, or with Discard
+// if it turned out to be broken.
+func (p *Pool[T]) Get(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return zero, ErrClosed
+		}
+		if n := len(p.idle); n > 0 {
+			it := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if p.expired(it) {
+				p.stats.Expired++
+				p.mu.Unlock()
+				p.closeItem(it.v)
+				p.release()
+				continue
+			}
+			p.mu.Unlock()
+			if p.cfg.Check != nil {
+				if err := p.cfg.Check(it.v); err != nil {
+					p.mu.Lock()
+					p.stats.Unhealthy++
+					p.mu.Unlock()
+					p.closeItem(it.v)
+					p.release()
+					continue
+				}
+			}
+			p.mu.Lock()
+			p.stats.Reused++
+			p.mu.Unlock()
+			return it.v, nil
+		}
+		if p.open < p.cfg.MaxOpen {
+			// reserve the slot before dialing, without holding the lock
+			p.open++
+			p.mu.Unlock()
+			return p.create(ctx)
+		}
+
+		ch := make(chan handoff[T], 1)
+		p.waiters = append(p.waiters, ch)
+		p.stats.Waits++
+		p.mu.Unlock()
+
+		start := time.Now()
+		select {
+		case h := <-ch:
+			p.addWait(time.Since(start))
+			switch {
+			case h.closed:
+				return zero, ErrClosed
+			case h.open:
+				return p.create(ctx)
+			}
+			p.mu.Lock()
+			p.stats.Reused++
+			p.mu.Unlock()
+			return h.v, nil
+		case <-ctx.Done():
+			p.addWait(time.Since(start))
+			p.mu.Lock()
+			p.stats.Canceled++
+			removed := p.removeWaiter(ch)
+			p.mu.Unlock()
+			if !removed {
+				// lost the race with a handoff, pass it on to the next in line
+				switch h := <-ch; {
+				case h.closed:
+				case h.open:
+					p.release()
+				default:
+					p.Put(h.v)
+				}
+			}
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Put gives v back to the pool.
+func (p *Pool[T]) Put(v T) {
+	p.mu.Lock()
+	if p.closed {
+		p.open--
+		p.mu.Unlock()
+		p.closeItem(v)
+		return
+	}
+	if ch := p.popWaiter(); ch != nil {
+		p.mu.Unlock()
+		ch <- handoff[T]{v: v}
+		return
+	}
+	if len(p.idle) < p.cfg.MaxIdle {
+		p.idle = append(p.idle, idleItem[T]{v, time.Now()})
+		p.mu.Unlock()
+		return
+	}
+	p.open--
+	p.mu.Unlock()
+	p.closeItem(v)
+}
+
+// Discard closes v instead of returning it to the pool, freeing its slot.
+func (p *Pool[T]) Discard(v T) {
+	p.mu.Lock()
+	p.stats.Discarded++
+	p.mu.Unlock()
+	p.closeItem(v)
+	p.release()
+}
+
+// Close closes the idle items and makes further Gets fail. Items still in use are
+// closed as they're put back.
+func (p *Pool[T]) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+	close(p.stop)
+	for _, ch := range waiters {
+		ch <- handoff[T]{closed: true}
+	}
+	for _, it := range idle {
+		p.closeItem(it.v)
+	}
+}
+
+// Stats returns the counters so far.
+func (p *Pool[T]) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Open, s.Idle = p.open, len(p.idle)
+	return s
+}
+
+func (p *Pool[T]) create(ctx context.Context) (T, error) {
+	v, err := p.cfg.New(ctx)
+	if err != nil {
+		p.release()
+		return v, err
+	}
+	p.mu.Lock()
+	p.stats.Created++
+	p.mu.Unlock()
+	return v, nil
+}
+
+// release gives up an open slot, or hands it to a waiter so it can open an item.
+func (p *Pool[T]) release() {
+	p.mu.Lock()
+	if !p.closed {
+		if ch := p.popWaiter(); ch != nil {
+			p.mu.Unlock()
+			ch <- handoff[T]{open: true}
+			return
+		}
+	}
+	p.open--
+	p.mu.Unlock()
+}
+
+func (p *Pool[T]) closeItem(v T) {
+	if p.cfg.Close != nil {
+		p.cfg.Close(v)
+	}
+}
+
+func (p *Pool[T]) addWait(d time.Duration) {
+	p.mu.Lock()
+	p.stats.WaitTime += d
+	p.mu.Unlock()
+}
+
+// expired reports whether an idle item has been sitting for too long. p.mu must be held.
+func (p *Pool[T]) expired(it idleItem[T]) bool {
+	return p.cfg.MaxIdleTime > 0 && time.Since(it.since) > p.cfg.MaxIdleTime
+}
+
+// popWaiter removes and returns the longest waiting Get, or nil. p.mu must be held.
+func (p *Pool[T]) popWaiter() chan handoff[T] {
+	if len(p.waiters) == 0 {
+		return nil
+	}
+	ch := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	return ch
+}
+
+// removeWaiter takes ch out of the queue, reporting false if it was already
+// popped (and a handoff is on its way). p.mu must be held.
+func (p *Pool[T]) removeWaiter(ch chan handoff[T]) bool {
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// reaper closes idle items past MaxIdleTime, oldest first.
+func (p *Pool[T]) reaper() {
+	ticker := time.NewTicker(p.cfg.MaxIdleTime / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		// idle is ordered by time put back, the expired ones are at the front
+		n := 0
+		for n < len(p.idle) && p.expired(p.idle[n]) {
+			n++
+		}
+		expired := append([]idleItem[T](nil), p.idle[:n]...)
+		p.idle = append(p.idle[:0], p.idle[n:]...)
+		p.stats.Expired += int64(n)
+		p.mu.Unlock()
+		for _, it := range expired {
+			p.closeItem(it.v)
+			p.release()
+		}
+	}
+}
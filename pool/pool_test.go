@@ -0,0 +1,263 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type item struct {
+	id     int
+	closed atomic.Bool
+}
+
+// items is a Config whose New hands out numbered items and Close marks them.
+type items struct {
+	created atomic.Int64
+	closed  atomic.Int64
+}
+
+func (it *items) config(maxOpen int) Config[*item] {
+	return Config[*item]{
+		New: func(ctx context.Context) (*item, error) {
+			return &item{id: int(it.created.Add(1))}, nil
+		},
+		Close: func(v *item) {
+			if v.closed.Swap(true) {
+				panic("item closed twice")
+			}
+			it.closed.Add(1)
+		},
+		MaxOpen: maxOpen,
+	}
+}
+
+func get(t *testing.T, p *Pool[*item]) *item {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := p.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return v
+}
+
+// blockedGet starts a Get and makes sure it's waiting before returning.
+func blockedGet(t *testing.T, p *Pool[*item], ctx context.Context) <-chan *item {
+	t.Helper()
+	waits := p.Stats().Waits
+	got := make(chan *item, 1)
+	go func() {
+		v, _ := p.Get(ctx)
+		got <- v
+	}()
+	for p.Stats().Waits == waits {
+		time.Sleep(time.Millisecond)
+	}
+	return got
+}
+
+func TestReuse(t *testing.T) {
+	var it items
+	p := New(it.config(2))
+	a := get(t, p)
+	p.Put(a)
+	if b := get(t, p); b != a {
+		t.Fatalf("got item %v, expected the idle one %v back", b.id, a.id)
+	}
+	if st := p.Stats(); st.Created != 1 || st.Reused != 1 || st.Open != 1 || st.Idle != 0 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestWaitForPut(t *testing.T) {
+	var it items
+	p := New(it.config(2))
+	a, b := get(t, p), get(t, p)
+	got := blockedGet(t, p, context.Background())
+	p.Put(b)
+	if v := <-got; v != b {
+		t.Fatalf("waiter got %v, expected the item put back", v.id)
+	}
+	p.Put(a)
+	if st := p.Stats(); st.Created != 2 || st.Waits != 1 || st.Open != 2 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestGetCanceled(t *testing.T) {
+	var it items
+	p := New(it.config(1))
+	a := get(t, p)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get on a full pool: %v", err)
+	}
+	// the canceled Get left the queue, the item goes idle instead of to nobody
+	p.Put(a)
+	if st := p.Stats(); st.Canceled != 1 || st.Idle != 1 {
+		t.Fatalf("stats %v", st)
+	}
+	if v := get(t, p); v != a {
+		t.Errorf("got %v, expected the idle item", v.id)
+	}
+}
+
+func TestDiscardLetsAWaiterOpen(t *testing.T) {
+	var it items
+	p := New(it.config(1))
+	a := get(t, p)
+	got := blockedGet(t, p, context.Background())
+	p.Discard(a)
+	v := <-got
+	if v == a || v.id != 2 {
+		t.Fatalf("waiter got item %v, expected a new one", v.id)
+	}
+	if !a.closed.Load() {
+		t.Error("discarded item not closed")
+	}
+	if st := p.Stats(); st.Open != 1 || st.Discarded != 1 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestNewErrorFreesTheSlot(t *testing.T) {
+	fail := true
+	p := New(Config[int]{
+		New: func(context.Context) (int, error) {
+			if fail {
+				return 0, errors.New("dial failed")
+			}
+			return 1, nil
+		},
+	})
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded with a failing New")
+	}
+	fail = false
+	if v, err := p.Get(context.Background()); v != 1 || err != nil {
+		t.Fatalf("Get after New recovered: %v, %v", v, err)
+	}
+}
+
+func TestUnhealthyAndExpired(t *testing.T) {
+	var it items
+	cfg := it.config(2)
+	broken := map[*item]bool{}
+	var mu sync.Mutex
+	cfg.Check = func(v *item) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if broken[v] {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	p := New(cfg)
+	a := get(t, p)
+	p.Put(a)
+	mu.Lock()
+	broken[a] = true
+	mu.Unlock()
+	if v := get(t, p); v == a || !a.closed.Load() {
+		t.Fatal("an item failing Check was handed out or not closed")
+	}
+	if st := p.Stats(); st.Unhealthy != 1 || st.Open != 1 {
+		t.Errorf("stats %v", st)
+	}
+
+	var it2 items
+	cfg = it2.config(1)
+	cfg.MaxIdleTime = 10 * time.Millisecond
+	p = New(cfg)
+	defer p.Close()
+	b := get(t, p)
+	p.Put(b)
+	time.Sleep(50 * time.Millisecond)
+	if v := get(t, p); v == b || !b.closed.Load() {
+		t.Fatal("an expired item was handed out or not closed")
+	}
+	if st := p.Stats(); st.Expired != 1 || st.Open != 1 {
+		t.Errorf("stats %v", st)
+	}
+}
+
+func TestClose(t *testing.T) {
+	var it items
+	p := New(it.config(3))
+	a, b, c := get(t, p), get(t, p), get(t, p)
+	waiter := blockedGet(t, p, context.Background())
+	p.Close()
+	if v := <-waiter; v != nil {
+		t.Fatal("a waiting Get got an item from a closed pool")
+	}
+	if _, err := p.Get(context.Background()); err != ErrClosed {
+		t.Fatalf("Get after Close: %v", err)
+	}
+	// items in use are closed as they come back
+	p.Put(a)
+	p.Put(b)
+	p.Put(c)
+	if !a.closed.Load() || !b.closed.Load() || !c.closed.Load() || p.Stats().Open != 0 {
+		t.Errorf("items not closed on Put after Close, stats %v", p.Stats())
+	}
+	p.Close()
+}
+
+func TestCloseClosesIdle(t *testing.T) {
+	var it items
+	p := New(it.config(2))
+	a := get(t, p)
+	p.Put(a)
+	p.Close()
+	if !a.closed.Load() || p.Stats().Open != 0 {
+		t.Fatalf("idle item not closed by Close, stats %v", p.Stats())
+	}
+}
+
+func TestNeverOverMaxOpen(t *testing.T) {
+	var it items
+	p := New(it.config(3))
+	var inUse, most atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%3)*time.Millisecond)
+				v, err := p.Get(ctx)
+				cancel()
+				if err != nil {
+					continue
+				}
+				n := inUse.Add(1)
+				for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+				}
+				time.Sleep(10 * time.Microsecond)
+				inUse.Add(-1)
+				if (g+i)%7 == 0 {
+					p.Discard(v)
+				} else {
+					p.Put(v)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if most.Load() > 3 {
+		t.Fatalf("%v items in use at once, MaxOpen is 3", most.Load())
+	}
+	st := p.Stats()
+	if st.Open > 3 || st.Open != st.Idle {
+		t.Fatalf("stats after everything was given back: %v", st)
+	}
+	if open := it.created.Load() - it.closed.Load(); open != int64(st.Open) {
+		t.Errorf("%v items open, the pool counts %v", open, st.Open)
+	}
+}
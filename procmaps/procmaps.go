@@ -0,0 +1,191 @@
+//go:build linux
+
+// Package procmaps reads a process' memory layout from /proc/<pid>/maps and
+// /proc/<pid>/smaps: every mapped region of the virtual address space, what it is
+// (text, data, heap, stack, mmap...) and how much of it is actually resident.
+package procmaps
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kind classifies a region.
+type Kind string
+
+const (
+	Text   Kind = "text"   // executable file mapping: program or library code
+	Data   Kind = "data"   // writable file mapping: initialised globals
+	ROData Kind = "rodata" // read only file mapping: constants, relro
+	BSS    Kind = "bss"    // anonymous mapping right after a binary's data
+	Heap   Kind = "heap"   // the brk heap
+	Stack  Kind = "stack"  // the main thread's stack
+	Anon   Kind = "anon"   // anonymous mmap: malloc arenas, the go heap, thread stacks
+	Kernel Kind = "kernel" // vdso, vvar, vsyscall
+)
+
+// Region is one line of the maps file plus its smaps counters (in kB).
+type Region struct {
+	Start, End uint64
+	Perms      string
+	Offset     uint64
+	Inode      uint64
+	Path       string
+	Kind       Kind
+
+	SizeKB int64
+	RSSKB  int64
+	PSSKB  int64
+	SwapKB int64
+}
+
+// Len is the size of the region in bytes.
+func (r Region) Len() uint64 { return r.End - r.Start }
+
+// Read parses /proc/<pid>/smaps, falling back to /proc/<pid>/maps (no RSS numbers)
+// when smaps can't be read. pid "self" is the calling process.
+func Read(pid string) ([]Region, error) {
+	f, err := os.Open("/proc/" + pid + "/smaps")
+	if err != nil {
+		if f, err = os.Open("/proc/" + pid + "/maps"); err != nil {
+			return nil, err
+		}
+	}
+	defer f.Close()
+	regions, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("procmaps: %v: %w", f.Name(), err)
+	}
+	return regions, nil
+}
+
+// Parse reads maps or smaps formatted text.
+func Parse(r io.Reader) ([]Region, error) {
+	var out []Region
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// smaps counter lines look like "Rss:   123 kB"
+		if strings.HasSuffix(fields[0], ":") {
+			if len(out) == 0 || len(fields) < 2 {
+				continue
+			}
+			v, _ := strconv.ParseInt(fields[1], 10, 64)
+			cur := &out[len(out)-1]
+			switch fields[0] {
+			case "Size:":
+				cur.SizeKB = v
+			case "Rss:":
+				cur.RSSKB = v
+			case "Pss:":
+				cur.PSSKB = v
+			case "Swap:":
+				cur.SwapKB = v
+			}
+			continue
+		}
+		reg, err := parseMapping(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", line, err)
+		}
+		out = append(out, reg)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	classify(out)
+	return out, nil
+}
+
+// parseMapping parses "start-end perms offset dev inode [path]".
+func parseMapping(f []string) (Region, error) {
+	var r Region
+	if len(f) < 5 {
+		return r, fmt.Errorf("short mapping line")
+	}
+	start, end, ok := strings.Cut(f[0], "-")
+	if !ok {
+		return r, fmt.Errorf("bad address range")
+	}
+	var err error
+	if r.Start, err = strconv.ParseUint(start, 16, 64); err != nil {
+		return r, err
+	}
+	if r.End, err = strconv.ParseUint(end, 16, 64); err != nil {
+		return r, err
+	}
+	r.Perms = f[1]
+	if r.Offset, err = strconv.ParseUint(f[2], 16, 64); err != nil {
+		return r, err
+	}
+	if r.Inode, err = strconv.ParseUint(f[4], 10, 64); err != nil {
+		return r, err
+	}
+	if len(f) > 5 {
+		r.Path = strings.Join(f[5:], " ")
+	}
+	r.SizeKB = int64(r.Len() / 1024)
+	return r, nil
+}
+
+func classify(regions []Region) {
+	for i := range regions {
+		r := &regions[i]
+		switch {
+		case r.Path == "[heap]":
+			r.Kind = Heap
+		case strings.HasPrefix(r.Path, "[stack"):
+			r.Kind = Stack
+		case r.Path == "[vdso]" || r.Path == "[vvar]" || r.Path == "[vsyscall]" || r.Path == "[vvar_vclock]":
+			r.Kind = Kernel
+		case r.Path != "" && !strings.HasPrefix(r.Path, "["):
+			switch {
+			case strings.Contains(r.Perms, "x"):
+				r.Kind = Text
+			case strings.Contains(r.Perms, "w"):
+				r.Kind = Data
+			default:
+				r.Kind = ROData
+			}
+		case i > 0 && regions[i-1].Kind == Data && regions[i-1].End == r.Start:
+			// zero filled globals are mapped anonymously right behind .data
+			r.Kind = BSS
+		default:
+			r.Kind = Anon
+		}
+	}
+}
+
+// Summary totals regions by kind.
+type Summary struct {
+	Kind    Kind
+	Regions int
+	SizeKB  int64
+	RSSKB   int64
+}
+
+// Summarize groups regions by kind, in the order kinds first appear.
+func Summarize(regions []Region) []Summary {
+	index := map[Kind]int{}
+	var out []Summary
+	for _, r := range regions {
+		i, ok := index[r.Kind]
+		if !ok {
+			i = len(out)
+			index[r.Kind] = i
+			out = append(out, Summary{Kind: r.Kind})
+		}
+		out[i].Regions++
+		out[i].SizeKB += r.SizeKB
+		out[i].RSSKB += r.RSSKB
+	}
+	return out
+}
@@ -0,0 +1,160 @@
+package pty
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Size is a terminal window size.
+type Size struct {
+	Rows, Cols uint16
+	x, y       uint16 // pixels, unused
+}
+
+// Recorder writes everything passing through it to an asciicast v2 file (the format
+// asciinema uses): a JSON header line, then one [seconds, "o", data] line per chunk
+// of output. Safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+// NewRecorder writes the header and returns a recorder appending to w.
+func NewRecorder(w io.Writer, size Size, command string) (*Recorder, error) {
+	header := map[string]any{
+		"version":   2,
+		"width":     size.Cols,
+		"height":    size.Rows,
+		"timestamp": time.Now().Unix(),
+		"command":   command,
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, start: time.Now()}, nil
+}
+
+// Write records one chunk of output.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	event, err := json.Marshal([]any{time.Since(r.start).Seconds(), "o", string(p)})
+	if err != nil {
+		return 0, err
+	}
+	if _, r.err = fmt.Fprintf(r.w, "%s\n", event); r.err != nil {
+		return 0, r.err
+	}
+	return len(p), nil
+}
+
+// Replay plays a recording back to w, sleeping between chunks like the original
+// session did. speed 2 plays twice as fast, maxIdle caps any single pause.
+func Replay(r io.Reader, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	if !s.Scan() {
+		return fmt.Errorf("pty: empty recording")
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(s.Bytes(), &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("pty: not an asciicast v2 recording")
+	}
+
+	last := 0.0
+	for s.Scan() {
+		var event []any
+		if err := json.Unmarshal(s.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("pty: bad event %q", s.Text())
+		}
+		at, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if kind != "o" {
+			continue
+		}
+		wait := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 && wait > maxIdle {
+			wait = maxIdle
+		}
+		time.Sleep(wait)
+		last = at
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// prefixWriter prefixes every line with a tag, so the output of several commands
+// can share one terminal. Lines are written atomically under a shared mutex.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+// NewPrefixWriter returns a writer that tags complete lines with prefix before
+// writing them to w. Writers sharing mu never interleave inside a line.
+func NewPrefixWriter(mu *sync.Mutex, w io.Writer, prefix string) io.WriteCloser {
+	return &prefixWriter{mu: mu, w: w, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := indexNewline(p.buf)
+		if i < 0 {
+			return len(b), nil
+		}
+		if err := p.emit(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Close flushes a trailing partial line.
+func (p *prefixWriter) Close() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.emit(append(p.buf, '\n'))
+	p.buf = nil
+	return err
+}
+
+func (p *prefixWriter) emit(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := fmt.Fprintf(p.w, "%v %s", p.prefix, line)
+	return err
+}
+
+func indexNewline(b []byte) int {
+	for i, c := range b {
+		if c == '\n' {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"sync"
+)
+
+// Bounded is a FIFO queue holding at most a fixed number of values, safe for
+// concurrent use.
+type Bounded[T any] struct {
+	mu                sync.Mutex
+	buf               []T
+	head, n           int
+	closed            bool
+	notEmpty, notFull chan struct{} // closed and replaced to wake all waiters
+}
+
+// NewBounded returns an empty queue of the given capacity.
+func NewBounded[T any](capacity int) *Bounded[T] {
+	return &Bounded[T]{
+		buf:      make([]T, max(capacity, 1)),
+		notEmpty: make(chan struct{}),
+		notFull:  make(chan struct{}),
+	}
+}
+
+// wake wakes everyone waiting on *ch, q.mu must be held.
+func wake(ch *chan struct{}) {
+	close(*ch)
+	*ch = make(chan struct{})
+}
+
+// TryPush appends v unless the queue is full.
+func (q *Bounded[T]) TryPush(v T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == len(q.buf) || q.closed {
+		return false
+	}
+	q.buf[(q.head+q.n)%len(q.buf)] = v
+	q.n++
+	wake(&q.notEmpty)
+	return true
+}
+
+// TryPop removes the oldest value, ok is false if the queue is empty.
+func (q *Bounded[T]) TryPop() (v T, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pop()
+}
+
+func (q *Bounded[T]) pop() (v T, ok bool) {
+	if q.n == 0 {
+		return v, false
+	}
+	v = q.buf[q.head]
+	var zero T
+	q.buf[q.head] = zero
+	q.head = (q.head + 1) % len(q.buf)
+	q.n--
+	wake(&q.notFull)
+	return v, true
+}
+
+// Push appends v, waiting for room. It fails with the context's error, or
+// ErrClosed once the queue is closed.
+func (q *Bounded[T]) Push(ctx context.Context, v T) error {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if q.n < len(q.buf) {
+			q.buf[(q.head+q.n)%len(q.buf)] = v
+			q.n++
+			wake(&q.notEmpty)
+			q.mu.Unlock()
+			return nil
+		}
+		wait := q.notFull
+		q.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pop removes the oldest value, waiting for one. After Close it keeps returning
+// what's left and then ErrClosed.
+func (q *Bounded[T]) Pop(ctx context.Context) (T, error) {
+	for {
+		q.mu.Lock()
+		if v, ok := q.pop(); ok {
+			q.mu.Unlock()
+			return v, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			var zero T
+			return zero, ErrClosed
+		}
+		wait := q.notEmpty
+		q.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Close stops further pushes and wakes every waiter.
+func (q *Bounded[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		wake(&q.notEmpty)
+		wake(&q.notFull)
+	}
+}
+
+// Len returns the number of values queued.
+func (q *Bounded[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Cap returns the capacity.
+func (q *Bounded[T]) Cap() int { return len(q.buf) }
@@ -0,0 +1,90 @@
+// Package queue has FIFO queues for passing values between goroutines:
+//
+//	LockFree - unbounded, the Michael-Scott queue: producers and consumers never
+//	           wait for each other's locks, they race with compare-and-swap
+//	Bounded  - a ring buffer of fixed capacity behind a mutex, producers block (or
+//	           fail with TryPush) when it's full, that's the back pressure
+package queue
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrClosed is returned by Bounded.Push after Close, and by Pop once a closed
+// queue is empty.
+var ErrClosed = errors.New("queue: closed")
+
+type node[T any] struct {
+	v    T
+	next atomic.Pointer[node[T]]
+}
+
+// LockFree is an unbounded lock free FIFO queue, safe for concurrent use. The zero
+// value isn't usable, create one with NewLockFree.
+type LockFree[T any] struct {
+	// head is a dummy node, the first value is in head.next
+	head, tail atomic.Pointer[node[T]]
+	len        atomic.Int64
+}
+
+// NewLockFree returns an empty queue.
+func NewLockFree[T any]() *LockFree[T] {
+	q := &LockFree[T]{}
+	dummy := &node[T]{}
+	q.head.Store(dummy)
+	q.tail.Store(dummy)
+	return q
+}
+
+// Push appends v.
+func (q *LockFree[T]) Push(v T) {
+	n := &node[T]{v: v}
+	for {
+		tail := q.tail.Load()
+		next := tail.next.Load()
+		if tail != q.tail.Load() {
+			continue
+		}
+		if next != nil {
+			// another push linked its node but hasn't swung tail yet, help it along
+			q.tail.CompareAndSwap(tail, next)
+			continue
+		}
+		if tail.next.CompareAndSwap(nil, n) {
+			q.tail.CompareAndSwap(tail, n)
+			q.len.Add(1)
+			return
+		}
+	}
+}
+
+// Pop removes and returns the oldest value, ok is false if the queue is empty.
+func (q *LockFree[T]) Pop() (v T, ok bool) {
+	for {
+		head := q.head.Load()
+		tail := q.tail.Load()
+		next := head.next.Load()
+		if head != q.head.Load() {
+			continue
+		}
+		if next == nil {
+			return v, false
+		}
+		if head == tail {
+			// tail is lagging behind, fix it before moving head past it
+			q.tail.CompareAndSwap(tail, next)
+			continue
+		}
+		if q.head.CompareAndSwap(head, next) {
+			// next is the new dummy, its value is ours. The garbage collector makes
+			// the ABA problem of the original algorithm a non issue: a node can't
+			// be reused while anyone still holds a pointer to it.
+			q.len.Add(-1)
+			return next.v, true
+		}
+	}
+}
+
+// Len returns the number of values queued, only a hint while others push and pop.
+func (q *LockFree[T]) Len() int { return int(q.len.Load()) }
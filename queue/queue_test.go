@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/neilharia7/operating-systems-with-go/linearize"
+)
+
+type qIn struct {
+	Push bool
+	V    int
+}
+
+type qOut struct {
+	V  int
+	Ok bool
+}
+
+// queueModel is a FIFO queue, capacity 0 means unbounded.
+func queueModel(capacity int) linearize.Model[[]int, qIn, qOut] {
+	return linearize.Model[[]int, qIn, qOut]{
+		Init: func() []int { return nil },
+		Step: func(q []int, in qIn, out qOut) (bool, []int) {
+			if in.Push {
+				if capacity > 0 && len(q) == capacity {
+					return !out.Ok, q
+				}
+				next := make([]int, len(q)+1)
+				copy(next, q)
+				next[len(q)] = in.V
+				return out.Ok, next
+			}
+			if len(q) == 0 {
+				return !out.Ok, q
+			}
+			return out.Ok && out.V == q[0], q[1:]
+		},
+		Key: func(q []int) string { return fmt.Sprint(q) },
+		Describe: func(in qIn, out qOut) string {
+			switch {
+			case in.Push:
+				return fmt.Sprintf("push(%v) -> %v", in.V, out.Ok)
+			case out.Ok:
+				return fmt.Sprintf("pop() -> %v", out.V)
+			}
+			return "pop() -> empty"
+		},
+	}
+}
+
+// fifo is what the tests need of a queue.
+type fifo interface {
+	push(v int) bool
+	pop() (int, bool)
+}
+
+type lockFree struct{ q *LockFree[int] }
+
+func (l lockFree) push(v int) bool  { l.q.Push(v); return true }
+func (l lockFree) pop() (int, bool) { return l.q.Pop() }
+
+type bounded struct{ q *Bounded[int] }
+
+func (b bounded) push(v int) bool  { return b.q.TryPush(v) }
+func (b bounded) pop() (int, bool) { return b.q.TryPop() }
+
+// decode turns fuzz input into at most 64 operations, an even byte is a push of a
+// value unique to the operation, an odd one a pop. Longer histories make the
+// linearizability search slow without finding more.
+func decode(data []byte) []qIn {
+	ops := make([]qIn, 0, min(len(data), 64))
+	for i, b := range data[:min(len(data), 64)] {
+		ops = append(ops, qIn{Push: b%2 == 0, V: i})
+	}
+	return ops
+}
+
+// checkSequential applies ops one at a time and compares every result with the
+// model.
+func checkSequential(t *testing.T, q fifo, capacity int, ops []qIn) {
+	m := queueModel(capacity)
+	state := m.Init()
+	for i, in := range ops {
+		var out qOut
+		if in.Push {
+			out.Ok = q.push(in.V)
+		} else {
+			out.V, out.Ok = q.pop()
+		}
+		ok, next := m.Step(state, in, out)
+		if !ok {
+			t.Fatalf("op %v: %v on %v, not what a FIFO queue does", i, m.Describe(in, out), state)
+		}
+		state = next
+	}
+}
+
+// runConcurrent deals ops out round robin to clients that run them at once and
+// returns the recorded history.
+func runConcurrent(q fifo, clients int, ops []qIn) []linearize.Op[qIn, qOut] {
+	var r linearize.Recorder[qIn, qOut]
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := c; i < len(ops); i += clients {
+				in := ops[i]
+				call := r.Begin(c, in)
+				// let the others in between the recorded call and the real one, so
+				// operations overlap even on one core
+				if i%3 == 0 {
+					runtime.Gosched()
+				}
+				var out qOut
+				if in.Push {
+					out.Ok = q.push(in.V)
+				} else {
+					out.V, out.Ok = q.pop()
+				}
+				call.End(out)
+			}
+		}(c)
+	}
+	wg.Wait()
+	return r.History()
+}
+
+// requireLinearizable fails the test with the end of the longest order found and
+// the operations none of which could come next.
+func requireLinearizable[S, I, O any](t *testing.T, m linearize.Model[S, I, O], hist []linearize.Op[I, O]) {
+	t.Helper()
+	res := linearize.Check(m, hist)
+	if res.Ok {
+		return
+	}
+	msg := fmt.Sprintf("not linearizable, the longest order found has %v of %v operations, it ends with\n", len(res.Longest), len(res.Failed))
+	for _, op := range res.Longest[max(len(res.Longest)-5, 0):] {
+		msg += fmt.Sprintf("  client %v [%v,%v] %v\n", op.Client, op.Call, op.Return, m.Describe(op.Input, op.Output))
+	}
+	msg += "and none of these can come next:\n"
+	for _, op := range res.Stuck {
+		msg += fmt.Sprintf("  client %v [%v,%v] %v\n", op.Client, op.Call, op.Return, m.Describe(op.Input, op.Output))
+	}
+	t.Fatal(msg)
+}
+
+var seeds = [][]byte{
+	{},
+	{0, 1},
+	{1, 1, 0, 0, 2, 1, 1, 1},
+	{0, 2, 4, 6, 8, 10, 1, 3, 5, 7, 9, 11},
+	{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+}
+
+func FuzzLockFree(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops := decode(data)
+		checkSequential(t, lockFree{NewLockFree[int]()}, 0, ops)
+		requireLinearizable(t, queueModel(0), runConcurrent(lockFree{NewLockFree[int]()}, 3, ops))
+	})
+}
+
+func FuzzBounded(f *testing.F) {
+	for _, s := range seeds {
+		f.Add(uint8(3), s)
+	}
+	f.Fuzz(func(t *testing.T, capacity uint8, data []byte) {
+		c := 1 + int(capacity%8)
+		ops := decode(data)
+		checkSequential(t, bounded{NewBounded[int](c)}, c, ops)
+		requireLinearizable(t, queueModel(c), runConcurrent(bounded{NewBounded[int](c)}, 3, ops))
+	})
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/neilharia7/operating-systems-with-go/linearize"
+)
+
+// brokenQueue checks and acts in two separate critical sections: no data race, but
+// two pops can return the same value. The checker has to catch it.
+type brokenQueue struct {
+	mu    sync.Mutex
+	items []int
+}
+
+func (q *brokenQueue) push(v int) bool {
+	q.mu.Lock()
+	q.items = append(q.items, v)
+	q.mu.Unlock()
+	return true
+}
+
+func (q *brokenQueue) pop() (int, bool) {
+	q.mu.Lock()
+	if len(q.items) == 0 {
+		q.mu.Unlock()
+		return 0, false
+	}
+	v := q.items[0]
+	q.mu.Unlock()
+	runtime.Gosched()
+	q.mu.Lock()
+	// whatever is at the head now goes, not necessarily v
+	if len(q.items) > 0 {
+		q.items = q.items[1:]
+	}
+	q.mu.Unlock()
+	return v, true
+}
+
+// randomOps returns n operations, a few more pops than pushes to keep the queue
+// short. Every pair of overlapping pushes doubles the orders the checker has to
+// consider until a pop tells them apart, on a long queue that's much later.
+func randomOps(seed int64, n int) []qIn {
+	rng := rand.New(rand.NewSource(seed))
+	ops := make([]qIn, n)
+	for i := range ops {
+		if rng.Intn(5) < 2 {
+			ops[i] = qIn{Push: true, V: i}
+		}
+	}
+	return ops
+}
+
+func stressRounds() int {
+	if testing.Short() {
+		return 2
+	}
+	return 10
+}
+
+func TestLockFreeStress(t *testing.T) {
+	for round := 0; round < stressRounds(); round++ {
+		hist := runConcurrent(lockFree{NewLockFree[int]()}, 4, randomOps(int64(round), 800))
+		requireLinearizable(t, queueModel(0), hist)
+	}
+}
+
+func TestBoundedStress(t *testing.T) {
+	for round := 0; round < stressRounds(); round++ {
+		hist := runConcurrent(bounded{NewBounded[int](4)}, 4, randomOps(int64(round), 800))
+		requireLinearizable(t, queueModel(4), hist)
+	}
+}
+
+func TestBrokenQueueCaught(t *testing.T) {
+	for round := 0; round < 10; round++ {
+		res := linearize.Check(queueModel(0), runConcurrent(&brokenQueue{}, 4, randomOps(int64(round), 800)))
+		if res.Ok {
+			continue
+		}
+		if len(res.Stuck) == 0 {
+			t.Fatal("failed check names no stuck operations")
+		}
+		return
+	}
+	t.Fatal("a queue whose pops can return the same value passed every round")
+}
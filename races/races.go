@@ -0,0 +1,287 @@
+//go:build go1.21
+
+// Package races is a gallery of data races, each next to its fix.
+//
+//	map-rw         - one goroutine reads a map while another updates it (the runtime
+//	                 may kill the program: concurrent map read and map write)
+//	check-then-act - "if balance >= amount { balance -= amount }" by many tellers
+//	                 at once, the check is stale by the time they act
+//	rmw            - counter++ is a read, an add and a write, increments get lost
+//	lazy-init      - "if cfg == nil { cfg = load() }", loaded more than once
+//	map-iter       - ranging over a map while another goroutine writes to it
+//	slice-append   - two appends to the same slice write the same backing array
+//	loop-capture   - goroutines started in a loop read the loop variable, which the
+//	                 loop keeps changing. The go1.21 build line pins this file to
+//	                 the old semantics, one variable for the whole loop. With go 1.22
+//	                 semantics every iteration gets its own and this one fixes itself.
+//
+// go test -race runs every fixed variant and expects no report, and every racy one
+// in a child process of its own, expecting one there. Without -race only the fixed
+// variants run, the racy ones need the detector to fail reliably.
+package races
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Variant runs a case once and returns whether it went wrong this time and what
+// happened.
+type Variant func() (bad bool, detail string)
+
+// Case is a race and its fix.
+type Case struct {
+	Name   string
+	Racy   Variant
+	Fixed  Variant
+	Crashy bool // the racy variant can take the whole program down
+}
+
+// mapRW is the original program: a reader and an updater on the same map.
+func mapRW(racy bool) (bool, string) {
+	m := map[int]int{0: 0}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100000; i++ {
+			if i%1000 == 0 {
+				runtime.Gosched() // interleave like two cores would
+			}
+			if racy {
+				_ = m[0]
+			} else {
+				mu.Lock()
+				_ = m[0]
+				mu.Unlock()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100000; i++ {
+			if i%1000 == 0 {
+				runtime.Gosched()
+			}
+			if racy {
+				m[0] = m[0] + 1
+			} else {
+				mu.Lock()
+				m[0] = m[0] + 1
+				mu.Unlock()
+			}
+		}
+	}()
+	wg.Wait()
+	return m[0] != 100000, fmt.Sprintf("m[0] = %v after 100000 updates", m[0])
+}
+
+func checkThenAct(racy bool) (bool, string) {
+	negative := 0
+	for round := 0; round < 200; round++ {
+		balance := 100
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for t := 0; t < 5; t++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if racy {
+					if balance >= 30 {
+						runtime.Gosched() // the teller looks away for a moment
+						balance -= 30
+					}
+					return
+				}
+				// the check and the act under one lock
+				mu.Lock()
+				if balance >= 30 {
+					balance -= 30
+				}
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		if balance < 0 {
+			negative++
+		}
+	}
+	return negative > 0, fmt.Sprintf("%v of 200 accounts ended below zero (5 tellers withdrawing 30 from 100)", negative)
+}
+
+func readModifyWrite(racy bool) (bool, string) {
+	const goroutines, adds = 8, 10000
+	var counter int
+	var safe atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				if !racy {
+					safe.Add(1)
+					continue
+				}
+				// counter++ spelled out
+				n := counter
+				if i%100 == 0 {
+					runtime.Gosched()
+				}
+				counter = n + 1
+			}
+		}()
+	}
+	wg.Wait()
+	got := counter
+	if !racy {
+		got = int(safe.Load())
+	}
+	return got != goroutines*adds, fmt.Sprintf("counter = %v, expected %v", got, goroutines*adds)
+}
+
+type config struct{ name string }
+
+func lazyInit(racy bool) (bool, string) {
+	var loads atomic.Int64
+	load := func() *config {
+		loads.Add(1)
+		runtime.Gosched() // loading takes a while
+		return &config{name: "prod"}
+	}
+	var cfg *config
+	get := func() *config {
+		if cfg == nil {
+			cfg = load()
+		}
+		return cfg
+	}
+	if !racy {
+		get = sync.OnceValue(load)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = get().name
+		}()
+	}
+	wg.Wait()
+	return loads.Load() != 1, fmt.Sprintf("config loaded %v times by 8 goroutines", loads.Load())
+}
+
+func mapIter(racy bool) (bool, string) {
+	m := map[int]int{}
+	var mu sync.RWMutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20000; i++ {
+			if !racy {
+				mu.Lock()
+			}
+			m[i%512] = i
+			if !racy {
+				mu.Unlock()
+			}
+		}
+	}()
+	sums := 0
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if !racy {
+				mu.RLock()
+			}
+			for _, v := range m {
+				sums += v & 1
+			}
+			if !racy {
+				mu.RUnlock()
+			}
+			runtime.Gosched()
+		}
+	}()
+	wg.Wait()
+	return false, fmt.Sprintf("iterated 200 times while writing, %v keys at the end", len(m))
+}
+
+func sliceAppend(racy bool) (bool, string) {
+	aliased := 0
+	for round := 0; round < 100; round++ {
+		base := make([]int, 0, 8)
+		base = append(base, 1, 2, 3)
+		if !racy {
+			// a full slice expression caps capacity at length, the appends copy
+			base = base[:len(base):len(base)]
+		}
+		var a, b []int
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); a = append(base, 10) }()
+		go func() { defer wg.Done(); b = append(base, 20) }()
+		wg.Wait()
+		if a[3] != 10 || b[3] != 20 {
+			aliased++
+		}
+	}
+	return aliased > 0, fmt.Sprintf("%v of 100 rounds: appending to one slice changed the other", aliased)
+}
+
+func loopCapture(racy bool) (bool, string) {
+	var mu sync.Mutex
+	var seen []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		if racy {
+			go func() {
+				defer wg.Done()
+				mu.Lock()
+				seen = append(seen, i)
+				mu.Unlock()
+			}()
+			continue
+		}
+		go func(i int) {
+			defer wg.Done()
+			mu.Lock()
+			seen = append(seen, i)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	sort.Ints(seen)
+	bad := false
+	for j, v := range seen {
+		bad = bad || v != j
+	}
+	return bad, fmt.Sprintf("goroutines for 0..9 saw %v", seen)
+}
+
+func both(fn func(bool) (bool, string)) (Variant, Variant) {
+	return func() (bool, string) { return fn(true) }, func() (bool, string) { return fn(false) }
+}
+
+// Gallery returns the cases in the order they're listed above.
+func Gallery() []Case {
+	var cases []Case
+	add := func(name string, crashy bool, fn func(bool) (bool, string)) {
+		racy, fixed := both(fn)
+		cases = append(cases, Case{Name: name, Racy: racy, Fixed: fixed, Crashy: crashy})
+	}
+	add("map-rw", true, mapRW)
+	add("check-then-act", false, checkThenAct)
+	add("rmw", false, readModifyWrite)
+	add("lazy-init", false, lazyInit)
+	add("map-iter", true, mapIter)
+	add("slice-append", false, sliceAppend)
+	add("loop-capture", false, loopCapture)
+	return cases
+}
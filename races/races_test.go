@@ -0,0 +1,18 @@
+//go:build go1.21
+
+package races
+
+import "testing"
+
+// TestFixed runs every fixed variant, under -race the detector fails the test if a
+// fix still races.
+func TestFixed(t *testing.T) {
+	for _, c := range Gallery() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if bad, detail := c.Fixed(); bad {
+				t.Errorf("fixed variant went wrong: %v", detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+//go:build race && go1.21
+
+package races
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// runRacy is set in the environment of a child process started by TestRacy, it
+// names the case whose racy variant the child runs instead of the tests.
+const runRacy = "RACES_RUN_RACY"
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv(runRacy); name != "" {
+		for _, c := range Gallery() {
+			if c.Name == name {
+				_, detail := c.Racy()
+				fmt.Println(detail)
+				os.Exit(0)
+			}
+		}
+		fmt.Printf("no case %q\n", name)
+		os.Exit(3)
+	}
+	os.Exit(m.Run())
+}
+
+// TestRacy runs every racy variant in a child process of this test binary, built
+// with -race like this one, and expects the detector's report. A race in this
+// process would fail every test, and the map cases may abort the whole program.
+func TestRacy(t *testing.T) {
+	for _, c := range Gallery() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^$")
+			cmd.Env = append(os.Environ(), runRacy+"="+c.Name, "GORACE=atexit_sleep_ms=0")
+			out, err := cmd.CombinedOutput()
+			text := string(out)
+			switch {
+			case strings.Contains(text, "WARNING: DATA RACE"):
+			case c.Crashy && strings.Contains(text, "concurrent map"):
+			default:
+				t.Fatalf("no race reported (%v):\n%s", err, text)
+			}
+			if err == nil {
+				t.Errorf("child exited cleanly after a race report")
+			}
+		})
+	}
+}
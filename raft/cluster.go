@@ -0,0 +1,185 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+)
+
+// Config describes a cluster.
+type Config struct {
+	Nodes int
+	// ElectionTimeout is the minimum, each node picks a random timeout between it
+	// and twice it every time its timer is reset.
+	ElectionTimeout time.Duration
+	Heartbeat       time.Duration
+	Seed            int64
+	Chaos           *chaos.Injector
+	// Logf, if set, receives a line for every election and step down.
+	Logf func(format string, args ...any)
+}
+
+// Cluster is a set of nodes sharing a network.
+type Cluster struct {
+	cfg   Config
+	net   *Network
+	nodes []*Node
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	leaders map[int][]int // every node elected in each term, more than one is a bug
+}
+
+// NewCluster creates the nodes, all followers in term 0. Start sets them running.
+func NewCluster(cfg Config) *Cluster {
+	if cfg.Nodes < 1 {
+		cfg.Nodes = 3
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = 50 * time.Millisecond
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 3 * cfg.Heartbeat
+	}
+	c := &Cluster{cfg: cfg, net: newNetwork(cfg.Nodes, cfg.Chaos), stop: make(chan struct{}), leaders: map[int][]int{}}
+	for i := 0; i < cfg.Nodes; i++ {
+		nd := &Node{
+			id:         i,
+			n:          cfg.Nodes,
+			cluster:    c,
+			inbox:      c.net.inboxes[i],
+			rng:        rand.New(rand.NewSource(cfg.Seed + int64(i))),
+			votedFor:   -1,
+			leader:     -1,
+			log:        []Entry{{}},
+			nextIndex:  make([]int, cfg.Nodes),
+			matchIndex: make([]int, cfg.Nodes),
+		}
+		nd.resetElectionTimer()
+		c.nodes = append(c.nodes, nd)
+	}
+	return c
+}
+
+// Start runs every node on its own goroutine.
+func (c *Cluster) Start() {
+	for _, nd := range c.nodes {
+		c.wg.Add(1)
+		go func(nd *Node) {
+			defer c.wg.Done()
+			nd.run(c.stop)
+		}(nd)
+	}
+}
+
+// Stop stops all nodes and waits for them.
+func (c *Cluster) Stop() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+// Network returns the cluster's network, to partition it.
+func (c *Cluster) Network() *Network { return c.net }
+
+// Node returns node i.
+func (c *Cluster) Node(i int) *Node { return c.nodes[i] }
+
+// Status returns a snapshot of every node.
+func (c *Cluster) Status() []Status {
+	st := make([]Status, len(c.nodes))
+	for i, nd := range c.nodes {
+		st[i] = nd.Status()
+	}
+	return st
+}
+
+// Leader returns the leader with the highest term, if any node thinks it leads.
+// Right after a partition there can be two nodes that think so, only the one with the
+// higher term can still commit anything.
+func (c *Cluster) Leader() (id, term int, ok bool) {
+	id, term = -1, -1
+	for _, st := range c.Status() {
+		if st.State == Leader && st.Term > term {
+			id, term, ok = st.ID, st.Term, true
+		}
+	}
+	return id, term, ok
+}
+
+// WaitLeader waits up to timeout for a leader, optionally one other than not.
+func (c *Cluster) WaitLeader(not int, timeout time.Duration) (int, bool) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if id, _, ok := c.Leader(); ok && id != not {
+			return id, true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return -1, false
+}
+
+// WaitCommit waits up to timeout for every node in ids to have applied index.
+func (c *Cluster) WaitCommit(index int, ids []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		all := true
+		for _, id := range ids {
+			if c.nodes[id].Status().Commit < index {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func (c *Cluster) logf(format string, args ...any) {
+	if c.cfg.Logf != nil {
+		c.cfg.Logf(format, args...)
+	}
+}
+
+func (c *Cluster) elected(id, term int) {
+	c.mu.Lock()
+	c.leaders[term] = append(c.leaders[term], id)
+	c.mu.Unlock()
+	c.logf("n%v is leader for term %v", id, term)
+}
+
+// Check verifies the two safety properties that can be observed from outside:
+// election safety (at most one leader per term) and state machine safety (no two
+// nodes applied different entries at the same index).
+func (c *Cluster) Check() error {
+	c.mu.Lock()
+	for term, ids := range c.leaders {
+		if len(ids) > 1 {
+			c.mu.Unlock()
+			return fmt.Errorf("term %v had %v leaders: %v", term, len(ids), ids)
+		}
+	}
+	c.mu.Unlock()
+
+	logs := make([][]Entry, len(c.nodes))
+	for i, nd := range c.nodes {
+		logs[i] = nd.Applied()
+	}
+	for i := range logs {
+		for j := i + 1; j < len(logs); j++ {
+			for k := 0; k < min(len(logs[i]), len(logs[j])); k++ {
+				if logs[i][k] != logs[j][k] {
+					return fmt.Errorf("n%v and n%v applied different entries at index %v: %v vs %v",
+						i, j, k+1, logs[i][k], logs[j][k])
+				}
+			}
+		}
+	}
+	return nil
+}
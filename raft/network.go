@@ -0,0 +1,92 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+)
+
+// Network carries messages between the nodes of a cluster over channels. Every
+// message goes through the chaos injector (delay, drop) on its own goroutine, so
+// messages can overtake each other like on a real network. The fate of a message is
+// drawn when it's sent, from a chaos site per sending node. Partitions cut the
+// cluster into groups that can't reach each other until Heal.
+type Network struct {
+	sites   []*chaos.Injector // by sending node
+	inboxes []chan message
+
+	mu    sync.Mutex
+	group []int // partition group of every node, all zero when healed
+
+	sent, dropped, cut atomic.Int64
+}
+
+func newNetwork(n int, inj *chaos.Injector) *Network {
+	net := &Network{sites: make([]*chaos.Injector, n), inboxes: make([]chan message, n), group: make([]int, n)}
+	for i := range net.inboxes {
+		net.inboxes[i] = make(chan message, 1024)
+		net.sites[i] = inj.Site(fmt.Sprintf("node %d", i))
+	}
+	return net
+}
+
+func (n *Network) reachable(from, to int) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.group[from] == n.group[to]
+}
+
+func (n *Network) send(m message) {
+	n.sent.Add(1)
+	if !n.reachable(m.from, m.to) {
+		n.cut.Add(1)
+		return
+	}
+	site := n.sites[m.from]
+	if site.Drop() {
+		n.dropped.Add(1)
+		return
+	}
+	delay := site.Latency()
+	go func() {
+		time.Sleep(delay)
+		// the partition may have come up while the message was in flight
+		if !n.reachable(m.from, m.to) {
+			n.cut.Add(1)
+			return
+		}
+		select {
+		case n.inboxes[m.to] <- m:
+		default:
+			// a node that's that far behind loses messages, Raft retries anyway
+			n.dropped.Add(1)
+		}
+	}()
+}
+
+// Partition splits the cluster: nodes in the same group can talk, nodes in
+// different groups can't. Nodes not listed end up together in one more group.
+func (n *Network) Partition(groups ...[]int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := range n.group {
+		n.group[i] = 0
+	}
+	for g, members := range groups {
+		for _, id := range members {
+			n.group[id] = g + 1
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() { n.Partition() }
+
+// Stats returns how many messages were sent, dropped by chaos or a full inbox, and
+// cut by a partition.
+func (n *Network) Stats() (sent, dropped, cut int64) {
+	return n.sent.Load(), n.dropped.Load(), n.cut.Load()
+}
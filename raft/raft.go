@@ -0,0 +1,337 @@
+// Package raft is an in-process Raft: leader election and log replication, no
+// snapshots and no membership changes. Nodes are goroutines and the network is a set
+// of channels run through the chaos injector, so elections and replication can be
+// watched under delays, message loss and partitions.
+//
+// Each node runs one goroutine that handles its inbox and its timers; everything it
+// owns is behind a mutex so Propose and Status can be called from outside.
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// State is a node's role.
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	}
+	return "leader"
+}
+
+// Entry is a log entry.
+type Entry struct {
+	Term    int
+	Command string
+}
+
+// noop is appended by every new leader, an entry from its own term is what lets it
+// commit the entries it inherited from earlier terms.
+const noop = "(noop)"
+
+// maxBatch caps the entries sent in one AppendEntries.
+const maxBatch = 64
+
+type kind int
+
+const (
+	requestVote kind = iota
+	voteReply
+	appendEntries
+	appendReply
+)
+
+type message struct {
+	kind     kind
+	from, to int
+	term     int
+
+	// requestVote
+	lastIndex, lastTerm int
+	// voteReply
+	granted bool
+	// appendEntries
+	prevIndex, prevTerm int
+	entries             []Entry
+	commit              int
+	// appendReply: on success the follower's match index, on failure a hint where
+	// the leader should back up to
+	success bool
+	match   int
+}
+
+// Status is a snapshot of a node.
+type Status struct {
+	ID     int
+	State  State
+	Term   int
+	Leader int // who this node believes leads, -1 if unknown
+	Log    int // last log index
+	Commit int
+}
+
+func (s Status) String() string {
+	return fmt.Sprintf("n%v %-9v term %-3v log %-4v commit %v", s.ID, s.State, s.Term, s.Log, s.Commit)
+}
+
+// Node is one Raft server.
+type Node struct {
+	id      int
+	n       int
+	cluster *Cluster
+	inbox   chan message
+	rng     *rand.Rand
+
+	mu          sync.Mutex
+	state       State
+	term        int
+	votedFor    int
+	leader      int
+	log         []Entry // log[0] is a sentinel, indices start at 1
+	commitIndex int
+	applied     []Entry // committed entries, in order: the "state machine"
+	votes       int
+	nextIndex   []int
+	matchIndex  []int
+	deadline    time.Time // election timeout
+	lastBeat    time.Time
+}
+
+func (nd *Node) lastIndex() int { return len(nd.log) - 1 }
+
+func (nd *Node) resetElectionTimer() {
+	base := nd.cluster.cfg.ElectionTimeout
+	nd.deadline = time.Now().Add(base + time.Duration(nd.rng.Int63n(int64(base))))
+}
+
+func (nd *Node) send(m message) {
+	m.from, m.term = nd.id, nd.term
+	nd.cluster.net.send(m)
+}
+
+func (nd *Node) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(nd.cluster.cfg.Heartbeat / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case m := <-nd.inbox:
+			nd.mu.Lock()
+			nd.handle(m)
+			nd.mu.Unlock()
+		case now := <-ticker.C:
+			nd.mu.Lock()
+			switch {
+			case nd.state == Leader && now.Sub(nd.lastBeat) >= nd.cluster.cfg.Heartbeat:
+				nd.broadcastAppend()
+			case nd.state != Leader && now.After(nd.deadline):
+				nd.startElection()
+			}
+			nd.mu.Unlock()
+		}
+	}
+}
+
+func (nd *Node) becomeFollower(term int) {
+	nd.state = Follower
+	nd.term = term
+	nd.votedFor = -1
+	nd.leader = -1
+}
+
+func (nd *Node) startElection() {
+	nd.state = Candidate
+	nd.term++
+	nd.votedFor = nd.id
+	nd.votes = 1
+	nd.leader = -1
+	nd.resetElectionTimer()
+	nd.cluster.logf("n%v starts an election for term %v", nd.id, nd.term)
+	if nd.votes > nd.n/2 {
+		nd.becomeLeader()
+		return
+	}
+	for p := 0; p < nd.n; p++ {
+		if p != nd.id {
+			nd.send(message{kind: requestVote, to: p, lastIndex: nd.lastIndex(), lastTerm: nd.log[nd.lastIndex()].Term})
+		}
+	}
+}
+
+func (nd *Node) becomeLeader() {
+	nd.state = Leader
+	nd.leader = nd.id
+	for p := range nd.nextIndex {
+		nd.nextIndex[p] = nd.lastIndex() + 1
+		nd.matchIndex[p] = 0
+	}
+	nd.log = append(nd.log, Entry{Term: nd.term, Command: noop})
+	nd.matchIndex[nd.id] = nd.lastIndex()
+	nd.cluster.elected(nd.id, nd.term)
+	nd.broadcastAppend()
+}
+
+func (nd *Node) broadcastAppend() {
+	nd.lastBeat = time.Now()
+	for p := 0; p < nd.n; p++ {
+		if p != nd.id {
+			nd.sendAppend(p)
+		}
+	}
+	nd.advanceCommit()
+}
+
+func (nd *Node) sendAppend(p int) {
+	prev := nd.nextIndex[p] - 1
+	end := min(nd.lastIndex()+1, prev+1+maxBatch)
+	entries := append([]Entry(nil), nd.log[prev+1:end]...)
+	nd.send(message{kind: appendEntries, to: p, prevIndex: prev, prevTerm: nd.log[prev].Term,
+		entries: entries, commit: nd.commitIndex})
+}
+
+func (nd *Node) handle(m message) {
+	if m.term > nd.term {
+		if nd.state == Leader {
+			nd.cluster.logf("n%v steps down, saw term %v from n%v", nd.id, m.term, m.from)
+		}
+		nd.becomeFollower(m.term)
+	}
+	switch m.kind {
+	case requestVote:
+		myLast := nd.lastIndex()
+		upToDate := m.lastTerm > nd.log[myLast].Term || (m.lastTerm == nd.log[myLast].Term && m.lastIndex >= myLast)
+		granted := m.term == nd.term && (nd.votedFor == -1 || nd.votedFor == m.from) && upToDate
+		if granted {
+			nd.votedFor = m.from
+			nd.resetElectionTimer()
+		}
+		nd.send(message{kind: voteReply, to: m.from, granted: granted})
+
+	case voteReply:
+		if nd.state != Candidate || m.term != nd.term || !m.granted {
+			return
+		}
+		nd.votes++
+		if nd.votes > nd.n/2 {
+			nd.becomeLeader()
+		}
+
+	case appendEntries:
+		if m.term < nd.term {
+			nd.send(message{kind: appendReply, to: m.from, match: nd.lastIndex()})
+			return
+		}
+		// a valid leader for this term exists, candidates give up
+		nd.state = Follower
+		nd.leader = m.from
+		nd.resetElectionTimer()
+		if m.prevIndex > nd.lastIndex() || nd.log[m.prevIndex].Term != m.prevTerm {
+			nd.send(message{kind: appendReply, to: m.from, match: min(nd.lastIndex(), m.prevIndex-1)})
+			return
+		}
+		for i, e := range m.entries {
+			idx := m.prevIndex + 1 + i
+			if idx <= nd.lastIndex() {
+				if nd.log[idx].Term == e.Term {
+					continue
+				}
+				// conflicting entry: it and everything after it was never committed
+				nd.log = nd.log[:idx]
+			}
+			nd.log = append(nd.log, m.entries[i:]...)
+			break
+		}
+		match := m.prevIndex + len(m.entries)
+		if m.commit > nd.commitIndex {
+			nd.commitIndex = min(m.commit, match)
+			nd.apply()
+		}
+		nd.send(message{kind: appendReply, to: m.from, success: true, match: match})
+
+	case appendReply:
+		if nd.state != Leader || m.term != nd.term {
+			return
+		}
+		if m.success {
+			nd.matchIndex[m.from] = max(nd.matchIndex[m.from], m.match)
+			nd.nextIndex[m.from] = nd.matchIndex[m.from] + 1
+			nd.advanceCommit()
+			if nd.nextIndex[m.from] <= nd.lastIndex() {
+				nd.sendAppend(m.from)
+			}
+			return
+		}
+		nd.nextIndex[m.from] = max(1, min(nd.nextIndex[m.from]-1, m.match+1))
+		nd.sendAppend(m.from)
+	}
+}
+
+// advanceCommit commits the highest index of the current term stored on a majority.
+func (nd *Node) advanceCommit() {
+	for idx := nd.lastIndex(); idx > nd.commitIndex; idx-- {
+		if nd.log[idx].Term != nd.term {
+			// only entries of the leader's own term are committed by counting
+			// replicas, older ones get committed along with them
+			break
+		}
+		count := 0
+		for _, m := range nd.matchIndex {
+			if m >= idx {
+				count++
+			}
+		}
+		if count > nd.n/2 {
+			nd.commitIndex = idx
+			nd.apply()
+			return
+		}
+	}
+}
+
+func (nd *Node) apply() {
+	for len(nd.applied) < nd.commitIndex {
+		nd.applied = append(nd.applied, nd.log[len(nd.applied)+1])
+	}
+}
+
+// Propose appends cmd to the log if this node is the leader, returning the index it
+// will have once committed.
+func (nd *Node) Propose(cmd string) (index, term int, ok bool) {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	if nd.state != Leader {
+		return 0, nd.term, false
+	}
+	nd.log = append(nd.log, Entry{Term: nd.term, Command: cmd})
+	nd.matchIndex[nd.id] = nd.lastIndex()
+	return nd.lastIndex(), nd.term, true
+}
+
+// Status returns a snapshot of the node.
+func (nd *Node) Status() Status {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	return Status{ID: nd.id, State: nd.state, Term: nd.term, Leader: nd.leader, Log: nd.lastIndex(), Commit: nd.commitIndex}
+}
+
+// Applied returns a copy of the committed entries the node has applied.
+func (nd *Node) Applied() []Entry {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	return append([]Entry(nil), nd.applied...)
+}
@@ -0,0 +1,191 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/chaos"
+)
+
+const wait = 5 * time.Second
+
+func start(t *testing.T, cfg Config) *Cluster {
+	t.Helper()
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = 20 * time.Millisecond
+	}
+	c := NewCluster(cfg)
+	c.Start()
+	t.Cleanup(func() {
+		c.Stop()
+		if err := c.Check(); err != nil {
+			t.Error(err)
+		}
+	})
+	return c
+}
+
+func leader(t *testing.T, c *Cluster, not int) int {
+	t.Helper()
+	id, ok := c.WaitLeader(not, wait)
+	if !ok {
+		t.Fatalf("no leader other than n%v: %v", not, c.Status())
+	}
+	return id
+}
+
+// propose retries on whatever node leads until one takes cmd.
+func propose(t *testing.T, c *Cluster, cmd string) int {
+	t.Helper()
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		if id, _, ok := c.Leader(); ok {
+			if idx, _, ok := c.Node(id).Propose(cmd); ok {
+				return idx
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nobody took %q: %v", cmd, c.Status())
+	return 0
+}
+
+func commands(es []Entry) []string {
+	var cmds []string
+	for _, e := range es {
+		if e.Command != noop {
+			cmds = append(cmds, e.Command)
+		}
+	}
+	return cmds
+}
+
+func TestElectsOneLeader(t *testing.T) {
+	c := start(t, Config{Nodes: 5, Seed: 1})
+	id := leader(t, c, -1)
+	// let a few heartbeats go by, the leader should stay put
+	time.Sleep(10 * c.cfg.Heartbeat)
+	if now, _, _ := c.Leader(); now != id {
+		t.Errorf("leader moved from n%v to n%v without any failure", id, now)
+	}
+	for _, st := range c.Status() {
+		if st.ID != id && st.State == Leader {
+			t.Errorf("second leader: %v", st)
+		}
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	c := start(t, Config{Nodes: 1})
+	leader(t, c, -1)
+	idx := propose(t, c, "x")
+	if !c.WaitCommit(idx, []int{0}, wait) {
+		t.Fatalf("single node didn't commit its own entry: %v", c.Status())
+	}
+}
+
+func TestReplicates(t *testing.T) {
+	c := start(t, Config{Nodes: 3, Seed: 2})
+	leader(t, c, -1)
+	var want []string
+	last := 0
+	for i := 0; i < 200; i++ {
+		cmd := fmt.Sprint("cmd ", i)
+		want = append(want, cmd)
+		last = propose(t, c, cmd)
+	}
+	if !c.WaitCommit(last, []int{0, 1, 2}, wait) {
+		t.Fatalf("index %v not committed everywhere: %v", last, c.Status())
+	}
+	for i := 0; i < 3; i++ {
+		got := commands(c.Node(i).Applied())
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("n%v applied %v commands, want the %v proposed in order", i, len(got), len(want))
+		}
+	}
+}
+
+func TestNoCommitWithoutMajority(t *testing.T) {
+	c := start(t, Config{Nodes: 3, Seed: 3})
+	id := leader(t, c, -1)
+	c.Network().Partition([]int{id})
+	idx, _, ok := c.Node(id).Propose("lost")
+	if !ok {
+		t.Skip("leader stepped down before it was cut off")
+	}
+	time.Sleep(10 * c.cfg.Heartbeat)
+	if st := c.Node(id).Status(); st.Commit >= idx {
+		t.Fatalf("isolated leader committed index %v alone: %v", idx, st)
+	}
+}
+
+func TestReelectsAfterPartition(t *testing.T) {
+	c := start(t, Config{Nodes: 5, Seed: 4})
+	old := leader(t, c, -1)
+	_, oldTerm, _ := c.Leader()
+	c.Network().Partition([]int{old})
+	// the isolated node keeps thinking it leads in its old term, the majority moves on
+	var next int
+	deadline := time.Now().Add(wait)
+	for {
+		if id, term, ok := c.Leader(); ok && id != old && term > oldTerm {
+			next = id
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("majority didn't elect a new leader: %v", c.Status())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// stuck with the old leader: lost. Proposed to the new one: kept.
+	c.Node(old).Propose("stale")
+	idx, _, ok := c.Node(next).Propose("fresh")
+	if !ok {
+		t.Fatalf("new leader n%v refused a proposal", next)
+	}
+	var majority []int
+	for i := 0; i < 5; i++ {
+		if i != old {
+			majority = append(majority, i)
+		}
+	}
+	if !c.WaitCommit(idx, majority, wait) {
+		t.Fatalf("majority didn't commit: %v", c.Status())
+	}
+
+	c.Network().Heal()
+	if !c.WaitCommit(idx, []int{old}, wait) {
+		t.Fatalf("old leader didn't catch up after heal: %v", c.Status())
+	}
+	if st := c.Node(old).Status(); st.State == Leader && st.Term <= oldTerm {
+		t.Errorf("old leader still leads its stale term: %v", st)
+	}
+	for _, cmd := range commands(c.Node(old).Applied()) {
+		if cmd == "stale" {
+			t.Errorf("entry proposed to the isolated leader was applied")
+		}
+	}
+}
+
+func TestSafeUnderChaos(t *testing.T) {
+	inj := chaos.New(chaos.Config{Seed: 5, LatencyProb: 0.3, MaxLatency: 15 * time.Millisecond, DropProb: 0.1})
+	c := start(t, Config{Nodes: 5, Seed: 5, Chaos: inj})
+	stop := time.Now().Add(time.Second)
+	for i := 0; time.Now().Before(stop); i++ {
+		if id, _, ok := c.Leader(); ok {
+			c.Node(id).Propose(fmt.Sprint("cmd ", i))
+		}
+		if i%50 == 25 {
+			c.Network().Partition([]int{i % 5, (i + 1) % 5})
+		} else if i%50 == 0 {
+			c.Network().Heal()
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	c.Network().Heal()
+	// Check runs again on cleanup, after the nodes have stopped
+	if err := c.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package rwlock has a readers-writer lock that, unlike sync.RWMutex, can change
+// mode while it's held:
+//
+//	Downgrade  - write to read, atomically: no other writer gets in between, so a
+//	             writer can publish a change and go on reading exactly what it
+//	             published while other readers are let in.
+//	TryUpgrade - read to write, best effort. Two readers waiting to upgrade would
+//	             deadlock, each waiting for the other to let go of its read lock, so
+//	             only one upgrade can be pending at a time. The first reader to ask
+//	             waits for the other readers to leave and gets the write lock with
+//	             nothing written in between, a second one fails at once and still
+//	             holds its read lock: it has to RUnlock, Lock and check again
+//	             whatever it read, someone may have changed it meanwhile.
+//
+// Writers are preferred: once a writer (or an upgrade) is waiting, new readers wait
+// too, so a steady stream of readers can't starve writers. As with sync.RWMutex a
+// goroutine must not take the read lock twice, and Lock while holding the read lock
+// deadlocks, use TryUpgrade.
+package rwlock
+
+import "sync"
+
+// Stats counts mode changes and how they went.
+type Stats struct {
+	Upgrades       int64
+	FailedUpgrades int64 // another upgrade was already pending
+	Downgrades     int64
+}
+
+// RWMutex is the lock. The zero value is unlocked, it must not be copied after
+// first use.
+type RWMutex struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	readers        int
+	writer         bool
+	waitingWriters int
+	upgrading      bool // a reader is waiting in TryUpgrade
+
+	stats Stats
+}
+
+// wait blocks on the condition, l.mu must be held.
+func (l *RWMutex) wait() {
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+	l.cond.Wait()
+}
+
+// wake wakes everybody waiting, they recheck their own condition. l.mu must be held.
+func (l *RWMutex) wake() {
+	if l.cond != nil {
+		l.cond.Broadcast()
+	}
+}
+
+// Lock takes the write lock.
+func (l *RWMutex) Lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waitingWriters++
+	// a pending upgrade goes first, it already holds a read lock and is only
+	// waiting for the other readers
+	for l.writer || l.readers > 0 || l.upgrading {
+		l.wait()
+	}
+	l.waitingWriters--
+	l.writer = true
+}
+
+// Unlock releases the write lock.
+func (l *RWMutex) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.writer {
+		panic("rwlock: Unlock of a lock not held for writing")
+	}
+	l.writer = false
+	l.wake()
+}
+
+// RLock takes a read lock.
+func (l *RWMutex) RLock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.writer || l.waitingWriters > 0 || l.upgrading {
+		l.wait()
+	}
+	l.readers++
+}
+
+// RUnlock releases a read lock.
+func (l *RWMutex) RUnlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readers == 0 {
+		panic("rwlock: RUnlock of a lock not held for reading")
+	}
+	l.readers--
+	// the last reader lets a writer in, the second to last a pending upgrade
+	if l.readers == 0 || (l.upgrading && l.readers == 1) {
+		l.wake()
+	}
+}
+
+// Downgrade turns the write lock the caller holds into a read lock, without
+// letting any writer in between.
+func (l *RWMutex) Downgrade() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.writer {
+		panic("rwlock: Downgrade of a lock not held for writing")
+	}
+	l.writer = false
+	l.readers++
+	l.stats.Downgrades++
+	l.wake()
+}
+
+// TryUpgrade turns the read lock the caller holds into the write lock, waiting for
+// the other readers to leave. It fails, returning false with the read lock still
+// held, if another upgrade is already pending.
+func (l *RWMutex) TryUpgrade() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readers == 0 {
+		panic("rwlock: TryUpgrade of a lock not held for reading")
+	}
+	if l.upgrading {
+		l.stats.FailedUpgrades++
+		return false
+	}
+	l.upgrading = true
+	for l.readers > 1 {
+		l.wait()
+	}
+	l.upgrading = false
+	l.readers = 0
+	l.writer = true
+	l.stats.Upgrades++
+	return true
+}
+
+// Stats returns the counters so far.
+func (l *RWMutex) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
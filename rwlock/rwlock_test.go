@@ -0,0 +1,232 @@
+package rwlock
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor yields until cond, checked under l.mu, holds: a goroutine is known to be
+// blocked in the lock once the state says so.
+func waitFor(t *testing.T, l *RWMutex, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		ok := cond()
+		l.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %v", what)
+		}
+		runtime.Gosched()
+	}
+}
+
+// stillBlocked fails the test if ch is closed after giving everyone else a chance
+// to run.
+func stillBlocked(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		runtime.Gosched()
+	}
+	select {
+	case <-ch:
+		t.Fatalf("%v got in", what)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestDowngradeLetsNoWriterIn(t *testing.T) {
+	var l RWMutex
+	value := 0
+	l.Lock()
+
+	written := make(chan struct{})
+	go func() {
+		l.Lock()
+		value = 2
+		l.Unlock()
+		close(written)
+	}()
+	waitFor(t, &l, "the second writer waits", func() bool { return l.waitingWriters == 1 })
+
+	value = 1
+	l.Downgrade()
+	stillBlocked(t, written, "a writer waiting during Downgrade")
+	if value != 1 {
+		t.Fatalf("read back %v after Downgrade, published 1", value)
+	}
+	l.RUnlock()
+	<-written
+	if st := l.Stats(); st.Downgrades != 1 {
+		t.Errorf("Downgrades = %v, expected 1", st.Downgrades)
+	}
+}
+
+func TestDowngradeUnderLoad(t *testing.T) {
+	var l RWMutex
+	version := 0
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				v := w*1000 + i
+				l.Lock()
+				version = v
+				l.Downgrade()
+				runtime.Gosched()
+				got := version
+				l.RUnlock()
+				if got != v {
+					t.Errorf("published %v, read back %v", v, got)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestTryUpgradeOneWinner(t *testing.T) {
+	var l RWMutex
+	l.RLock() // the loser's read lock
+
+	upgraded := make(chan struct{})
+	go func() {
+		l.RLock()
+		if !l.TryUpgrade() {
+			t.Error("the first upgrade failed")
+		}
+		close(upgraded)
+		l.Unlock()
+	}()
+	waitFor(t, &l, "the first upgrade is pending", func() bool { return l.upgrading })
+
+	if l.TryUpgrade() {
+		t.Fatal("a second upgrade succeeded while one was pending")
+	}
+	l.mu.Lock()
+	readers := l.readers
+	l.mu.Unlock()
+	if readers != 2 {
+		t.Fatalf("%v readers after the failed upgrade, the loser should still hold its read lock", readers)
+	}
+	stillBlocked(t, upgraded, "the upgrade with another reader left")
+
+	l.RUnlock()
+	<-upgraded
+	st := l.Stats()
+	if st.Upgrades != 1 || st.FailedUpgrades != 1 {
+		t.Errorf("stats %+v, expected one upgrade and one failed upgrade", st)
+	}
+}
+
+func TestUpgradedCacheFillsOnce(t *testing.T) {
+	var l RWMutex
+	cache := map[int]int{}
+	var mu sync.Mutex
+	computed := map[int]int{}
+	fill := func(k int) {
+		mu.Lock()
+		computed[k]++
+		mu.Unlock()
+		cache[k] = k * k
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := (w*7 + i) % 50
+				l.RLock()
+				if _, ok := cache[k]; ok {
+					l.RUnlock()
+					continue
+				}
+				runtime.Gosched()
+				if l.TryUpgrade() {
+					fill(k)
+					l.Unlock()
+					continue
+				}
+				l.RUnlock()
+				l.Lock()
+				if _, ok := cache[k]; !ok {
+					fill(k)
+				}
+				l.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	for k := 0; k < 50; k++ {
+		if computed[k] != 1 {
+			t.Errorf("key %v computed %v times", k, computed[k])
+		}
+	}
+}
+
+func TestWritersPreferred(t *testing.T) {
+	var l RWMutex
+	l.RLock()
+
+	var mu sync.Mutex
+	var order []string
+	wrote, read := make(chan struct{}), make(chan struct{})
+	go func() {
+		l.Lock()
+		mu.Lock()
+		order = append(order, "writer")
+		mu.Unlock()
+		l.Unlock()
+		close(wrote)
+	}()
+	waitFor(t, &l, "the writer waits", func() bool { return l.waitingWriters == 1 })
+
+	go func() {
+		l.RLock()
+		mu.Lock()
+		order = append(order, "reader")
+		mu.Unlock()
+		l.RUnlock()
+		close(read)
+	}()
+	stillBlocked(t, read, "a new reader while a writer waits")
+
+	l.RUnlock()
+	<-wrote
+	<-read
+	if len(order) != 2 || order[0] != "writer" {
+		t.Fatalf("order %v, expected the waiting writer before the new reader", order)
+	}
+}
+
+func TestMisusePanics(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(l *RWMutex)
+	}{
+		{"Unlock", func(l *RWMutex) { l.Unlock() }},
+		{"RUnlock", func(l *RWMutex) { l.RUnlock() }},
+		{"Downgrade", func(l *RWMutex) { l.Downgrade() }},
+		{"TryUpgrade", func(l *RWMutex) { l.TryUpgrade() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v of an unlocked lock didn't panic", tt.name)
+				}
+			}()
+			tt.f(&RWMutex{})
+		})
+	}
+}
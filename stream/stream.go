@@ -0,0 +1,161 @@
+// Package stream has combinators that reshape bursty event channels.
+//
+// Both take an input channel and return an output channel that is closed once the
+// input is closed and anything pending has been delivered.
+//
+//	Debounce - waits for the input to go quiet for a while, a burst becomes one event
+//	Throttle - lets at most one event through per interval, a burst is thinned out
+//
+// With the Leading edge the first event of a burst goes out immediately, with the
+// Trailing edge the last one goes out once the burst (or interval) is over. Both
+// edges can be combined, a burst of a single event is then only delivered once.
+//
+// The timing comes from a clock.Clock, nil means the wall clock. With a clock.Fake
+// the quiet periods and intervals only pass when the fake is advanced. A quiet period
+// or interval that has ended is handled before an event that is also waiting, so the
+// outcome doesn't depend on which of the two the runtime picks.
+package stream
+
+import (
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/clock"
+)
+
+// Edge selects which events of a burst are delivered.
+type Edge int
+
+const (
+	Leading Edge = 1 << iota
+	Trailing
+)
+
+// Debounce delivers an event once no new event has arrived on in for wait.
+//
+// With Leading the first event of a burst is delivered right away and the burst is
+// considered over after wait of silence. With Trailing the last event of the burst is
+// delivered when it's over. Leading|Trailing delivers both, unless the burst was a
+// single event.
+func Debounce[T any](clk clock.Clock, in <-chan T, wait time.Duration, edge Edge) <-chan T {
+	clk = clock.Or(clk)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var (
+			timer   clock.Timer
+			quiet   <-chan time.Time // nil while no burst is in progress
+			last    T
+			pending bool // last hasn't been delivered yet
+		)
+		burstOver := func() {
+			quiet = nil
+			if pending && edge&Trailing != 0 {
+				out <- last
+			}
+			pending = false
+		}
+		for {
+			select {
+			case <-quiet:
+				burstOver()
+				continue
+			default:
+			}
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if pending && edge&Trailing != 0 {
+						out <- last
+					}
+					return
+				}
+				if quiet == nil {
+					timer = clk.NewTimer(wait)
+					quiet = timer.C()
+					if edge&Leading != 0 {
+						out <- v
+						continue
+					}
+				} else {
+					// every event restarts the quiet period
+					if !timer.Stop() {
+						<-timer.C()
+					}
+					timer.Reset(wait)
+				}
+				last, pending = v, true
+			case <-quiet:
+				burstOver()
+			}
+		}
+	}()
+	return out
+}
+
+// Throttle delivers at most one event per interval.
+//
+// With Leading the first event is delivered immediately and starts the interval,
+// events during the interval are suppressed. With Trailing the most recent suppressed
+// event is delivered when the interval ends, which starts the next interval.
+// Trailing alone delays every event to the end of an interval.
+func Throttle[T any](clk clock.Clock, in <-chan T, interval time.Duration, edge Edge) <-chan T {
+	clk = clock.Or(clk)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var (
+			ticker  clock.Ticker
+			tick    <-chan time.Time // nil while no interval is running
+			last    T
+			pending bool
+		)
+		start := func() {
+			ticker = clk.NewTicker(interval)
+			tick = ticker.C()
+		}
+		intervalOver := func() {
+			if pending && edge&Trailing != 0 {
+				out <- last
+				pending = false
+				// delivering an event starts a new interval, keep ticking
+				return
+			}
+			// nothing happened during the interval, go idle so the next event is
+			// treated as a fresh leading edge
+			ticker.Stop()
+			ticker, tick = nil, nil
+			pending = false
+		}
+		for {
+			select {
+			case <-tick:
+				intervalOver()
+				continue
+			default:
+			}
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if ticker != nil {
+						ticker.Stop()
+					}
+					if pending && edge&Trailing != 0 {
+						out <- last
+					}
+					return
+				}
+				if tick == nil {
+					start()
+					if edge&Leading != 0 {
+						out <- v
+						continue
+					}
+				}
+				last, pending = v, true
+			case <-tick:
+				intervalOver()
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,161 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neilharia7/operating-systems-with-go/clock"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type event struct {
+	v  int
+	at time.Duration // fake time since start
+}
+
+// harness feeds a combinator on a fake clock and notes the fake time of every
+// event that comes out. The test only moves the clock once what it expects by then
+// has come out, so every event's time is exact.
+type harness struct {
+	t    *testing.T
+	edge Edge
+	f    *clock.Fake
+	in   chan int
+	got  chan event
+}
+
+func newHarness(t *testing.T, edge Edge, combinator func(clock.Clock, <-chan int) <-chan int) *harness {
+	h := &harness{t: t, edge: edge, f: clock.NewFake(start), in: make(chan int), got: make(chan event, 100)}
+	out := combinator(h.f, h.in)
+	go func() {
+		defer close(h.got)
+		for v := range out {
+			h.got <- event{v, h.f.Since(start)}
+		}
+	}()
+	return h
+}
+
+func (h *harness) send(v int) { h.in <- v }
+
+// due waits for the combinator to arm its timer or ticker for d.
+func (h *harness) due(d time.Duration) { h.f.BlockUntilDue(start.Add(d)) }
+
+func (h *harness) advanceTo(d time.Duration) { h.f.Set(start.Add(d)) }
+
+// emit expects v to come out right now, if the harness's edge is one of edges.
+func (h *harness) emit(v int, edges ...Edge) {
+	h.t.Helper()
+	for _, e := range edges {
+		if e == h.edge {
+			h.expect(event{v, h.f.Since(start)})
+			return
+		}
+	}
+}
+
+func (h *harness) expect(want event) {
+	h.t.Helper()
+	select {
+	case got, ok := <-h.got:
+		if !ok {
+			h.t.Fatalf("output closed, expected %v at %v", want.v, want.at)
+		}
+		if got != want {
+			h.t.Fatalf("got %v at %v, expected %v at %v", got.v, got.at, want.v, want.at)
+		}
+	case <-time.After(5 * time.Second):
+		h.t.Fatalf("nothing came out, expected %v at %v", want.v, want.at)
+	}
+}
+
+// close closes the input and expects nothing more than a final v, if the edge is
+// one of edges, before the output is closed.
+func (h *harness) close(v int, edges ...Edge) {
+	h.t.Helper()
+	close(h.in)
+	h.emit(v, edges...)
+	for got := range h.got {
+		h.t.Fatalf("unexpected %v at %v", got.v, got.at)
+	}
+}
+
+var edges = map[string]Edge{"leading": Leading, "trailing": Trailing, "both": Leading | Trailing}
+
+func TestDebounce(t *testing.T) {
+	const all = Leading | Trailing
+	for name, edge := range edges {
+		t.Run(name, func(t *testing.T) {
+			h := newHarness(t, edge, func(clk clock.Clock, in <-chan int) <-chan int {
+				return Debounce(clk, in, 100*time.Millisecond, edge)
+			})
+			// a burst, every event restarts the quiet period
+			h.send(1)
+			h.due(100 * time.Millisecond)
+			h.emit(1, Leading, all)
+			h.advanceTo(50 * time.Millisecond)
+			h.send(2)
+			h.due(150 * time.Millisecond)
+			h.advanceTo(100 * time.Millisecond)
+			h.send(3)
+			h.due(200 * time.Millisecond)
+			h.advanceTo(199 * time.Millisecond)
+			h.advanceTo(200 * time.Millisecond)
+			h.emit(3, Trailing, all)
+
+			// a burst of one, delivered once on both edges
+			h.advanceTo(400 * time.Millisecond)
+			h.send(4)
+			h.due(500 * time.Millisecond)
+			h.emit(4, Leading, all)
+			h.advanceTo(500 * time.Millisecond)
+			h.emit(4, Trailing)
+
+			// closing in the middle of a burst delivers its trailing event
+			h.advanceTo(600 * time.Millisecond)
+			h.send(5)
+			h.due(700 * time.Millisecond)
+			h.emit(5, Leading, all)
+			h.advanceTo(610 * time.Millisecond)
+			h.send(6)
+			h.due(710 * time.Millisecond)
+			h.close(6, Trailing, all)
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const all = Leading | Trailing
+	for name, edge := range edges {
+		t.Run(name, func(t *testing.T) {
+			h := newHarness(t, edge, func(clk clock.Clock, in <-chan int) <-chan int {
+				return Throttle(clk, in, 100*time.Millisecond, edge)
+			})
+			// three events in one interval
+			h.send(1)
+			h.due(100 * time.Millisecond)
+			h.emit(1, Leading, all)
+			h.advanceTo(30 * time.Millisecond)
+			h.send(2)
+			h.advanceTo(60 * time.Millisecond)
+			h.send(3)
+			h.advanceTo(100 * time.Millisecond)
+			// the most recent suppressed event ends the interval and starts the next
+			h.emit(3, Trailing, all)
+			// an interval without events makes it go idle
+			h.advanceTo(200 * time.Millisecond)
+
+			// so the next event is a fresh leading edge
+			h.advanceTo(250 * time.Millisecond)
+			h.send(4)
+			h.due(350 * time.Millisecond)
+			h.emit(4, Leading, all)
+			h.advanceTo(350 * time.Millisecond)
+			// trailing alone delays it to the end of its interval
+			h.emit(4, Trailing)
+			h.advanceTo(500 * time.Millisecond)
+			h.close(0)
+		})
+	}
+}
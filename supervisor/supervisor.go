@@ -0,0 +1,300 @@
+//go:build unix
+
+// Package supervisor starts child processes and controls them with signals:
+//
+//	Pause  - SIGSTOP, the kernel stops scheduling the child (it can't be caught)
+//	Resume - SIGCONT, it picks up where it left off
+//	Reload - SIGUSR1, by convention the child re-reads its config
+//	Stop   - SIGTERM, then SIGKILL if it hasn't exited within the grace period
+//
+// A child that exits on its own is restarted when the supervisor was asked to, after
+// a backoff that doubles with every restart, so a child that crashes on startup
+// doesn't spin. One that needs more than MaxRestarts restarts within Window is given
+// up on and left Failed.
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// State of a child process.
+type State string
+
+const (
+	Running State = "running"
+	Paused  State = "paused"
+	Stopped State = "stopped"
+	Exited  State = "exited"
+	// Restarting is a child that exited, waiting out its backoff.
+	Restarting State = "restarting"
+	// Failed is a child that was restarted too often and given up on.
+	Failed State = "failed"
+)
+
+var ErrUnknownChild = errors.New("supervisor: unknown child")
+
+// Spec describes how to run a child.
+type Spec struct {
+	Name    string
+	Path    string
+	Args    []string
+	Restart bool
+	// Output receives the child's stdout and stderr.
+	Output io.Writer
+	// Backoff is the wait before the first restart, it doubles with every restart
+	// up to MaxBackoff and starts over once the child stays up for MaxBackoff.
+	// Default 100ms and 10s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxRestarts within Window is the restart intensity, one restart more and the
+	// child is left Failed. Default 5 in 30s, a negative MaxRestarts has no limit.
+	MaxRestarts int
+	Window      time.Duration
+}
+
+// Status is a snapshot of a child.
+type Status struct {
+	Name     string
+	Pid      int
+	State    State
+	Restarts int
+	LastExit string
+}
+
+type child struct {
+	spec     Spec
+	cmd      *exec.Cmd
+	state    State
+	restarts int
+	lastExit string
+	exited   chan struct{}
+	stopping bool
+
+	started  time.Time
+	backoff  time.Duration // the last one waited, zero after a stable run
+	recent   []time.Time   // restarts within Window
+	restartT *time.Timer   // pending restart, while Restarting
+}
+
+// Supervisor owns a set of children. It is safe for concurrent use.
+type Supervisor struct {
+	mu       sync.Mutex
+	children map[string]*child
+	grace    time.Duration
+}
+
+// New returns a supervisor that gives children grace to exit after SIGTERM.
+func New(grace time.Duration) *Supervisor {
+	return &Supervisor{children: map[string]*child{}, grace: grace}
+}
+
+// Start launches a new child.
+func (s *Supervisor) Start(spec Spec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.children[spec.Name]; ok {
+		return fmt.Errorf("supervisor: child %q already exists", spec.Name)
+	}
+	if spec.Backoff <= 0 {
+		spec.Backoff = 100 * time.Millisecond
+	}
+	if spec.MaxBackoff <= 0 {
+		spec.MaxBackoff = 10 * time.Second
+	}
+	spec.MaxBackoff = max(spec.MaxBackoff, spec.Backoff)
+	if spec.MaxRestarts == 0 {
+		spec.MaxRestarts = 5
+	}
+	if spec.Window <= 0 {
+		spec.Window = 30 * time.Second
+	}
+	c := &child{spec: spec}
+	if err := s.spawn(c); err != nil {
+		return err
+	}
+	s.children[spec.Name] = c
+	return nil
+}
+
+// spawn starts the child's process, s.mu must be held.
+func (s *Supervisor) spawn(c *child) error {
+	cmd := exec.Command(c.spec.Path, c.spec.Args...)
+	cmd.Stdout, cmd.Stderr = c.spec.Output, c.spec.Output
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("supervisor: start %v: %w", c.spec.Name, err)
+	}
+	c.cmd, c.state, c.exited, c.started = cmd, Running, make(chan struct{}), time.Now()
+	go s.wait(c, cmd, c.exited)
+	return nil
+}
+
+func (s *Supervisor) wait(c *child, cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(exited)
+	if err != nil {
+		c.lastExit = err.Error()
+	} else {
+		c.lastExit = "exit status 0"
+	}
+	if c.stopping {
+		c.state = Stopped
+		return
+	}
+	c.state = Exited
+	if c.spec.Restart {
+		s.scheduleRestart(c)
+	}
+}
+
+// scheduleRestart restarts c after its backoff, or gives up on it if it has been
+// restarted too often. s.mu must be held.
+func (s *Supervisor) scheduleRestart(c *child) {
+	now := time.Now()
+	kept := c.recent[:0]
+	for _, t := range c.recent {
+		if now.Sub(t) < c.spec.Window {
+			kept = append(kept, t)
+		}
+	}
+	c.recent = kept
+	if c.spec.MaxRestarts >= 0 && len(c.recent) >= c.spec.MaxRestarts {
+		c.state = Failed
+		c.lastExit += fmt.Sprintf(", gave up after %v restarts in %v", len(c.recent), c.spec.Window)
+		return
+	}
+
+	switch {
+	case c.backoff == 0 || now.Sub(c.started) >= c.spec.MaxBackoff:
+		// the first crash, or the first after a stable run
+		c.backoff = c.spec.Backoff
+	default:
+		c.backoff = min(2*c.backoff, c.spec.MaxBackoff)
+	}
+	c.state = Restarting
+	c.restartT = time.AfterFunc(c.backoff, func() { s.restart(c) })
+}
+
+func (s *Supervisor) restart(c *child) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.state != Restarting {
+		// stopped while waiting
+		return
+	}
+	c.restarts++
+	c.recent = append(c.recent, time.Now())
+	if err := s.spawn(c); err != nil {
+		// counts against the restart intensity like a crash
+		c.lastExit = err.Error()
+		c.started = time.Now()
+		s.scheduleRestart(c)
+	}
+}
+
+// signal sends sig to a child in one of the from states and moves it to to, or
+// leaves its state alone if to is empty. Checking, signaling and updating the state
+// all happen under s.mu, so a concurrent Pause or Resume can't get in between.
+func (s *Supervisor) signal(name string, sig syscall.Signal, from []State, to State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.children[name]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownChild, name)
+	}
+	allowed := false
+	for _, st := range from {
+		allowed = allowed || c.state == st
+	}
+	if !allowed {
+		return fmt.Errorf("supervisor: %v is %v", name, c.state)
+	}
+	if err := c.cmd.Process.Signal(sig); err != nil {
+		return fmt.Errorf("supervisor: %v %v: %w", sig, name, err)
+	}
+	if to != "" {
+		c.state = to
+	}
+	return nil
+}
+
+// Pause stops the child with SIGSTOP.
+func (s *Supervisor) Pause(name string) error {
+	return s.signal(name, syscall.SIGSTOP, []State{Running}, Paused)
+}
+
+// Resume continues a paused child with SIGCONT.
+func (s *Supervisor) Resume(name string) error {
+	return s.signal(name, syscall.SIGCONT, []State{Paused}, Running)
+}
+
+// Reload sends SIGUSR1. A paused child only sees it once it's resumed.
+func (s *Supervisor) Reload(name string) error {
+	return s.signal(name, syscall.SIGUSR1, []State{Running, Paused}, "")
+}
+
+// Stop asks the child to exit with SIGTERM and kills it after the grace period.
+func (s *Supervisor) Stop(name string) error {
+	s.mu.Lock()
+	c, ok := s.children[name]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w %q", ErrUnknownChild, name)
+	}
+	switch c.state {
+	case Restarting:
+		c.restartT.Stop()
+		c.state = Stopped
+		fallthrough
+	case Stopped, Exited, Failed:
+		s.mu.Unlock()
+		return nil
+	}
+	c.stopping = true
+	proc, exited, paused := c.cmd.Process, c.exited, c.state == Paused
+	s.mu.Unlock()
+
+	proc.Signal(syscall.SIGTERM)
+	if paused {
+		// a stopped process can't act on SIGTERM until it runs again
+		proc.Signal(syscall.SIGCONT)
+	}
+	select {
+	case <-exited:
+	case <-time.After(s.grace):
+		proc.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// StopAll stops every child.
+func (s *Supervisor) StopAll() {
+	for _, st := range s.Status() {
+		s.Stop(st.Name)
+	}
+}
+
+// Status lists the children sorted by name.
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.children))
+	for _, c := range s.children {
+		st := Status{Name: c.spec.Name, State: c.state, Restarts: c.restarts, LastExit: c.lastExit}
+		if c.cmd != nil && c.cmd.Process != nil {
+			st.Pid = c.cmd.Process.Pid
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
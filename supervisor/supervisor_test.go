@@ -0,0 +1,182 @@
+//go:build unix
+
+package supervisor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func start(t *testing.T, s *Supervisor, spec Spec) {
+	t.Helper()
+	if err := s.Start(spec); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.StopAll)
+}
+
+func status(t *testing.T, s *Supervisor, name string) Status {
+	t.Helper()
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	t.Fatalf("no child %v", name)
+	return Status{}
+}
+
+// waitState polls until the child is in state, failing after timeout.
+func waitState(t *testing.T, s *Supervisor, name string, state State, timeout time.Duration) Status {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		st := status(t, s, name)
+		if st.State == state {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v is %v after %v, expected %v", name, st.State, timeout, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func sh(script string) Spec {
+	return Spec{Name: "c", Path: "/bin/sh", Args: []string{"-c", script}}
+}
+
+func TestSignals(t *testing.T) {
+	s := New(time.Second)
+	start(t, s, sh(`trap "" USR1; sleep 10`))
+	if err := s.Start(sh("sleep 10")); err == nil {
+		t.Fatal("a second child with the same name")
+	}
+	if err := s.Resume("c"); err == nil {
+		t.Error("Resume of a running child")
+	}
+	if err := s.Pause("c"); err != nil {
+		t.Fatal(err)
+	}
+	// Reload leaves the state alone, the child sees it once it runs again
+	if err := s.Reload("c"); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, s, "c"); st.State != Paused {
+		t.Fatalf("state %v after Pause and Reload", st.State)
+	}
+	if err := s.Resume("c"); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, s, "c"); st.State != Running {
+		t.Fatalf("state %v after Resume", st.State)
+	}
+	if err := s.Pause("nobody"); !errors.Is(err, ErrUnknownChild) {
+		t.Errorf("Pause of an unknown child: %v", err)
+	}
+}
+
+func TestStopKillsAfterGrace(t *testing.T) {
+	s := New(50 * time.Millisecond)
+	start(t, s, sh(`trap "" TERM; while :; do sleep 0.01; done`))
+	time.Sleep(50 * time.Millisecond) // let sh set the trap
+	begin := time.Now()
+	if err := s.Stop("c"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d < 50*time.Millisecond {
+		t.Errorf("Stop returned after %v, before the grace period", d)
+	}
+	if st := status(t, s, "c"); st.State != Stopped || !strings.Contains(st.LastExit, "killed") {
+		t.Errorf("status after Stop: %+v", st)
+	}
+}
+
+func TestStopPausedChild(t *testing.T) {
+	s := New(5 * time.Second)
+	start(t, s, sh("sleep 10"))
+	s.Pause("c")
+	begin := time.Now()
+	s.Stop("c")
+	if d := time.Since(begin); d > 2*time.Second {
+		t.Errorf("stopping a paused child took %v, SIGTERM wasn't acted on", d)
+	}
+}
+
+func TestNoRestart(t *testing.T) {
+	s := New(time.Second)
+	start(t, s, sh("exit 3"))
+	st := waitState(t, s, "c", Exited, 5*time.Second)
+	if st.Restarts != 0 || st.LastExit != "exit status 3" {
+		t.Errorf("status %+v", st)
+	}
+}
+
+func TestRestartBacksOff(t *testing.T) {
+	s := New(time.Second)
+	spec := sh("exit 1")
+	spec.Restart = true
+	spec.Backoff, spec.MaxBackoff = 20*time.Millisecond, 80*time.Millisecond
+	spec.MaxRestarts, spec.Window = 4, time.Minute
+	begin := time.Now()
+	start(t, s, spec)
+
+	st := waitState(t, s, "c", Failed, 5*time.Second)
+	// 20, 40, 80 and 80 again: capped
+	if d := time.Since(begin); d < 220*time.Millisecond {
+		t.Errorf("4 restarts in %v, backoff not honoured", d)
+	}
+	if st.Restarts != 4 || !strings.Contains(st.LastExit, "gave up after 4 restarts") {
+		t.Errorf("status %+v", st)
+	}
+	// Failed stays Failed
+	time.Sleep(200 * time.Millisecond)
+	if st := status(t, s, "c"); st.State != Failed || st.Restarts != 4 {
+		t.Errorf("a failed child came back: %+v", st)
+	}
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	s := New(time.Second)
+	spec := sh("exit 1")
+	spec.Restart, spec.Backoff = true, time.Hour
+	start(t, s, spec)
+	waitState(t, s, "c", Restarting, 5*time.Second)
+	if err := s.Stop("c"); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(t, s, "c"); st.State != Stopped || st.Restarts != 0 {
+		t.Errorf("status after Stop during the backoff: %+v", st)
+	}
+}
+
+func TestStableRunResetsBackoff(t *testing.T) {
+	s := New(time.Second)
+	// crashes at once the first two times, then runs for a while before crashing
+	dir := t.TempDir()
+	spec := sh(`n=$(cat ` + dir + `/n 2>/dev/null || echo 0); echo $((n+1)) > ` + dir + `/n
+	if [ $n -ge 2 ]; then sleep 0.2; fi; exit 1`)
+	spec.Restart = true
+	spec.Backoff, spec.MaxBackoff = 10*time.Millisecond, 100*time.Millisecond
+	spec.MaxRestarts = -1
+	start(t, s, spec)
+
+	// restarts 1 and 2 wait 10 and 20ms, run 3 stays up 200ms, so restart 3
+	// waits 10ms again
+	waitState(t, s, "c", Running, time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for status(t, s, "c").Restarts < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("no third restart")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.mu.Lock()
+	backoff := s.children["c"].backoff
+	s.mu.Unlock()
+	if backoff != 10*time.Millisecond {
+		t.Errorf("backoff %v after a stable run, expected it back at 10ms", backoff)
+	}
+}
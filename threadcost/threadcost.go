@@ -0,0 +1,149 @@
+//go:build linux
+
+// Package threadcost measures what OS threads and goroutines cost, behind
+// Scripts/thread_vs_goroutine.go:
+//
+//	creation   - time to get N of them up and parked
+//	memory     - resident memory added per thread/goroutine (VmRSS from /proc/self/status)
+//	switch     - one way latency of a ping-pong between two of them over channels
+//
+// Raw OS threads are made without cgo: every "thread" is a goroutine that calls
+// runtime.LockOSThread and then parks in a blocking read(2) on a pipe. A goroutine stuck
+// in a syscall pins its M, so the runtime has to create a new OS thread for each one,
+// which is exactly the cost of thread-per-task. For the switch test both ends are
+// locked to their own thread, so every handoff is a futex wake and a real kernel
+// context switch instead of a cheap goroutine switch inside the scheduler.
+package threadcost
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// procStatus reads a numeric field (e.g. "Threads", "VmRSS" in kB) from /proc/self/status.
+func procStatus(field string) int64 {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), field+":"); ok {
+			n, _ := strconv.ParseInt(strings.Fields(v)[0], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// Cost is what it took to get n threads or goroutines up and parked.
+type Cost struct {
+	Create time.Duration
+	// RSSKB is the resident memory they added, in kB. It only counts touched
+	// pages, a C pthread also reserves 8M of stack address space by default.
+	RSSKB int64
+}
+
+// Goroutines starts n goroutines that park on a channel.
+func Goroutines(n int) Cost {
+	runtime.GC()
+	before := procStatus("VmRSS")
+	release := make(chan struct{})
+	var started, done sync.WaitGroup
+	start := time.Now()
+	started.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			<-release
+		}()
+	}
+	started.Wait()
+	c := Cost{Create: time.Since(start), RSSKB: procStatus("VmRSS") - before}
+	close(release)
+	done.Wait()
+	return c
+}
+
+// Threads starts n OS threads that park in a read(2), see the package comment.
+func Threads(n int) (Cost, error) {
+	runtime.GC()
+	before := procStatus("VmRSS")
+	writers := make([]int, 0, n)
+	release := func() {
+		for _, w := range writers {
+			syscall.Write(w, []byte{1})
+			syscall.Close(w)
+		}
+	}
+	var locked, done sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		var p [2]int
+		if err := syscall.Pipe(p[:]); err != nil {
+			release()
+			done.Wait()
+			return Cost{}, err
+		}
+		writers = append(writers, p[1])
+		locked.Add(1)
+		done.Add(1)
+		go func(r int) {
+			defer done.Done()
+			runtime.LockOSThread()
+			locked.Done()
+			buf := make([]byte, 1)
+			syscall.Read(r, buf)
+			syscall.Close(r)
+			// returning without unlocking makes the runtime throw the thread away
+		}(p[0])
+	}
+	// each one holds a thread of its own once it's locked. Counting the process'
+	// threads instead would miss the idle ones the runtime hands out first.
+	locked.Wait()
+	c := Cost{Create: time.Since(start), RSSKB: procStatus("VmRSS") - before}
+	release()
+	done.Wait()
+	return c, nil
+}
+
+// PingPong bounces a token between two goroutines and returns the one way latency.
+// With lockThreads each end is locked to an OS thread of its own.
+func PingPong(switches int, lockThreads bool) time.Duration {
+	ping, pong := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if lockThreads {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+		}
+		for range ping {
+			pong <- struct{}{}
+		}
+	}()
+
+	if lockThreads {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+	}
+	start := time.Now()
+	for i := 0; i < switches/2; i++ {
+		ping <- struct{}{}
+		<-pong
+	}
+	elapsed := time.Since(start)
+	close(ping)
+	wg.Wait()
+	return elapsed / time.Duration(switches)
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package threadcost
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGoroutines(t *testing.T) {
+	before := procStatus("Threads")
+	c := Goroutines(1000)
+	if c.Create <= 0 {
+		t.Fatalf("creation took %v", c.Create)
+	}
+	// parked goroutines share the threads there are, they don't make new ones
+	if after := procStatus("Threads"); after > before+2 {
+		t.Errorf("%v threads before, %v after parking goroutines", before, after)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	before := procStatus("Threads")
+	if before == 0 {
+		t.Skip("no Threads in /proc/self/status")
+	}
+	const n = 40
+	c, err := Threads(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Create <= 0 {
+		t.Fatalf("creation took %v", c.Create)
+	}
+	// every thread exited locked, the runtime throws them away instead of keeping
+	// them idle. It may keep a few it made to run everything else meanwhile.
+	deadline := time.Now().Add(5 * time.Second)
+	for procStatus("Threads") >= before+n/2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%v threads before, still %v after they were released", before, procStatus("Threads"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	for _, locked := range []bool{false, true} {
+		if d := PingPong(1000, locked); d <= 0 {
+			t.Errorf("locked=%v: switch took %v", locked, d)
+		}
+	}
+}
+
+func TestProcStatus(t *testing.T) {
+	if procStatus("VmRSS") <= 0 {
+		t.Error("no VmRSS in /proc/self/status")
+	}
+	if procStatus("NoSuchField") != 0 {
+		t.Error("a missing field should read as 0")
+	}
+}
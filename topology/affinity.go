@@ -0,0 +1,75 @@
+//go:build linux
+
+package topology
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// cpuSet is the kernel's cpu_set_t, room for 1024 CPUs.
+type cpuSet [1024 / 64]uint64
+
+// Pin restricts the calling OS thread to cpus. Call runtime.LockOSThread first,
+// otherwise the goroutine moves on to some other thread and the pin stays behind.
+func Pin(cpus []int) error {
+	var set cpuSet
+	for _, c := range cpus {
+		if c < 0 || c >= len(set)*64 {
+			return fmt.Errorf("topology: cpu %v out of range", c)
+		}
+		set[c/64] |= 1 << (c % 64)
+	}
+	// pid 0 is the calling thread
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set)))
+	if errno != 0 {
+		return fmt.Errorf("topology: sched_setaffinity: %w", errno)
+	}
+	return nil
+}
+
+// Affinity returns the CPUs the calling thread may run on.
+func Affinity() ([]int, error) {
+	var set cpuSet
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(set), uintptr(unsafe.Pointer(&set)))
+	if errno != 0 {
+		return nil, fmt.Errorf("topology: sched_getaffinity: %w", errno)
+	}
+	var cpus []int
+	for c := 0; c < len(set)*64; c++ {
+		if set[c/64]&(1<<(c%64)) != 0 {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+// PageNodes reports which NUMA node holds the pages of buf, sampling at most
+// samples pages: node id -> pages. Pages not faulted in yet don't count.
+func PageNodes(buf []byte, samples int) (map[int]int, error) {
+	page := syscall.Getpagesize()
+	n := len(buf) / page
+	if n == 0 {
+		return nil, nil
+	}
+	step := max(n/max(samples, 1), 1)
+	var addrs []uintptr
+	for i := 0; i < n; i += step {
+		addrs = append(addrs, uintptr(unsafe.Pointer(&buf[i*page])))
+	}
+	status := make([]int32, len(addrs))
+	// move_pages with no target nodes only reports where the pages are
+	_, _, errno := syscall.Syscall6(syscall.SYS_MOVE_PAGES, 0, uintptr(len(addrs)),
+		uintptr(unsafe.Pointer(&addrs[0])), 0, uintptr(unsafe.Pointer(&status[0])), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("topology: move_pages: %w", errno)
+	}
+	out := map[int]int{}
+	for _, s := range status {
+		if s >= 0 {
+			out[int(s)]++
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,263 @@
+//go:build linux
+
+// Package topology reads the machine's processor and memory layout from sysfs:
+//
+//	/sys/devices/system/cpu/cpuN/topology  package, core and hyperthread siblings
+//	/sys/devices/system/cpu/cpuN/cache     the cache levels and who shares them
+//	/sys/devices/system/node/nodeN         NUMA nodes: their CPUs, memory and the
+//	                                       distance table between nodes
+//
+// On a NUMA machine every node has its own memory controller. A CPU reaches its own
+// node's memory directly and the other nodes' through the interconnect, slower and
+// with less bandwidth (distance 10 is local, 20 or 21 is typical for one hop). The
+// kernel puts a page on the node of the CPU that touches it first, so where a
+// goroutine's OS thread runs decides where its memory ends up. Pin pins the calling
+// thread, PageNodes tells where pages actually are.
+package topology
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CPU is one logical processor.
+type CPU struct {
+	ID       int
+	Package  int   // physical socket
+	Core     int   // core id within the package
+	Node     int   // NUMA node, 0 if the kernel has no NUMA support
+	Siblings []int // logical CPUs on the same core (hyperthreads), itself included
+}
+
+// Cache is one cache instance, shared by the CPUs listed.
+type Cache struct {
+	Level int
+	Type  string // Data, Instruction or Unified
+	Size  int64  // bytes
+	CPUs  []int
+}
+
+// Node is a NUMA node.
+type Node struct {
+	ID       int
+	CPUs     []int
+	MemTotal int64 // bytes
+	MemFree  int64
+	// Distance to every node by id, 10 for itself.
+	Distance []int
+}
+
+// Topology is what Read found.
+type Topology struct {
+	CPUs   []CPU
+	Caches []Cache
+	Nodes  []Node
+}
+
+// Read reads the topology of this machine.
+func Read() (*Topology, error) { return ReadFrom("/sys") }
+
+// ReadFrom reads the topology from a sysfs mounted at root, a copy of it works too.
+func ReadFrom(root string) (*Topology, error) {
+	cpuDir := filepath.Join(root, "devices/system/cpu")
+	online, err := readList(filepath.Join(cpuDir, "online"))
+	if err != nil {
+		return nil, err
+	}
+	t := &Topology{}
+	nodeOf := map[int]int{}
+	nodeDir := filepath.Join(root, "devices/system/node")
+	nodes, _ := readList(filepath.Join(nodeDir, "online"))
+	for _, id := range nodes {
+		dir := filepath.Join(nodeDir, fmt.Sprintf("node%d", id))
+		n := Node{ID: id}
+		if n.CPUs, err = readList(filepath.Join(dir, "cpulist")); err != nil {
+			return nil, err
+		}
+		for _, c := range n.CPUs {
+			nodeOf[c] = id
+		}
+		n.MemTotal, n.MemFree = readNodeMeminfo(filepath.Join(dir, "meminfo"))
+		if s, err := readString(filepath.Join(dir, "distance")); err == nil {
+			for _, f := range strings.Fields(s) {
+				d, _ := strconv.Atoi(f)
+				n.Distance = append(n.Distance, d)
+			}
+		}
+		t.Nodes = append(t.Nodes, n)
+	}
+	if len(t.Nodes) == 0 {
+		// no NUMA support compiled in: everything is one node
+		t.Nodes = []Node{{ID: 0, CPUs: online, Distance: []int{10}}}
+	}
+
+	caches := map[string]bool{}
+	for _, id := range online {
+		dir := filepath.Join(cpuDir, fmt.Sprintf("cpu%d", id))
+		c := CPU{ID: id, Node: nodeOf[id]}
+		c.Package, _ = readInt(filepath.Join(dir, "topology/physical_package_id"))
+		c.Core, _ = readInt(filepath.Join(dir, "topology/core_id"))
+		if c.Siblings, err = readList(filepath.Join(dir, "topology/thread_siblings_list")); err != nil {
+			c.Siblings = []int{id}
+		}
+		t.CPUs = append(t.CPUs, c)
+
+		indexes, _ := filepath.Glob(filepath.Join(dir, "cache/index*"))
+		for _, idx := range indexes {
+			shared, _ := readString(filepath.Join(idx, "shared_cpu_list"))
+			level, _ := readInt(filepath.Join(idx, "level"))
+			typ, _ := readString(filepath.Join(idx, "type"))
+			key := fmt.Sprintf("%v/%v/%v", level, typ, shared)
+			if caches[key] {
+				continue
+			}
+			caches[key] = true
+			size, _ := readString(filepath.Join(idx, "size"))
+			cpus, _ := ParseList(shared)
+			t.Caches = append(t.Caches, Cache{Level: level, Type: typ, Size: parseSize(size), CPUs: cpus})
+		}
+	}
+	sort.Slice(t.Caches, func(i, j int) bool {
+		a, b := t.Caches[i], t.Caches[j]
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.CPUs[0] < b.CPUs[0]
+	})
+	return t, nil
+}
+
+// Node returns the node with the given id.
+func (t *Topology) Node(id int) (Node, bool) {
+	for _, n := range t.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+// Cores counts the physical cores.
+func (t *Topology) Cores() int {
+	seen := map[[2]int]bool{}
+	for _, c := range t.CPUs {
+		seen[[2]int{c.Package, c.Core}] = true
+	}
+	return len(seen)
+}
+
+// Packages counts the sockets.
+func (t *Topology) Packages() int {
+	seen := map[int]bool{}
+	for _, c := range t.CPUs {
+		seen[c.Package] = true
+	}
+	return len(seen)
+}
+
+// ParseList parses the kernel's CPU list format, "0-3,8,10-11".
+func ParseList(s string) ([]int, error) {
+	var out []int
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("topology: bad cpu list %q", s)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(hi); err != nil || b < a {
+				return nil, fmt.Errorf("topology: bad cpu list %q", s)
+			}
+		}
+		for i := a; i <= b; i++ {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
+
+// FormatList is the inverse of ParseList.
+func FormatList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func readString(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	return strings.TrimSpace(string(b)), err
+}
+
+func readInt(path string) (int, error) {
+	s, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+func readList(path string) ([]int, error) {
+	s, err := readString(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseList(s)
+}
+
+// parseSize reads cache sizes like "32K" or "8192K".
+func parseSize(s string) int64 {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult, s = 1<<10, strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1<<20, strings.TrimSuffix(s, "M")
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n * mult
+}
+
+// readNodeMeminfo reads lines like "Node 0 MemTotal:  16314180 kB".
+func readNodeMeminfo(path string) (total, free int64) {
+	s, err := readString(path)
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(s, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 4 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(f[3], 10, 64)
+		switch f[2] {
+		case "MemTotal:":
+			total = kb << 10
+		case "MemFree:":
+			free = kb << 10
+		}
+	}
+	return total, free
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package treecopy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// chunk is the most a single copy_file_range or sendfile call is asked to move,
+// small enough that progress is steady, big enough that the syscalls don't show.
+const chunk = 16 << 20
+
+// copyFileRange copies n bytes from src to dst inside the kernel. On filesystems
+// that support it (btrfs, xfs, NFS 4.2...) this can be a reflink or a server side
+// copy that moves no data at all.
+func copyFileRange(dst, src *os.File, n int64) (int64, error) {
+	trap := sysCopyFileRange
+	if trap < 0 {
+		return 0, syscall.ENOSYS
+	}
+	var done int64
+	for done < n {
+		r, _, errno := syscall.Syscall6(uintptr(trap), src.Fd(), 0, dst.Fd(), 0, uintptr(min(n-done, chunk)), 0)
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			return done, errno
+		}
+		if r == 0 {
+			break // the file shrank under us
+		}
+		done += int64(r)
+	}
+	return done, nil
+}
+
+// sendfile copies n bytes from src to dst through the page cache without bringing
+// them into user space. It has accepted a regular file as the destination since
+// Linux 2.6.33.
+func sendfile(dst, src *os.File, n int64) (int64, error) {
+	var done int64
+	for done < n {
+		r, err := syscall.Sendfile(int(dst.Fd()), int(src.Fd()), nil, int(min(n-done, chunk)))
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.EAGAIN {
+				continue
+			}
+			return done, err
+		}
+		if r == 0 {
+			break
+		}
+		done += int64(r)
+	}
+	return done, nil
+}
+
+// buffered is the portable way: read into a buffer, write it out.
+func buffered(dst, src *os.File, buf []byte) (int64, error) {
+	// plain Reader and Writer so io.CopyBuffer doesn't take its own fast path
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
+// unsupported tells whether a fast path failed because it can't do this copy, as
+// opposed to an I/O error. Nothing has been written in that case.
+func unsupported(err error) bool {
+	return errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.EBADF)
+}
@@ -0,0 +1,4 @@
+package treecopy
+
+// the syscall package predates copy_file_range and has no number for it
+const sysCopyFileRange = 326
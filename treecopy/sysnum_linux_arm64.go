@@ -0,0 +1,3 @@
+package treecopy
+
+const sysCopyFileRange = 285
@@ -0,0 +1,6 @@
+//go:build linux && !amd64 && !arm64
+
+package treecopy
+
+// unknown here, copies fall back to sendfile
+const sysCopyFileRange = -1
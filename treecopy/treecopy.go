@@ -0,0 +1,304 @@
+//go:build linux
+
+// Package treecopy copies directory trees with a pool of workers.
+//
+// Every regular file is copied with the cheapest method the kernel offers for it:
+//
+//	copy_file_range - the data never leaves the kernel, and filesystems that can
+//	                  share extents or copy server side don't move it at all
+//	sendfile        - in kernel too, through the page cache, for when
+//	                  copy_file_range can't (different filesystems on old kernels)
+//	buffered        - read(2) into a buffer and write(2) it out, works everywhere
+//
+// A method that reports it can't handle a file (ENOSYS, EXDEV, EINVAL...) before
+// copying anything falls through to the next one. Modes, ownership (when allowed)
+// and timestamps are carried over, symlinks are recreated rather than followed.
+//
+// Every file being copied holds two descriptors, the number of files open at once is
+// capped so a big tree doesn't run the process into its RLIMIT_NOFILE.
+package treecopy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Method is a way to copy file data.
+type Method int
+
+const (
+	Auto Method = iota // copy_file_range, then sendfile, then buffered
+	CopyFileRange
+	Sendfile
+	Buffered
+)
+
+func (m Method) String() string {
+	switch m {
+	case Auto:
+		return "auto"
+	case CopyFileRange:
+		return "copy_file_range"
+	case Sendfile:
+		return "sendfile"
+	case Buffered:
+		return "buffered"
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// ParseMethod is the inverse of Method.String.
+func ParseMethod(s string) (Method, error) {
+	for m := Auto; m <= Buffered; m++ {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("treecopy: unknown method %q", s)
+}
+
+// Options tune a copy.
+type Options struct {
+	Workers int // files copied in parallel, 8 by default
+	// MaxOpen caps the descriptors held by workers, 2 per file in flight. It's
+	// raised to 2 if lower and Workers is cut down to MaxOpen/2.
+	MaxOpen int
+	// Method forces one way of copying, fast paths that can't do a file fall
+	// through to the next method anyway.
+	Method     Method
+	BufferSize int // for buffered copies, 1MB by default
+}
+
+// Stats counts what a copy did.
+type Stats struct {
+	Files, Dirs, Symlinks int64
+	Bytes                 int64
+	ByMethod              [Buffered + 1]int64 // files per method
+	PeakOpen              int64
+	Elapsed               time.Duration
+}
+
+// Throughput returns the bytes copied per second.
+func (s Stats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+type copier struct {
+	opts    Options
+	bufs    sync.Pool
+	open    atomic.Int64
+	peak    atomic.Int64
+	files   atomic.Int64
+	bytes   atomic.Int64
+	methods [Buffered + 1]atomic.Int64
+}
+
+// Copy copies the tree at src to dst, which must not exist yet. Errors don't stop
+// the copy, they're all returned together at the end.
+func Copy(src, dst string, opts Options) (Stats, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	if opts.MaxOpen <= 0 {
+		opts.MaxOpen = 2 * opts.Workers
+	}
+	opts.MaxOpen = max(opts.MaxOpen, 2)
+	opts.Workers = min(opts.Workers, opts.MaxOpen/2)
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1 << 20
+	}
+	c := &copier{opts: opts}
+	c.bufs.New = func() any { return make([]byte, opts.BufferSize) }
+
+	var st Stats
+	start := time.Now()
+	type job struct {
+		src, dst string
+		info     fs.FileInfo
+	}
+	jobs := make(chan job)
+	var mu sync.Mutex
+	var errs []error
+	fail := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := c.copyFile(j.src, j.dst, j.info); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	// directories get their metadata once everything inside them is written,
+	// creating files would bump their mtime again
+	type dir struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dir
+	walkErr := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fail(err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			fail(err)
+			return nil
+		}
+		switch {
+		case d.IsDir():
+			// writable for now so we can fill it in even when the source isn't
+			if err := os.Mkdir(target, info.Mode().Perm()|0o700); err != nil {
+				if path == src {
+					return err
+				}
+				fail(err)
+				return filepath.SkipDir
+			}
+			st.Dirs++
+			dirs = append(dirs, dir{target, info})
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err == nil {
+				err = os.Symlink(link, target)
+			}
+			if err == nil {
+				err = copyMeta(target, info, true)
+			}
+			if err != nil {
+				fail(err)
+				return nil
+			}
+			st.Symlinks++
+		case d.Type().IsRegular():
+			jobs <- job{path, target, info}
+		default:
+			fail(fmt.Errorf("treecopy: skipping %v: %v", path, d.Type()))
+		}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	if walkErr != nil {
+		return st, walkErr
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyMeta(dirs[i].path, dirs[i].info, false); err != nil {
+			fail(err)
+		}
+	}
+
+	st.Files, st.Bytes, st.PeakOpen = c.files.Load(), c.bytes.Load(), c.peak.Load()
+	for m := range st.ByMethod {
+		st.ByMethod[m] = c.methods[m].Load()
+	}
+	st.Elapsed = time.Since(start)
+	return st, errors.Join(errs...)
+}
+
+func (c *copier) track(n int64) {
+	now := c.open.Add(n)
+	for {
+		peak := c.peak.Load()
+		if now <= peak || c.peak.CompareAndSwap(peak, now) {
+			return
+		}
+	}
+}
+
+func (c *copier) copyFile(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	c.track(1)
+	defer func() { in.Close(); c.track(-1) }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm()|0o200)
+	if err != nil {
+		return err
+	}
+	c.track(1)
+	defer func() { out.Close(); c.track(-1) }()
+
+	size := info.Size()
+	var n int64
+	method := c.opts.Method
+	if method == Auto {
+		method = CopyFileRange
+	}
+	for {
+		switch method {
+		case CopyFileRange:
+			n, err = copyFileRange(out, in, size)
+		case Sendfile:
+			n, err = sendfile(out, in, size)
+		default:
+			buf := c.bufs.Get().([]byte)
+			n, err = buffered(out, in, buf)
+			c.bufs.Put(buf)
+		}
+		if method < Buffered && n == 0 && err != nil && unsupported(err) {
+			method++
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return fmt.Errorf("treecopy: %v: %v: %w", src, method, err)
+	}
+	c.methods[method].Add(1)
+	c.files.Add(1)
+	c.bytes.Add(n)
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return copyMeta(dst, info, false)
+}
+
+// copyMeta carries mode, owner and timestamps over. Changing the owner needs
+// privileges, without them the copy simply belongs to us.
+func copyMeta(path string, info fs.FileInfo, link bool) error {
+	sys, _ := info.Sys().(*syscall.Stat_t)
+	if sys != nil {
+		if err := os.Lchown(path, int(sys.Uid), int(sys.Gid)); err != nil && !errors.Is(err, syscall.EPERM) {
+			return err
+		}
+	}
+	if link {
+		// symlinks have no mode of their own and os.Chtimes would follow them
+		return nil
+	}
+	if err := os.Chmod(path, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	atime := info.ModTime()
+	if sys != nil {
+		atime = time.Unix(sys.Atim.Unix())
+	}
+	return os.Chtimes(path, atime, info.ModTime())
+}
@@ -0,0 +1,174 @@
+//go:build linux
+
+package treecopy
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// tree builds a source tree: nested directories, files of a few sizes (one bigger
+// than a buffered copy's buffer), odd modes, a symlink and an old mtime.
+func tree(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "src")
+	rng := rand.New(rand.NewSource(1))
+	for i, size := range []int{0, 1, 4096, 100000, 3 << 20} {
+		dir := filepath.Join(src, fmt.Sprint("d", i%2), fmt.Sprint("e", i%3))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		rng.Read(data)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprint("f", i)), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, "script"), []byte("#!/bin/sh\n"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("d0/e0/f0", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "script"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "d1"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// same fails unless dst mirrors src: contents, modes, mtimes and link targets.
+func same(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		want, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		got, err := os.Lstat(target)
+		if err != nil {
+			return err
+		}
+		if got.Mode() != want.Mode() {
+			t.Errorf("%v: mode %v, want %v", rel, got.Mode(), want.Mode())
+		}
+		switch {
+		case want.Mode()&fs.ModeSymlink != 0:
+			wl, _ := os.Readlink(path)
+			gl, _ := os.Readlink(target)
+			if gl != wl {
+				t.Errorf("%v: link to %q, want %q", rel, gl, wl)
+			}
+			return nil
+		case want.Mode().IsRegular():
+			a, _ := os.ReadFile(path)
+			b, _ := os.ReadFile(target)
+			if !bytes.Equal(a, b) {
+				t.Errorf("%v: contents differ (%v bytes, want %v)", rel, len(b), len(a))
+			}
+		}
+		if !got.ModTime().Equal(want.ModTime()) {
+			t.Errorf("%v: mtime %v, want %v", rel, got.ModTime(), want.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := tree(t)
+	for m := Auto; m <= Buffered; m++ {
+		t.Run(m.String(), func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "dst")
+			st, err := Copy(src, dst, Options{Method: m, BufferSize: 64 << 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			same(t, src, dst)
+			if st.Files != 6 || st.Symlinks != 1 || st.Dirs != 8 {
+				t.Errorf("files %v symlinks %v dirs %v, want 6, 1 and 8", st.Files, st.Symlinks, st.Dirs)
+			}
+			if st.Bytes != 0+1+4096+100000+3<<20+10 {
+				t.Errorf("copied %v bytes", st.Bytes)
+			}
+			var byMethod int64
+			for _, n := range st.ByMethod {
+				byMethod += n
+			}
+			if byMethod != st.Files {
+				t.Errorf("ByMethod %v doesn't add up to %v files", st.ByMethod, st.Files)
+			}
+			if m == Buffered && st.ByMethod[Buffered] != st.Files {
+				t.Errorf("forced buffered copy used %v", st.ByMethod)
+			}
+		})
+	}
+}
+
+func TestMaxOpen(t *testing.T) {
+	src := tree(t)
+	st, err := Copy(src, filepath.Join(t.TempDir(), "dst"), Options{Workers: 8, MaxOpen: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 is room for one file, so one worker
+	if st.PeakOpen > 2 {
+		t.Errorf("peak of %v descriptors open with MaxOpen 3", st.PeakOpen)
+	}
+}
+
+func TestDestinationExists(t *testing.T) {
+	src := tree(t)
+	dst := t.TempDir()
+	if _, err := Copy(src, dst, Options{}); err == nil {
+		t.Fatal("copy into an existing directory succeeded")
+	}
+}
+
+func TestErrorsDontStopCopy(t *testing.T) {
+	src := tree(t)
+	// a fifo can't be copied, the copy reports it and carries on with the rest
+	if err := syscall.Mkfifo(filepath.Join(src, "fifo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "dst")
+	st, err := Copy(src, dst, Options{})
+	if err == nil {
+		t.Fatal("a fifo in the tree wasn't reported")
+	}
+	if st.Files != 6 {
+		t.Errorf("copied %v files alongside the error, want 6", st.Files)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "fifo")); err == nil {
+		t.Error("fifo was created in the copy")
+	}
+}
+
+func TestParseMethod(t *testing.T) {
+	for m := Auto; m <= Buffered; m++ {
+		got, err := ParseMethod(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseMethod(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+	if _, err := ParseMethod("rsync"); err == nil {
+		t.Error("ParseMethod accepted an unknown method")
+	}
+}
@@ -0,0 +1,145 @@
+// Package wakeup has two one-shot "wait until ready" signals, one with the lost
+// wakeup bug and one without:
+//
+//	Flag - a flag plus a non blocking channel send as the wakeup. Checking the flag
+//	       and going to sleep are two steps, a Wake in between finds nobody
+//	       sleeping and is dropped, the waiter sleeps forever.
+//	Cond - sync.Cond: Wait releases the mutex and goes to sleep atomically and the
+//	       flag is re-checked in a loop, a Wake can't fall in between.
+//
+// Both have a Gap hook called in that window, between the check and the sleep. It
+// lets a stress run widen the window and a test put the Wake right into it.
+package wakeup
+
+import (
+	"sync"
+	"time"
+)
+
+// Signal is woken once, Wait returns after Wake was called.
+type Signal interface {
+	Wait()
+	Wake()
+}
+
+// Flag is the buggy signal, ready for use as the zero value.
+type Flag struct {
+	// Gap, if set, runs after Wait found the flag false and before it sleeps.
+	Gap func()
+
+	mu     sync.Mutex
+	ready  bool
+	once   sync.Once
+	wakeup chan struct{}
+}
+
+func (f *Flag) ch() chan struct{} {
+	f.once.Do(func() { f.wakeup = make(chan struct{}) })
+	return f.wakeup
+}
+
+// Wait sleeps until Wake, unless the wakeup came too early: then forever.
+func (f *Flag) Wait() {
+	f.mu.Lock()
+	ok := f.ready
+	f.mu.Unlock()
+	if ok {
+		return
+	}
+	// the race window: the flag was false, but we're not asleep yet
+	if f.Gap != nil {
+		f.Gap()
+	}
+	<-f.ch()
+}
+
+// Wake sets the flag and wakes the waiter, if it's already asleep.
+func (f *Flag) Wake() {
+	f.mu.Lock()
+	f.ready = true
+	f.mu.Unlock()
+	select {
+	case f.ch() <- struct{}{}:
+	default:
+		// nobody waiting right now, the wakeup is simply dropped
+	}
+}
+
+// Cond is the correct signal, create it with NewCond.
+type Cond struct {
+	// Gap, if set, runs after Wait found the flag false and before it sleeps. The
+	// mutex is still held, so a Wake can't get in.
+	Gap func()
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	ready bool
+}
+
+// NewCond returns a signal that's not ready.
+func NewCond() *Cond {
+	c := &Cond{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Wait sleeps until Wake.
+func (c *Cond) Wait() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.ready {
+		if c.Gap != nil {
+			c.Gap()
+		}
+		c.cond.Wait()
+	}
+}
+
+// Wake sets the flag and wakes the waiter.
+func (c *Cond) Wake() {
+	c.mu.Lock()
+	c.ready = true
+	c.cond.Signal()
+	c.mu.Unlock()
+}
+
+// Woke runs a waiter and a waker on s and reports whether the waiter woke up
+// within timeout. A waiter that didn't is left asleep.
+func Woke(s Signal, timeout time.Duration) bool {
+	woke := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(woke)
+	}()
+	go s.Wake()
+	select {
+	case <-woke:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Stress runs rounds independent waiter/waker pairs, up to 64 at a time, each on a
+// signal from newSignal, and returns how many waiters never woke up.
+func Stress(newSignal func() Signal, rounds int, timeout time.Duration) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	lost := 0
+	sem := make(chan struct{}, 64)
+	for i := 0; i < rounds; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if !Woke(newSignal(), timeout) {
+				mu.Lock()
+				lost++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return lost
+}
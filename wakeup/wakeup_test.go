@@ -0,0 +1,111 @@
+package wakeup
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// wakeInGap makes the gap of a wait the moment the waker runs: it starts Wake and
+// gives it until it returns, or 50ms if it can't get in, before the waiter goes on
+// to sleep. The flag's Wake never blocks, so on a Flag the wakeup always lands in
+// the gap and the outcome is the same every run.
+func wakeInGap(s Signal) func() {
+	return func() {
+		done := make(chan struct{})
+		go func() {
+			s.Wake()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// waitWoke waits on s with nobody else waking it and reports whether the waiter
+// returned within timeout.
+func waitWoke(s Signal, timeout time.Duration) bool {
+	woke := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(woke)
+	}()
+	select {
+	case <-woke:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestFlagLosesWakeupInGap(t *testing.T) {
+	f := &Flag{}
+	f.Gap = wakeInGap(f)
+	if waitWoke(f, 500*time.Millisecond) {
+		t.Fatal("waiter woke up, the wakeup in the gap should have been lost")
+	}
+	// the waiter is asleep now, a second wakeup reaches it
+	f.Wake()
+}
+
+func TestCondKeepsWakeupInGap(t *testing.T) {
+	c := NewCond()
+	c.Gap = wakeInGap(c)
+	if !waitWoke(c, 2*time.Second) {
+		t.Fatal("waiter hung, the wakeup was lost")
+	}
+}
+
+// inGap waits on rounds signals at once, each woken from its own gap and by
+// nobody else, and returns how many waiters never woke up.
+func inGap(newSignal func() Signal, rounds int, timeout time.Duration) int {
+	var wg sync.WaitGroup
+	var lost atomic.Int64
+	for i := 0; i < rounds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newSignal()
+			if !waitWoke(s, timeout) {
+				lost.Add(1)
+				// let the sleeper go, this wakeup finds it asleep
+				s.Wake()
+			}
+		}()
+	}
+	wg.Wait()
+	return int(lost.Load())
+}
+
+func TestStress(t *testing.T) {
+	const rounds = 200
+	lost := inGap(func() Signal {
+		f := &Flag{}
+		f.Gap = wakeInGap(f)
+		return f
+	}, rounds, 100*time.Millisecond)
+	if lost != rounds {
+		t.Errorf("flag: %v of %v wakeups in the gap lost, expected all of them", lost, rounds)
+	}
+	lost = inGap(func() Signal {
+		c := NewCond()
+		c.Gap = wakeInGap(c)
+		return c
+	}, rounds, 2*time.Second)
+	if lost != 0 {
+		t.Errorf("cond: %v of %v wakeups in the gap lost", lost, rounds)
+	}
+	// waker and waiter left to the scheduler
+	lost = Stress(func() Signal {
+		c := NewCond()
+		c.Gap = runtime.Gosched
+		return c
+	}, rounds, time.Second)
+	if lost != 0 {
+		t.Errorf("cond: %v of %v wakeups lost", lost, rounds)
+	}
+}
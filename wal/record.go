@@ -0,0 +1,115 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Every record on disk is framed as
+//
+//	| length uint32 | crc32c uint32 | seq uint64 | data ... |
+//
+// length is the size of data, the checksum covers seq and data. A record whose
+// header or body is cut short, or whose checksum doesn't match, marks the end of the
+// valid log: that's what a crash in the middle of a write looks like.
+const headerSize = 16
+
+// MaxRecordSize bounds a single record so a corrupt length can't make us allocate
+// gigabytes during recovery.
+const MaxRecordSize = 16 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is reported by Replay for a record that is complete but fails its checksum.
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+func appendRecord(buf []byte, seq uint64, data []byte) []byte {
+	var hdr [headerSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint64(hdr[8:16], seq)
+	crc := crc32.Update(0, crcTable, hdr[8:16])
+	crc = crc32.Update(crc, crcTable, data)
+	binary.LittleEndian.PutUint32(hdr[4:8], crc)
+	buf = append(buf, hdr[:]...)
+	return append(buf, data...)
+}
+
+// readRecord reads the next record. io.EOF means a clean end, io.ErrUnexpectedEOF a
+// torn record and ErrCorrupt a checksum mismatch.
+func readRecord(r io.Reader) (seq uint64, data []byte, err error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[0:4])
+	if n > MaxRecordSize {
+		return 0, nil, fmt.Errorf("%w: length %v", ErrCorrupt, n)
+	}
+	data = make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	seq = binary.LittleEndian.Uint64(hdr[8:16])
+	crc := crc32.Update(0, crcTable, hdr[8:16])
+	crc = crc32.Update(crc, crcTable, data)
+	if crc != binary.LittleEndian.Uint32(hdr[4:8]) {
+		return 0, nil, fmt.Errorf("%w: seq %v", ErrCorrupt, seq)
+	}
+	return seq, data, nil
+}
+
+// ReplayResult describes what recovery found in a log file.
+type ReplayResult struct {
+	Records int
+	LastSeq uint64
+	// ValidSize is the length of the intact prefix of the file.
+	ValidSize int64
+	// Torn is set when the file ends with a partial or corrupt record.
+	Torn bool
+}
+
+// Replay calls fn for every intact record of the log at path, in order. It stops
+// quietly at the first torn or corrupt record, everything after it is considered
+// lost in the crash. An error from fn stops the replay and is returned.
+func Replay(path string, fn func(seq uint64, data []byte) error) (ReplayResult, error) {
+	var res ReplayResult
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		seq, data, err := readRecord(r)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || errors.Is(err, ErrCorrupt) {
+				res.Torn = true
+				return res, nil
+			}
+			return res, err
+		}
+		if fn != nil {
+			if err := fn(seq, data); err != nil {
+				return res, err
+			}
+		}
+		res.Records++
+		res.LastSeq = seq
+		res.ValidSize += int64(headerSize + len(data))
+	}
+}
@@ -0,0 +1,263 @@
+// Package wal is an append only write-ahead log with group commit.
+//
+// Appenders don't write to the file themselves. They hand their record to a single
+// committer goroutine and wait. The committer grabs everything that's queued up,
+// writes it with one write(2), fsyncs once (depending on the policy) and then wakes
+// all of those appenders. With many concurrent appenders a single fsync ends up
+// covering dozens of records, which is where the throughput comes from.
+//
+// A failed write or fsync is final. The file may end in a partial record that hides
+// everything after it from Replay, and after a failed fsync the kernel may already
+// have dropped the dirty pages, so retrying proves nothing. The first such error is
+// returned to its batch, to every later Append and by Close.
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when the committer calls fsync.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every batch before acknowledging it, an acknowledged
+	// record survives a power loss.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs at most every Options.SyncInterval. Records are
+	// acknowledged once written, the last interval can be lost on power loss.
+	SyncInterval
+	// SyncNever leaves flushing to the kernel. Survives a process crash, not a
+	// machine crash.
+	SyncNever
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	case SyncNever:
+		return "never"
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// Options configure a WAL.
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// MaxBatch caps the number of records per group commit.
+	MaxBatch int
+}
+
+// ErrClosed is returned by Append after Close.
+var ErrClosed = errors.New("wal: closed")
+
+// Stats counts the committer's work.
+type Stats struct {
+	Records int64
+	Batches int64
+	Syncs   int64
+}
+
+type request struct {
+	data []byte
+	seq  uint64
+	done chan error
+}
+
+// WAL is an open log. Append is safe for concurrent use.
+type WAL struct {
+	f    *os.File
+	opts Options
+
+	mu      sync.Mutex // guards closed and the send on reqs
+	closed  bool
+	reqs    chan *request
+	stopped chan struct{}
+
+	// committer only, err is read by Close once the committer stopped
+	nextSeq uint64
+	buf     []byte
+	dirty   bool
+	err     error // the first failed write or sync, sticky
+
+	statsMu sync.Mutex
+	stats   Stats
+}
+
+// Open opens the log at path, creating it if needed. Recovery runs first: the file is
+// scanned and anything after the last intact record (a write torn by a crash) is cut
+// off, so new records are appended to a clean tail.
+func Open(path string, opts Options) (*WAL, ReplayResult, error) {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 1024
+	}
+	if opts.Sync == SyncInterval && opts.SyncInterval <= 0 {
+		opts.SyncInterval = 10 * time.Millisecond
+	}
+
+	res, err := Replay(path, nil)
+	if err != nil {
+		return nil, res, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, res, err
+	}
+	if err := f.Truncate(res.ValidSize); err != nil {
+		f.Close()
+		return nil, res, err
+	}
+	if _, err := f.Seek(res.ValidSize, 0); err != nil {
+		f.Close()
+		return nil, res, err
+	}
+
+	w := &WAL{
+		f:       f,
+		opts:    opts,
+		reqs:    make(chan *request, opts.MaxBatch),
+		stopped: make(chan struct{}),
+		nextSeq: res.LastSeq + 1,
+	}
+	go w.commit()
+	return w, res, nil
+}
+
+// Append adds a record and returns its sequence number once the record is written
+// (and synced, for SyncAlways).
+func (w *WAL) Append(data []byte) (uint64, error) {
+	if len(data) > MaxRecordSize {
+		return 0, fmt.Errorf("wal: record of %v bytes is over the limit", len(data))
+	}
+	req := &request{data: data, done: make(chan error, 1)}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return 0, ErrClosed
+	}
+	// sending under the lock keeps Close from closing the channel under us, the
+	// committer drains it without taking the lock so this can't deadlock
+	w.reqs <- req
+	w.mu.Unlock()
+	err := <-req.done
+	return req.seq, err
+}
+
+func (w *WAL) commit() {
+	defer close(w.stopped)
+	var tick <-chan time.Time
+	if w.opts.Sync == SyncInterval {
+		t := time.NewTicker(w.opts.SyncInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	batch := make([]*request, 0, w.opts.MaxBatch)
+	for {
+		select {
+		case req, ok := <-w.reqs:
+			if !ok {
+				w.fail(w.sync())
+				return
+			}
+			batch = append(batch[:0], req)
+			// take whatever else is already waiting, that's the group
+		drain:
+			for len(batch) < w.opts.MaxBatch {
+				select {
+				case req, ok := <-w.reqs:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, req)
+				default:
+					break drain
+				}
+			}
+			w.flush(batch)
+		case <-tick:
+			w.fail(w.sync())
+		}
+	}
+}
+
+// fail records err if it's the first one.
+func (w *WAL) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *WAL) flush(batch []*request) {
+	if w.err != nil {
+		for _, req := range batch {
+			req.done <- w.err
+		}
+		return
+	}
+	w.buf = w.buf[:0]
+	for _, req := range batch {
+		req.seq = w.nextSeq
+		w.nextSeq++
+		w.buf = appendRecord(w.buf, req.seq, req.data)
+	}
+	_, err := w.f.Write(w.buf)
+	w.dirty = true
+	if err == nil && w.opts.Sync == SyncAlways {
+		err = w.sync()
+	}
+	w.fail(err)
+
+	w.statsMu.Lock()
+	w.stats.Batches++
+	w.stats.Records += int64(len(batch))
+	w.statsMu.Unlock()
+
+	for _, req := range batch {
+		req.done <- err
+	}
+}
+
+func (w *WAL) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	w.statsMu.Lock()
+	w.stats.Syncs++
+	w.statsMu.Unlock()
+	return w.f.Sync()
+}
+
+// Stats returns a snapshot of the commit counters.
+func (w *WAL) Stats() Stats {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	return w.stats
+}
+
+// Close waits for queued records to be committed, syncs and closes the file. It
+// returns the first write or sync error the log ran into, the final sync's included.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.closed = true
+	close(w.reqs)
+	w.mu.Unlock()
+	<-w.stopped
+	err := w.f.Close()
+	if w.err != nil {
+		err = w.err
+	}
+	return err
+}
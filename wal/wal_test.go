@@ -0,0 +1,276 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// appendAll appends records "0" to "n-1" from n goroutines at once.
+func appendAll(t *testing.T, w *WAL, n int) map[uint64]string {
+	t.Helper()
+	var mu sync.Mutex
+	acked := map[uint64]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := fmt.Sprint(i)
+			seq, err := w.Append([]byte(data))
+			if err != nil {
+				t.Errorf("Append: %v", err)
+				return
+			}
+			mu.Lock()
+			acked[seq] = data
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	return acked
+}
+
+func replayAll(t *testing.T, path string) (map[uint64]string, ReplayResult) {
+	t.Helper()
+	got := map[uint64]string{}
+	res, err := Replay(path, func(seq uint64, data []byte) error {
+		got[seq] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	return got, res
+}
+
+func TestGroupCommit(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		t.Run(policy.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			w, _, err := Open(path, Options{Sync: policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			acked := appendAll(t, w, 200)
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			st := w.Stats()
+			if st.Records != 200 || st.Batches < 1 || st.Batches > st.Records {
+				t.Errorf("stats %+v for 200 records", st)
+			}
+
+			got, res := replayAll(t, path)
+			if res.Records != 200 || res.LastSeq != 200 || res.Torn {
+				t.Errorf("replay %+v, expected 200 intact records", res)
+			}
+			for seq := uint64(1); seq <= 200; seq++ {
+				if got[seq] != acked[seq] {
+					t.Fatalf("seq %v replayed %q, acknowledged %q", seq, got[seq], acked[seq])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchesShareSyncs(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// queue a batch worth of records before the committer starts: it takes
+	// everything that's waiting, so they land in one group behind one fsync
+	opts := Options{Sync: SyncAlways, MaxBatch: 8}
+	w := &WAL{f: f, opts: opts, reqs: make(chan *request, 8), stopped: make(chan struct{}), nextSeq: 1}
+	reqs := make([]*request, 8)
+	for i := range reqs {
+		reqs[i] = &request{data: []byte{byte(i)}, done: make(chan error, 1)}
+		w.reqs <- reqs[i]
+	}
+	go w.commit()
+	for i, req := range reqs {
+		if err := <-req.done; err != nil || req.seq != uint64(i+1) {
+			t.Fatalf("record %v: seq %v, %v", i, req.seq, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := w.Stats(); st != (Stats{Records: 8, Batches: 1, Syncs: 1}) {
+		t.Errorf("stats %+v, expected 8 records in one synced batch", st)
+	}
+}
+
+func writeLog(t *testing.T, n int) (string, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log")
+	w, _, err := Open(path, Options{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := w.Append([]byte(fmt.Sprintf("record %v", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, fi.Size()
+}
+
+func TestRecoverTail(t *testing.T) {
+	recordSize := int64(headerSize + len("record 0"))
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, path string, size int64)
+		records int
+	}{
+		{"intact", func(*testing.T, string, int64) {}, 5},
+		{"header cut short", func(t *testing.T, path string, size int64) {
+			truncate(t, path, size-recordSize+3)
+		}, 4},
+		{"body cut short", func(t *testing.T, path string, size int64) {
+			truncate(t, path, size-2)
+		}, 4},
+		{"last checksum wrong", func(t *testing.T, path string, size int64) {
+			flip(t, path, size-1)
+		}, 4},
+		// everything after a bad record is lost, even intact records
+		{"middle record corrupt", func(t *testing.T, path string, size int64) {
+			flip(t, path, 2*recordSize+headerSize)
+		}, 2},
+		{"length way over the limit", func(t *testing.T, path string, size int64) {
+			flip(t, path, 4*recordSize+3)
+		}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, size := writeLog(t, 5)
+			tt.damage(t, path, size)
+
+			got, res := replayAll(t, path)
+			if res.Records != tt.records || res.LastSeq != uint64(tt.records) || len(got) != tt.records {
+				t.Fatalf("replay %+v, expected %v records", res, tt.records)
+			}
+			if res.Torn != (tt.records < 5) {
+				t.Errorf("Torn = %v", res.Torn)
+			}
+			if res.ValidSize != int64(tt.records)*recordSize {
+				t.Errorf("ValidSize = %v, expected %v", res.ValidSize, int64(tt.records)*recordSize)
+			}
+
+			// Open cuts the torn tail off, new records follow the last intact one
+			w, opened, err := Open(path, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opened != res {
+				t.Errorf("Open found %+v, Replay %+v", opened, res)
+			}
+			seq, err := w.Append([]byte("after recovery"))
+			if err != nil || seq != uint64(tt.records+1) {
+				t.Fatalf("Append after recovery: seq %v, %v", seq, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, res = replayAll(t, path)
+			if res.Torn || res.Records != tt.records+1 || got[seq] != "after recovery" {
+				t.Errorf("after recovery: %+v", res)
+			}
+		})
+	}
+}
+
+func truncate(t *testing.T, path string, size int64) {
+	t.Helper()
+	if err := os.Truncate(path, size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func flip(t *testing.T, path string, off int64) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, off); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayMissingFile(t *testing.T) {
+	res, err := Replay(filepath.Join(t.TempDir(), "nothing"), nil)
+	if err != nil || res != (ReplayResult{}) {
+		t.Fatalf("Replay of a missing file: %+v, %v", res, err)
+	}
+}
+
+func TestReplayStopsOnCallbackError(t *testing.T) {
+	path, _ := writeLog(t, 5)
+	stop := errors.New("stop")
+	res, err := Replay(path, func(seq uint64, _ []byte) error {
+		if seq == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || res.Records != 2 {
+		t.Fatalf("Replay: %+v, %v", res, err)
+	}
+}
+
+func TestWriteErrorIsSticky(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	w, _, err := Open(path, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Append([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	// the committer is idle, the next Append's send orders this before its read
+	good := w.f
+	ro, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	w.f = ro
+	_, failed := w.Append([]byte("lost"))
+	if failed == nil {
+		t.Fatal("Append to a read only file succeeded")
+	}
+	w.f = good
+	// the file is writable again, the log still refuses: the failed batch may have
+	// left a partial record that would hide this one from Replay
+	if _, err := w.Append([]byte("after")); !errors.Is(err, failed) {
+		t.Errorf("Append after a failed write: %v, expected %v", err, failed)
+	}
+	if err := w.Close(); !errors.Is(err, failed) {
+		t.Errorf("Close after a failed write: %v, expected %v", err, failed)
+	}
+	if _, err := w.Append(nil); err != ErrClosed {
+		t.Errorf("Append after Close: %v", err)
+	}
+	got, _ := replayAll(t, path)
+	if len(got) != 1 || got[1] != "before" {
+		t.Errorf("replayed %v, expected only the record before the failure", got)
+	}
+}
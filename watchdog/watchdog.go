@@ -0,0 +1,243 @@
+// Package watchdog notices workers that stopped making progress.
+//
+// Every worker checks in with Beat whenever it gets something done. A worker that
+// hasn't beaten for Misses intervals is reported as stalled, together with a dump of
+// every goroutine's stack, and the run can be canceled. A deadlocked worker stops
+// beating because it's blocked, a livelocked one because it's busy doing nothing
+// useful, which is why the beat has to mark progress and not just being alive: a
+// livelock looks perfectly alive.
+//
+// Register labels the calling goroutine with the worker's name (a pprof label), so
+// in the stack dump the worker's goroutine shows up as
+//
+//	# labels: {"watchdog":"teller 3"}
+//
+// next to the line it's stuck on.
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ctxKey struct{}
+
+// Config describes what counts as a stall and what happens then.
+type Config struct {
+	// Interval is how often a worker is expected to beat, default 100ms. A worker
+	// is stalled after Misses intervals without a beat, default 3.
+	Interval time.Duration
+	Misses   int
+	// Output gets the stall reports, default stderr.
+	Output io.Writer
+	// Stacks adds every goroutine's stack to a report.
+	Stacks bool
+	// Cancel, if set, is called on the first stall, e.g. to stop the run.
+	Cancel context.CancelFunc
+	// OnStall, if set, is called with every report's stalls.
+	OnStall func([]Stall)
+}
+
+// Stall describes a worker that stopped beating.
+type Stall struct {
+	Worker string
+	Silent time.Duration // since its last beat, or since it registered
+	Beats  int64
+	Note   string // the worker's last Note
+}
+
+func (s Stall) String() string {
+	note := ""
+	if s.Note != "" {
+		note = ", last note: " + s.Note
+	}
+	return fmt.Sprintf("%v silent for %v after %v beats%v", s.Worker, s.Silent.Round(time.Millisecond), s.Beats, note)
+}
+
+// Worker is one watched worker.
+type Worker struct {
+	name    string
+	last    atomic.Int64 // unix nanos of the last beat
+	beats   atomic.Int64
+	note    atomic.Pointer[string]
+	done    atomic.Bool
+	stalled bool // reported and not beaten since, only touched by the check loop
+}
+
+// Watchdog watches the registered workers. It is safe for concurrent use.
+type Watchdog struct {
+	cfg Config
+
+	mu      sync.Mutex
+	workers []*Worker
+	stalls  int64
+	cancel  sync.Once
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a watchdog for cfg, Start starts watching.
+func New(cfg Config) *Watchdog {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.Misses <= 0 {
+		cfg.Misses = 3
+	}
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+	return &Watchdog{cfg: cfg, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Register adds a worker named name. Call it from the worker's own goroutine, which
+// gets the name as a pprof label for the stack dumps.
+func (w *Watchdog) Register(name string) *Worker {
+	wk := &Worker{name: name}
+	wk.last.Store(time.Now().UnixNano())
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("watchdog", name)))
+	w.mu.Lock()
+	w.workers = append(w.workers, wk)
+	w.mu.Unlock()
+	return wk
+}
+
+// WithWorker returns a context carrying wk, for code deep in a worker that wants to
+// Note what it's doing.
+func WithWorker(ctx context.Context, wk *Worker) context.Context {
+	return context.WithValue(ctx, ctxKey{}, wk)
+}
+
+// From returns the context's worker, nil if it has none. A nil *Worker ignores
+// everything.
+func From(ctx context.Context) *Worker {
+	wk, _ := ctx.Value(ctxKey{}).(*Worker)
+	return wk
+}
+
+// Beat marks progress.
+func (wk *Worker) Beat() {
+	if wk == nil {
+		return
+	}
+	wk.last.Store(time.Now().UnixNano())
+	wk.beats.Add(1)
+}
+
+// Note records what the worker is up to, it shows up in a stall report.
+func (wk *Worker) Note(format string, args ...any) {
+	if wk == nil {
+		return
+	}
+	s := fmt.Sprintf(format, args...)
+	wk.note.Store(&s)
+}
+
+// Done stops watching the worker, it finished.
+func (wk *Worker) Done() {
+	if wk == nil {
+		return
+	}
+	wk.done.Store(true)
+}
+
+// Start starts checking on the workers every Interval.
+func (w *Watchdog) Start() {
+	go w.loop()
+}
+
+// Stop stops checking and waits for the check loop to exit.
+func (w *Watchdog) Stop() {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+}
+
+// Stalls returns how many stalls have been reported.
+func (w *Watchdog) Stalls() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stalls
+}
+
+func (w *Watchdog) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check(time.Now())
+		}
+	}
+}
+
+// check reports the workers that newly went silent, one report for all of them.
+func (w *Watchdog) check(now time.Time) {
+	w.mu.Lock()
+	workers := append([]*Worker(nil), w.workers...)
+	w.mu.Unlock()
+
+	limit := time.Duration(w.cfg.Misses) * w.cfg.Interval
+	var stalls []Stall
+	fresh := false
+	for _, wk := range workers {
+		if wk.done.Load() {
+			continue
+		}
+		silent := now.Sub(time.Unix(0, wk.last.Load()))
+		if silent < limit {
+			wk.stalled = false
+			continue
+		}
+		st := Stall{Worker: wk.name, Silent: silent, Beats: wk.beats.Load()}
+		if n := wk.note.Load(); n != nil {
+			st.Note = *n
+		}
+		stalls = append(stalls, st)
+		// a worker already reported is listed again, but doesn't trigger a report
+		fresh = fresh || !wk.stalled
+		wk.stalled = true
+	}
+	if !fresh {
+		return
+	}
+	w.mu.Lock()
+	w.stalls++
+	w.mu.Unlock()
+
+	// the longest silent went quiet first, it's the likely culprit the others
+	// ended up waiting on
+	sort.SliceStable(stalls, func(i, j int) bool { return stalls[i].Silent > stalls[j].Silent })
+	out := w.cfg.Output
+	fmt.Fprintf(out, "watchdog: %v of %v workers made no progress for %v (%v missed beats)\n",
+		len(stalls), len(workers), limit, w.cfg.Misses)
+	fmt.Fprintf(out, "  likely stalled: %v\n", stalls[0])
+	for _, st := range stalls[1:] {
+		fmt.Fprintf(out, "  also:           %v\n", st)
+	}
+	if w.cfg.Stacks {
+		fmt.Fprintln(out, "watchdog: goroutine stacks")
+		// debug=1 groups identical stacks and shows the labels
+		pprof.Lookup("goroutine").WriteTo(out, 1)
+	}
+	if w.cfg.OnStall != nil {
+		w.cfg.OnStall(stalls)
+	}
+	if w.cfg.Cancel != nil {
+		w.cancel.Do(w.cfg.Cancel)
+	}
+}
@@ -0,0 +1,116 @@
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// silence makes wk look like it last beat d ago.
+func silence(wk *Worker, d time.Duration) {
+	wk.last.Store(time.Now().Add(-d).UnixNano())
+}
+
+func TestReportsStall(t *testing.T) {
+	var got [][]Stall
+	var out bytes.Buffer
+	w := New(Config{Interval: 10 * time.Millisecond, Output: &out, OnStall: func(s []Stall) { got = append(got, s) }})
+	busy, stuck, later := w.Register("busy"), w.Register("stuck"), w.Register("later")
+	busy.Beat()
+	stuck.Beat()
+	stuck.Note("waiting for fork %v", 3)
+	silence(stuck, time.Second)
+	silence(later, 500*time.Millisecond)
+	w.check(time.Now())
+
+	if len(got) != 1 || len(got[0]) != 2 {
+		t.Fatalf("stalls reported: %v", got)
+	}
+	// longest silent first, it's the one the others are likely waiting on
+	first, second := got[0][0], got[0][1]
+	if first.Worker != "stuck" || first.Beats != 1 || first.Note != "waiting for fork 3" || second.Worker != "later" {
+		t.Errorf("stalls %v", got[0])
+	}
+	if s := out.String(); !strings.Contains(s, "2 of 3 workers made no progress") || !strings.Contains(s, "likely stalled: stuck") {
+		t.Errorf("report:\n%v", s)
+	}
+	if w.Stalls() != 1 {
+		t.Errorf("Stalls = %v", w.Stalls())
+	}
+}
+
+func TestReportsOncePerStall(t *testing.T) {
+	w := New(Config{Interval: 10 * time.Millisecond, Output: io.Discard})
+	wk := w.Register("w")
+	silence(wk, time.Second)
+	w.check(time.Now())
+	w.check(time.Now())
+	if w.Stalls() != 1 {
+		t.Fatalf("a worker still silent was reported again: %v reports", w.Stalls())
+	}
+	// it recovers, then stalls again: that's a new stall
+	wk.Beat()
+	w.check(time.Now())
+	silence(wk, time.Second)
+	w.check(time.Now())
+	if w.Stalls() != 2 {
+		t.Fatalf("%v reports, want 2", w.Stalls())
+	}
+}
+
+func TestDoneNotWatched(t *testing.T) {
+	w := New(Config{Interval: 10 * time.Millisecond, Output: io.Discard})
+	wk := w.Register("finished")
+	wk.Done()
+	silence(wk, time.Hour)
+	w.check(time.Now())
+	if w.Stalls() != 0 {
+		t.Fatal("a finished worker was reported")
+	}
+}
+
+func TestNilWorker(t *testing.T) {
+	wk := From(context.Background())
+	if wk != nil {
+		t.Fatal("worker from a bare context")
+	}
+	wk.Beat()
+	wk.Note("x")
+	wk.Done()
+}
+
+func TestCancelsStuckRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canceled := 0
+	var out bytes.Buffer
+	w := New(Config{Interval: 5 * time.Millisecond, Misses: 2, Output: &out, Stacks: true,
+		Cancel: func() { canceled++; cancel() }})
+	registered := make(chan *Worker)
+	release := make(chan struct{})
+	go func() {
+		wk := w.Register("teller 3")
+		registered <- wk
+		<-release // stuck without a beat
+	}()
+	defer close(release)
+	<-registered
+	w.Start()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stuck worker didn't cancel the run")
+	}
+	// give it a few more checks, Cancel must only run once
+	time.Sleep(20 * time.Millisecond)
+	w.Stop()
+	if canceled != 1 {
+		t.Errorf("Cancel called %v times", canceled)
+	}
+	if s := out.String(); !strings.Contains(s, `"watchdog":"teller 3"`) {
+		t.Errorf("stack dump doesn't label the worker's goroutine:\n%v", s)
+	}
+}